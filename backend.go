@@ -8,7 +8,13 @@
 package knoxite
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/url"
 	"strings"
 )
@@ -32,13 +38,23 @@ type Backend interface {
 
 	// LoadChunk loads a single Chunk
 	LoadChunk(shasum string, part, totalParts uint) (*[]byte, error)
+	// StatChunk returns the size of a stored Chunk, or an error if it's missing
+	StatChunk(shasum string, part, totalParts uint) (uint64, error)
 	// StoreChunk stores a single Chunk
 	StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error)
+	// DeleteChunk deletes a single Chunk
+	DeleteChunk(shasum string, part, totalParts uint) error
+	// ListChunks returns the names (SHASUM.PART_TOTALPARTS) of all stored Chunks
+	ListChunks() ([]string, error)
 
 	// LoadSnapshot loads a snapshot
 	LoadSnapshot(id string) ([]byte, error)
 	// SaveSnapshot stores a snapshot
 	SaveSnapshot(id string, data []byte) error
+	// DeleteSnapshot deletes a snapshot
+	DeleteSnapshot(id string) error
+	// ListSnapshots returns the IDs of all stored snapshots
+	ListSnapshots() ([]string, error)
 
 	// InitRepository creates a new repository
 	InitRepository() error
@@ -46,6 +62,55 @@ type Backend interface {
 	LoadRepository() ([]byte, error)
 	// SaveRepository stores the metadata for a repository
 	SaveRepository(data []byte) error
+	// ListRepositoryParts returns the names of all stored repository metadata files
+	ListRepositoryParts() ([]string, error)
+}
+
+// Kinds of the objects stored via SaveSnapshot. Besides the snapshots
+// themselves, index, location and lock files get stored next to them, so
+// they end up on every backend. Their names carry a prefix no snapshot ID has
+const (
+	objectSnapshot = iota
+	objectIndexFile
+	objectLocationFile
+	objectLock
+)
+
+// objectKind returns the kind of the object name, as returned by
+// ListSnapshots
+func objectKind(name string) int {
+	switch {
+	case strings.HasPrefix(name, indexFilePrefix):
+		return objectIndexFile
+	case strings.HasPrefix(name, locationFilePrefix):
+		return objectLocationFile
+	case strings.HasPrefix(name, lockFilePrefix):
+		return objectLock
+	}
+	return objectSnapshot
+}
+
+// isSnapshotID returns true if name, as returned by ListSnapshots, is the ID
+// of a snapshot
+func isSnapshotID(name string) bool {
+	return objectKind(name) == objectSnapshot
+}
+
+// CancelableBackend is implemented by backends whose chunk downloads can be
+// aborted before they completed, e.g. once another backend delivered the same
+// part. Loads from all other backends always run to completion
+type CancelableBackend interface {
+	// LoadChunkContext loads a single Chunk, giving up as soon as ctx is done
+	LoadChunkContext(ctx context.Context, shasum string, part, totalParts uint) (*[]byte, error)
+}
+
+// loadChunkContext loads a Chunk from backend, cancelling the load when ctx
+// is done if backend supports it
+func loadChunkContext(ctx context.Context, backend Backend, shasum string, part, totalParts uint) (*[]byte, error) {
+	if b, ok := backend.(CancelableBackend); ok {
+		return b.LoadChunkContext(ctx, shasum, part, totalParts)
+	}
+	return backend.LoadChunk(shasum, part, totalParts)
 }
 
 // Error declarations
@@ -53,8 +118,53 @@ var (
 	ErrRepositoryExists      = errors.New("Repository seems to already exist")
 	ErrInvalidRepositoryURL  = errors.New("Invalid repository url specified")
 	ErrAvailableSpaceUnknown = errors.New("Available space is unknown or undefined")
+	ErrListingUnsupported    = errors.New("This storage backend can't list its content")
+	ErrCheckDataMismatch     = errors.New("Loaded data differs from stored data")
 )
 
+// CheckError is returned when a backend fails its health check
+type CheckError struct {
+	Op  string
+	Err error
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+}
+
+// CheckBackend verifies that backend is reachable, accepts its credentials and
+// can be written to. It reads the repository metadata, then stores, loads and
+// deletes a small probe chunk
+func CheckBackend(backend Backend) error {
+	if _, err := backend.LoadRepository(); err != nil {
+		return &CheckError{"Reading repository", err}
+	}
+
+	data := make([]byte, 64)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	shasumdata := sha256.Sum256(data)
+	shasum := hex.EncodeToString(shasumdata[:])
+
+	if _, err := backend.StoreChunk(shasum, 0, 1, &data); err != nil {
+		return &CheckError{"Writing", err}
+	}
+	b, err := backend.LoadChunk(shasum, 0, 1)
+	if err == nil && !bytes.Equal(*b, data) {
+		err = ErrCheckDataMismatch
+	}
+	if err != nil {
+		backend.DeleteChunk(shasum, 0, 1)
+		return &CheckError{"Reading", err}
+	}
+	if err = backend.DeleteChunk(shasum, 0, 1); err != nil {
+		return &CheckError{"Deleting", err}
+	}
+
+	return nil
+}
+
 // BackendFromURL returns the matching backend for path
 func BackendFromURL(path string) (Backend, error) {
 	if strings.Index(path, "://") < 0 {
@@ -66,25 +176,94 @@ func BackendFromURL(path string) (Backend, error) {
 		return nil, err
 	}
 
+	rawQuery := u.RawQuery
+	options, err := parseBackendOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" && u.RawQuery != rawQuery {
+		// Local paths are taken verbatim, strip the backend options
+		path = strings.TrimSuffix(strings.TrimSuffix(path, rawQuery), "?")
+		if u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+	}
+
+	if u.User == nil && !isLocalScheme(u.Scheme) {
+		// Credentials that aren't part of the URL must never get persisted
+		user, err := resolveCredentials(*u)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			u.User = user
+			options.hideCredentials = true
+		}
+	}
+
+	backend, err := backendFromURL(u, path)
+	if err != nil {
+		return nil, err
+	}
+	return newStorageWithOptions(backend, options), nil
+}
+
+// isLocalScheme returns true for backends that never need credentials
+func isLocalScheme(scheme string) bool {
+	return scheme == "file" || scheme == "memory" || scheme == "tape"
+}
+
+// backendFromURL instantiates the backend for u
+func backendFromURL(u *url.URL, path string) (Backend, error) {
 	switch u.Scheme {
 	case "http":
 		fallthrough
 	case "https":
-		return &StorageHTTP{
-			URL: path,
-		}, nil
+		return NewStorageHTTP(*u)
+
+	case "static+http":
+		fallthrough
+	case "static+https":
+		return NewStorageStatic(*u)
 
 	case "dropbox":
-		return NewStorageDropbox(*u), nil
+		return NewStorageDropbox(*u)
+
+	case "onedrive":
+		return NewStorageOneDrive(*u)
 
+	case "b2":
+		fallthrough
 	case "backblaze":
-		return NewStorageBackblaze(*u)
+		return NewStorageB2(*u)
 
 	case "s3":
 		fallthrough
 	case "s3s":
 		return NewStorageAmazonS3(*u)
 
+	case "ftp":
+		fallthrough
+	case "ftps":
+		return NewStorageFTP(*u)
+
+	case "sia":
+		fallthrough
+	case "sias":
+		return NewStorageSia(*u)
+
+	case "sftp":
+		return NewStorageSFTP(*u)
+
+	case "pipe":
+		return NewStoragePipe(*u)
+
+	case "memory":
+		return MemoryStorage(u.Host), nil
+
+	case "tape":
+		return NewStorageTape(u.Path)
+
 	case "file":
 		return NewStorageLocal(path[8:])
 
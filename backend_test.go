@@ -0,0 +1,48 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import "testing"
+
+func TestCheckBackend(t *testing.T) {
+	backend := NewStorageMemory()
+	if err := CheckBackend(backend); err == nil {
+		t.Errorf("Expected check of uninitialized backend to fail")
+	}
+
+	if err := backend.SaveRepository([]byte("repository")); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+	if err := CheckBackend(backend); err != nil {
+		t.Errorf("Failed checking backend: %s", err)
+	}
+
+	chunks, err := backend.ListChunks()
+	if err != nil || len(chunks) != 0 {
+		t.Errorf("Expected probe chunk to be deleted, found %v %v", chunks, err)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	var be Backend = NewStorageMemory()
+	bm := BackendManager{}
+	bm.AddBackend(&be)
+
+	for _, name := range []string{"abcdef12", indexFilePrefix + "1", locationFilePrefix + "2", lockFilePrefix + "3"} {
+		if err := be.SaveSnapshot(name, []byte(name)); err != nil {
+			t.Errorf("Failed saving %s: %s", name, err)
+			return
+		}
+	}
+
+	ids, err := bm.ListSnapshots()
+	if err != nil || len(ids) != 1 || ids[0] != "abcdef12" {
+		t.Errorf("Expected only the snapshot to be listed, got %v %v", ids, err)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Error declarations
+var (
+	ErrBackendUnavailable = errors.New("Storage backend is temporarily unavailable")
+)
+
+var (
+	// blacklistThreshold is the amount of consecutive connection failures
+	// after which a backend gets blacklisted
+	blacklistThreshold uint32 = 3
+	// blacklistDuration is how long a backend stays blacklisted before it
+	// gets probed again
+	blacklistDuration = 30 * time.Second
+)
+
+// backendHealth tracks the connection failures of a backend
+type backendHealth struct {
+	failures uint32
+	// until is the time (in ns since the epoch) until which the backend is
+	// blacklisted, or 0
+	until int64
+	// stale is 1 if a metadata write to the backend failed
+	stale uint32
+}
+
+// isConnectionFailure returns true for errors which indicate a backend isn't
+// reachable. Missing chunks are expected and don't count
+func isConnectionFailure(err error) bool {
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// record updates the health after an operation finished with err
+func (h *backendHealth) record(err error) {
+	if err == nil {
+		atomic.StoreUint32(&h.failures, 0)
+		atomic.StoreInt64(&h.until, 0)
+		return
+	}
+	if !isConnectionFailure(err) {
+		return
+	}
+
+	if atomic.AddUint32(&h.failures, 1) >= blacklistThreshold {
+		atomic.StoreInt64(&h.until, time.Now().Add(blacklistDuration).UnixNano())
+	}
+}
+
+// available returns false while the backend is blacklisted. Once the
+// blacklisting expired, a single caller gets to probe the backend, everybody
+// else has to wait for the outcome
+func (h *backendHealth) available() bool {
+	until := atomic.LoadInt64(&h.until)
+	if until == 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.UnixNano() < until {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&h.until, until, now.Add(blacklistDuration).UnixNano())
+}
@@ -0,0 +1,73 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// unreachableStorage is a backend that can't be connected to while down is set
+type unreachableStorage struct {
+	*StorageMemory
+	down     bool
+	attempts int
+}
+
+func (backend *unreachableStorage) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	backend.attempts++
+	if backend.down {
+		return 0, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return backend.StorageMemory.StoreChunk(shasum, part, totalParts, data)
+}
+
+func TestBackendManagerBlacklist(t *testing.T) {
+	defer func(d time.Duration) { blacklistDuration = d }(blacklistDuration)
+	blacklistDuration = 50 * time.Millisecond
+
+	storage := &unreachableStorage{StorageMemory: NewStorageMemory(), down: true}
+	var unreachable Backend = storage
+	var healthy Backend = NewStorageMemory()
+
+	bm := BackendManager{}
+	bm.AddBackend(&unreachable)
+	bm.AddBackend(&healthy)
+
+	store := func(n int) {
+		for i := 0; i < n; i++ {
+			data := []byte{byte(i)}
+			chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+			if _, err := bm.StoreChunk(&chunk); err != nil {
+				t.Errorf("Failed storing chunk: %s", err)
+			}
+		}
+	}
+
+	store(20)
+	if storage.attempts != int(blacklistThreshold) {
+		t.Errorf("Expected %d attempts before blacklisting, got %d", blacklistThreshold, storage.attempts)
+	}
+	if bm.available(&unreachable) {
+		t.Errorf("Expected backend to be blacklisted")
+	}
+
+	// Once the backend recovered, a probe lifts the blacklisting
+	storage.down = false
+	time.Sleep(2 * blacklistDuration)
+	store(20)
+	if !bm.available(&unreachable) {
+		t.Errorf("Expected backend to be available again")
+	}
+	if chunks, _ := storage.ListChunks(); len(chunks) == 0 {
+		t.Errorf("Expected recovered backend to get chunks")
+	}
+}
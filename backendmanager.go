@@ -7,25 +7,182 @@
 
 package knoxite
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // BackendManager stores data on multiple backends
 type BackendManager struct {
 	Backends []*Backend
+	// Replication is the amount of distinct backends each chunk part gets
+	// stored on
+	Replication uint
+	// WriteQuorum is the amount of backends snapshots and repository metadata
+	// have to be written to. 0 requires all writable backends
+	WriteQuorum uint
 
-	lastUsedBackend int
+	lastUsedBackend uint32
+	placement       *placement
+	stats           map[*Backend]*BackendStats
+	health          map[*Backend]*backendHealth
+	index           *chunkIndex
+	// upload and download limit the combined rate of all backends
+	upload   *rateLimiter
+	download *rateLimiter
 }
 
+// loadRace is the maximum amount of backends a chunk gets requested from at
+// once
+var loadRace = 2
+
 // Error declarations
 var (
 	ErrLoadChunkFailed      = errors.New("Unable to load chunk from any storage backend")
 	ErrLoadSnapshotFailed   = errors.New("Unable to load repository from any storage backend")
 	ErrLoadRepositoryFailed = errors.New("Unable to load repository from any storage backend")
+	ErrNoWritableBackend    = errors.New("No writable storage backend available")
+	ErrReplicationAmount    = errors.New("Replication factor can't be higher than the number of writable storage backends")
+	ErrNotEnoughBackends    = errors.New("Not enough writable storage backends to store each chunk part on a distinct one")
 )
 
 // AddBackend adds a backend
 func (backend *BackendManager) AddBackend(be *Backend) {
 	backend.Backends = append(backend.Backends, be)
+	backend.placement = newPlacement(backend.chunkBackends())
+
+	if backend.stats == nil {
+		backend.stats = make(map[*Backend]*BackendStats)
+	}
+	backend.stats[be] = &BackendStats{}
+
+	if backend.health == nil {
+		backend.health = make(map[*Backend]*backendHealth)
+	}
+	backend.health[be] = &backendHealth{}
+
+	if limits := backendLimits(*be); limits != nil {
+		limits.set(limitShared, backend.upload, backend.download)
+	}
+
+	if backend.index == nil {
+		backend.index = newChunkIndex(nil)
+	}
+}
+
+// Index returns the locations of the backends holding each chunk part
+func (backend *BackendManager) Index() map[string][]string {
+	return backend.index.copy()
+}
+
+// SetIndex replaces the chunk part index
+func (backend *BackendManager) SetIndex(parts map[string][]string) {
+	backend.index = newChunkIndex(parts)
+}
+
+// SetLimits limits the combined upload and download rate of all backends to
+// the given bytes per second, on top of the limits of single backends. Zero
+// removes a limit
+func (backend *BackendManager) SetLimits(upload, download uint64) {
+	backend.upload, backend.download = nil, nil
+	if upload > 0 {
+		backend.upload = newRateLimiter(upload)
+	}
+	if download > 0 {
+		backend.download = newRateLimiter(download)
+	}
+
+	for _, be := range backend.Backends {
+		if limits := backendLimits(*be); limits != nil {
+			limits.set(limitShared, backend.upload, backend.download)
+		}
+	}
+}
+
+// throttle paces the transfer of data to or from be according to limiter,
+// unless be throttles its connections itself
+func (backend *BackendManager) throttle(be *Backend, limiter *rateLimiter, data []byte) {
+	if backendLimits(*be) == nil {
+		throttle(limiter, data)
+	}
+}
+
+// indexed returns backends with the ones known to hold the part name first,
+// as well as how many of them are known to hold it
+func (backend *BackendManager) indexed(backends []*Backend, name string) ([]*Backend, int) {
+	locations := backend.index.locations(name)
+	if len(locations) == 0 {
+		return backends, 0
+	}
+
+	first := []*Backend{}
+	rest := []*Backend{}
+	for _, be := range backends {
+		known := false
+		for _, l := range locations {
+			known = known || (*be).Location() == l
+		}
+		if known {
+			first = append(first, be)
+		} else {
+			rest = append(rest, be)
+		}
+	}
+	return append(first, rest...), len(first)
+}
+
+// RemoveBackend removes a backend. Its data stays untouched, see EvacuateChunk
+func (backend *BackendManager) RemoveBackend(be *Backend) {
+	for i, b := range backend.Backends {
+		if b == be {
+			backend.Backends = append(backend.Backends[:i], backend.Backends[i+1:]...)
+			break
+		}
+	}
+	backend.placement = newPlacement(backend.chunkBackends())
+	backend.index.removeLocation((*be).Location())
+
+	delete(backend.stats, be)
+	delete(backend.health, be)
+}
+
+// available returns false if be is blacklisted after failing repeatedly
+func (backend *BackendManager) available(be *Backend) bool {
+	if h, ok := backend.health[be]; ok {
+		return h.available()
+	}
+	return true
+}
+
+// Stats returns the transfer statistics of be since it got added
+func (backend *BackendManager) Stats(be *Backend) BackendStats {
+	if s, ok := backend.stats[be]; ok {
+		return s.load(false)
+	}
+	return BackendStats{}
+}
+
+// TakeStats returns the transfer statistics of be and resets them
+func (backend *BackendManager) TakeStats(be *Backend) BackendStats {
+	if s, ok := backend.stats[be]; ok {
+		return s.load(true)
+	}
+	return BackendStats{}
+}
+
+// record accounts for an operation on be that started at start
+func (backend *BackendManager) record(be *Backend, start time.Time, uploaded, downloaded int, err error) {
+	if s, ok := backend.stats[be]; ok {
+		s.record(start, uploaded, downloaded, err)
+	}
+	if h, ok := backend.health[be]; ok {
+		h.record(err)
+	}
 }
 
 // Locations returns the urls for all backends
@@ -38,44 +195,454 @@ func (backend *BackendManager) Locations() []string {
 	return paths
 }
 
-// LoadChunk loads a Chunk from backends
-func (backend *BackendManager) LoadChunk(chunk Chunk, part uint) ([]byte, error) {
+// writableBackends returns all backends that aren't read-only
+func (backend *BackendManager) writableBackends() []*Backend {
+	backends := []*Backend{}
 	for _, be := range backend.Backends {
-		b, err := (*be).LoadChunk(chunk.ShaSum, uint(part), chunk.DataParts)
-		if err == nil {
-			return *b, err
+		if !backendReadOnly(*be) {
+			backends = append(backends, be)
 		}
 	}
 
+	return backends
+}
+
+// tieredBackends splits the writable backends into the ones chunks get
+// distributed over and the cold tier, which only receives an additional copy.
+// Without any other backends, the cold tier takes the chunks
+func (backend *BackendManager) tieredBackends() ([]*Backend, []*Backend) {
+	primary := []*Backend{}
+	cold := []*Backend{}
+	for _, be := range backend.writableBackends() {
+		if backendTier(*be) == TierCold {
+			cold = append(cold, be)
+		} else {
+			primary = append(primary, be)
+		}
+	}
+
+	if len(primary) == 0 {
+		return cold, []*Backend{}
+	}
+	return primary, cold
+}
+
+// chunkBackends returns the backends chunks get distributed over
+func (backend *BackendManager) chunkBackends() []*Backend {
+	primary, _ := backend.tieredBackends()
+	return primary
+}
+
+// readRank orders backends by their storage tier, hot backends come first
+func readRank(be Backend) int {
+	switch backendTier(be) {
+	case TierHot:
+		return 0
+	case TierCold:
+		return 2
+	}
+	return 1
+}
+
+// readBackends returns all backends in the order data should be loaded from
+// them: hot backends first, cold ones last and by their read priority within
+// each tier. Backends with the same tier and priority keep the order they got
+// added in. Blacklisted backends only get tried last
+func (backend *BackendManager) readBackends() []*Backend {
+	backends := []*Backend{}
+	blacklisted := []*Backend{}
+	for _, be := range backend.Backends {
+		if backend.available(be) {
+			backends = append(backends, be)
+		} else {
+			blacklisted = append(blacklisted, be)
+		}
+	}
+
+	for _, l := range [][]*Backend{backends, blacklisted} {
+		sort.SliceStable(l, func(i, j int) bool {
+			if ri, rj := readRank(*l[i]), readRank(*l[j]); ri != rj {
+				return ri < rj
+			}
+			return backendReadPriority(*l[i]) > backendReadPriority(*l[j])
+		})
+	}
+	return append(backends, blacklisted...)
+}
+
+// Connections returns how many chunks can be stored concurrently, which is
+// the total amount of connections of all writable backends
+func (backend *BackendManager) Connections() int {
+	connections := 0
+	for _, be := range backend.writableBackends() {
+		connections += backendConnections(*be)
+	}
+
+	if connections < 1 {
+		return 1
+	}
+	return connections
+}
+
+// LoadChunk loads a Chunk from backends, trying all replicas in order of
+// their tier and read priority. Backends of equal tier and priority get raced
+// against each other, the first successful response wins and the other loads
+// get cancelled. Only a CancelableBackend actually aborts its transfer, the
+// loads of all other backends keep running in the background and their result
+// gets discarded. Backends the index knows to hold the part get tried before
+// all others
+func (backend *BackendManager) LoadChunk(chunk Chunk, part uint) ([]byte, error) {
+	backends, known := backend.indexed(backend.readBackends(), partName(chunk.ShaSum, part, chunk.DataParts))
+	for i := 0; i < len(backends); {
+		race := 1
+		for i+race < len(backends) && race < loadRace &&
+			readRank(*backends[i+race]) == readRank(*backends[i]) &&
+			backendReadPriority(*backends[i+race]) == backendReadPriority(*backends[i]) &&
+			(i < known) == (i+race < known) {
+			race++
+		}
+
+		type result struct {
+			be   *Backend
+			data *[]byte
+		}
+
+		// Buffered, so the losers of the race don't block once we returned
+		results := make(chan result, race)
+		ctx, cancel := context.WithCancel(context.Background())
+		for _, be := range backends[i : i+race] {
+			go func(be *Backend) {
+				start := time.Now()
+				b, err := loadChunkContext(ctx, *be, chunk.ShaSum, part, chunk.DataParts)
+				if err != nil {
+					// Losing a race doesn't count against a backend's health
+					if ctx.Err() == nil {
+						backend.record(be, start, 0, 0, err)
+					}
+					results <- result{be, nil}
+					return
+				}
+				backend.record(be, start, 0, len(*b), nil)
+				results <- result{be, b}
+			}(be)
+		}
+		for j := 0; j < race; j++ {
+			if r := <-results; r.data != nil {
+				cancel()
+				backend.throttle(r.be, backend.download, *r.data)
+				return *r.data, nil
+			}
+		}
+		cancel()
+		i += race
+	}
+
 	return []byte{}, ErrLoadChunkFailed
 }
 
-// StoreChunk stores a single Chunk on backends
-func (backend *BackendManager) StoreChunk(chunk Chunk) (size uint64, err error) {
-	for i, data := range *chunk.Data {
-		// Use storage backends in a round robin fashion to store chunks
-		backend.lastUsedBackend++
-		if backend.lastUsedBackend+1 > len(backend.Backends) {
-			backend.lastUsedBackend = 0
+// StoreChunk stores a single Chunk on backends. Each part gets replicated to
+// as many backends as the replication factor demands, with every copy of every
+// part going to a distinct backend. Parts that fail to upload get re-routed to
+// other backends, preferably ones not holding any part of this chunk yet. If a
+// part has to share a backend with another part of the chunk, the chunk gets
+// marked as Degraded. Cold backends receive one more copy of every part. Those
+// copies aren't required for the chunk to be stored: cold backends failing to
+// take them get flagged as stale instead, see RepairColdChunk
+func (backend *BackendManager) StoreChunk(chunk *Chunk) (size uint64, err error) {
+	backends, cold := backend.tieredBackends()
+	if len(backends) == 0 {
+		return 0, ErrNoWritableBackend
+	}
+	replicas := int(backend.Replication)
+	if replicas < 1 {
+		replicas = 1
+	}
+	if replicas > len(backends) {
+		return 0, ErrReplicationAmount
+	}
+
+	// Assign all parts and replicas to backends up front, so failover knows
+	// which backends are already in use by this chunk
+	copies := make([]int, len(*chunk.Data))
+	for i := range copies {
+		copies[i] = replicas
+	}
+	holders, err := backend.assign(backends, copies, func(part int) uint64 {
+		// This may get called concurrently, hence the atomic counter
+		return uint64(atomic.AddUint32(&backend.lastUsedBackend, 1))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	type upload struct {
+		part    int
+		backend int
+	}
+	uploads := []upload{}
+	used := make(map[int]bool)
+	for i := range holders {
+		for _, idx := range holders[i] {
+			uploads = append(uploads, upload{i, idx})
+			used[idx] = true
+		}
+	}
+
+	// Upload all parts and replicas concurrently, but never more at once
+	// than there are backends
+	workers := make(chan struct{}, len(backends))
+	errs := make(chan error, len(uploads))
+	failed := make(map[int]bool)
+	mut := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+
+	for _, u := range uploads {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(part, idx int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			data := &(*chunk.Data)[part]
+			err := backend.storePart(backends[idx], chunk, part, data)
+			for err != nil {
+				mut.Lock()
+				failed[idx] = true
+				alt, degraded := failover(len(backends), holders[part], used, failed)
+				if alt >= 0 {
+					for k, h := range holders[part] {
+						if h == idx {
+							holders[part][k] = alt
+						}
+					}
+					used[alt] = true
+					chunk.Degraded = chunk.Degraded || degraded
+				}
+				mut.Unlock()
+
+				if alt < 0 {
+					errs <- err
+					return
+				}
+				idx = alt
+				err = backend.storePart(backends[idx], chunk, part, data)
+			}
+		}(u.part, u.backend)
+	}
+
+	wg.Wait()
+	close(errs)
+	if err = <-errs; err != nil {
+		return 0, err
+	}
+	if len(cold) > 0 {
+		backend.storeCold(chunk, cold)
+	}
+
+	return uint64(chunk.Size), nil
+}
+
+// storeCold stores a copy of every part of chunk on the cold tier. The copies
+// aren't part of the failure tolerance, so parts may share a backend. If a
+// backend fails, the next one gets tried
+func (backend *BackendManager) storeCold(chunk *Chunk, cold []*Backend) {
+	wg := &sync.WaitGroup{}
+
+	for i := range *chunk.Data {
+		n := int(atomic.AddUint32(&backend.lastUsedBackend, 1))
+		wg.Add(1)
+		go func(part, n int) {
+			defer wg.Done()
+			backend.storeColdPart(chunk, part, &(*chunk.Data)[part], cold, n)
+		}(i, n)
+	}
+
+	wg.Wait()
+}
+
+// storeColdPart stores a copy of a part of chunk on one of the cold backends,
+// starting with the n-th one. Backends failing to store it get flagged as
+// stale
+func (backend *BackendManager) storeColdPart(chunk *Chunk, part int, data *[]byte, cold []*Backend, n int) error {
+	var err error
+	for j := 0; j < len(cold); j++ {
+		be := cold[(n+j)%len(cold)]
+		if err = backend.storePart(be, chunk, part, data); err == nil {
+			return nil
+		}
+		backend.setStale(be, true)
+	}
+	return err
+}
+
+// RepairColdChunk stores the copies of chunk's parts missing on the cold tier,
+// e.g. because a cold backend failed while the chunk got stored. Returns the
+// amount of bytes copied
+func (backend *BackendManager) RepairColdChunk(chunk Chunk) (uint64, error) {
+	_, cold := backend.tieredBackends()
+	if len(cold) == 0 {
+		return 0, nil
+	}
+
+	var copied uint64
+	for part := uint(0); part < chunk.DataParts+chunk.ParityParts; part++ {
+		stored := false
+		for _, be := range cold {
+			if _, err := (*be).StatChunk(chunk.ShaSum, part, chunk.DataParts); err == nil {
+				stored = true
+				break
+			}
+		}
+		if stored {
+			continue
 		}
 
-		be := backend.Backends[backend.lastUsedBackend]
-		//	for _, be := range backend.Backends {
-		_, err = (*be).StoreChunk(chunk.ShaSum, uint(i), chunk.DataParts, &data)
+		data, err := backend.LoadChunk(chunk, part)
 		if err != nil {
-			return 0, err
+			return copied, err
 		}
-		//	}
+		n := int(partHash(chunk.ShaSum, part) % uint64(len(cold)))
+		if err = backend.storeColdPart(&chunk, int(part), &data, cold, n); err != nil {
+			return copied, err
+		}
+		copied += uint64(len(data))
 	}
 
-	return uint64(chunk.Size), nil
+	return copied, nil
+}
+
+// storePart uploads a single part of chunk to be. Blacklisted backends aren't
+// tried at all, so the part gets re-routed right away
+func (backend *BackendManager) storePart(be *Backend, chunk *Chunk, part int, data *[]byte) error {
+	if !backend.available(be) {
+		return ErrBackendUnavailable
+	}
+
+	start := time.Now()
+	n, err := (*be).StoreChunk(chunk.ShaSum, uint(part), chunk.DataParts, data)
+	backend.record(be, start, int(n), 0, err)
+	if n > 0 {
+		backend.throttle(be, backend.upload, *data)
+	}
+	if err == nil {
+		backend.index.add(partName(chunk.ShaSum, uint(part), chunk.DataParts), (*be).Location())
+	}
+	return err
+}
+
+// assign picks the backends for all copies of a chunk's parts, where copies
+// holds the amount of copies per part. No backend gets more than one of them,
+// otherwise losing a single backend could cost more parts than the parity
+// can make up for. seed returns the placement number for a part
+func (backend *BackendManager) assign(backends []*Backend, copies []int, seed func(part int) uint64) ([][]int, error) {
+	total := 0
+	for _, c := range copies {
+		total += c
+	}
+	if total > len(backends) {
+		return nil, ErrNotEnoughBackends
+	}
+
+	holders := make([][]int, len(copies))
+	used := make(map[int]bool)
+	for i, c := range copies {
+		// Distribute the parts over the backends according to their weights,
+		// moving on to the next backend if one is taken already
+		n := backend.pick(backends, seed(i))
+		for r := 0; r < c; r++ {
+			idx := (n + r) % len(backends)
+			for used[idx] {
+				idx = (idx + 1) % len(backends)
+			}
+			holders[i] = append(holders[i], idx)
+			used[idx] = true
+		}
+	}
+
+	return holders, nil
+}
+
+// failover picks a backend to re-route a part to, which neither failed nor
+// already holds a replica of the part. Backends not storing any part of the
+// chunk are preferred, otherwise the placement is degraded. Returns -1 if
+// there's no backend left
+func failover(backends int, holders []int, used, failed map[int]bool) (int, bool) {
+	for i := 0; i < backends; i++ {
+		if !failed[i] && !used[i] {
+			return i, false
+		}
+	}
+
+	for i := 0; i < backends; i++ {
+		if failed[i] {
+			continue
+		}
+		holding := false
+		for _, h := range holders {
+			holding = holding || h == i
+		}
+		if !holding {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
+
+// pick returns the index of the backend in backends to store the n-th chunk
+// part on
+func (backend *BackendManager) pick(backends []*Backend, n uint64) int {
+	if backend.placement == nil || len(backend.placement.backends) != len(backends) {
+		// Backends got set up without AddBackend, fall back to round robin
+		return int(n % uint64(len(backends)))
+	}
+	return backend.placement.pick(n)
+}
+
+// DeleteChunk deletes all parts (including parity parts) of a Chunk from all
+// writable backends. Parts are spread over the backends, so failing to delete
+// a part is only an error if no backend held it
+func (backend *BackendManager) DeleteChunk(chunk Chunk) error {
+	backends := backend.writableBackends()
+	if len(backends) == 0 {
+		return ErrNoWritableBackend
+	}
+
+	for i := uint(0); i < chunk.DataParts+chunk.ParityParts; i++ {
+		deleted := false
+		for _, be := range backends {
+			start := time.Now()
+			err := (*be).DeleteChunk(chunk.ShaSum, i, chunk.DataParts)
+			// Most backends only hold some of the parts, that's no error
+			backend.record(be, start, 0, 0, nil)
+			if err == nil {
+				deleted = true
+				backend.index.remove(partName(chunk.ShaSum, i, chunk.DataParts), (*be).Location())
+			}
+		}
+		if !deleted {
+			return ErrDeleteChunkFailed
+		}
+	}
+
+	return nil
+}
+
+// ListChunks returns the names of all Chunks stored on any backend
+func (backend *BackendManager) ListChunks() ([]string, error) {
+	return backend.list(func(be Backend) ([]string, error) {
+		return be.ListChunks()
+	})
 }
 
 // LoadSnapshot loads a snapshot
 func (backend *BackendManager) LoadSnapshot(id string) ([]byte, error) {
-	for _, be := range backend.Backends {
+	for _, be := range backend.readBackends() {
+		start := time.Now()
 		b, err := (*be).LoadSnapshot(id)
+		backend.record(be, start, 0, len(b), err)
 		if err == nil {
+			backend.throttle(be, backend.download, b)
 			return b, err
 		}
 	}
@@ -83,21 +650,102 @@ func (backend *BackendManager) LoadSnapshot(id string) ([]byte, error) {
 	return []byte{}, ErrLoadSnapshotFailed
 }
 
-// SaveSnapshot stores a snapshot on all storage backends
+// SaveSnapshot stores a snapshot on all writable storage backends, or at
+// least as many as the write quorum demands
 func (backend *BackendManager) SaveSnapshot(id string, b []byte) error {
-	for _, be := range backend.Backends {
+	return backend.writeMetadata(func(be *Backend) error {
+		start := time.Now()
 		err := (*be).SaveSnapshot(id, b)
+		backend.record(be, start, len(b), 0, err)
+		if err == nil {
+			backend.throttle(be, backend.upload, b)
+		}
+		return err
+	})
+}
+
+// DeleteSnapshot deletes a snapshot from all writable storage backends.
+// Backends not holding it, e.g. stale ones, get skipped. Failing to delete it
+// from one backend doesn't stop it from getting deleted from the others
+func (backend *BackendManager) DeleteSnapshot(id string) error {
+	backends := backend.writableBackends()
+	if len(backends) == 0 {
+		return ErrNoWritableBackend
+	}
+
+	deleted := 0
+	errs := make(map[string]error)
+	for _, be := range backends {
+		start := time.Now()
+		err := (*be).DeleteSnapshot(id)
+		if err != nil && snapshotMissing(*be, id, err) {
+			backend.record(be, start, 0, 0, nil)
+			continue
+		}
+		backend.record(be, start, 0, 0, err)
 		if err != nil {
-			return err
+			errs[(*be).Location()] = err
+			continue
 		}
+		deleted++
 	}
 
+	if len(errs) > 0 && len(backends) == 1 {
+		// Without other backends, there's nothing to report but the error
+		return errs[(*backends[0]).Location()]
+	}
+	if len(errs) > 0 {
+		return &DeleteError{deleted, errs}
+	}
+	if deleted == 0 {
+		return ErrSnapshotNotFound
+	}
 	return nil
 }
 
+// snapshotMissing returns true if deleting the snapshot id from be failed
+// with err because be doesn't hold it. Not all backends tell why deleting
+// failed, so it gets looked up in their list of snapshots otherwise
+func snapshotMissing(be Backend, id string, err error) bool {
+	if err == ErrSnapshotNotFound || err == ErrTapeRecordNotFound || os.IsNotExist(err) {
+		return true
+	}
+
+	ids, lerr := be.ListSnapshots()
+	if lerr != nil {
+		return false
+	}
+	for _, i := range ids {
+		if i == id {
+			return false
+		}
+	}
+	return true
+}
+
+// ListSnapshots returns the IDs of all snapshots stored on any backend. The
+// index, location and lock files stored alongside them are left out
+func (backend *BackendManager) ListSnapshots() ([]string, error) {
+	return backend.list(func(be Backend) ([]string, error) {
+		names, err := be.ListSnapshots()
+		ids := []string{}
+		for _, name := range names {
+			if isSnapshotID(name) {
+				ids = append(ids, name)
+			}
+		}
+		return ids, err
+	})
+}
+
 // InitRepository creates a new repository
 func (backend *BackendManager) InitRepository() error {
-	for _, be := range backend.Backends {
+	backends := backend.writableBackends()
+	if len(backends) == 0 {
+		return ErrNoWritableBackend
+	}
+
+	for _, be := range backends {
 		err := (*be).InitRepository()
 		if err != nil {
 			return err
@@ -109,8 +757,10 @@ func (backend *BackendManager) InitRepository() error {
 
 // LoadRepository reads the metadata for a repository
 func (backend *BackendManager) LoadRepository() ([]byte, error) {
-	for _, be := range backend.Backends {
+	for _, be := range backend.readBackends() {
+		start := time.Now()
 		b, err := (*be).LoadRepository()
+		backend.record(be, start, 0, len(b), err)
 		if err == nil {
 			return b, err
 		}
@@ -119,14 +769,42 @@ func (backend *BackendManager) LoadRepository() ([]byte, error) {
 	return []byte{}, ErrLoadRepositoryFailed
 }
 
-// SaveRepository stores the metadata for a repository
+// SaveRepository stores the metadata for a repository on all writable storage
+// backends, or at least as many as the write quorum demands
 func (backend *BackendManager) SaveRepository(b []byte) error {
-	for _, be := range backend.Backends {
+	return backend.writeMetadata(func(be *Backend) error {
+		start := time.Now()
 		err := (*be).SaveRepository(b)
+		backend.record(be, start, len(b), 0, err)
+		return err
+	})
+}
+
+// ListRepositoryParts returns the names of all repository metadata files
+// stored on any backend
+func (backend *BackendManager) ListRepositoryParts() ([]string, error) {
+	return backend.list(func(be Backend) ([]string, error) {
+		return be.ListRepositoryParts()
+	})
+}
+
+// list merges the names returned by f for all backends. An incomplete list
+// would be dangerous for garbage collection, so any failing backend is an error
+func (backend *BackendManager) list(f func(be Backend) ([]string, error)) ([]string, error) {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, be := range backend.Backends {
+		l, err := f(*be)
 		if err != nil {
-			return err
+			return []string{}, err
+		}
+		for _, name := range l {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
 		}
 	}
 
-	return nil
+	return names, nil
 }
@@ -0,0 +1,466 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackendManagerReplication(t *testing.T) {
+	bm := BackendManager{Replication: 2}
+	storages := []*StorageMemory{}
+	for i := 0; i < 3; i++ {
+		storage := NewStorageMemory()
+		storages = append(storages, storage)
+
+		var be Backend = storage
+		bm.AddBackend(&be)
+	}
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+
+	replicas := 0
+	for _, storage := range storages {
+		if _, err := storage.StatChunk("abcdef", 0, 1); err == nil {
+			replicas++
+			// Remove the first replica, the chunk must still be loadable
+			if replicas == 1 {
+				storage.DeleteChunk("abcdef", 0, 1)
+			}
+		}
+	}
+	if replicas != 2 {
+		t.Errorf("Expected chunk on 2 backends, found it on %d", replicas)
+	}
+
+	b, err := bm.LoadChunk(chunk, 0)
+	if err != nil || string(b) != string(data) {
+		t.Errorf("Failed loading chunk: %v %s", err, string(b))
+	}
+
+	bm.Replication = 4
+	if _, err = bm.StoreChunk(&chunk); err != ErrReplicationAmount {
+		t.Errorf("Expected %v, got %v", ErrReplicationAmount, err)
+	}
+}
+
+func TestBackendManagerWeights(t *testing.T) {
+	heavy, _ := BackendFromURL("memory://heavy?weight=3")
+	light, _ := BackendFromURL("memory://light")
+
+	bm := BackendManager{}
+	bm.AddBackend(&heavy)
+	bm.AddBackend(&light)
+
+	for i := 0; i < 400; i++ {
+		data := []byte{byte(i), byte(i >> 8)}
+		chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+		if _, err := bm.StoreChunk(&chunk); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+			return
+		}
+	}
+
+	chunks, _ := heavy.ListChunks()
+	if len(chunks) < 250 || len(chunks) > 350 {
+		t.Errorf("Expected about 300 of 400 chunks on heavy backend, found %d", len(chunks))
+	}
+}
+
+// failingStorage is a backend that can't store any chunks
+type failingStorage struct {
+	*StorageMemory
+}
+
+func (backend failingStorage) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	return 0, ErrChunkNotFound
+}
+
+func TestBackendManagerFailover(t *testing.T) {
+	var failing Backend = failingStorage{NewStorageMemory()}
+	var healthy Backend = NewStorageMemory()
+
+	bm := BackendManager{}
+	bm.AddBackend(&failing)
+	bm.AddBackend(&healthy)
+
+	// With a single part the healthy backend takes over without degrading
+	for i := 0; i < 4; i++ {
+		data := []byte{byte(i)}
+		chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+		if _, err := bm.StoreChunk(&chunk); err != nil || chunk.Degraded {
+			t.Errorf("Failed storing chunk: %v %v", err, chunk.Degraded)
+		}
+	}
+
+	// Two parts end up on the same backend
+	chunk := Chunk{ShaSum: fmt.Sprintf("%064x", 5), DataParts: 2, Size: 2, Data: &[][]byte{{1}, {2}}}
+	if _, err := bm.StoreChunk(&chunk); err != nil || !chunk.Degraded {
+		t.Errorf("Expected degraded placement: %v %v", err, chunk.Degraded)
+	}
+	for part := uint(0); part < 2; part++ {
+		if _, err := healthy.StatChunk(chunk.ShaSum, part, 2); err != nil {
+			t.Errorf("Part %d missing on healthy backend: %s", part, err)
+		}
+	}
+
+	// Without a healthy backend the error gets passed on
+	bm = BackendManager{}
+	bm.AddBackend(&failing)
+	chunk = Chunk{ShaSum: fmt.Sprintf("%064x", 6), DataParts: 1, Size: 1, Data: &[][]byte{{1}}}
+	if _, err := bm.StoreChunk(&chunk); err != ErrChunkNotFound {
+		t.Errorf("Expected %v, got %v", ErrChunkNotFound, err)
+	}
+}
+
+func TestBackendManagerRebalance(t *testing.T) {
+	var old Backend = NewStorageMemory()
+	var added Backend = NewStorageMemory()
+
+	bm := BackendManager{}
+	bm.AddBackend(&old)
+
+	chunks := []Chunk{}
+	for i := 0; i < 40; i++ {
+		data := []byte{byte(i)}
+		chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+		if _, err := bm.StoreChunk(&chunk); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+			return
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	bm.AddBackend(&added)
+	var moved uint64
+	for _, chunk := range chunks {
+		n, err := bm.RebalanceChunk(chunk)
+		if err != nil {
+			t.Errorf("Failed rebalancing chunk: %s", err)
+			return
+		}
+		moved += n
+	}
+
+	oldChunks, _ := old.ListChunks()
+	addedChunks, _ := added.ListChunks()
+	if len(addedChunks) == 0 || uint64(len(addedChunks)) != moved {
+		t.Errorf("Expected moved chunks on new backend, found %d (moved %d)", len(addedChunks), moved)
+	}
+	if len(oldChunks)+len(addedChunks) != len(chunks) {
+		t.Errorf("Expected every chunk on a single backend, found %d", len(oldChunks)+len(addedChunks))
+	}
+	for i, chunk := range chunks {
+		b, err := bm.LoadChunk(chunk, 0)
+		if err != nil || len(b) != 1 || b[0] != byte(i) {
+			t.Errorf("Failed loading chunk: %v %v", err, b)
+		}
+	}
+}
+
+func TestBackendManagerDistinctParts(t *testing.T) {
+	heavy, _ := BackendFromURL("memory://distinct-heavy?weight=10")
+	light, _ := BackendFromURL("memory://distinct-light")
+	other, _ := BackendFromURL("memory://distinct-other")
+	backends := []Backend{heavy, light, other}
+
+	bm := BackendManager{}
+	for i := range backends {
+		bm.AddBackend(&backends[i])
+	}
+
+	for i := 0; i < 50; i++ {
+		chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 2, ParityParts: 1, Size: 3, Data: &[][]byte{{1}, {2}, {3}}}
+		if _, err := bm.StoreChunk(&chunk); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+			return
+		}
+		for _, be := range backends {
+			parts := 0
+			for part := uint(0); part < 3; part++ {
+				if _, err := be.StatChunk(chunk.ShaSum, part, 2); err == nil {
+					parts++
+				}
+			}
+			if parts != 1 {
+				t.Errorf("Expected one part of chunk %d on %s, found %d", i, be.Location(), parts)
+			}
+		}
+	}
+
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 3, ParityParts: 1, Size: 4, Data: &[][]byte{{1}, {2}, {3}, {4}}}
+	if _, err := bm.StoreChunk(&chunk); err != ErrNotEnoughBackends {
+		t.Errorf("Expected %v, got %v", ErrNotEnoughBackends, err)
+	}
+}
+
+// slowStorage is a backend that takes a while to load chunks
+type slowStorage struct {
+	*StorageMemory
+}
+
+func (backend slowStorage) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	time.Sleep(500 * time.Millisecond)
+	return backend.StorageMemory.LoadChunk(shasum, part, totalParts)
+}
+
+func TestBackendManagerLoadRace(t *testing.T) {
+	var slow Backend = slowStorage{NewStorageMemory()}
+	var fast Backend = NewStorageMemory()
+
+	bm := BackendManager{Replication: 2}
+	bm.AddBackend(&slow)
+	bm.AddBackend(&fast)
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+
+	start := time.Now()
+	b, err := bm.LoadChunk(chunk, 0)
+	if err != nil || string(b) != string(data) {
+		t.Errorf("Failed loading chunk: %v %s", err, string(b))
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Errorf("Loading chunk waited for the slow backend, took %v", d)
+	}
+}
+
+// cancelableStorage is a backend whose chunk loads block until they get
+// cancelled
+type cancelableStorage struct {
+	*StorageMemory
+	cancelled chan struct{}
+}
+
+func (backend cancelableStorage) LoadChunkContext(ctx context.Context, shasum string, part, totalParts uint) (*[]byte, error) {
+	<-ctx.Done()
+	close(backend.cancelled)
+	return nil, ctx.Err()
+}
+
+func TestBackendManagerLoadRaceCancel(t *testing.T) {
+	blocking := cancelableStorage{NewStorageMemory(), make(chan struct{})}
+	var slow Backend = blocking
+	var fast Backend = NewStorageMemory()
+
+	bm := BackendManager{Replication: 2}
+	bm.AddBackend(&slow)
+	bm.AddBackend(&fast)
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+
+	b, err := bm.LoadChunk(chunk, 0)
+	if err != nil || string(b) != string(data) {
+		t.Errorf("Failed loading chunk: %v %s", err, string(b))
+	}
+
+	select {
+	case <-blocking.cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the losing load to get cancelled")
+	}
+	if s := bm.Stats(&slow); s.Errors != 0 {
+		t.Errorf("Expected a cancelled load not to count as error, got %d errors", s.Errors)
+	}
+}
+
+func TestBackendManagerEvacuate(t *testing.T) {
+	storages := []*StorageMemory{}
+	backends := []Backend{}
+	for i := 0; i < 4; i++ {
+		storages = append(storages, NewStorageMemory())
+		backends = append(backends, storages[i])
+	}
+
+	bm := BackendManager{}
+	for i := range backends {
+		bm.AddBackend(&backends[i])
+	}
+
+	chunks := []Chunk{}
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("chunk %d with some data", i))
+		pars, err := redundantData(data, 2, 1)
+		if err != nil {
+			t.Errorf("Failed encoding chunk: %s", err)
+			return
+		}
+		chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 2, ParityParts: 1, Size: len(data), Data: &pars}
+		if _, err = bm.StoreChunk(&chunk); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+			return
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// Half of the chunks got lost on the departing backend and need to be
+	// re-encoded
+	for _, chunk := range chunks[:5] {
+		for part := uint(0); part < 3; part++ {
+			storages[0].DeleteChunk(chunk.ShaSum, part, 2)
+		}
+	}
+
+	for _, chunk := range chunks {
+		if _, err := bm.EvacuateChunk(chunk, &backends[0]); err != nil {
+			t.Errorf("Failed evacuating chunk: %s", err)
+			return
+		}
+	}
+	bm.RemoveBackend(&backends[0])
+	if len(bm.Backends) != 3 {
+		t.Errorf("Expected 3 backends, got %d", len(bm.Backends))
+	}
+
+	for _, chunk := range chunks {
+		for part := uint(0); part < 3; part++ {
+			b, err := bm.LoadChunk(chunk, part)
+			if err != nil || string(b) != string((*chunk.Data)[part]) {
+				t.Errorf("Part %d of chunk %s got lost: %v", part, chunk.ShaSum, err)
+			}
+		}
+	}
+}
+
+func TestBackendManagerTiers(t *testing.T) {
+	var loads int32
+	hotStorage := MemoryStorage("tier-hot")
+	coldStorage := MemoryStorage("tier-cold")
+	hot := newStorageWithOptions(countingStorage{hotStorage, new(int32)}, BackendOptions{Tier: TierHot})
+	cold := newStorageWithOptions(countingStorage{coldStorage, &loads}, BackendOptions{Tier: TierCold})
+
+	bm := BackendManager{}
+	bm.AddBackend(&cold)
+	bm.AddBackend(&hot)
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+	for _, storage := range []*StorageMemory{hotStorage, coldStorage} {
+		if _, err := storage.StatChunk("abcdef", 0, 1); err != nil {
+			t.Errorf("Chunk missing on %s: %s", storage.Location(), err)
+		}
+	}
+
+	if _, err := bm.LoadChunk(chunk, 0); err != nil || loads != 0 {
+		t.Errorf("Expected chunk to be loaded from hot tier: %v %d", err, loads)
+	}
+
+	// The cold tier steps in once the hot tier lost the chunk
+	hotStorage.DeleteChunk("abcdef", 0, 1)
+	if b, err := bm.LoadChunk(chunk, 0); err != nil || string(b) != string(data) || loads != 1 {
+		t.Errorf("Expected chunk to be loaded from cold tier: %v %d", err, loads)
+	}
+}
+
+// flakyStorage is a backend that can't store any chunks while failing is set
+type flakyStorage struct {
+	*StorageMemory
+	failing *bool
+}
+
+func (backend flakyStorage) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	if *backend.failing {
+		return 0, ErrStoreChunkFailed
+	}
+	return backend.StorageMemory.StoreChunk(shasum, part, totalParts, data)
+}
+
+func TestBackendManagerColdFailure(t *testing.T) {
+	failing := true
+	coldStorage := MemoryStorage("cold-flaky")
+	hot := newStorageWithOptions(NewStorageMemory(), BackendOptions{Tier: TierHot})
+	cold := newStorageWithOptions(flakyStorage{coldStorage, &failing}, BackendOptions{Tier: TierCold})
+
+	bm := BackendManager{}
+	bm.AddBackend(&hot)
+	bm.AddBackend(&cold)
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Expected a cold tier failure not to fail the chunk: %s", err)
+		return
+	}
+	if stale := bm.Stale(); len(stale) != 1 || stale[0] != cold.Location() {
+		t.Errorf("Expected cold backend to be stale, got %v", stale)
+	}
+	if s := bm.Stats(&cold); s.Errors != 1 {
+		t.Errorf("Expected 1 error, got %d", s.Errors)
+	}
+
+	failing = false
+	n, err := bm.RepairColdChunk(chunk)
+	if err != nil || n != uint64(len(data)) {
+		t.Errorf("Failed repairing cold tier: %v %d", err, n)
+	}
+	if _, err := coldStorage.StatChunk("abcdef", 0, 1); err != nil {
+		t.Errorf("Chunk missing on cold tier: %s", err)
+	}
+	if n, _ = bm.RepairColdChunk(chunk); n != 0 {
+		t.Errorf("Expected nothing left to repair, got %d bytes", n)
+	}
+}
+
+func TestBackendManagerLimits(t *testing.T) {
+	bm := BackendManager{}
+	for i := 0; i < 2; i++ {
+		var be Backend = NewStorageMemory()
+		bm.AddBackend(&be)
+	}
+	bm.Replication = 2
+	bm.SetLimits(256*1024, 512*1024)
+
+	// Both replicas count towards the same limit
+	data := make([]byte, 64*1024)
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	start := time.Now()
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("Expected upload to take at least 500ms, took %s", d)
+	}
+
+	start = time.Now()
+	if _, err := bm.LoadChunk(chunk, 0); err != nil {
+		t.Errorf("Failed loading chunk: %s", err)
+		return
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Expected download to take at least 125ms, took %s", d)
+	}
+
+	bm.SetLimits(0, 0)
+	start = time.Now()
+	bm.LoadChunk(chunk, 0)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Expected unlimited download, took %s", d)
+	}
+}
@@ -0,0 +1,419 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Error declarations
+var (
+	ErrInvalidConnections = errors.New("Invalid amount of connections, expected a number greater than 0")
+	ErrInvalidReadOnly    = errors.New("Invalid read-only flag, expected true or false")
+	ErrInvalidWeight      = errors.New("Invalid weight, expected a number greater than 0")
+	ErrInvalidPriority    = errors.New("Invalid read priority, expected a number")
+	ErrInvalidTier        = errors.New("Invalid storage tier, expected hot or cold")
+)
+
+// Storage tiers
+const (
+	// TierHot backends are read from first
+	TierHot = "hot"
+	// TierCold backends get an additional copy of all chunks, but are only
+	// read from if no other backend can deliver
+	TierCold = "cold"
+)
+
+// backendOptionKeys contains the query parameters consumed as BackendOptions
+var backendOptionKeys = []string{"limit-upload", "limit-download", "connections", "readonly", "weight", "read-priority", "tier"}
+
+// BackendOptions can be set for any backend by appending them to its URL as
+// query parameters, e.g. sftp://host/path?limit-upload=2M
+type BackendOptions struct {
+	// LimitUpload is the maximum upload rate in bytes per second
+	LimitUpload uint64
+	// LimitDownload is the maximum download rate in bytes per second
+	LimitDownload uint64
+	// Connections is the maximum amount of concurrent chunk transfers
+	Connections uint
+	// ReadOnly backends are never written to
+	ReadOnly bool
+	// Weight scales the share of chunks stored on the backend
+	Weight uint
+	// ReadPriority orders the backends when loading data, higher goes first
+	ReadPriority int
+	// Tier is either TierHot, TierCold or empty
+	Tier string
+
+	// hideCredentials strips resolved credentials from the location, so
+	// they never get persisted in the repository
+	hideCredentials bool
+}
+
+// parseBackendOptions extracts all backend options from u's query
+func parseBackendOptions(u *url.URL) (BackendOptions, error) {
+	options := BackendOptions{}
+	query := u.Query()
+
+	var err error
+	if v := query.Get("limit-upload"); v != "" {
+		if options.LimitUpload, err = ParseSize(v); err != nil {
+			return options, err
+		}
+	}
+	if v := query.Get("limit-download"); v != "" {
+		if options.LimitDownload, err = ParseSize(v); err != nil {
+			return options, err
+		}
+	}
+
+	if v := query.Get("connections"); v != "" {
+		n, perr := strconv.ParseUint(v, 10, 32)
+		if perr != nil || n == 0 {
+			return options, ErrInvalidConnections
+		}
+		options.Connections = uint(n)
+	}
+
+	if v := query.Get("readonly"); v != "" {
+		if options.ReadOnly, err = strconv.ParseBool(v); err != nil {
+			return options, ErrInvalidReadOnly
+		}
+	}
+
+	if v := query.Get("weight"); v != "" {
+		n, perr := strconv.ParseUint(v, 10, 32)
+		if perr != nil || n == 0 {
+			return options, ErrInvalidWeight
+		}
+		options.Weight = uint(n)
+	}
+
+	if v := query.Get("read-priority"); v != "" {
+		n, perr := strconv.ParseInt(v, 10, 32)
+		if perr != nil {
+			return options, ErrInvalidPriority
+		}
+		options.ReadPriority = int(n)
+	}
+
+	if v := query.Get("tier"); v != "" {
+		if v != TierHot && v != TierCold {
+			return options, ErrInvalidTier
+		}
+		options.Tier = v
+	}
+
+	found := false
+	for _, key := range backendOptionKeys {
+		if _, ok := query[key]; ok {
+			query.Del(key)
+			found = true
+		}
+	}
+	if found {
+		u.RawQuery = query.Encode()
+	}
+	return options, nil
+}
+
+// query returns the options encoded as URL query parameters
+func (options BackendOptions) query() string {
+	query := url.Values{}
+	if options.LimitUpload > 0 {
+		query.Set("limit-upload", strconv.FormatUint(options.LimitUpload, 10))
+	}
+	if options.LimitDownload > 0 {
+		query.Set("limit-download", strconv.FormatUint(options.LimitDownload, 10))
+	}
+	if options.Connections > 1 {
+		query.Set("connections", strconv.FormatUint(uint64(options.Connections), 10))
+	}
+	if options.ReadOnly {
+		query.Set("readonly", "true")
+	}
+	if options.Weight > 1 {
+		query.Set("weight", strconv.FormatUint(uint64(options.Weight), 10))
+	}
+	if options.ReadPriority != 0 {
+		query.Set("read-priority", strconv.Itoa(options.ReadPriority))
+	}
+	if options.Tier != "" {
+		query.Set("tier", options.Tier)
+	}
+	return query.Encode()
+}
+
+// storageWithOptions applies generic BackendOptions to a backend
+type storageWithOptions struct {
+	Backend
+
+	options     BackendOptions
+	upload      *rateLimiter
+	download    *rateLimiter
+	connections chan struct{}
+}
+
+func newStorageWithOptions(backend Backend, options BackendOptions) Backend {
+	if options.query() == "" && !options.hideCredentials {
+		return backend
+	}
+
+	storage := storageWithOptions{
+		Backend: backend,
+		options: options,
+	}
+	if options.Connections > 1 {
+		storage.connections = make(chan struct{}, options.Connections)
+	}
+	if options.LimitUpload > 0 {
+		storage.upload = newRateLimiter(options.LimitUpload)
+	}
+	if options.LimitDownload > 0 {
+		storage.download = newRateLimiter(options.LimitDownload)
+	}
+	if limits := backendLimits(backend); limits != nil {
+		// Throttle the backend's connections instead of pacing afterwards
+		limits.set(limitBackend, storage.upload, storage.download)
+		storage.upload, storage.download = nil, nil
+	}
+	return &storage
+}
+
+// limitedBackend is implemented by backends which can throttle their
+// connections while data is being transferred
+type limitedBackend interface {
+	transferLimits() *transferLimits
+}
+
+// backendLimits returns the limits backend's connections are subject to, or
+// nil if its transfers can't be throttled while they're running
+func backendLimits(backend Backend) *transferLimits {
+	if b, ok := backend.(limitedBackend); ok {
+		return b.transferLimits()
+	}
+	return nil
+}
+
+func (backend *storageWithOptions) transferLimits() *transferLimits {
+	return backendLimits(backend.Backend)
+}
+
+// backendConnections returns how many chunks can be transferred concurrently
+// with backend
+func backendConnections(backend Backend) int {
+	if b, ok := backend.(*storageWithOptions); ok && b.options.Connections > 1 {
+		return int(b.options.Connections)
+	}
+	return 1
+}
+
+// backendWeight returns the user-assigned weight of backend
+func backendWeight(backend Backend) uint64 {
+	if b, ok := backend.(*storageWithOptions); ok && b.options.Weight > 1 {
+		return uint64(b.options.Weight)
+	}
+	return 1
+}
+
+// backendReadPriority returns the user-assigned read priority of backend
+func backendReadPriority(backend Backend) int {
+	if b, ok := backend.(*storageWithOptions); ok {
+		return b.options.ReadPriority
+	}
+	return 0
+}
+
+// backendTier returns the storage tier of backend
+func backendTier(backend Backend) string {
+	if b, ok := backend.(*storageWithOptions); ok {
+		return b.options.Tier
+	}
+	return ""
+}
+
+// backendReadOnly returns true if backend got flagged as read-only
+func backendReadOnly(backend Backend) bool {
+	b, ok := backend.(*storageWithOptions)
+	return ok && b.options.ReadOnly
+}
+
+// acquire blocks until a connection is available
+func (backend *storageWithOptions) acquire() {
+	if backend.connections != nil {
+		backend.connections <- struct{}{}
+	}
+}
+
+// acquireContext blocks until a connection is available or ctx is done
+func (backend *storageWithOptions) acquireContext(ctx context.Context) error {
+	if backend.connections == nil {
+		return nil
+	}
+	select {
+	case backend.connections <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a connection acquired earlier
+func (backend *storageWithOptions) release() {
+	if backend.connections != nil {
+		<-backend.connections
+	}
+}
+
+// throttle paces the transfer of data according to limiter. It's only used
+// for backends which can't throttle their connections, the transfer already
+// happened then and the caller gets held back until it would have finished
+func throttle(limiter *rateLimiter, data []byte) {
+	if limiter != nil {
+		io.Copy(ioutil.Discard, newThrottledReader(bytes.NewReader(data), limiter))
+	}
+}
+
+// Location returns the type and location of the repository
+func (backend *storageWithOptions) Location() string {
+	location := backend.Backend.Location()
+	if backend.options.hideCredentials {
+		if u, err := url.Parse(location); err == nil {
+			u.User = nil
+			location = u.String()
+		}
+	}
+
+	query := backend.options.query()
+	if query == "" {
+		return location
+	}
+	if strings.Contains(location, "?") {
+		return location + "&" + query
+	}
+	return location + "?" + query
+}
+
+// LoadChunk loads a Chunk
+func (backend *storageWithOptions) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	backend.acquire()
+	defer backend.release()
+
+	b, err := backend.Backend.LoadChunk(shasum, part, totalParts)
+	if err == nil {
+		throttle(backend.download, *b)
+	}
+	return b, err
+}
+
+// LoadChunkContext loads a Chunk, giving up as soon as ctx is done. This
+// only aborts running transfers if the wrapped backend is a CancelableBackend
+func (backend *storageWithOptions) LoadChunkContext(ctx context.Context, shasum string, part, totalParts uint) (*[]byte, error) {
+	if err := backend.acquireContext(ctx); err != nil {
+		return nil, err
+	}
+	defer backend.release()
+
+	b, err := loadChunkContext(ctx, backend.Backend, shasum, part, totalParts)
+	if err == nil {
+		throttle(backend.download, *b)
+	}
+	return b, err
+}
+
+// StoreChunk stores a single Chunk
+func (backend *storageWithOptions) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	if backend.options.ReadOnly {
+		return 0, ErrReadOnlyBackend
+	}
+
+	backend.acquire()
+	defer backend.release()
+
+	size, err := backend.Backend.StoreChunk(shasum, part, totalParts, data)
+	if err == nil && size > 0 {
+		// Skipped chunks didn't need to be transferred
+		throttle(backend.upload, *data)
+	}
+	return size, err
+}
+
+// DeleteChunk deletes a single Chunk
+func (backend *storageWithOptions) DeleteChunk(shasum string, part, totalParts uint) error {
+	if backend.options.ReadOnly {
+		return ErrReadOnlyBackend
+	}
+	return backend.Backend.DeleteChunk(shasum, part, totalParts)
+}
+
+// LoadSnapshot loads a snapshot
+func (backend *storageWithOptions) LoadSnapshot(id string) ([]byte, error) {
+	b, err := backend.Backend.LoadSnapshot(id)
+	if err == nil {
+		throttle(backend.download, b)
+	}
+	return b, err
+}
+
+// SaveSnapshot stores a snapshot
+func (backend *storageWithOptions) SaveSnapshot(id string, data []byte) error {
+	if backend.options.ReadOnly {
+		return ErrReadOnlyBackend
+	}
+
+	err := backend.Backend.SaveSnapshot(id, data)
+	if err == nil {
+		throttle(backend.upload, data)
+	}
+	return err
+}
+
+// DeleteSnapshot deletes a snapshot
+func (backend *storageWithOptions) DeleteSnapshot(id string) error {
+	if backend.options.ReadOnly {
+		return ErrReadOnlyBackend
+	}
+	return backend.Backend.DeleteSnapshot(id)
+}
+
+// InitRepository creates a new repository
+func (backend *storageWithOptions) InitRepository() error {
+	if backend.options.ReadOnly {
+		return ErrReadOnlyBackend
+	}
+	return backend.Backend.InitRepository()
+}
+
+// LoadRepository reads the metadata for a repository
+func (backend *storageWithOptions) LoadRepository() ([]byte, error) {
+	b, err := backend.Backend.LoadRepository()
+	if err == nil {
+		throttle(backend.download, b)
+	}
+	return b, err
+}
+
+// SaveRepository stores the metadata for a repository
+func (backend *storageWithOptions) SaveRepository(data []byte) error {
+	if backend.options.ReadOnly {
+		return ErrReadOnlyBackend
+	}
+
+	err := backend.Backend.SaveRepository(data)
+	if err == nil {
+		throttle(backend.upload, data)
+	}
+	return err
+}
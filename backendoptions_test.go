@@ -0,0 +1,171 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	sizes := map[string]uint64{
+		"512":    512,
+		"2K":     2048,
+		"2M":     2 << 20,
+		"1GiB":   1 << 30,
+		" 3 mb ": 3 << 20,
+	}
+	for s, expected := range sizes {
+		size, err := ParseSize(s)
+		if err != nil || size != expected {
+			t.Errorf("Failed parsing %q: expected %d, got %d (%v)", s, expected, size, err)
+		}
+	}
+
+	if _, err := ParseSize("2X"); err != ErrInvalidSize {
+		t.Errorf("Expected %v, got %v", ErrInvalidSize, err)
+	}
+}
+
+func TestBackendOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for repository: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := BackendFromURL(dir + "?limit-upload=1M")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	if backend.Location() != dir+"?limit-upload=1048576" {
+		t.Errorf("Unexpected location: %s", backend.Location())
+	}
+	if err = backend.InitRepository(); err != nil {
+		t.Errorf("Failed initializing repository: %s", err)
+		return
+	}
+
+	data := make([]byte, 256*1024)
+	start := time.Now()
+	if _, err = backend.StoreChunk("0123456789abcdef", 0, 1, &data); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("Upload wasn't throttled, took %v", d)
+	}
+
+	// Storing the chunk again needs no transfer and must not get throttled
+	start = time.Now()
+	if _, err = backend.StoreChunk("0123456789abcdef", 0, 1, &data); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Skipped upload got throttled, took %v", d)
+	}
+}
+
+func TestBackendConnections(t *testing.T) {
+	backend, err := BackendFromURL("memory://connections?connections=4")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	if backend.Location() != "memory://connections?connections=4" {
+		t.Errorf("Unexpected location: %s", backend.Location())
+	}
+
+	bm := BackendManager{}
+	bm.AddBackend(&backend)
+	bm.AddBackend(&backend)
+	if bm.Connections() != 8 {
+		t.Errorf("Expected 8 connections, got %d", bm.Connections())
+	}
+
+	if _, err = BackendFromURL("memory://connections?connections=0"); err != ErrInvalidConnections {
+		t.Errorf("Expected %v, got %v", ErrInvalidConnections, err)
+	}
+}
+
+func TestBackendReadOnly(t *testing.T) {
+	readonly, err := BackendFromURL("memory://readonly?readonly=1")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	if readonly.Location() != "memory://readonly?readonly=true" {
+		t.Errorf("Unexpected location: %s", readonly.Location())
+	}
+	writable, err := BackendFromURL("memory://writable")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+
+	data := []byte("1234567890")
+	if _, err = readonly.StoreChunk("abcdef", 0, 1, &data); err != ErrReadOnlyBackend {
+		t.Errorf("Expected %v, got %v", ErrReadOnlyBackend, err)
+	}
+
+	bm := BackendManager{}
+	bm.AddBackend(&readonly)
+	if _, err = bm.StoreChunk(&Chunk{ShaSum: "abcdef", DataParts: 1, Data: &[][]byte{data}}); err != ErrNoWritableBackend {
+		t.Errorf("Expected %v, got %v", ErrNoWritableBackend, err)
+	}
+
+	bm.AddBackend(&writable)
+	for i := 0; i < 2; i++ {
+		if _, err = bm.StoreChunk(&Chunk{ShaSum: "abcdef", DataParts: 1, Data: &[][]byte{data}}); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+		}
+	}
+	if err = bm.SaveSnapshot("snap", data); err != nil {
+		t.Errorf("Failed saving snapshot: %s", err)
+	}
+	if chunks, _ := readonly.ListChunks(); len(chunks) != 0 {
+		t.Errorf("Read-only backend got written to: %v", chunks)
+	}
+	if _, err = readonly.LoadSnapshot("snap"); err == nil {
+		t.Errorf("Read-only backend got written to")
+	}
+	if _, err = writable.LoadSnapshot("snap"); err != nil {
+		t.Errorf("Failed loading snapshot: %s", err)
+	}
+}
+
+func TestBackendReadPriority(t *testing.T) {
+	remote, _ := BackendFromURL("memory://remote")
+	local, err := BackendFromURL("memory://local?read-priority=10")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	if local.Location() != "memory://local?read-priority=10" {
+		t.Errorf("Unexpected location: %s", local.Location())
+	}
+
+	remoteData, localData := []byte("remote"), []byte("local")
+	remote.StoreChunk("abcdef", 0, 1, &remoteData)
+	local.StoreChunk("abcdef", 0, 1, &localData)
+
+	bm := BackendManager{}
+	bm.AddBackend(&remote)
+	bm.AddBackend(&local)
+	b, err := bm.LoadChunk(Chunk{ShaSum: "abcdef", DataParts: 1}, 0)
+	if err != nil || string(b) != "local" {
+		t.Errorf("Expected chunk from prioritized backend: %v %s", err, string(b))
+	}
+
+	if _, err = BackendFromURL("memory://local?read-priority=high"); err != ErrInvalidPriority {
+		t.Errorf("Expected %v, got %v", ErrInvalidPriority, err)
+	}
+}
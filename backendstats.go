@@ -0,0 +1,78 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// BackendStats contains transfer statistics of a single backend
+type BackendStats struct {
+	Uploaded   uint64        `json:"uploaded"`
+	Downloaded uint64        `json:"downloaded"`
+	Operations uint64        `json:"operations"`
+	Errors     uint64        `json:"errors"`
+	Latency    time.Duration `json:"latency"`
+}
+
+// Add accumulates other into s
+func (s *BackendStats) Add(other BackendStats) {
+	s.Uploaded += other.Uploaded
+	s.Downloaded += other.Downloaded
+	s.Operations += other.Operations
+	s.Errors += other.Errors
+	s.Latency += other.Latency
+}
+
+// AverageLatency returns the average duration of an operation
+func (s BackendStats) AverageLatency() time.Duration {
+	if s.Operations == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Operations)
+}
+
+// String returns human-readable BackendStats
+func (s BackendStats) String() string {
+	return fmt.Sprintf("%v uploaded, %v downloaded, %d operations, %d errors, %v average latency",
+		SizeToString(s.Uploaded), SizeToString(s.Downloaded), s.Operations, s.Errors, s.AverageLatency())
+}
+
+// record accounts for a single operation that started at start
+func (s *BackendStats) record(start time.Time, uploaded, downloaded int, err error) {
+	atomic.AddUint64(&s.Uploaded, uint64(uploaded))
+	atomic.AddUint64(&s.Downloaded, uint64(downloaded))
+	atomic.AddUint64(&s.Operations, 1)
+	if err != nil {
+		atomic.AddUint64(&s.Errors, 1)
+	}
+	atomic.AddInt64((*int64)(&s.Latency), int64(time.Since(start)))
+}
+
+// load returns a copy of s, resetting s if reset is true
+func (s *BackendStats) load(reset bool) BackendStats {
+	if reset {
+		return BackendStats{
+			Uploaded:   atomic.SwapUint64(&s.Uploaded, 0),
+			Downloaded: atomic.SwapUint64(&s.Downloaded, 0),
+			Operations: atomic.SwapUint64(&s.Operations, 0),
+			Errors:     atomic.SwapUint64(&s.Errors, 0),
+			Latency:    time.Duration(atomic.SwapInt64((*int64)(&s.Latency), 0)),
+		}
+	}
+
+	return BackendStats{
+		Uploaded:   atomic.LoadUint64(&s.Uploaded),
+		Downloaded: atomic.LoadUint64(&s.Downloaded),
+		Operations: atomic.LoadUint64(&s.Operations),
+		Errors:     atomic.LoadUint64(&s.Errors),
+		Latency:    time.Duration(atomic.LoadInt64((*int64)(&s.Latency))),
+	}
+}
@@ -0,0 +1,38 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import "testing"
+
+func TestBackendStats(t *testing.T) {
+	var be Backend = NewStorageMemory()
+	bm := BackendManager{}
+	bm.AddBackend(&be)
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err := bm.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+	}
+	if _, err := bm.LoadChunk(chunk, 0); err != nil {
+		t.Errorf("Failed loading chunk: %s", err)
+	}
+	if _, err := bm.LoadSnapshot("missing"); err == nil {
+		t.Errorf("Expected loading missing snapshot to fail")
+	}
+
+	stats := bm.Stats(&be)
+	if stats.Uploaded != 10 || stats.Downloaded != 10 || stats.Operations != 3 || stats.Errors != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	stats = bm.TakeStats(&be)
+	if stats.Operations != 3 || bm.Stats(&be).Operations != 0 {
+		t.Errorf("Failed resetting stats: %+v %+v", stats, bm.Stats(&be))
+	}
+}
@@ -8,13 +8,13 @@
 package knoxite
 
 import (
-	"bytes"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"io"
-	"os"
+	"math"
+	"math/bits"
+	"runtime"
 	"sync"
 
 	"github.com/muesli/chunker"
@@ -27,6 +27,9 @@ const (
 	CompressionLZW
 	CompressionFlate
 	CompressionZlib
+	CompressionZstd
+	CompressionLZ4
+	CompressionXZ
 )
 
 // CompressionText returns a user-friendly string indicating the compression algo that was used
@@ -42,12 +45,51 @@ func CompressionText(enum int) string {
 		return "Flate"
 	case CompressionZlib:
 		return "zlib"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionLZ4:
+		return "LZ4"
+	case CompressionXZ:
+		return "xz"
 	}
 
 	return "unknown"
 }
 
-// Chunk stores an encrypted chunk alongside with its metadata
+// Which chunker splits files into chunks
+const (
+	ChunkerRabin = iota
+	ChunkerFixed
+	ChunkerFastCDC
+)
+
+// Error declarations
+var (
+	ErrUnknownChunker   = errors.New("Unknown chunker")
+	ErrInvalidChunkSize = errors.New("Invalid chunk size, expected min <= average <= max and at most 128MiB")
+)
+
+// maxChunkSize is the largest chunk size permitted, chunks are kept in memory
+// while they get processed
+const maxChunkSize = 128 * (1 << 20)
+
+// ChunkerText returns a user-friendly string indicating the chunker that was used
+func ChunkerText(enum int) string {
+	switch enum {
+	case ChunkerRabin:
+		return "Rabin"
+	case ChunkerFixed:
+		return "fixed"
+	case ChunkerFastCDC:
+		return "FastCDC"
+	}
+
+	return "unknown"
+}
+
+// Chunk stores an encrypted chunk alongside with its metadata. ShaSum
+// identifies the chunk on the backends, see chunkID. Volume is set if the
+// chunk got encrypted with the key of that volume
 // MUST BE encrypted
 type Chunk struct {
 	Data            *[][]byte `json:"-"`
@@ -60,6 +102,8 @@ type Chunk struct {
 	Encrypted       int       `json:"encrypted"`
 	Compressed      int       `json:"compressed"`
 	Num             uint      `json:"num"`
+	Degraded        bool      `json:"degraded,omitempty"`
+	Volume          string    `json:"volume,omitempty"`
 }
 
 type inputChunk struct {
@@ -67,116 +111,399 @@ type inputChunk struct {
 	Num  uint
 }
 
-func processChunk(id int, compress, encrypt bool, password string, dataParts, parityParts int, jobs <-chan inputChunk, results chan<- Chunk, wg *sync.WaitGroup) {
-	for j := range jobs {
-		//		fmt.Println("\tWorker", id, "processing job", j.Num, len(j.Data))
-
-		finalData := j.Data
-		if compress {
-			var compdata bytes.Buffer
-			zipwriter := gzip.NewWriter(&compdata)
-			zipwriter.Write(j.Data)
-			zipwriter.Close()
-			finalData = compdata.Bytes()
-		}
+// pipelineChunk is a chunk passing through the stages of a Chunker
+type pipelineChunk struct {
+	inputChunk
+	originalSize int
+	decshasum    string
+	compressed   int
+}
 
-		if encrypt {
-			encryptedData, err := Encrypt(finalData, password)
-			if err != nil {
-				panic(err)
-			}
+// encodeChunk compresses and encrypts data and splits the result into the
+// configured amount of data and parity parts
+func encodeChunk(data []byte, compression, level, encryption int, password string, idKey []byte, dataParts, parityParts int) (Chunk, error) {
+	finalData, compressed, err := compressChunk(data, compression, level)
+	if err != nil {
+		return Chunk{}, err
+	}
+	decshasumdata := sha256.Sum256(data)
 
-			finalData = encryptedData
-		}
-		shasumdata := sha256.Sum256(finalData)
-		shasum := hex.EncodeToString(shasumdata[:])
-		decshasumdata := sha256.Sum256(j.Data)
-		decshasum := hex.EncodeToString(decshasumdata[:])
+	return sealChunk(finalData, len(data), hex.EncodeToString(decshasumdata[:]), compressed, encryption, password, idKey, dataParts, parityParts)
+}
 
-		cd := Chunk{
-			DataParts:       uint(dataParts),
-			ParityParts:     uint(parityParts),
-			OriginalSize:    len(j.Data),
-			Size:            len(finalData),
-			DecryptedShaSum: decshasum,
-			ShaSum:          shasum,
-			Encrypted:       EncryptionAES,
-			Compressed:      CompressionNone,
-			Num:             j.Num,
-		}
-		if compress {
-			cd.Compressed = CompressionGZip
+// sealChunk encrypts the compressed data of a chunk and splits it into the
+// configured amount of data and parity parts
+func sealChunk(finalData []byte, originalSize int, decshasum string, compressed, encryption int, password string, idKey []byte, dataParts, parityParts int) (Chunk, error) {
+	if encryption != EncryptionNone {
+		encryptedData, err := EncryptWith(finalData, password, encryption)
+		if err != nil {
+			return Chunk{}, err
 		}
-		if !encrypt {
-			cd.Encrypted = EncryptionNone
+
+		finalData = encryptedData
+	}
+	shasum := chunkID(finalData, idKey)
+
+	cd := Chunk{
+		DataParts:       uint(dataParts),
+		ParityParts:     uint(parityParts),
+		OriginalSize:    originalSize,
+		Size:            len(finalData),
+		DecryptedShaSum: decshasum,
+		ShaSum:          shasum,
+		Encrypted:       encryption,
+		Compressed:      compressed,
+	}
+	if parityParts > 0 {
+		pars, err := redundantData(finalData, dataParts, parityParts)
+		if err != nil {
+			return cd, err
 		}
-		if parityParts > 0 {
-			pars, err := redundantData(finalData, dataParts, parityParts)
-			if err != nil {
-				panic(err)
-			}
-			cd.Data = &pars
-		} else {
-			cd.DataParts = 1
-			cd.Data = &[][]byte{finalData}
+		cd.Data = &pars
+	} else {
+		cd.DataParts = 1
+		cd.Data = &[][]byte{finalData}
+	}
+
+	return cd, nil
+}
+
+// fileChunk is the default size of fixed chunks and the default average
+// size of content-defined chunks
+const fileChunk = 1 * (1 << 20) // 1 MB, change this to your requirement
+
+// ChunkSize limits the size of chunks. Zero values select the defaults,
+// derived from the average size. The fixed chunker only uses Average
+type ChunkSize struct {
+	Min     int `json:"min,omitempty"`
+	Average int `json:"average,omitempty"`
+	Max     int `json:"max,omitempty"`
+}
+
+// override returns size with all values set in o replacing its own
+func (size ChunkSize) override(o ChunkSize) ChunkSize {
+	if o.Min > 0 {
+		size.Min = o.Min
+	}
+	if o.Average > 0 {
+		size.Average = o.Average
+	}
+	if o.Max > 0 {
+		size.Max = o.Max
+	}
+	return size
+}
+
+// resolve fills in the defaults and validates the resulting sizes
+func (size ChunkSize) resolve() (ChunkSize, error) {
+	if size.Average == 0 {
+		size.Average = fileChunk
+	}
+	if size.Min == 0 {
+		size.Min = size.Average / 2
+	}
+	if size.Max == 0 {
+		size.Max = size.Average * 8
+	}
+
+	if size.Min <= 0 || size.Min > size.Average || size.Average > size.Max || size.Max > maxChunkSize {
+		return size, ErrInvalidChunkSize
+	}
+	return size, nil
+}
+
+// CheckChunkSize returns ErrInvalidChunkSize unless size is valid
+func CheckChunkSize(size ChunkSize) error {
+	_, err := size.resolve()
+	return err
+}
+
+// A splitter divides a stream of data into chunks
+type splitter interface {
+	// next returns the next chunk or io.EOF once all data got consumed
+	next() ([]byte, error)
+}
+
+// newSplitter returns a splitter for rd using the given chunker and sizes
+func newSplitter(rd io.Reader, algo int, size ChunkSize) (splitter, error) {
+	size, err := size.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	switch algo {
+	case ChunkerRabin:
+		c := chunker.NewWithBoundaries(rd, chunker.Pol(0x3DA3358B4DC173), uint(size.Min), uint(size.Max))
+		c.SetAverageBits(bits.Len(uint(size.Average)) - 1)
+		return &rabinSplitter{c, size.Average}, nil
+	case ChunkerFixed:
+		return &fixedSplitter{rd, size.Average}, nil
+	case ChunkerFastCDC:
+		return newFastCDC(rd, size.Min, size.Average, size.Max), nil
+	}
+
+	return nil, ErrUnknownChunker
+}
+
+// rabinSplitter finds content-defined chunk boundaries with a Rabin
+// fingerprint
+type rabinSplitter struct {
+	chunker *chunker.Chunker
+	size    int
+}
+
+func (s *rabinSplitter) next() ([]byte, error) {
+	partBuffer := make([]byte, s.size)
+	chunk, err := s.chunker.Next(partBuffer)
+	return chunk.Data, err
+}
+
+// fixedSplitter divides data into chunks of equal size. Inserting data shifts
+// all following boundaries, which defeats deduplication
+type fixedSplitter struct {
+	rd   io.Reader
+	size int
+}
+
+func (s *fixedSplitter) next() ([]byte, error) {
+	partBuffer := make([]byte, s.size)
+	n, err := io.ReadFull(s.rd, partBuffer)
+	if n == 0 {
+		if err == nil || err == io.ErrUnexpectedEOF {
+			err = io.EOF
 		}
+		return nil, err
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return partBuffer[:n], err
+}
 
-		results <- cd
-		wg.Done()
+// Pipeline configures how many workers each stage of storing data uses.
+// Chunks get hashed, compressed, encrypted and uploaded by separate workers,
+// so all stages keep running in parallel. Zero values select the defaults
+type Pipeline struct {
+	// Hashers hash chunks to look them up in the dedup index, defaults to
+	// the number of CPUs
+	Hashers int
+	// Compressors default to the number of CPUs
+	Compressors int
+	// Encryptors encrypt chunks and compute their parity parts, defaults to
+	// the number of CPUs
+	Encryptors int
+	// Uploaders store chunks on the backends, defaults to as many
+	// connections as the backends allow
+	Uploaders int
+}
+
+// resolve fills in the defaults of all stages but the uploaders, which depend
+// on the backends
+func (p Pipeline) resolve() Pipeline {
+	for _, workers := range []*int{&p.Hashers, &p.Compressors, &p.Encryptors} {
+		if *workers <= 0 {
+			*workers = runtime.NumCPU()
+		}
 	}
+	return p
 }
 
-// chunkFile divides filename into chunks of 1MiB each
-func chunkFile(filename string, compress, encrypt bool, password string, dataParts, parityParts int) (chan Chunk, error) {
-	c := make(chan Chunk)
+// A Chunker splits streams of data into chunks, compressing and encrypting
+// them the way its repository expects. It doesn't store the chunks
+type Chunker struct {
+	algo        int
+	size        ChunkSize
+	compression int
+	level       int
+	encryption  int
+	secret      string
+	idKey       []byte
+	dataParts   int
+	parityParts int
+	dedup       *dedupIndex
+	volume      string
+	pipeline    Pipeline
+	deltaBlock  int
+}
 
-	file, err := os.Open(filename)
+// NewChunker returns a Chunker for data getting stored in volume, which may
+// be nil. Compression rules of opts are ignored
+func (r *Repository) NewChunker(volume *Volume, opts StoreOptions) (Chunker, error) {
+	if err := opts.checkCompression(); err != nil {
+		return Chunker{}, err
+	}
+	if r.Chunker < ChunkerRabin || r.Chunker > ChunkerFastCDC {
+		return Chunker{}, ErrUnknownChunker
+	}
+	size, err := r.ChunkSize.override(opts.ChunkSize).resolve()
 	if err != nil {
-		fmt.Println(err)
-		return c, err
+		return Chunker{}, err
+	}
+	deltaBlock := opts.DeltaBlockSize
+	if deltaBlock == 0 {
+		deltaBlock = deltaBlockSize
+	}
+	if deltaBlock < 0 || deltaBlock > maxChunkSize {
+		return Chunker{}, ErrInvalidChunkSize
+	}
+	secret, err := r.volumeSecret(volume)
+	if err != nil {
+		return Chunker{}, err
+	}
+	volumeID := ""
+	if volume != nil && volume.Key != nil {
+		volumeID = volume.ID
 	}
 
-	const fileChunk = 1 * (1 << 20) // 1 MB, change this to your requirement
+	return Chunker{
+		algo:        r.Chunker,
+		size:        size,
+		compression: opts.Compression,
+		level:       opts.CompressionLevel,
+		encryption:  opts.Encryption,
+		secret:      secret,
+		idKey:       r.chunkIDKey(secret),
+		dataParts:   int(math.Max(1, float64(opts.DataParts))),
+		parityParts: int(opts.ParityParts),
+		dedup:       r.dedup,
+		volume:      volumeID,
+		pipeline:    opts.Pipeline.resolve(),
+		deltaBlock:  deltaBlock,
+	}, nil
+}
 
-	wg := &sync.WaitGroup{}
-	jobs := make(chan inputChunk)
-	for w := 1; w <= 4; w++ {
-		go processChunk(w, compress, encrypt, password, dataParts, parityParts, jobs, c, wg)
+// Chunks reads rd until EOF and emits its chunks, numbered by their position
+// but in no particular order. Chunks already stored in the repository are
+// emitted without Data, they only need to be referenced. Once all chunks got
+// emitted, the error channel delivers the first error that occurred, if any
+func (c Chunker) Chunks(rd io.Reader) (<-chan Chunk, <-chan error) {
+	split, err := newSplitter(rd, c.algo, c.size)
+	if err != nil {
+		chunks := make(chan Chunk)
+		errs := make(chan error, 1)
+		errs <- err
+		close(chunks)
+		close(errs)
+		return chunks, errs
 	}
 
-	wg.Add(1)
+	return c.run(split)
+}
+
+// run passes the chunks of split through the pipeline
+func (c Chunker) run(split splitter) (<-chan Chunk, <-chan error) {
+	chunks := make(chan Chunk)
+	errs := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	// Every stage closes its output once all its workers finished
+	raw := make(chan inputChunk, c.pipeline.Hashers)
+	hashed := make(chan pipelineChunk, c.pipeline.Compressors)
+	compressed := make(chan pipelineChunk, c.pipeline.Encryptors)
+
 	go func() {
-		chunker := chunker.New(file, chunker.Pol(0x3DA3358B4DC173))
-
-		i := uint(0)
-		for {
-			partBuffer := make([]byte, fileChunk)
-			chunk, err := chunker.Next(partBuffer)
-			if err == io.EOF {
-				wg.Done()
-				break
-			}
+		for i := uint(0); ; i++ {
+			data, err := split.next()
 			if err != nil {
-				panic(err)
-			}
-
-			wg.Add(1)
-			j := inputChunk{
-				Data: chunk.Data,
-				Num:  i,
+				if err != io.EOF {
+					report(err)
+				}
+				break
 			}
-
-			i++
-			jobs <- j
+			raw <- inputChunk{Data: data, Num: i}
 		}
-		file.Close()
+		close(raw)
 	}()
+	runStage(c.pipeline.Hashers, func() {
+		c.hash(raw, hashed, chunks)
+	}, func() {
+		close(hashed)
+	})
+	runStage(c.pipeline.Compressors, func() {
+		c.compress(hashed, compressed, report)
+	}, func() {
+		close(compressed)
+	})
+	runStage(c.pipeline.Encryptors, func() {
+		c.encrypt(compressed, chunks, report)
+	}, func() {
+		close(chunks)
+		close(errs)
+	})
+
+	return chunks, errs
+}
+
+// runStage runs work on the given amount of workers and calls done once all
+// of them returned
+func runStage(workers int, work func(), done func()) {
+	wg := &sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
 
 	go func() {
 		wg.Wait()
-		close(jobs)
-		close(c)
+		done()
 	}()
+}
+
+// hash computes the hash of the chunks it receives. Chunks found in the dedup
+// index skip the remaining stages
+func (c Chunker) hash(jobs <-chan inputChunk, results chan<- pipelineChunk, stored chan<- Chunk) {
+	for j := range jobs {
+		decshasumdata := sha256.Sum256(j.Data)
+		decshasum := hex.EncodeToString(decshasumdata[:])
+
+		if c.dedup != nil {
+			key := dedupKey(decshasum, c.volume, c.encryption, uint(c.dataParts), uint(c.parityParts))
+			if cd, ok := c.dedup.lookup(key); ok {
+				// Already stored, Data stays nil so it doesn't get stored again
+				cd.Num = j.Num
+				stored <- cd
+				continue
+			}
+		}
+
+		results <- pipelineChunk{inputChunk: j, originalSize: len(j.Data), decshasum: decshasum}
+	}
+}
 
-	return c, nil
+// compress compresses the chunks it receives
+func (c Chunker) compress(jobs <-chan pipelineChunk, results chan<- pipelineChunk, report func(error)) {
+	for j := range jobs {
+		data, compressed, err := compressChunk(j.Data, c.compression, c.level)
+		if err != nil {
+			report(err)
+			continue
+		}
+
+		j.Data = data
+		j.compressed = compressed
+		results <- j
+	}
+}
+
+// encrypt encrypts the chunks it receives and splits them into parts
+func (c Chunker) encrypt(jobs <-chan pipelineChunk, results chan<- Chunk, report func(error)) {
+	for j := range jobs {
+		cd, err := sealChunk(j.Data, j.originalSize, j.decshasum, j.compressed, c.encryption, c.secret, c.idKey, c.dataParts, c.parityParts)
+		if err != nil {
+			report(err)
+			continue
+		}
+		cd.Num = j.Num
+		cd.Volume = c.volume
+
+		results <- cd
+	}
 }
@@ -0,0 +1,123 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestChunker(t *testing.T) {
+	r, err := NewRepository("memory://chunker", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	c, err := r.NewChunker(nil, StoreOptions{Encryption: EncryptionAESGCM, ChunkSize: ChunkSize{Average: 4096}})
+	if err != nil {
+		t.Errorf("Failed creating chunker: %s", err)
+		return
+	}
+
+	data := make([]byte, 1<<16)
+	rand.New(rand.NewSource(42)).Read(data)
+	chunks, errs := c.Chunks(bytes.NewReader(data))
+	ordered := make(map[uint]Chunk)
+	for chunk := range chunks {
+		ordered[chunk.Num] = chunk
+	}
+	if err = <-errs; err != nil {
+		t.Errorf("Failed chunking data: %s", err)
+		return
+	}
+
+	restored := []byte{}
+	for i := uint(0); i < uint(len(ordered)); i++ {
+		chunk := ordered[i]
+		b, err := decodeChunk(r, chunk, (*chunk.Data)[0])
+		if err != nil {
+			t.Errorf("Failed decoding chunk %d: %s", i, err)
+			return
+		}
+		restored = append(restored, b...)
+	}
+	if !bytes.Equal(restored, data) {
+		t.Errorf("Chunks don't add up to the original data")
+	}
+
+	readErr := errors.New("read failed")
+	chunks, errs = c.Chunks(io.MultiReader(bytes.NewReader(data), failingReader{readErr}))
+	for range chunks {
+	}
+	if err = <-errs; err != readErr {
+		t.Errorf("Expected %v, got %v", readErr, err)
+	}
+
+	r.Chunker = -1
+	if _, err = r.NewChunker(nil, StoreOptions{}); err != ErrUnknownChunker {
+		t.Errorf("Expected %v, got %v", ErrUnknownChunker, err)
+	}
+}
+
+func TestChunkerPipeline(t *testing.T) {
+	r, err := NewRepository("memory://chunkerpipeline", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+
+	data := make([]byte, 1<<16)
+	rand.New(rand.NewSource(23)).Read(data)
+	for _, p := range []Pipeline{{}, {Hashers: 1, Compressors: 1, Encryptors: 1}, {Hashers: 1, Compressors: 3, Encryptors: 2}} {
+		c, err := r.NewChunker(nil, StoreOptions{
+			Compression: CompressionGZip,
+			Encryption:  EncryptionAESGCM,
+			ChunkSize:   ChunkSize{Average: 4096},
+			Pipeline:    p,
+		})
+		if err != nil {
+			t.Errorf("Failed creating chunker: %s", err)
+			return
+		}
+
+		chunks, errs := c.Chunks(bytes.NewReader(data))
+		ordered := make(map[uint]Chunk)
+		for chunk := range chunks {
+			ordered[chunk.Num] = chunk
+		}
+		if err = <-errs; err != nil {
+			t.Errorf("Failed chunking data with pipeline %+v: %s", p, err)
+			return
+		}
+
+		restored := []byte{}
+		for i := uint(0); i < uint(len(ordered)); i++ {
+			chunk := ordered[i]
+			b, err := decodeChunk(r, chunk, (*chunk.Data)[0])
+			if err != nil {
+				t.Errorf("Failed decoding chunk %d: %s", i, err)
+				return
+			}
+			restored = append(restored, b...)
+		}
+		if !bytes.Equal(restored, data) {
+			t.Errorf("Chunks of pipeline %+v don't add up to the original data", p)
+		}
+	}
+}
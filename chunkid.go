@@ -0,0 +1,135 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// chunkID returns the ID a chunk's data gets stored under. Without a key it's
+// the plain SHA-256 of data, which would let anyone knowing a file confirm
+// that it's stored in the repository
+func chunkID(data, key []byte) string {
+	if key == nil {
+		shasum := sha256.Sum256(data)
+		return hex.EncodeToString(shasum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deriveChunkIDKey derives the key chunk IDs get computed with from the key
+// the chunks get encrypted with
+func deriveChunkIDKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("knoxite chunk id"))
+	return mac.Sum(nil)
+}
+
+// chunkIDKey returns the key new chunk IDs get computed with, or nil if the
+// repository predates keyed chunk IDs
+func (r *Repository) chunkIDKey(secret string) []byte {
+	if r.Version < 1 {
+		return nil
+	}
+	return deriveChunkIDKey(secret)
+}
+
+// MigrateChunkID stores chunk under its keyed ID. Returns the new ID, which
+// matches the current one if chunk already got migrated
+func (r *Repository) MigrateChunkID(chunk Chunk) (string, error) {
+	data, err := loadChunkData(*r, chunk)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := r.chunkSecret(chunk)
+	if err != nil {
+		return "", err
+	}
+	id := chunkID(data, deriveChunkIDKey(secret))
+	if id == chunk.ShaSum {
+		return id, nil
+	}
+	if shasum := chunkID(data, nil); shasum != chunk.ShaSum {
+		return "", &CheckSumError{"sha256", chunk.ShaSum, shasum}
+	}
+
+	migrated := chunk
+	migrated.ShaSum = id
+	if chunk.ParityParts > 0 {
+		pars, err := redundantData(data, int(chunk.DataParts), int(chunk.ParityParts))
+		if err != nil {
+			return "", err
+		}
+		migrated.Data = &pars
+	} else {
+		migrated.Data = &[][]byte{data}
+	}
+
+	_, err = r.Backend.StoreChunk(&migrated)
+	return id, err
+}
+
+// CompleteChunkIDMigration points all snapshots to the chunks migrated with
+// MigrateChunkID and bumps the repository's format version. ids maps the old
+// chunk IDs to the new ones. Afterwards the chunks get deleted from their old
+// location
+func (r *Repository) CompleteChunkIDMigration(ids map[string]string) error {
+	obsolete := []Chunk{}
+	seen := make(map[string]bool)
+	for _, volume := range r.Volumes {
+		for _, snapshotID := range volume.Snapshots {
+			snapshot, err := volume.LoadSnapshot(snapshotID, r)
+			if err != nil {
+				return err
+			}
+
+			changed := false
+			for i := range snapshot.Items {
+				for j, chunk := range snapshot.Items[i].Chunks {
+					id, ok := ids[chunk.ShaSum]
+					if !ok || id == chunk.ShaSum {
+						continue
+					}
+					if key := partName(chunk.ShaSum, 0, chunk.DataParts); !seen[key] {
+						seen[key] = true
+						obsolete = append(obsolete, chunk)
+					}
+					snapshot.Items[i].Chunks[j].ShaSum = id
+					changed = true
+				}
+			}
+			if changed {
+				if err = snapshot.Save(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	r.Version = repositoryVersion
+	// The index still refers to the old IDs, it gets rebuilt on the next store
+	if err := r.resetDedupIndex(); err != nil {
+		return err
+	}
+	if err := r.Save(); err != nil {
+		return err
+	}
+
+	for _, chunk := range obsolete {
+		if err := r.Backend.DeleteChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrateChunkIDs(t *testing.T) {
+	r, err := NewRepository("memory://chunkid", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	// Store data the way repositories predating keyed chunk IDs did
+	r.Version = 0
+
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	snapshot, _ := NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"chunkid_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+	snapshot.Save(&r)
+	vol.AddSnapshot(snapshot.ID)
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://chunkid", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if r.ChunkIDsKeyed() {
+		t.Errorf("Repository should still use plain chunk IDs")
+	}
+
+	chunks, _ := r.Chunks()
+	ids := make(map[string]string)
+	for _, chunk := range chunks {
+		data, _ := loadChunkData(r, chunk)
+		if chunk.ShaSum != chunkID(data, nil) {
+			t.Errorf("Expected plain chunk ID, got %s", chunk.ShaSum)
+		}
+
+		id, err := r.MigrateChunkID(chunk)
+		if err != nil {
+			t.Errorf("Failed migrating chunk: %s", err)
+			return
+		}
+		if id != chunkID(data, deriveChunkIDKey(r.secret())) {
+			t.Errorf("Expected keyed chunk ID, got %s", id)
+		}
+		ids[chunk.ShaSum] = id
+	}
+	if err = r.CompleteChunkIDMigration(ids); err != nil {
+		t.Errorf("Failed completing migration: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://chunkid", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if !r.ChunkIDsKeyed() {
+		t.Errorf("Repository did not get migrated")
+	}
+	for old := range ids {
+		if _, err := MemoryStorage("chunkid").StatChunk(old, 0, 1); err == nil {
+			t.Errorf("Chunk %s did not get deleted", old)
+		}
+	}
+
+	_, s, err := r.FindSnapshot(snapshot.ID)
+	if err != nil {
+		t.Errorf("Failed finding snapshot: %s", err)
+		return
+	}
+	data, _, err := DecodeArchiveData(r, s.Items[0])
+	if err != nil {
+		t.Errorf("Failed restoring migrated data: %s", err)
+		return
+	}
+	original, _ := ioutil.ReadFile("chunkid_test.go")
+	if string(data) != string(original) {
+		t.Errorf("Restored data does not match the original")
+	}
+
+	// Migrating twice leaves chunks untouched
+	chunks, _ = r.Chunks()
+	if id, err := r.MigrateChunkID(chunks[0]); err != nil || id != chunks[0].ShaSum {
+		t.Errorf("Expected chunk %s to be migrated already, got %s (%v)", chunks[0].ShaSum, id, err)
+	}
+}
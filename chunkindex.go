@@ -0,0 +1,163 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"strconv"
+	"sync"
+)
+
+// chunkIndex remembers the locations of the backends holding each chunk part,
+// so loading a part doesn't have to probe every backend
+type chunkIndex struct {
+	sync.RWMutex
+	parts map[string][]string
+	// pending maps the parts changed since the last location file got written
+	// to their locations, which are empty for parts that got removed
+	pending map[string][]string
+}
+
+// partName returns the name (SHASUM.PART_TOTALPARTS) of a chunk part
+func partName(shasum string, part, totalParts uint) string {
+	return shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+}
+
+func newChunkIndex(parts map[string][]string) *chunkIndex {
+	index := &chunkIndex{
+		parts:   make(map[string][]string),
+		pending: make(map[string][]string),
+	}
+	for name, locations := range parts {
+		index.parts[name] = append([]string{}, locations...)
+	}
+	return index
+}
+
+// add records that the backend at location holds the part name
+func (index *chunkIndex) add(name, location string) {
+	if index == nil {
+		return
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	for _, l := range index.parts[name] {
+		if l == location {
+			return
+		}
+	}
+	index.parts[name] = append(index.parts[name], location)
+	index.pending[name] = append([]string{}, index.parts[name]...)
+}
+
+// remove records that the backend at location doesn't hold the part name
+func (index *chunkIndex) remove(name, location string) {
+	if index == nil {
+		return
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	locations := []string{}
+	for _, l := range index.parts[name] {
+		if l != location {
+			locations = append(locations, l)
+		}
+	}
+	if len(locations) == len(index.parts[name]) {
+		return
+	}
+	if len(locations) == 0 {
+		delete(index.parts, name)
+	} else {
+		index.parts[name] = locations
+	}
+	index.pending[name] = append([]string{}, locations...)
+}
+
+// merge applies the changes of a location file
+func (index *chunkIndex) merge(parts map[string][]string) {
+	if index == nil {
+		return
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	for name, locations := range parts {
+		if len(locations) == 0 {
+			delete(index.parts, name)
+		} else {
+			index.parts[name] = append([]string{}, locations...)
+		}
+	}
+}
+
+// takePending returns the changes since the last call
+func (index *chunkIndex) takePending() map[string][]string {
+	if index == nil {
+		return nil
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	parts := index.pending
+	index.pending = make(map[string][]string)
+	return parts
+}
+
+// restorePending marks changes as pending again, e.g. if they couldn't be
+// written. Parts that changed again in the meantime keep their newer state
+func (index *chunkIndex) restorePending(parts map[string][]string) {
+	if index == nil {
+		return
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	for name, locations := range parts {
+		if _, ok := index.pending[name]; !ok {
+			index.pending[name] = locations
+		}
+	}
+}
+
+// removeLocation forgets all parts held by the backend at location
+func (index *chunkIndex) removeLocation(location string) {
+	if index == nil {
+		return
+	}
+	for name := range index.copy() {
+		index.remove(name, location)
+	}
+}
+
+// locations returns the locations of the backends known to hold the part name
+func (index *chunkIndex) locations(name string) []string {
+	if index == nil {
+		return nil
+	}
+	index.RLock()
+	defer index.RUnlock()
+
+	return index.parts[name]
+}
+
+// copy returns the index as a plain map
+func (index *chunkIndex) copy() map[string][]string {
+	parts := make(map[string][]string)
+	if index == nil {
+		return parts
+	}
+	index.RLock()
+	defer index.RUnlock()
+
+	for name, locations := range index.parts {
+		parts[name] = append([]string{}, locations...)
+	}
+	return parts
+}
@@ -0,0 +1,137 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// countingStorage is a backend counting how often chunks got loaded from it
+type countingStorage struct {
+	*StorageMemory
+	loads *int32
+}
+
+func (backend countingStorage) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	atomic.AddInt32(backend.loads, 1)
+	return backend.StorageMemory.LoadChunk(shasum, part, totalParts)
+}
+
+func TestChunkIndex(t *testing.T) {
+	var loads int32
+	bm := BackendManager{}
+	backends := []Backend{}
+	for i := 0; i < 4; i++ {
+		backends = append(backends, countingStorage{MemoryStorage(fmt.Sprintf("index%d", i)), &loads})
+	}
+	for i := range backends {
+		bm.AddBackend(&backends[i])
+	}
+
+	chunks := []Chunk{}
+	for i := 0; i < 20; i++ {
+		data := []byte{byte(i)}
+		chunk := Chunk{ShaSum: fmt.Sprintf("%064x", i), DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+		if _, err := bm.StoreChunk(&chunk); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+			return
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// The index can be restored from a copy
+	index := bm.Index()
+	if len(index) != len(chunks) {
+		t.Errorf("Expected %d parts in index, got %d", len(chunks), len(index))
+	}
+	bm.SetIndex(index)
+
+	for _, chunk := range chunks {
+		if _, err := bm.LoadChunk(chunk, 0); err != nil {
+			t.Errorf("Failed loading chunk: %s", err)
+		}
+	}
+	if loads != int32(len(chunks)) {
+		t.Errorf("Expected %d loads, got %d", len(chunks), loads)
+	}
+
+	// A stale index only costs some additional loads
+	bm.SetIndex(nil)
+	for _, chunk := range chunks {
+		if _, err := bm.LoadChunk(chunk, 0); err != nil {
+			t.Errorf("Failed loading chunk without index: %s", err)
+		}
+	}
+
+	if err := bm.DeleteChunk(chunks[0]); err != nil {
+		t.Errorf("Failed deleting chunk: %s", err)
+	}
+	bm.SetIndex(index)
+	bm.DeleteChunk(chunks[1])
+	if l := bm.Index()[partName(chunks[1].ShaSum, 0, 1)]; len(l) != 0 {
+		t.Errorf("Expected deleted chunk to be removed from index, got %v", l)
+	}
+}
+
+func TestChunkIndexLocationFiles(t *testing.T) {
+	defer func(dir string) { indexCacheDir = dir }(indexCacheDir)
+	indexCacheDir = ""
+
+	r, err := NewRepository("memory://locations-first", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	second, _ := BackendFromURL("memory://locations-second")
+	r.Backend.AddBackend(&second)
+
+	data := []byte("1234567890")
+	chunks := []Chunk{}
+	for _, shasum := range []string{"abcdef", "fedcba"} {
+		chunk := Chunk{ShaSum: shasum, DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+		if _, err = r.Backend.StoreChunk(&chunk); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+			return
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+	if err = r.Backend.DeleteChunk(chunks[0]); err != nil {
+		t.Errorf("Failed deleting chunk: %s", err)
+		return
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+	if len(r.LocationFiles) != 2 {
+		t.Errorf("Expected 2 location files, got %d", len(r.LocationFiles))
+	}
+
+	r, err = OpenRepository("memory://locations-first", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if bytes.Contains(r.RawJSON, []byte(chunks[1].ShaSum)) {
+		t.Errorf("Expected the index not to be stored in the repository metadata")
+	}
+	index := r.Backend.Index()
+	if l := index[partName(chunks[0].ShaSum, 0, 1)]; len(l) != 0 {
+		t.Errorf("Expected deleted chunk to be removed from index, got %v", l)
+	}
+	if l := index[partName(chunks[1].ShaSum, 0, 1)]; len(l) != 1 {
+		t.Errorf("Expected chunk to be indexed, got %v", l)
+	}
+}
@@ -0,0 +1,301 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+// Error declarations
+var (
+	ErrUnknownCompression      = errors.New("Unknown compression algorithm")
+	ErrInvalidCompressionLevel = errors.New("Invalid compression level")
+)
+
+// zstd encoders are expensive to set up, but safe for concurrent use
+var (
+	zstdMutex    sync.Mutex
+	zstdEncoders = make(map[int]*zstd.Encoder)
+	zstdDecoder  *zstd.Decoder
+)
+
+// incompressibleExtensions lists file types which are compressed already.
+// Compressing them again would only waste CPU time
+var incompressibleExtensions = map[string]bool{
+	".7z": true, ".apk": true, ".avi": true, ".bz2": true, ".docx": true,
+	".flac": true, ".gif": true, ".gz": true, ".jar": true, ".jpeg": true,
+	".jpg": true, ".lz4": true, ".m4a": true, ".mkv": true, ".mov": true,
+	".mp3": true, ".mp4": true, ".ogg": true, ".png": true, ".pptx": true,
+	".rar": true, ".tgz": true, ".webm": true, ".webp": true, ".xlsx": true,
+	".xz": true, ".zip": true, ".zst": true,
+}
+
+// Chunks get trial-compressed by their first compressionSample bytes. If
+// that doesn't shrink them below compressibleRatio, they're stored
+// uncompressed
+const (
+	compressionSample = 64 * 1024
+	compressibleRatio = 0.95
+)
+
+// xzDictCaps maps the xz presets 1 to 9 to their dictionary sizes
+var xzDictCaps = []int{1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// checkCompression returns an error if algo isn't known or doesn't support
+// level
+func checkCompression(algo, level int) error {
+	switch algo {
+	case CompressionNone, CompressionLZ4:
+		if level != 0 {
+			return ErrInvalidCompressionLevel
+		}
+		return nil
+	case CompressionGZip:
+		if level < 0 || level > gzip.BestCompression {
+			return ErrInvalidCompressionLevel
+		}
+		return nil
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return ErrInvalidCompressionLevel
+		}
+		return nil
+	case CompressionXZ:
+		if level < 0 || level > len(xzDictCaps) {
+			return ErrInvalidCompressionLevel
+		}
+		return nil
+	}
+
+	return ErrUnknownCompression
+}
+
+// CompressionRule overrides the compression of files matching Pattern
+type CompressionRule struct {
+	// Pattern is a shell pattern like "*.sql". It gets matched against the
+	// file's name, or against its path if the pattern contains a slash
+	Pattern     string
+	Compression int
+	Level       int
+}
+
+// matches returns true if the rule applies to the file at path
+func (rule CompressionRule) matches(path string) bool {
+	name := path
+	if !strings.Contains(rule.Pattern, "/") {
+		name = filepath.Base(path)
+	}
+	ok, _ := filepath.Match(rule.Pattern, filepath.ToSlash(name))
+	return ok
+}
+
+// checkCompression validates the compression settings and rules
+func (opts StoreOptions) checkCompression() error {
+	if err := checkCompression(opts.Compression, opts.CompressionLevel); err != nil {
+		return err
+	}
+	for _, rule := range opts.CompressionRules {
+		if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+			return err
+		}
+		if err := checkCompression(rule.Compression, rule.Level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressionFor returns the compression algo and level for the file at path
+func (opts StoreOptions) compressionFor(path string) (int, int) {
+	for _, rule := range opts.CompressionRules {
+		if rule.matches(path) {
+			return rule.Compression, rule.Level
+		}
+	}
+
+	if !compressible(path) {
+		return CompressionNone, 0
+	}
+	return opts.Compression, opts.CompressionLevel
+}
+
+// compressible returns false for files of a type which is compressed already
+func compressible(filename string) bool {
+	return !incompressibleExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// compressChunk compresses data, unless it turns out to be incompressible.
+// Returns the compression algo that got applied
+func compressChunk(data []byte, algo, level int) ([]byte, int, error) {
+	if algo == CompressionNone {
+		return data, CompressionNone, nil
+	}
+
+	if len(data) > compressionSample {
+		sample, err := compress(data[:compressionSample], algo, level)
+		if err != nil {
+			return nil, algo, err
+		}
+		if float64(len(sample)) > compressibleRatio*compressionSample {
+			return data, CompressionNone, nil
+		}
+	}
+
+	compressed, err := compress(data, algo, level)
+	if err != nil {
+		return nil, algo, err
+	}
+	if len(compressed) >= len(data) {
+		return data, CompressionNone, nil
+	}
+	return compressed, algo, nil
+}
+
+// compress compresses data using the given compression algo. Level 0 selects
+// the algo's default level
+func compress(data []byte, algo, level int) ([]byte, error) {
+	switch algo {
+	case CompressionNone:
+		return data, nil
+
+	case CompressionGZip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		var b bytes.Buffer
+		w, err := gzip.NewWriterLevel(&b, level)
+		if err != nil {
+			return nil, ErrInvalidCompressionLevel
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+
+	case CompressionZstd:
+		enc, err := zstdEncoder(level)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, nil), nil
+
+	case CompressionLZ4:
+		var b bytes.Buffer
+		w := lz4.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+
+	case CompressionXZ:
+		if level == 0 {
+			level = 6
+		}
+		if level < 1 || level > len(xzDictCaps) {
+			return nil, ErrInvalidCompressionLevel
+		}
+
+		var b bytes.Buffer
+		w, err := xz.WriterConfig{DictCap: xzDictCaps[level-1]}.NewWriter(&b)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(data); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	return nil, ErrUnknownCompression
+}
+
+// decompress decompresses data, which got compressed using the given
+// compression algo
+func decompress(data []byte, algo int) ([]byte, error) {
+	switch algo {
+	case CompressionNone:
+		return data, nil
+
+	case CompressionGZip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+
+	case CompressionZstd:
+		zstdMutex.Lock()
+		if zstdDecoder == nil {
+			dec, err := zstd.NewReader(nil)
+			if err != nil {
+				zstdMutex.Unlock()
+				return nil, err
+			}
+			zstdDecoder = dec
+		}
+		dec := zstdDecoder
+		zstdMutex.Unlock()
+		return dec.DecodeAll(data, nil)
+
+	case CompressionLZ4:
+		return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+
+	case CompressionXZ:
+		r, err := xz.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+
+	return nil, ErrUnknownCompression
+}
+
+// zstdEncoder returns the shared zstd encoder for level, which ranges from 1
+// (fastest) to 22 (smallest)
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	if level == 0 {
+		level = 3
+	}
+	if level < 1 || level > 22 {
+		return nil, ErrInvalidCompressionLevel
+	}
+
+	zstdMutex.Lock()
+	defer zstdMutex.Unlock()
+	if enc, ok := zstdEncoders[level]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[level] = enc
+	return enc, nil
+}
@@ -0,0 +1,119 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	b := bytes.Repeat([]byte("knoxite compresses repetitive data "), 1024)
+
+	for _, algo := range []int{CompressionNone, CompressionGZip, CompressionZstd, CompressionLZ4, CompressionXZ} {
+		bc, err := compress(b, algo, 0)
+		if err != nil {
+			t.Errorf("Failed compressing with %s: %s", CompressionText(algo), err)
+			continue
+		}
+		if algo != CompressionNone && len(bc) >= len(b) {
+			t.Errorf("%s did not compress the data: %d >= %d bytes", CompressionText(algo), len(bc), len(b))
+		}
+
+		bd, err := decompress(bc, algo)
+		if err != nil || !bytes.Equal(b, bd) {
+			t.Errorf("Data mismatch after %s compression & decompression cycle: %v", CompressionText(algo), err)
+		}
+	}
+
+	if bc, err := compress(b, CompressionZstd, 19); err != nil {
+		t.Errorf("Failed compressing with zstd level 19: %s", err)
+	} else if bd, _ := decompress(bc, CompressionZstd); !bytes.Equal(b, bd) {
+		t.Errorf("Data mismatch after zstd level 19 compression & decompression cycle")
+	}
+
+	if err := checkCompression(CompressionXZ, 10); err != ErrInvalidCompressionLevel {
+		t.Errorf("Expected %v, got %v", ErrInvalidCompressionLevel, err)
+	}
+	if err := checkCompression(CompressionZstd, 23); err != ErrInvalidCompressionLevel {
+		t.Errorf("Expected %v, got %v", ErrInvalidCompressionLevel, err)
+	}
+	if bc, err := compress(b, CompressionGZip, 9); err != nil {
+		t.Errorf("Failed compressing with gzip level 9: %s", err)
+	} else if bd, _ := decompress(bc, CompressionGZip); !bytes.Equal(b, bd) {
+		t.Errorf("Data mismatch after gzip level 9 compression & decompression cycle")
+	}
+
+	if err := checkCompression(CompressionGZip, 10); err != ErrInvalidCompressionLevel {
+		t.Errorf("Expected %v, got %v", ErrInvalidCompressionLevel, err)
+	}
+	if _, err := compress(b, 42, 0); err != ErrUnknownCompression {
+		t.Errorf("Expected %v, got %v", ErrUnknownCompression, err)
+	}
+}
+
+func TestAdaptiveCompression(t *testing.T) {
+	random := make([]byte, 256*1024)
+	rand.Read(random)
+	b, algo, err := compressChunk(random, CompressionZstd, 0)
+	if err != nil || algo != CompressionNone || !bytes.Equal(b, random) {
+		t.Errorf("Expected random data to be stored uncompressed, got %s (%v)", CompressionText(algo), err)
+	}
+
+	repetitive := bytes.Repeat([]byte("knoxite"), 64*1024)
+	b, algo, err = compressChunk(repetitive, CompressionZstd, 0)
+	if err != nil || algo != CompressionZstd || len(b) >= len(repetitive) {
+		t.Errorf("Expected repetitive data to be compressed, got %s (%v)", CompressionText(algo), err)
+	}
+
+	// Small chunks skip the trial, but still only get compressed if they shrink
+	_, algo, _ = compressChunk(random[:16], CompressionGZip, 0)
+	if algo != CompressionNone {
+		t.Errorf("Expected tiny chunk to be stored uncompressed, got %s", CompressionText(algo))
+	}
+
+	if compressible("holiday/IMG_0001.JPG") || !compressible("dump.sql") {
+		t.Errorf("Failed detecting compressed file types")
+	}
+}
+
+func TestCompressionRules(t *testing.T) {
+	opts := StoreOptions{
+		Compression: CompressionGZip,
+		CompressionRules: []CompressionRule{
+			{Pattern: "*.sql", Compression: CompressionZstd, Level: 19},
+			{Pattern: "*.log", Compression: CompressionNone},
+			{Pattern: "photos/*.jpg", Compression: CompressionZstd},
+		},
+	}
+	if err := opts.checkCompression(); err != nil {
+		t.Errorf("Failed validating compression rules: %s", err)
+	}
+
+	for _, tc := range []struct {
+		path  string
+		algo  int
+		level int
+	}{
+		{"backup/dump.sql", CompressionZstd, 19},
+		{"var/app.log", CompressionNone, 0},
+		{"photos/beach.jpg", CompressionZstd, 0},
+		{"other/beach.jpg", CompressionNone, 0},
+		{"notes.txt", CompressionGZip, 0},
+	} {
+		if algo, level := opts.compressionFor(tc.path); algo != tc.algo || level != tc.level {
+			t.Errorf("Expected %s:%d for %s, got %s:%d", CompressionText(tc.algo), tc.level, tc.path, CompressionText(algo), level)
+		}
+	}
+
+	opts.CompressionRules = append(opts.CompressionRules, CompressionRule{Pattern: "[", Compression: CompressionGZip})
+	if err := opts.checkCompression(); err == nil {
+		t.Errorf("Expected invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+// ConvertChunk stores chunk again, compressed and encrypted with the given
+// algos. The converted chunk gets recorded in the repository's Conversion,
+// until CompleteConversion points the snapshots to it. Chunks already using
+// the algos or converted before are skipped. Returns whether chunk got
+// converted
+func (r *Repository) ConvertChunk(chunk Chunk, compression, level, encryption int) (bool, error) {
+	if err := checkCompression(compression, level); err != nil {
+		return false, err
+	}
+	if chunk.Compressed == compression && chunk.Encrypted == encryption {
+		return false, nil
+	}
+	if _, ok := r.Conversion[chunk.ShaSum]; ok {
+		return false, nil
+	}
+
+	data, err := loadChunk(*r, chunk)
+	if err != nil {
+		return false, err
+	}
+	secret, err := r.chunkSecret(chunk)
+	if err != nil {
+		return false, err
+	}
+
+	converted, err := encodeChunk(data, compression, level, encryption, secret, r.chunkIDKey(secret), int(chunk.DataParts), int(chunk.ParityParts))
+	if err != nil {
+		return false, err
+	}
+	converted.Volume = chunk.Volume
+	if _, err = r.Backend.StoreChunk(&converted); err != nil {
+		return false, err
+	}
+
+	converted.Data = nil
+	if r.Conversion == nil {
+		r.Conversion = make(map[string]Chunk)
+	}
+	r.Conversion[chunk.ShaSum] = converted
+	return true, nil
+}
+
+// CompleteConversion points all snapshots to the chunks converted with
+// ConvertChunk and deletes the chunks from their old location. It can safely
+// be run again if it got interrupted
+func (r *Repository) CompleteConversion() error {
+	if len(r.Conversion) == 0 {
+		return nil
+	}
+	// Persist the conversion first, so an interrupted run can be resumed
+	if err := r.Save(); err != nil {
+		return err
+	}
+
+	for _, volume := range r.Volumes {
+		for _, snapshotID := range volume.Snapshots {
+			snapshot, err := volume.LoadSnapshot(snapshotID, r)
+			if err != nil {
+				return err
+			}
+
+			changed := false
+			for i := range snapshot.Items {
+				for j, chunk := range snapshot.Items[i].Chunks {
+					converted, ok := r.Conversion[chunk.ShaSum]
+					if !ok {
+						continue
+					}
+					c := &snapshot.Items[i].Chunks[j]
+					c.ShaSum = converted.ShaSum
+					c.Size = converted.Size
+					c.Compressed = converted.Compressed
+					c.Encrypted = converted.Encrypted
+					changed = true
+				}
+			}
+			if changed {
+				if err = snapshot.Save(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for id, converted := range r.Conversion {
+		if id == converted.ShaSum {
+			continue
+		}
+		obsolete := converted
+		obsolete.ShaSum = id
+		// The chunk may already be gone if a previous run got interrupted
+		if err := r.Backend.DeleteChunk(obsolete); err != nil && err != ErrDeleteChunkFailed {
+			return err
+		}
+	}
+
+	r.Conversion = nil
+	// The index still refers to the old IDs, it gets rebuilt on the next store
+	if err := r.resetDedupIndex(); err != nil {
+		return err
+	}
+	return r.Save()
+}
@@ -0,0 +1,109 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestConvertChunks(t *testing.T) {
+	r, err := NewRepository("memory://convert", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	snapshot, _ := NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"convert_test.go"}, r, StoreOptions{Compression: CompressionGZip, Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+	snapshot.Save(&r)
+	vol.AddSnapshot(snapshot.ID)
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	chunks, _ := r.Chunks()
+	for _, chunk := range chunks {
+		converted, err := r.ConvertChunk(chunk, CompressionZstd, 0, EncryptionChaCha20)
+		if err != nil || !converted {
+			t.Errorf("Failed converting chunk: %v", err)
+			return
+		}
+		// Resuming the conversion skips chunks converted before
+		if converted, _ = r.ConvertChunk(chunk, CompressionZstd, 0, EncryptionChaCha20); converted {
+			t.Errorf("Chunk %s got converted twice", chunk.ShaSum)
+		}
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://convert", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if len(r.Conversion) != len(chunks) {
+		t.Errorf("Expected %d converted chunks, got %d", len(chunks), len(r.Conversion))
+	}
+	if err = r.CompleteConversion(); err != nil {
+		t.Errorf("Failed completing conversion: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://convert", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if len(r.Conversion) != 0 {
+		t.Errorf("Conversion did not get cleared")
+	}
+	for _, chunk := range chunks {
+		if _, err = r.Backend.LoadChunk(chunk, 0); err == nil {
+			t.Errorf("Chunk %s did not get deleted", chunk.ShaSum)
+		}
+	}
+
+	chunks, _ = r.Chunks()
+	for _, chunk := range chunks {
+		if chunk.Compressed != CompressionZstd || chunk.Encrypted != EncryptionChaCha20 {
+			t.Errorf("Chunk %s did not get converted", chunk.ShaSum)
+		}
+		if converted, _ := r.ConvertChunk(chunk, CompressionZstd, 0, EncryptionChaCha20); converted {
+			t.Errorf("Chunk %s got converted again", chunk.ShaSum)
+		}
+	}
+
+	_, snapshot2, err := r.FindSnapshot(snapshot.ID)
+	if err != nil {
+		t.Errorf("Failed finding snapshot: %s", err)
+		return
+	}
+	data, _, err := DecodeArchiveData(r, snapshot2.Items[0])
+	if err != nil {
+		t.Errorf("Failed decoding converted data: %s", err)
+		return
+	}
+	orig, _ := ioutil.ReadFile("convert_test.go")
+	if string(data) != string(orig) {
+		t.Errorf("Converted data does not match the original")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"math"
+)
+
+// Error declarations
+var (
+	ErrSnapshotExists = errors.New("Snapshot exists already in the destination repository")
+)
+
+// CopyStats describes the data transferred by CopySnapshot
+type CopyStats struct {
+	// Chunks is the amount of chunks the snapshot refers to, Copied how many
+	// of them had to be stored in the destination repository
+	Chunks int
+	Copied int
+	// Size is the size of the copied chunks as stored
+	Size uint64
+}
+
+// CopySnapshot copies snapshot from the repository src into volume, which
+// belongs to r. The snapshot keeps its ID. Chunks get decoded and stored
+// again with r's keys, encrypted with the algo and split into the amount of
+// parts given in opts. Chunks r
+// holds already are only referenced. The copy still needs to be saved and
+// added to volume
+func (r *Repository) CopySnapshot(src *Repository, snapshot Snapshot, volume *Volume, opts StoreOptions) (Snapshot, CopyStats, error) {
+	stats := CopyStats{}
+	if _, _, err := r.FindSnapshot(snapshot.ID); err == nil {
+		return Snapshot{}, stats, ErrSnapshotExists
+	}
+	if err := r.buildDedupIndex(); err != nil {
+		return Snapshot{}, stats, err
+	}
+	secret, err := r.volumeSecret(volume)
+	if err != nil {
+		return Snapshot{}, stats, err
+	}
+	volumeID := ""
+	if volume.Key != nil {
+		volumeID = volume.ID
+	}
+	dataParts := int(math.Max(1, float64(opts.DataParts)))
+
+	dst := Snapshot{
+		ID:          snapshot.ID,
+		Date:        snapshot.Date,
+		Description: snapshot.Description,
+		Tags:        snapshot.Tags,
+		Stats:       snapshot.Stats,
+		volume:      volume,
+	}
+	for _, item := range snapshot.Items {
+		chunks := item.Chunks
+		item.Chunks = []Chunk{}
+		for _, chunk := range chunks {
+			stats.Chunks++
+			key := dedupKey(chunk.DecryptedShaSum, volumeID, opts.Encryption, uint(dataParts), opts.ParityParts)
+			if cd, ok := r.dedup.lookup(key); ok {
+				cd.Num = chunk.Num
+				item.Chunks = append(item.Chunks, cd)
+				continue
+			}
+
+			data, err := loadChunk(*src, chunk)
+			if err != nil {
+				return dst, stats, err
+			}
+			cd, err := encodeChunk(data, chunk.Compressed, 0, opts.Encryption, secret, r.chunkIDKey(secret), dataParts, int(opts.ParityParts))
+			if err != nil {
+				return dst, stats, err
+			}
+			cd.Num = chunk.Num
+			cd.Volume = volumeID
+			size, err := r.Backend.StoreChunk(&cd)
+			if err != nil {
+				return dst, stats, err
+			}
+			r.dedup.add(cd)
+			stats.Copied++
+			stats.Size += size
+
+			cd.Data = nil
+			item.Chunks = append(item.Chunks, cd)
+		}
+		if len(item.Chunks) == 0 {
+			item.Chunks = nil
+		}
+		dst.Items = append(dst.Items, item)
+	}
+
+	return dst, stats, nil
+}
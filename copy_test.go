@@ -0,0 +1,81 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCopySnapshot(t *testing.T) {
+	src, err := NewRepository("memory://copy-src", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	dst, err := NewRepository("memory://copy-dst", "other_password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	srcVol, _ := NewVolume("test_name", "test_description")
+	src.AddVolume(srcVol)
+	dstVol := &Volume{ID: srcVol.ID, Name: srcVol.Name}
+	dst.AddVolume(dstVol)
+
+	orig, _ := ioutil.ReadFile("copy_test.go")
+	store := func() Snapshot {
+		snapshot, _ := srcVol.NewSnapshot("test_snapshot")
+		err := snapshot.AddStream("copy_test.go", bytes.NewReader(orig), src, StoreOptions{Compression: CompressionGZip, Encryption: EncryptionNone, DataParts: 1})
+		if err != nil {
+			t.Errorf("Failed adding stream: %s", err)
+		}
+		snapshot.Save(&src)
+		srcVol.AddSnapshot(snapshot.ID)
+		return snapshot
+	}
+
+	copySnapshot := func(snapshot Snapshot) CopyStats {
+		copied, stats, err := dst.CopySnapshot(&src, snapshot, dstVol, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+		if err != nil {
+			t.Errorf("Failed copying snapshot: %s", err)
+			return stats
+		}
+		copied.Save(&dst)
+		dstVol.AddSnapshot(copied.ID)
+
+		s, err := dstVol.LoadSnapshot(snapshot.ID, &dst)
+		if err != nil {
+			t.Errorf("Failed loading copied snapshot: %s", err)
+			return stats
+		}
+		data, _, err := DecodeArchiveData(dst, s.Items[0])
+		if err != nil || !bytes.Equal(data, orig) {
+			t.Errorf("Failed decoding copied file: %v", err)
+		}
+		// Chunks get encrypted as requested for the destination
+		if chunk := s.Items[0].Chunks[0]; chunk.Encrypted != EncryptionAESGCM {
+			t.Errorf("Expected chunk to be encrypted with %d, got %d", EncryptionAESGCM, chunk.Encrypted)
+		}
+		return stats
+	}
+
+	first := store()
+	if stats := copySnapshot(first); stats.Chunks != 1 || stats.Copied != 1 {
+		t.Errorf("Expected 1 chunk to be copied, got %d of %d", stats.Copied, stats.Chunks)
+	}
+	// The destination holds all chunks of the same data already
+	if stats := copySnapshot(store()); stats.Chunks != 1 || stats.Copied != 0 {
+		t.Errorf("Expected no chunks to be copied, got %d of %d", stats.Copied, stats.Chunks)
+	}
+
+	if _, _, err = dst.CopySnapshot(&src, first, dstVol, StoreOptions{DataParts: 1}); err != ErrSnapshotExists {
+		t.Errorf("Expected %v, got %v", ErrSnapshotExists, err)
+	}
+}
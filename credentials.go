@@ -0,0 +1,148 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// credentialSource looks up the credentials for the backend at u. It returns
+// nil if it doesn't know any
+type credentialSource func(u url.URL) (*url.Userinfo, error)
+
+// credentialSources are queried in order for backends without credentials in
+// their URL
+var credentialSources = []credentialSource{
+	credentialsFromEnvironment,
+	credentialsFromKeychain,
+	credentialsFromFile,
+	credentialsFromHelper,
+}
+
+// resolveCredentials returns the credentials for the backend at u, or nil if
+// none of the credential sources knows any
+func resolveCredentials(u url.URL) (*url.Userinfo, error) {
+	u.User = nil
+	u.RawQuery = ""
+	for _, source := range credentialSources {
+		user, err := source(u)
+		if err != nil || user != nil {
+			return user, err
+		}
+	}
+
+	return nil, nil
+}
+
+// userinfo returns a Userinfo for username and password, which are optional
+func userinfo(username, password string) *url.Userinfo {
+	if username == "" && password == "" {
+		return nil
+	}
+	if password == "" {
+		return url.User(username)
+	}
+	return url.UserPassword(username, password)
+}
+
+// credentialsFromEnvironment reads the KNOXITE_<SCHEME>_USERNAME and
+// KNOXITE_<SCHEME>_PASSWORD environment variables, e.g. KNOXITE_S3S_USERNAME
+func credentialsFromEnvironment(u url.URL) (*url.Userinfo, error) {
+	prefix := "KNOXITE_" + strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(u.Scheme)) + "_"
+
+	return userinfo(os.Getenv(prefix+"USERNAME"), os.Getenv(prefix+"PASSWORD")), nil
+}
+
+// credentialsFile returns the path of the credentials file
+func credentialsFile() string {
+	if path := os.Getenv("KNOXITE_CREDENTIALS"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".knoxite", "credentials")
+}
+
+// credentialsFromFile looks up u in the credentials file. It contains a JSON
+// object mapping backend URLs (without credentials) to a username and password,
+// e.g. {"s3s://s3.amazonaws.com/": {"username": "KEY", "password": "SECRET"}}.
+// The longest URL that is a prefix of u wins
+func credentialsFromFile(u url.URL) (*url.Userinfo, error) {
+	b, err := ioutil.ReadFile(credentialsFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var credentials map[string]struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err = json.Unmarshal(b, &credentials); err != nil {
+		return nil, err
+	}
+
+	location := u.String()
+	match := ""
+	for prefix := range credentials {
+		if strings.HasPrefix(location, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return nil, nil
+	}
+	return userinfo(credentials[match].Username, credentials[match].Password), nil
+}
+
+// credentialsFromHelper runs the command set in KNOXITE_CREDENTIAL_HELPER with
+// the arguments "get" and the backend URL. The helper prints "username=" and
+// "password=" lines, printing nothing means it doesn't know the backend
+func credentialsFromHelper(u url.URL) (*url.Userinfo, error) {
+	helper := os.Getenv("KNOXITE_CREDENTIAL_HELPER")
+	if helper == "" {
+		return nil, nil
+	}
+
+	cmd := exec.Command(helper, "get", u.String())
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var username, password string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			username = kv[1]
+		case "password":
+			password = kv[1]
+		}
+	}
+
+	return userinfo(username, password), scanner.Err()
+}
@@ -0,0 +1,67 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for credentials: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	var username, password string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ = r.BasicAuth()
+	}))
+	defer ts.Close()
+
+	file := filepath.Join(dir, "credentials")
+	err = ioutil.WriteFile(file, []byte(`{"`+ts.URL+`/": {"username": "user", "password": "secret"}}`), 0600)
+	if err != nil {
+		t.Errorf("Failed writing credentials: %s", err)
+		return
+	}
+	os.Setenv("KNOXITE_CREDENTIALS", file)
+	defer os.Unsetenv("KNOXITE_CREDENTIALS")
+
+	backend, err := BackendFromURL(ts.URL + "/repository")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	if strings.Contains(backend.Location(), "secret") {
+		t.Errorf("Credentials leaked into location: %s", backend.Location())
+	}
+	backend.LoadRepository()
+	if username != "user" || password != "secret" {
+		t.Errorf("Unexpected credentials: %s %s", username, password)
+	}
+
+	os.Setenv("KNOXITE_HTTP_USERNAME", "envuser")
+	defer os.Unsetenv("KNOXITE_HTTP_USERNAME")
+	backend, err = BackendFromURL(ts.URL + "/repository")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	backend.LoadRepository()
+	if username != "envuser" || password != "" {
+		t.Errorf("Unexpected credentials: %s %s", username, password)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// cryptoHeaderMagic prefixes all data encrypted with a crypto header
+var cryptoHeaderMagic = []byte("KXC")
+
+// cryptoHeaderVersion is the version of newly written crypto headers
+const cryptoHeaderVersion = 1
+
+// Key derivations, describing how the key of an encrypted object gets derived
+// from the secret it got encrypted with
+const (
+	KeyDerivationSHA256 = 1
+)
+
+// Error declarations
+var (
+	ErrCryptoHeaderVersion = errors.New("Data was encrypted by a newer version of knoxite, please upgrade")
+)
+
+// cryptoHeader precedes every object encrypted with an authenticated cipher.
+// It records how the object got encrypted, so future ciphers and key
+// derivations can coexist with data written by older versions. The header is
+// authenticated alongside the data
+type cryptoHeader struct {
+	Version uint8
+	Cipher  uint8
+	KDF     uint8
+	// Params holds the parameters of the key derivation, if it has any
+	Params []byte
+}
+
+// newCryptoHeader returns the header for data encrypted with algo
+func newCryptoHeader(algo int) cryptoHeader {
+	return cryptoHeader{
+		Version: cryptoHeaderVersion,
+		Cipher:  uint8(algo),
+		KDF:     KeyDerivationSHA256,
+	}
+}
+
+// marshal returns the header's binary representation
+func (h cryptoHeader) marshal() []byte {
+	var b bytes.Buffer
+	b.Write(cryptoHeaderMagic)
+	b.WriteByte(h.Version)
+	b.WriteByte(h.Cipher)
+	b.WriteByte(h.KDF)
+	binary.Write(&b, binary.BigEndian, uint16(len(h.Params)))
+	b.Write(h.Params)
+	return b.Bytes()
+}
+
+// parseCryptoHeader splits b into its crypto header and the encrypted data.
+// found is false if b doesn't start with a crypto header
+func parseCryptoHeader(b []byte) (h cryptoHeader, data []byte, found bool, err error) {
+	if !bytes.HasPrefix(b, cryptoHeaderMagic) {
+		return h, b, false, nil
+	}
+	b = b[len(cryptoHeaderMagic):]
+	if len(b) < 5 {
+		return h, nil, true, ErrDecryptionFailed
+	}
+
+	h.Version, h.Cipher, h.KDF = b[0], b[1], b[2]
+	if h.Version > cryptoHeaderVersion {
+		return h, nil, true, ErrCryptoHeaderVersion
+	}
+	l := int(binary.BigEndian.Uint16(b[3:]))
+	b = b[5:]
+	if len(b) < l {
+		return h, nil, true, ErrDecryptionFailed
+	}
+	h.Params = b[:l]
+	return h, b[l:], true, nil
+}
+
+// key derives the key of the encrypted object from password
+func (h cryptoHeader) key(password string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, ErrInvalidPassword
+	}
+
+	switch h.KDF {
+	case KeyDerivationSHA256:
+		key := sha256.Sum256([]byte(password))
+		return key[:], nil
+	}
+
+	return nil, ErrUnknownKDF
+}
+
+// seal encrypts data and prefixes it with the header
+func (h cryptoHeader) seal(data []byte, password string) ([]byte, error) {
+	key, err := h.key(password)
+	if err != nil {
+		return []byte{}, err
+	}
+	aead, err := newAEAD(int(h.Cipher), key)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	header := h.marshal()
+	return append(header, sealAEAD(aead, nonceKey(key), data, header)...), nil
+}
+
+// open decrypts data, which got encrypted as described by the header
+func (h cryptoHeader) open(data []byte, password string) ([]byte, error) {
+	key, err := h.key(password)
+	if err != nil {
+		return []byte{}, err
+	}
+	aead, err := newAEAD(int(h.Cipher), key)
+	if err != nil {
+		return []byte{}, err
+	}
+	return openAEAD(aead, data, h.marshal())
+}
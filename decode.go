@@ -10,15 +10,14 @@ package knoxite
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/klauspost/reedsolomon"
 )
@@ -70,13 +69,54 @@ func (e *DataReconstructionError) Error() string {
 // DecodeSnapshot restores an entire snapshot to dst
 func DecodeSnapshot(repository Repository, snapshot Snapshot, dst string) (prog chan Progress, err error) {
 	prog = make(chan Progress)
+	items := make(chan Progress)
 	go func() {
+		restored := make(map[string]bool)
 		for _, arc := range snapshot.Items {
 			path := filepath.Join(dst, arc.Path)
-			err := DecodeArchive(prog, repository, arc, path)
+			restored[arc.Path] = true
+			// Hardlinks carry the data of the file they link to, in case
+			// it didn't get restored along with them
+			if arc.LinkTo != "" && restored[arc.LinkTo] {
+				os.Remove(path)
+				if os.Link(filepath.Join(dst, arc.LinkTo), path) == nil {
+					continue
+				}
+			}
+
+			err := DecodeArchive(items, repository, arc, path)
 			if err != nil {
-				panic(err)
+				// Skip the item, the others can still be restored
+				p := Progress{Path: arc.Path, Err: err}
+				p.Statistics.Errors++
+				items <- p
+			}
+		}
+		close(items)
+	}()
+
+	go func() {
+		var total uint64
+		for _, arc := range snapshot.Items {
+			total += arc.Size
+		}
+		meter := newProgressMeter(time.Now())
+
+		// Items get restored in order, all items before the current one are
+		// done. Directories and symlinks don't report any progress
+		var handled uint64
+		i := 0
+		for p := range items {
+			for i < len(snapshot.Items) && snapshot.Items[i].Path != p.Path {
+				handled += snapshot.Items[i].Size
+				i++
 			}
+			p.Done = handled + p.Size
+			p.Total = total
+			p.Items = uint64(i)
+			p.TotalItems = uint64(len(snapshot.Items))
+			meter.update(&p, time.Now())
+			prog <- p
 		}
 		close(prog)
 	}()
@@ -85,8 +125,12 @@ func DecodeSnapshot(repository Repository, snapshot Snapshot, dst string) (prog
 }
 
 func decodeChunk(repository Repository, chunk Chunk, finalData []byte) ([]byte, error) {
-	if chunk.Encrypted == EncryptionAES {
-		data, err := Decrypt(finalData, repository.Password)
+	if chunk.Encrypted != EncryptionNone {
+		secret, err := repository.chunkSecret(chunk)
+		if err != nil {
+			return []byte{}, err
+		}
+		data, err := DecryptWith(finalData, secret, chunk.Encrypted)
 		if err != nil {
 			return []byte{}, err
 		}
@@ -94,17 +138,9 @@ func decodeChunk(repository Repository, chunk Chunk, finalData []byte) ([]byte,
 		finalData = data
 	}
 
-	if chunk.Compressed == CompressionGZip {
-		reader := bytes.NewReader(finalData)
-		zipreader, err := gzip.NewReader(reader)
-		if err != nil {
-			return []byte{}, err
-		}
-		defer zipreader.Close()
-		finalData, err = ioutil.ReadAll(zipreader)
-		if err != nil {
-			return []byte{}, err
-		}
+	finalData, err := decompress(finalData, chunk.Compressed)
+	if err != nil {
+		return []byte{}, err
 	}
 
 	shasumdata := sha256.Sum256(finalData)
@@ -117,7 +153,22 @@ func decodeChunk(repository Repository, chunk Chunk, finalData []byte) ([]byte,
 	return finalData, nil
 }
 
+// DecodeChunk loads a chunk and returns its decrypted and decompressed data
+func DecodeChunk(repository Repository, chunk Chunk) ([]byte, error) {
+	return loadChunk(repository, chunk)
+}
+
 func loadChunk(repository Repository, chunk Chunk) ([]byte, error) {
+	data, err := loadChunkData(repository, chunk)
+	if err != nil {
+		return []byte{}, err
+	}
+	return decodeChunk(repository, chunk, data)
+}
+
+// loadChunkData loads the data of a chunk as it got stored, reconstructing
+// missing parts from parity parts if necessary
+func loadChunkData(repository Repository, chunk Chunk) ([]byte, error) {
 	if chunk.ParityParts > 0 {
 		enc, err := reedsolomon.New(int(chunk.DataParts), int(chunk.ParityParts))
 		if err != nil {
@@ -151,18 +202,14 @@ func loadChunk(repository Repository, chunk Chunk) ([]byte, error) {
 					continue
 				}
 				bufWriter.Flush()
-				return decodeChunk(repository, chunk, b.Bytes())
+				return b.Bytes(), nil
 			}
 		}
 
 		return []byte{}, &DataReconstructionError{chunk, parsFound, chunk.DataParts - parsFound}
 	}
 
-	data, err := repository.Backend.LoadChunk(chunk, 0)
-	if err != nil {
-		return []byte{}, err
-	}
-	return decodeChunk(repository, chunk, data)
+	return repository.Backend.LoadChunk(chunk, 0)
 }
 
 // DecodeArchive restores a single archive to path
@@ -178,6 +225,19 @@ func DecodeArchive(progress chan Progress, repository Repository, arc ItemData,
 		//fmt.Printf("Creating symlink %s -> %s\n", path, arc.PointsTo)
 		os.Symlink(arc.PointsTo, path)
 		prog.Statistics.SymLinks++
+	} else if isSpecial(arc.Type) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := mknod(path, arc); err != nil {
+			// Device nodes can usually only be created by root, which
+			// mustn't stop the rest from getting restored
+			prog.Err = err
+			prog.Statistics.Errors++
+			progress <- prog
+			return nil
+		}
+		if err := os.Chtimes(path, arc.ModTime, arc.ModTime); err != nil {
+			return err
+		}
 	} else if arc.Type == File {
 		prog.Statistics.Files++
 		prog.Statistics.StorageSize = arc.StorageSize
@@ -193,6 +253,15 @@ func DecodeArchive(progress chan Progress, repository Repository, arc ItemData,
 			return err
 		}
 
+		if arc.Data != nil {
+			if _, err = f.Write(arc.Data); err != nil {
+				return err
+			}
+			prog.Statistics.Size += uint64(len(arc.Data))
+			prog.Size += uint64(len(arc.Data))
+			progress <- prog
+		}
+
 		for i := uint(0); i < parts; i++ {
 			idx, erri := indexOfChunk(arc, i)
 			if erri != nil {
@@ -228,12 +297,48 @@ func DecodeArchive(progress chan Progress, repository Repository, arc ItemData,
 	}
 
 	// Restore ownerships
-	return os.Lchown(path, int(arc.UID), int(arc.GID))
+	if err := os.Lchown(path, int(arc.UID), int(arc.GID)); err != nil {
+		return err
+	}
+
+	// Changing the owner drops some attributes, e.g. file capabilities, so
+	// they come last
+	return writeXattrs(path, arc.Xattrs, arc.Type != SymLink)
 }
 
+// WriteArchive writes the content of a single archive to w, one chunk at a
+// time
+func WriteArchive(repository Repository, arc ItemData, w io.Writer) error {
+	if arc.Data != nil {
+		_, err := w.Write(arc.Data)
+		return err
+	}
+
+	for i := uint(0); i < uint(len(arc.Chunks)); i++ {
+		idx, err := indexOfChunk(arc, i)
+		if err != nil {
+			return err
+		}
+		data, err := loadChunk(repository, arc.Chunks[idx])
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maxCachedChunks limits how many decoded chunks ReadArchive keeps around
+const maxCachedChunks = 64
+
 var (
 	cache map[string][]byte
-	mutex = &sync.Mutex{}
+	// cacheOrder lists the cached chunks, oldest first
+	cacheOrder []string
+	mutex      = &sync.Mutex{}
 )
 
 func init() {
@@ -245,6 +350,11 @@ func init() {
 func DecodeArchiveData(repository Repository, arc ItemData) (dat []byte, stats Stats, err error) {
 	if arc.Type == File {
 		parts := uint(len(arc.Chunks))
+		if arc.Data != nil {
+			dat = append(dat, arc.Data...)
+			stats.StorageSize += uint64(len(dat))
+			stats.Size += uint64(len(dat))
+		}
 
 		for i := uint(0); i < parts; i++ {
 			idx, err := indexOfChunk(arc, i)
@@ -298,11 +408,17 @@ func readArchiveChunk(repository Repository, arc ItemData, chunkNum uint) (dat *
 
 	finalData, err := loadChunk(repository, chunk)
 	if err != nil {
+		mutex.Unlock()
 		return dat, err
 	}
 
 	*dat = append(*dat, finalData...)
 	cache[chunk.ShaSum] = finalData
+	cacheOrder = append(cacheOrder, chunk.ShaSum)
+	if len(cacheOrder) > maxCachedChunks {
+		delete(cache, cacheOrder[0])
+		cacheOrder = cacheOrder[1:]
+	}
 	mutex.Unlock()
 
 	return dat, nil
@@ -342,7 +458,16 @@ func chunkForOffset(arc ItemData, offset int) (uint, int, error) {
 func ReadArchive(repository Repository, arc ItemData, offset int, size int) (dat *[]byte, err error) {
 	dat = &[]byte{}
 	//	fmt.Println("Read req:", offset, size)
-	if arc.Type == File {
+	if arc.Type == File && arc.Data != nil {
+		if offset >= len(arc.Data) {
+			return dat, io.EOF
+		}
+		end := offset + size
+		if end > len(arc.Data) {
+			end = len(arc.Data)
+		}
+		*dat = append(*dat, arc.Data[offset:end]...)
+	} else if arc.Type == File {
 		neededPart, internalOffset, err := chunkForOffset(arc, offset)
 		if err != nil {
 			return dat, err
@@ -0,0 +1,134 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"strconv"
+	"sync"
+)
+
+// dedupIndex remembers every chunk stored in the repository by the hash of
+// its original data, so chunks already present don't get stored again. It's
+// persisted incrementally in index files, see saveIndexFile
+type dedupIndex struct {
+	sync.RWMutex
+	chunks map[string]Chunk
+	// pending are the chunks added since the last index file got written
+	pending map[string]Chunk
+}
+
+// dedupKey returns the key of a chunk in the dedup index. Chunks can only be
+// reused if they got encrypted with the same algo and key and split into the
+// same amount of parts
+func dedupKey(decshasum, volume string, encryption int, dataParts, parityParts uint) string {
+	if parityParts == 0 {
+		dataParts = 1
+	}
+	return volume + "/" + decshasum + "_" + strconv.Itoa(encryption) + "_" + strconv.FormatUint(uint64(dataParts), 10) + "_" + strconv.FormatUint(uint64(parityParts), 10)
+}
+
+func newDedupIndex() *dedupIndex {
+	return &dedupIndex{
+		chunks:  make(map[string]Chunk),
+		pending: make(map[string]Chunk),
+	}
+}
+
+// merge adds the chunks of an index file. Keys get derived from the chunks
+// again, index files may have been written with an older key format
+func (index *dedupIndex) merge(chunks map[string]Chunk) {
+	index.Lock()
+	defer index.Unlock()
+
+	for _, chunk := range chunks {
+		index.chunks[dedupKey(chunk.DecryptedShaSum, chunk.Volume, chunk.Encrypted, chunk.DataParts, chunk.ParityParts)] = chunk
+	}
+}
+
+// add records that chunk got stored. Chunks already known are left alone
+func (index *dedupIndex) add(chunk Chunk) {
+	if index == nil {
+		return
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	key := dedupKey(chunk.DecryptedShaSum, chunk.Volume, chunk.Encrypted, chunk.DataParts, chunk.ParityParts)
+	if _, ok := index.chunks[key]; ok {
+		return
+	}
+	chunk.Data = nil
+	chunk.Num = 0
+	index.chunks[key] = chunk
+	index.pending[key] = chunk
+}
+
+// takePending returns the chunks added since the last call
+func (index *dedupIndex) takePending() map[string]Chunk {
+	if index == nil {
+		return nil
+	}
+	index.Lock()
+	defer index.Unlock()
+
+	chunks := index.pending
+	index.pending = make(map[string]Chunk)
+	return chunks
+}
+
+// restorePending marks chunks as pending again, e.g. if they couldn't be
+// written
+func (index *dedupIndex) restorePending(chunks map[string]Chunk) {
+	index.Lock()
+	defer index.Unlock()
+
+	for key, chunk := range chunks {
+		index.pending[key] = chunk
+	}
+}
+
+// lookup returns the stored chunk with the given key
+func (index *dedupIndex) lookup(key string) (Chunk, bool) {
+	if index == nil {
+		return Chunk{}, false
+	}
+	index.RLock()
+	defer index.RUnlock()
+
+	chunk, ok := index.chunks[key]
+	return chunk, ok
+}
+
+// empty returns whether the index doesn't know any chunks
+func (index *dedupIndex) empty() bool {
+	if index == nil {
+		return true
+	}
+	index.RLock()
+	defer index.RUnlock()
+
+	return len(index.chunks) == 0
+}
+
+// buildDedupIndex fills the dedup index with the chunks of all snapshots.
+// Repositories predating the index, or whose chunks changed their IDs, get it
+// built once on the next store
+func (r *Repository) buildDedupIndex() error {
+	if !r.dedup.empty() {
+		return nil
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		r.dedup.add(chunk)
+	}
+	return nil
+}
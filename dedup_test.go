@@ -0,0 +1,145 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func storeDedupSnapshot(r Repository, vol *Volume) (Snapshot, error) {
+	snapshot, _ := NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"dedup_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		return snapshot, err
+	}
+	for range progress {
+	}
+	if err = snapshot.Save(&r); err != nil {
+		return snapshot, err
+	}
+	vol.AddSnapshot(snapshot.ID)
+	return snapshot, r.Save()
+}
+
+func TestDedupIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { indexCacheDir = dir }(indexCacheDir)
+	indexCacheDir = dir
+
+	r, err := NewRepository("memory://dedup", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	first, err := storeDedupSnapshot(r, vol)
+	if err != nil {
+		t.Errorf("Failed storing snapshot: %s", err)
+		return
+	}
+	stored, _ := r.Backend.ListChunks()
+
+	r, err = OpenRepository("memory://dedup", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if len(r.IndexFiles) != 1 || r.dedup.empty() {
+		t.Errorf("Dedup index did not get saved")
+		return
+	}
+	if _, err = os.Stat(filepath.Join(dir, r.IndexFiles[0])); err != nil {
+		t.Errorf("Index file did not get cached: %s", err)
+	}
+	vol = r.Volumes[0]
+	second, err := storeDedupSnapshot(r, vol)
+	if err != nil {
+		t.Errorf("Failed storing snapshot: %s", err)
+		return
+	}
+
+	chunks, _ := r.Backend.ListChunks()
+	if len(chunks) != len(stored) {
+		t.Errorf("Expected %d stored chunks, got %d", len(stored), len(chunks))
+	}
+	if second.Stats.StorageSize != 0 {
+		t.Errorf("Expected no data to be stored, got %d bytes", second.Stats.StorageSize)
+	}
+	if second.Items[0].Chunks[0].ShaSum != first.Items[0].Chunks[0].ShaSum {
+		t.Errorf("Expected chunk %s to be reused, got %s", first.Items[0].Chunks[0].ShaSum, second.Items[0].Chunks[0].ShaSum)
+	}
+
+	// Nothing new got stored, so no new index file either
+	if len(r.IndexFiles) != 1 {
+		t.Errorf("Expected a single index file, got %d", len(r.IndexFiles))
+	}
+
+	// Without a cache the index files get loaded from the backends
+	indexCacheDir = ""
+	r, err = OpenRepository("memory://dedup", "password")
+	if err != nil || r.dedup.empty() {
+		t.Errorf("Failed loading index files: %v", err)
+		return
+	}
+
+	// Repositories without an index get it built from their snapshots
+	names := r.IndexFiles
+	if err = r.resetDedupIndex(); err != nil {
+		t.Errorf("Failed resetting dedup index: %s", err)
+		return
+	}
+	if _, err = r.Backend.LoadSnapshot(names[0]); err == nil {
+		t.Errorf("Index file did not get deleted")
+	}
+	if err = r.buildDedupIndex(); err != nil {
+		t.Errorf("Failed building dedup index: %s", err)
+		return
+	}
+	chunk := first.Items[0].Chunks[0]
+	if _, ok := r.dedup.lookup(dedupKey(chunk.DecryptedShaSum, "", EncryptionAESGCM, 1, 0)); !ok {
+		t.Errorf("Chunk %s is missing in the rebuilt index", chunk.ShaSum)
+	}
+}
+
+func TestDedupEncryption(t *testing.T) {
+	r, err := NewRepository("memory://dedup-encryption", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+
+	data := []byte("some data stored with different encryption algos")
+	plain, _ := NewSnapshot("plain")
+	if err = plain.AddStream("file", bytes.NewReader(data), r, StoreOptions{Encryption: EncryptionNone, DataParts: 1}); err != nil {
+		t.Errorf("Failed adding stream: %s", err)
+		return
+	}
+	encrypted, _ := NewSnapshot("encrypted")
+	if err = encrypted.AddStream("file", bytes.NewReader(data), r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1}); err != nil {
+		t.Errorf("Failed adding stream: %s", err)
+		return
+	}
+
+	// Chunks stored unencrypted must not be reused for encrypted data
+	chunk := encrypted.Items[0].Chunks[0]
+	if chunk.Encrypted != EncryptionAESGCM {
+		t.Errorf("Expected chunk to be encrypted with %d, got %d", EncryptionAESGCM, chunk.Encrypted)
+	}
+}
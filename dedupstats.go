@@ -0,0 +1,103 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"strconv"
+	"time"
+)
+
+// DedupStats describes how much storage deduplication saves
+type DedupStats struct {
+	// Size is the original size of all files in all snapshots
+	Size uint64
+	// UniqueSize is the original size of all distinct chunks and inlined
+	// files, i.e. the data left after deduplication
+	UniqueSize uint64
+	// StorageSize is the size of all distinct chunks as stored, after
+	// compression and encryption
+	StorageSize uint64
+	// Snapshots lists the statistics of each snapshot
+	Snapshots []SnapshotDedupStats
+}
+
+// SnapshotDedupStats describes the deduplication of a single snapshot
+type SnapshotDedupStats struct {
+	ID   string
+	Date time.Time
+	// Size is the original size of all files in the snapshot
+	Size uint64
+	// UniqueSize is the original size of the chunks no other snapshot refers
+	// to, i.e. the space deleting the snapshot would free
+	UniqueSize uint64
+}
+
+// Ratio returns the ratio of the original size to the deduplicated size
+func (s DedupStats) Ratio() float64 {
+	if s.UniqueSize == 0 {
+		return 1
+	}
+	return float64(s.Size) / float64(s.UniqueSize)
+}
+
+// DedupStats gathers deduplication statistics for all snapshots
+func (r *Repository) DedupStats() (DedupStats, error) {
+	stats := DedupStats{}
+	// Snapshots referring to each chunk
+	refs := make(map[string]map[string]bool)
+	chunks := make(map[string]Chunk)
+	snapshotChunks := make(map[string]map[string]bool)
+
+	for _, volume := range r.Volumes {
+		for _, id := range volume.Snapshots {
+			snapshot, err := volume.LoadSnapshot(id, r)
+			if err != nil {
+				return stats, err
+			}
+
+			s := SnapshotDedupStats{ID: snapshot.ID, Date: snapshot.Date}
+			snapshotChunks[id] = make(map[string]bool)
+			for _, item := range snapshot.Items {
+				if item.Type != File {
+					continue
+				}
+				s.Size += item.Size
+				// Inlined data is never shared
+				s.UniqueSize += uint64(len(item.Data))
+				stats.UniqueSize += uint64(len(item.Data))
+				stats.StorageSize += uint64(len(item.Data))
+
+				for _, chunk := range item.Chunks {
+					key := chunk.ShaSum + "_" + strconv.FormatUint(uint64(chunk.DataParts), 10)
+					if refs[key] == nil {
+						refs[key] = make(map[string]bool)
+						chunks[key] = chunk
+					}
+					refs[key][id] = true
+					snapshotChunks[id][key] = true
+				}
+			}
+			stats.Size += s.Size
+			stats.Snapshots = append(stats.Snapshots, s)
+		}
+	}
+
+	for _, chunk := range chunks {
+		stats.UniqueSize += uint64(chunk.OriginalSize)
+		stats.StorageSize += uint64(chunk.Size)
+	}
+	for i, s := range stats.Snapshots {
+		for key := range snapshotChunks[s.ID] {
+			if len(refs[key]) == 1 {
+				stats.Snapshots[i].UniqueSize += uint64(chunks[key].OriginalSize)
+			}
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDedupStats(t *testing.T) {
+	r, err := NewRepository("memory://dedupstats", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	wd, _ := os.Getwd()
+	sizes := []uint64{}
+	for _, paths := range [][]string{
+		{"dedupstats.go"},
+		{"dedupstats.go", "dedupstats_test.go"},
+	} {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		progress, err := snapshot.Add(wd, paths, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+		if err != nil {
+			t.Errorf("Failed adding to snapshot: %s", err)
+			return
+		}
+		for range progress {
+		}
+		snapshot.Save(&r)
+		vol.AddSnapshot(snapshot.ID)
+		sizes = append(sizes, snapshot.Stats.Size)
+	}
+
+	stats, err := r.DedupStats()
+	if err != nil {
+		t.Errorf("Failed gathering dedup stats: %s", err)
+		return
+	}
+	if stats.Size != sizes[0]+sizes[1] {
+		t.Errorf("Expected size %d, got %d", sizes[0]+sizes[1], stats.Size)
+	}
+	if stats.UniqueSize != sizes[1] {
+		t.Errorf("Expected unique size %d, got %d", sizes[1], stats.UniqueSize)
+	}
+	if len(stats.Snapshots) != 2 || stats.Snapshots[0].UniqueSize != 0 ||
+		stats.Snapshots[1].UniqueSize != sizes[1]-sizes[0] {
+		t.Errorf("Unexpected snapshot stats: %+v", stats.Snapshots)
+	}
+	if ratio := stats.Ratio(); ratio <= 1 {
+		t.Errorf("Expected a dedup ratio above 1, got %f", ratio)
+	}
+}
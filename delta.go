@@ -0,0 +1,116 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+)
+
+// deltaBlockSize is the default size of the blocks changed chunks get split
+// into by the delta mode
+const deltaBlockSize = 64 * (1 << 10)
+
+// DeltaChunks works like Chunks, but splits rd into small blocks of a fixed
+// size and compares them with the previous version of the same file, which
+// got stored as the chunks parent. Blocks matching a chunk of parent reuse it.
+// Chunks of parent which changed get split into blocks, so parent may also
+// have been split by a content-defined chunker. parent may be empty.
+// Blocks only get compared at the offsets the chunks of parent had, so this
+// merely suits large files modified in place or appended to, like VM images
+// or mailboxes: inserting or removing data shifts everything after it and
+// nothing matches anymore. If already the first chunk of parent doesn't
+// match, the file most likely got rewritten and is split like Chunks does
+func (c Chunker) DeltaChunks(rd io.Reader, parent []Chunk) (<-chan Chunk, <-chan error) {
+	ordered := make([]Chunk, len(parent))
+	copy(ordered, parent)
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].Num < ordered[j].Num
+	})
+
+	return c.run(&deltaSplitter{
+		rd:        rd,
+		parent:    ordered,
+		blockSize: c.deltaBlock,
+		algo:      c.algo,
+		size:      c.size,
+	})
+}
+
+// deltaSplitter divides data at the boundaries of the chunks of its previous
+// version
+type deltaSplitter struct {
+	rd        io.Reader
+	parent    []Chunk
+	blockSize int
+	// pending are the blocks of a changed chunk not emitted yet
+	pending [][]byte
+	// compared is set once the first chunk of parent got compared
+	compared bool
+	// algo and size configure the splitter the data falls back to if the
+	// first chunk of parent doesn't match, which then takes over
+	algo     int
+	size     ChunkSize
+	fallback splitter
+}
+
+func (s *deltaSplitter) next() ([]byte, error) {
+	if s.fallback != nil {
+		return s.fallback.next()
+	}
+	if len(s.pending) > 0 {
+		block := s.pending[0]
+		s.pending = s.pending[1:]
+		return block, nil
+	}
+
+	if len(s.parent) == 0 {
+		return (&fixedSplitter{s.rd, s.blockSize}).next()
+	}
+	chunk := s.parent[0]
+	s.parent = s.parent[1:]
+
+	buf := make([]byte, chunk.OriginalSize)
+	n, err := io.ReadFull(s.rd, buf)
+	if n == 0 {
+		if err == nil || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	first := !s.compared
+	s.compared = true
+	sum := sha256.Sum256(buf)
+	if hex.EncodeToString(sum[:]) == chunk.DecryptedShaSum {
+		// Unchanged, the dedup index knows this chunk
+		return buf, nil
+	}
+	if first {
+		split, err := newSplitter(io.MultiReader(bytes.NewReader(buf), s.rd), s.algo, s.size)
+		if err != nil {
+			return nil, err
+		}
+		s.fallback = split
+		return s.next()
+	}
+
+	for len(buf) > s.blockSize {
+		s.pending = append(s.pending, buf[:s.blockSize])
+		buf = buf[s.blockSize:]
+	}
+	s.pending = append(s.pending, buf)
+	return s.next()
+}
@@ -0,0 +1,95 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestDeltaChunks(t *testing.T) {
+	r, err := NewRepository("memory://delta", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	c, err := r.NewChunker(nil, StoreOptions{Encryption: EncryptionAESGCM, DeltaBlockSize: 4096})
+	if err != nil {
+		t.Errorf("Failed creating chunker: %s", err)
+		return
+	}
+
+	collect := func(chunks <-chan Chunk, errs <-chan error) map[uint]Chunk {
+		ordered := make(map[uint]Chunk)
+		for chunk := range chunks {
+			ordered[chunk.Num] = chunk
+		}
+		if err := <-errs; err != nil {
+			t.Errorf("Failed chunking data: %s", err)
+		}
+		return ordered
+	}
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(7)).Read(data)
+	parent := []Chunk{}
+	for _, chunk := range collect(c.DeltaChunks(bytes.NewReader(data), nil)) {
+		r.dedup.add(chunk)
+		parent = append(parent, chunk)
+	}
+
+	// Modify a single byte in place and append some data
+	data[len(data)/2] ^= 0xff
+	data = append(data, make([]byte, 1000)...)
+	ordered := collect(c.DeltaChunks(bytes.NewReader(data), parent))
+
+	restored := []byte{}
+	stored := 0
+	for i := uint(0); i < uint(len(ordered)); i++ {
+		chunk := ordered[i]
+		if chunk.Data == nil {
+			// Reused, take the data from parent
+			for _, p := range parent {
+				if p.ShaSum == chunk.ShaSum {
+					chunk.Data = p.Data
+				}
+			}
+		} else {
+			stored += chunk.OriginalSize
+		}
+
+		b, err := decodeChunk(r, chunk, (*chunk.Data)[0])
+		if err != nil {
+			t.Errorf("Failed decoding chunk %d: %s", i, err)
+			return
+		}
+		restored = append(restored, b...)
+	}
+	if !bytes.Equal(restored, data) {
+		t.Errorf("Chunks don't add up to the modified data")
+	}
+	if stored != 4096+1000 {
+		t.Errorf("Expected 5096 bytes to be stored again, got %d", stored)
+	}
+
+	// Inserting data in front shifts all blocks, so the content-defined
+	// chunker takes over
+	data = append([]byte("inserted"), data...)
+	delta := collect(c.DeltaChunks(bytes.NewReader(data), parent))
+	chunked := collect(c.Chunks(bytes.NewReader(data)))
+	if len(delta) != len(chunked) {
+		t.Errorf("Expected %d chunks, got %d", len(chunked), len(delta))
+		return
+	}
+	for i, chunk := range chunked {
+		if delta[i].DecryptedShaSum != chunk.DecryptedShaSum {
+			t.Errorf("Chunk %d differs from the one split by Chunks", i)
+		}
+	}
+}
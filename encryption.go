@@ -10,20 +10,27 @@ package knoxite
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
 	// "reflect"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // Which encryption algo
 const (
 	EncryptionNone = iota
 	EncryptionAES
+	EncryptionAESGCM
+	EncryptionChaCha20
 )
 
 // Error declarations
 var (
-	ErrInvalidPassword = errors.New("Empty password not permitted")
+	ErrInvalidPassword   = errors.New("Empty password not permitted")
+	ErrDecryptionFailed  = errors.New("Decryption failed, data got tampered with or the password is wrong")
+	ErrUnknownEncryption = errors.New("Unknown encryption algorithm")
 )
 
 // EncryptionText returns a user-friendly string indicating the encryption algo that was used
@@ -33,6 +40,10 @@ func EncryptionText(enum int) string {
 		return "none"
 	case EncryptionAES:
 		return "AES"
+	case EncryptionAESGCM:
+		return "AES-GCM"
+	case EncryptionChaCha20:
+		return "ChaCha20-Poly1305"
 	}
 
 	return "unknown"
@@ -60,6 +71,119 @@ func decryptAESCFB(dst, src, key, iv []byte) error {
 	return nil
 }
 
+// nonceKeyLabel separates the key nonces get derived with from the key data
+// gets encrypted with
+const nonceKeyLabel = "knoxite nonce"
+
+// nonceKey derives the key for sealAEAD's nonces from the encryption key
+func nonceKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonceKeyLabel))
+	return mac.Sum(nil)
+}
+
+// sealAEAD encrypts and authenticates src, as well as the additional data ad.
+// The nonce is derived from nonceKey and src, so equal data results in equal
+// ciphertexts and can still be deduplicated, while different data never
+// shares a nonce. nonceKey must not be the key aead uses, see nonceKey. The
+// nonce gets stored with the ciphertext, so opening doesn't need it
+func sealAEAD(aead cipher.AEAD, nonceKey, src, ad []byte) []byte {
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write(src)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	return aead.Seal(nonce, nonce, src, ad)
+}
+
+// openAEAD decrypts src and verifies its authenticity, as well as the
+// authenticity of the additional data ad
+func openAEAD(aead cipher.AEAD, src, ad []byte) ([]byte, error) {
+	if len(src) < aead.NonceSize()+aead.Overhead() {
+		return []byte{}, ErrDecryptionFailed
+	}
+
+	nonce := src[:aead.NonceSize()]
+	dst, err := aead.Open(nil, nonce, src[aead.NonceSize():], ad)
+	if err != nil {
+		return []byte{}, ErrDecryptionFailed
+	}
+	return dst, nil
+}
+
+// newAEAD returns the authenticated cipher for algo
+func newAEAD(algo int, key []byte) (cipher.AEAD, error) {
+	switch algo {
+	case EncryptionAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case EncryptionChaCha20:
+		return chacha20poly1305.New(key)
+	}
+
+	return nil, ErrUnknownEncryption
+}
+
+// EncryptWith encrypts data using the given encryption algo. Authenticated
+// algos prefix the data with a crypto header describing how it got encrypted
+func EncryptWith(data []byte, password string, algo int) ([]byte, error) {
+	switch algo {
+	case EncryptionNone:
+		return data, nil
+	case EncryptionAES:
+		return Encrypt(data, password)
+	}
+
+	return newCryptoHeader(algo).seal(data, password)
+}
+
+// DecryptWith decrypts data, which got encrypted using the given encryption
+// algo. Authenticated algos detect any tampering with the data. If the data
+// carries a crypto header, the cipher recorded in it takes precedence
+func DecryptWith(data []byte, password string, algo int) ([]byte, error) {
+	switch algo {
+	case EncryptionNone:
+		return data, nil
+	case EncryptionAES:
+		return Decrypt(data, password)
+	}
+
+	h, encrypted, found, err := parseCryptoHeader(data)
+	if found {
+		var dst []byte
+		if err == nil {
+			dst, err = h.open(encrypted, password)
+		}
+		if err == nil || err == ErrInvalidPassword {
+			return dst, err
+		}
+		// Data written before crypto headers existed may start just like one
+		if dst, lerr := decryptLegacyAEAD(data, password, algo); lerr == nil {
+			return dst, nil
+		}
+		return []byte{}, err
+	}
+
+	return decryptLegacyAEAD(data, password, algo)
+}
+
+// decryptLegacyAEAD decrypts data written by an authenticated algo before
+// crypto headers existed
+func decryptLegacyAEAD(data []byte, password string, algo int) ([]byte, error) {
+	if len(password) == 0 {
+		return []byte{}, ErrInvalidPassword
+	}
+
+	key := sha256.Sum256([]byte(password))
+	aead, err := newAEAD(algo, key[:])
+	if err != nil {
+		return []byte{}, err
+	}
+	return openAEAD(aead, data, nil)
+}
+
 // Encrypt data
 func Encrypt(data []byte, password string) ([]byte, error) {
 	var err error
@@ -8,6 +8,7 @@
 package knoxite
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -42,3 +43,97 @@ func TestEmptyPassword(t *testing.T) {
 		t.Errorf("Expected %v, got %v", ErrInvalidPassword, err)
 	}
 }
+
+func TestAuthenticatedEncryption(t *testing.T) {
+	testPassword := "this_is_a_password"
+	b := []byte("1234567890")
+
+	for _, algo := range []int{EncryptionAESGCM, EncryptionChaCha20} {
+		be, err := EncryptWith(b, testPassword, algo)
+		if err != nil {
+			t.Error(err)
+		}
+		bd, err := DecryptWith(be, testPassword, algo)
+		if err != nil || string(b) != string(bd) {
+			t.Errorf("Data mismatch after %s encryption & decryption cycle: %v", EncryptionText(algo), err)
+		}
+
+		// Equal data must result in equal ciphertexts for deduplication
+		if be2, _ := EncryptWith(b, testPassword, algo); string(be) != string(be2) {
+			t.Errorf("Encrypting the same data twice with %s resulted in different ciphertexts", EncryptionText(algo))
+		}
+
+		be[len(be)-1] ^= 0xff
+		if _, err = DecryptWith(be, testPassword, algo); err != ErrDecryptionFailed {
+			t.Errorf("Expected %v for tampered data, got %v", ErrDecryptionFailed, err)
+		}
+		if _, err = DecryptWith(be[:4], testPassword, algo); err != ErrDecryptionFailed {
+			t.Errorf("Expected %v for truncated data, got %v", ErrDecryptionFailed, err)
+		}
+	}
+
+	// Data encrypted with the legacy algo stays readable
+	be, _ := Encrypt(b, testPassword)
+	if bd, err := DecryptWith(be, testPassword, EncryptionAES); err != nil || string(b) != string(bd) {
+		t.Errorf("Failed decrypting legacy data: %v", err)
+	}
+}
+
+func TestCryptoHeader(t *testing.T) {
+	testPassword := "this_is_a_password"
+	b := []byte("1234567890")
+
+	be, err := EncryptWith(b, testPassword, EncryptionChaCha20)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	h, _, found, err := parseCryptoHeader(be)
+	if !found || err != nil || h.Cipher != EncryptionChaCha20 || h.KDF != KeyDerivationSHA256 {
+		t.Errorf("Unexpected crypto header %+v: %v", h, err)
+	}
+
+	// The header decides how the data gets decrypted
+	if bd, err := DecryptWith(be, testPassword, EncryptionAESGCM); err != nil || string(b) != string(bd) {
+		t.Errorf("Failed decrypting data with crypto header: %v", err)
+	}
+
+	// Tampering with the header gets detected
+	tampered := append([]byte{}, be...)
+	tampered[len(cryptoHeaderMagic)+1] = EncryptionAESGCM
+	if _, err = DecryptWith(tampered, testPassword, EncryptionChaCha20); err != ErrDecryptionFailed {
+		t.Errorf("Expected %v, got %v", ErrDecryptionFailed, err)
+	}
+	tampered[len(cryptoHeaderMagic)] = cryptoHeaderVersion + 1
+	if _, err = DecryptWith(tampered, testPassword, EncryptionChaCha20); err != ErrCryptoHeaderVersion {
+		t.Errorf("Expected %v, got %v", ErrCryptoHeaderVersion, err)
+	}
+
+	// Data written before crypto headers existed stays readable
+	legacy, _ := cryptoHeader{Cipher: EncryptionAESGCM, KDF: KeyDerivationSHA256}.key(testPassword)
+	aead, _ := newAEAD(EncryptionAESGCM, legacy)
+	if bd, err := DecryptWith(sealAEAD(aead, legacy, b, nil), testPassword, EncryptionAESGCM); err != nil || string(b) != string(bd) {
+		t.Errorf("Failed decrypting legacy data: %v", err)
+	}
+}
+
+func TestNonceKey(t *testing.T) {
+	testPassword := "this_is_a_password"
+	h := newCryptoHeader(EncryptionChaCha20)
+	key, _ := h.key(testPassword)
+	aead, _ := newAEAD(EncryptionChaCha20, key)
+	b := []byte("some data to encrypt")
+
+	// Nonces must not be derived with the key the data gets encrypted with
+	sealed := sealAEAD(aead, nonceKey(key), b, nil)
+	if bytes.Equal(sealed[:aead.NonceSize()], sealAEAD(aead, key, b, nil)[:aead.NonceSize()]) {
+		t.Errorf("Expected nonce to be derived with a separate key")
+	}
+
+	// Data sealed with the encryption key as nonce key stays readable
+	header := h.marshal()
+	old := append(header, sealAEAD(aead, key, b, header)...)
+	if bd, err := DecryptWith(old, testPassword, EncryptionChaCha20); err != nil || !bytes.Equal(b, bd) {
+		t.Errorf("Failed decrypting data sealed before nonce keys: %v", err)
+	}
+}
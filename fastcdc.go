@@ -0,0 +1,110 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gear maps each byte to a random number, driving the rolling hash of FastCDC.
+// It must never change, or chunk boundaries of existing data would shift
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed
+	seed := uint64(0x6b6e6f78697465)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// fastCDC splits data into content-defined chunks with the FastCDC algorithm.
+// Boundaries only depend on the bytes preceding them, so inserting data into
+// a file doesn't shift the boundaries of the chunks that follow
+type fastCDC struct {
+	rd  io.Reader
+	buf []byte
+	eof bool
+
+	min, avg, max int
+	// maskS is used before reaching the average size, making a cut less
+	// likely, maskL afterwards. This normalizes the chunk size distribution
+	maskS, maskL uint64
+}
+
+// newFastCDC returns a FastCDC splitter producing chunks of at least min and
+// at most max bytes, averaging at roughly avg bytes
+func newFastCDC(rd io.Reader, min, avg, max int) *fastCDC {
+	b := bits.Len(uint(avg)) - 1
+	return &fastCDC{
+		rd:    rd,
+		buf:   make([]byte, 0, max),
+		min:   min,
+		avg:   avg,
+		max:   max,
+		maskS: ^uint64(0) << uint(64-b-1),
+		maskL: ^uint64(0) << uint(64-b+1),
+	}
+}
+
+// next returns the next chunk of data or io.EOF once all data got consumed
+func (c *fastCDC) next() ([]byte, error) {
+	if !c.eof && len(c.buf) < c.max {
+		n, err := io.ReadFull(c.rd, c.buf[len(c.buf):c.max])
+		c.buf = c.buf[:len(c.buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf)
+	data := make([]byte, n)
+	copy(data, c.buf[:n])
+	c.buf = c.buf[:copy(c.buf, c.buf[n:])]
+
+	return data, nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *fastCDC) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+
+	var hash uint64
+	i := c.min
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,112 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func splitAll(s splitter) ([][]byte, error) {
+	chunks := [][]byte{}
+	for {
+		data, err := s.next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, data)
+	}
+}
+
+func TestFastCDC(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(42)).Read(data)
+
+	chunks, err := splitAll(newFastCDC(bytes.NewReader(data), 2048, 8192, 65536))
+	if err != nil {
+		t.Errorf("Failed splitting data: %s", err)
+		return
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Errorf("Chunks don't add up to the original data")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 65536 || (len(chunk) < 2048 && i < len(chunks)-1) {
+			t.Errorf("Chunk %d has invalid size %d", i, len(chunk))
+		}
+	}
+	if len(chunks) < 64 || len(chunks) > 256 {
+		t.Errorf("Expected roughly 128 chunks, got %d", len(chunks))
+	}
+
+	// Inserting a byte must only affect the chunk it got inserted into
+	shifted := append([]byte{0x42}, data...)
+	shiftedChunks, _ := splitAll(newFastCDC(bytes.NewReader(shifted), 2048, 8192, 65536))
+	known := make(map[string]bool)
+	for _, chunk := range chunks {
+		known[string(chunk)] = true
+	}
+	changed := 0
+	for _, chunk := range shiftedChunks {
+		if !known[string(chunk)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("Expected a single changed chunk, got %d", changed)
+	}
+}
+
+func TestFixedSplitter(t *testing.T) {
+	data := make([]byte, fileChunk*2+42)
+	s, err := newSplitter(bytes.NewReader(data), ChunkerFixed, ChunkSize{})
+	if err != nil {
+		t.Errorf("Failed creating splitter: %s", err)
+		return
+	}
+	chunks, err := splitAll(s)
+	if err != nil || len(chunks) != 3 || len(chunks[0]) != fileChunk || len(chunks[2]) != 42 {
+		t.Errorf("Unexpected fixed chunks: %d, %v", len(chunks), err)
+	}
+
+	if _, err = newSplitter(bytes.NewReader(data), -1, ChunkSize{}); err != ErrUnknownChunker {
+		t.Errorf("Expected %v, got %v", ErrUnknownChunker, err)
+	}
+
+	s, _ = newSplitter(bytes.NewReader(data), ChunkerFixed, ChunkSize{Average: 4096})
+	if chunk, _ := s.next(); len(chunk) != 4096 {
+		t.Errorf("Expected chunk of 4096 bytes, got %d", len(chunk))
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	size, err := ChunkSize{Average: 4 << 20}.resolve()
+	if err != nil || size.Min != 2<<20 || size.Max != 32<<20 {
+		t.Errorf("Unexpected default chunk sizes: %+v, %v", size, err)
+	}
+	size = ChunkSize{Min: 1024, Average: 8192}.override(ChunkSize{Average: 4096})
+	if size.Min != 1024 || size.Average != 4096 {
+		t.Errorf("Unexpected overridden chunk sizes: %+v", size)
+	}
+
+	for _, size := range []ChunkSize{
+		{Min: 8192, Average: 4096},
+		{Average: 8192, Max: 4096},
+		{Average: maxChunkSize},
+		{Min: -1},
+	} {
+		if _, err = size.resolve(); err != ErrInvalidChunkSize {
+			t.Errorf("Expected %v for %+v, got %v", ErrInvalidChunkSize, size, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A PathFilter selects items by their path. Patterns are shell patterns like
+// "home/**/*.jpg", where ** matches any number of directories. Patterns
+// without a slash, like "*.jpg", match the name of an item at any depth. A
+// pattern matching a directory also matches everything within it
+type PathFilter struct {
+	// Include selects the matching items, all items if empty
+	Include []string
+	// Exclude skips the matching items, even if they got included
+	Exclude []string
+}
+
+// Check returns an error if any of the patterns is malformed
+func (f PathFilter) Check() error {
+	for _, pattern := range append(f.Include, f.Exclude...) {
+		if _, err := path.Match(strings.Trim(filepath.ToSlash(pattern), "/"), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match returns whether the item at p passes the filter
+func (f PathFilter) Match(p string) bool {
+	segments := splitPath(p)
+	included := len(f.Include) == 0
+	for _, pattern := range f.Include {
+		if matchPrefix(pattern, segments) {
+			included = true
+			break
+		}
+	}
+	return included && !f.excluded(segments)
+}
+
+// Excluded returns whether the item at p matches an exclude pattern. Unlike
+// items that merely aren't included, nothing within it can pass the filter
+func (f PathFilter) Excluded(p string) bool {
+	return f.excluded(splitPath(p))
+}
+
+func (f PathFilter) excluded(segments []string) bool {
+	for _, pattern := range f.Exclude {
+		if matchPrefix(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns a copy of the snapshot containing only the items passing f.
+// Directories containing such items are kept as well, so their permissions
+// get restored
+func (snapshot Snapshot) Filter(f PathFilter) (Snapshot, error) {
+	if err := f.Check(); err != nil {
+		return snapshot, err
+	}
+
+	parents := make(map[string]bool)
+	for _, item := range snapshot.Items {
+		if f.Match(item.Path) {
+			for dir := filepath.Dir(item.Path); dir != "." && dir != "/" && !parents[dir]; dir = filepath.Dir(dir) {
+				parents[dir] = true
+			}
+		}
+	}
+
+	filtered := []ItemData{}
+	stats := Stats{}
+	for _, item := range snapshot.Items {
+		if (item.Type == Directory && parents[item.Path]) || f.Match(item.Path) {
+			filtered = append(filtered, item)
+			stats.AddItem(&item)
+		}
+	}
+
+	snapshot.Items = filtered
+	snapshot.Stats = stats
+	return snapshot, nil
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(filepath.ToSlash(p), "/"), "/")
+}
+
+// matchPrefix returns whether pattern matches the path made of segments or
+// one of its parent directories
+func matchPrefix(pattern string, segments []string) bool {
+	patterns := splitPath(pattern)
+	if !strings.Contains(filepath.ToSlash(pattern), "/") && pattern != "**" {
+		// Matches a name at any depth
+		for _, segment := range segments {
+			if ok, _ := path.Match(patterns[0], segment); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for i := len(segments); i > 0; i-- {
+		if matchSegments(patterns, segments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches a path segment by segment, ** matching any number of
+// them
+func matchSegments(patterns, segments []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(patterns[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], segments[0]); !ok {
+			return false
+		}
+		patterns, segments = patterns[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
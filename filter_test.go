@@ -0,0 +1,80 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	f := PathFilter{
+		Include: []string{"home/**/*.jpg", "etc/fstab", "/srv"},
+		Exclude: []string{"**/cache/**"},
+	}
+	for path, expected := range map[string]bool{
+		"home/a.jpg":              true,
+		"home/user/photos/b.jpg":  true,
+		"home/user/photos/b.png":  false,
+		"home/user/cache/c.jpg":   false,
+		"etc/fstab":               true,
+		"etc/passwd":              false,
+		"/srv/www/index.html":     true,
+		"srv/www/cache/data":      false,
+		"other/home/user/cat.jpg": false,
+	} {
+		if f.Match(path) != expected {
+			t.Errorf("Expected match of %s to be %v", path, expected)
+		}
+	}
+
+	// Patterns without a slash match names at any depth
+	f = PathFilter{Include: []string{"*.go"}, Exclude: []string{"vendor"}}
+	for path, expected := range map[string]bool{
+		"main.go":            true,
+		"cmd/knoxite/ls.go":  true,
+		"vendor/lib/lib.go":  false,
+		"src/vendor/lib.go":  false,
+		"cmd/knoxite/README": false,
+	} {
+		if f.Match(path) != expected {
+			t.Errorf("Expected match of %s to be %v", path, expected)
+		}
+	}
+
+	if (PathFilter{}).Match("anything") != true {
+		t.Errorf("Empty filter should match everything")
+	}
+	if err := (PathFilter{Include: []string{"[a-"}}).Check(); err == nil {
+		t.Errorf("Malformed pattern did not fail")
+	}
+}
+
+func TestSnapshotFilter(t *testing.T) {
+	snapshot := Snapshot{Items: []ItemData{
+		{Path: "home", Type: Directory},
+		{Path: "home/user", Type: Directory},
+		{Path: "home/user/a.jpg", Type: File, Size: 10},
+		{Path: "home/user/b.txt", Type: File, Size: 20},
+		{Path: "var", Type: Directory},
+	}}
+
+	filtered, err := snapshot.Filter(PathFilter{Include: []string{"**/*.jpg"}})
+	if err != nil {
+		t.Errorf("Failed filtering snapshot: %s", err)
+		return
+	}
+	if len(filtered.Items) != 3 || filtered.Items[2].Path != "home/user/a.jpg" {
+		t.Errorf("Expected a.jpg and its parent directories, got %v", filtered.Items)
+	}
+	if filtered.Stats.Files != 1 || filtered.Stats.Size != 10 {
+		t.Errorf("Unexpected stats of filtered snapshot: %v", filtered.Stats)
+	}
+	if len(snapshot.Items) != 5 {
+		t.Errorf("Filtering modified the original snapshot")
+	}
+}
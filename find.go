@@ -0,0 +1,109 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FindQuery describes the files to search for. Zero values don't restrict
+// the search
+type FindQuery struct {
+	// Pattern is a shell pattern like "*.jpg". It gets matched against the
+	// file's name, or against its path if the pattern contains a slash, in
+	// which case ** matches any number of directories
+	Pattern string
+	// MinSize and MaxSize limit the size of files
+	MinSize uint64
+	MaxSize uint64
+	// NewerThan and OlderThan limit the modification time of files
+	NewerThan time.Time
+	OlderThan time.Time
+}
+
+// FoundFile is a version of a file found by Find
+type FoundFile struct {
+	Item ItemData
+	// Snapshots are the IDs of the snapshots containing this version
+	Snapshots []string
+}
+
+// Check returns an error if the pattern is malformed
+func (q FindQuery) Check() error {
+	_, err := path.Match(strings.Trim(q.Pattern, "/"), "")
+	return err
+}
+
+// Match returns whether item satisfies the query
+func (q FindQuery) Match(item ItemData) bool {
+	if q.Pattern != "" {
+		segments := splitPath(item.Path)
+		if strings.Contains(q.Pattern, "/") {
+			if !matchSegments(splitPath(q.Pattern), segments) {
+				return false
+			}
+		} else if ok, _ := path.Match(q.Pattern, segments[len(segments)-1]); !ok {
+			return false
+		}
+	}
+	if item.Size < q.MinSize || (q.MaxSize > 0 && item.Size > q.MaxSize) {
+		return false
+	}
+	if (!q.NewerThan.IsZero() && !item.ModTime.After(q.NewerThan)) ||
+		(!q.OlderThan.IsZero() && !item.ModTime.Before(q.OlderThan)) {
+		return false
+	}
+	return true
+}
+
+// Find searches all snapshots of the volume for files matching q. Versions of
+// a file that didn't change between snapshots get reported once, along with
+// all snapshots containing them. Results are sorted by path and modification
+// time
+func (v *Volume) Find(repository *Repository, q FindQuery) ([]FoundFile, error) {
+	if err := q.Check(); err != nil {
+		return nil, err
+	}
+
+	found := []FoundFile{}
+	versions := make(map[string]int)
+	for _, id := range v.Snapshots {
+		snapshot, err := v.LoadSnapshot(id, repository)
+		if err != nil {
+			return found, err
+		}
+
+		for _, item := range snapshot.Items {
+			if !q.Match(item) {
+				continue
+			}
+
+			key := item.Path + "\x00" + item.ModTime.String() + "\x00" + strconv.FormatUint(item.Size, 10)
+			if i, ok := versions[key]; ok {
+				found[i].Snapshots = append(found[i].Snapshots, snapshot.ID)
+				continue
+			}
+			item.Chunks = nil
+			item.Data = nil
+			versions[key] = len(found)
+			found = append(found, FoundFile{Item: item, Snapshots: []string{snapshot.ID}})
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Item.Path != found[j].Item.Path {
+			return found[i].Item.Path < found[j].Item.Path
+		}
+		return found[i].Item.ModTime.Before(found[j].Item.ModTime)
+	})
+	return found, nil
+}
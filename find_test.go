@@ -0,0 +1,65 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFind(t *testing.T) {
+	r, err := NewRepository("memory://find", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	versions := [][]ItemData{
+		{{Path: "photos/a.jpg", Size: 100, ModTime: old}, {Path: "notes.txt", Size: 10, ModTime: old}},
+		{{Path: "photos/a.jpg", Size: 100, ModTime: old}, {Path: "photos/b.jpg", Size: 5000, ModTime: old}},
+		{{Path: "photos/a.jpg", Size: 200, ModTime: old.AddDate(1, 0, 0)}},
+	}
+	ids := []string{}
+	for _, items := range versions {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		snapshot.Items = items
+		if err = snapshot.Save(&r); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+			return
+		}
+		vol.AddSnapshot(snapshot.ID)
+		ids = append(ids, snapshot.ID)
+	}
+
+	found, err := vol.Find(&r, FindQuery{Pattern: "*.jpg"})
+	if err != nil {
+		t.Errorf("Failed finding files: %s", err)
+		return
+	}
+	if len(found) != 3 {
+		t.Errorf("Expected 3 versions, got %d", len(found))
+		return
+	}
+	if found[0].Item.Path != "photos/a.jpg" || len(found[0].Snapshots) != 2 || found[0].Snapshots[1] != ids[1] {
+		t.Errorf("Unexpected first version of a.jpg: %v", found[0])
+	}
+	if found[1].Item.Size != 200 || found[1].Snapshots[0] != ids[2] {
+		t.Errorf("Unexpected second version of a.jpg: %v", found[1])
+	}
+
+	found, _ = vol.Find(&r, FindQuery{Pattern: "photos/*", MinSize: 150, OlderThan: old.AddDate(0, 6, 0)})
+	if len(found) != 1 || found[0].Item.Path != "photos/b.jpg" {
+		t.Errorf("Size and time constraints not applied: %v", found)
+	}
+	if _, err = vol.Find(&r, FindQuery{Pattern: "[a-"}); err == nil {
+		t.Errorf("Malformed pattern did not fail")
+	}
+}
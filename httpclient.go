@@ -0,0 +1,104 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Error declarations
+var (
+	ErrInvalidProxy      = errors.New("Invalid proxy, expected an http, https or socks5 URL")
+	ErrInvalidCACert     = errors.New("No valid certificates found in CA file")
+	ErrMissingClientCert = errors.New("Client certificate and key have to be given together")
+)
+
+// newTLSConfig returns the TLS configuration for connections to the storage
+// at u. The URL parameter "cacert" names a PEM file with additional CAs to
+// trust, "cert" and "key" name the client certificate used for mutual TLS
+func newTLSConfig(u url.URL) (*tls.Config, error) {
+	query := u.Query()
+	config := &tls.Config{}
+
+	if v := query.Get("cacert"); v != "" {
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, ErrInvalidCACert
+		}
+		config.RootCAs = pool
+	}
+
+	cert, key := query.Get("cert"), query.Get("key")
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, ErrMissingClientCert
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config, nil
+}
+
+// newHTTPTransport returns the transport used by backends talking HTTP(S) to
+// the storage at u. Requests honor the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, unless a "proxy" URL parameter like
+// socks5://localhost:1080 is given for the backend. All connections get
+// throttled according to limits
+func newHTTPTransport(u url.URL, limits *transferLimits) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(u)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+	if limits != nil {
+		transport.DialContext = limits.dialer(transport.DialContext)
+	}
+
+	if v := u.Query().Get("proxy"); v != "" {
+		proxy, err := url.Parse(v)
+		if err != nil || proxy.Host == "" {
+			return nil, ErrInvalidProxy
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, ErrInvalidProxy
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return transport, nil
+}
+
+// newHTTPClient returns an http.Client using the transport of newHTTPTransport
+func newHTTPClient(u url.URL, limits *transferLimits) (*http.Client, error) {
+	transport, err := newHTTPTransport(u, limits)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,155 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	u, _ := url.Parse("https://host/repository?proxy=" + url.QueryEscape(proxy.URL))
+	client, err := newHTTPClient(*u, nil)
+	if err != nil {
+		t.Errorf("Failed creating client: %s", err)
+		return
+	}
+
+	res, err := client.Get("http://knoxite.invalid/chunks")
+	if err != nil {
+		t.Errorf("Failed sending request: %s", err)
+		return
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	if string(b) != "proxied" || proxied != "http://knoxite.invalid/chunks" {
+		t.Errorf("Request didn't go through proxy: %s %s", proxied, string(b))
+	}
+
+	u, _ = url.Parse("https://host/repository?proxy=ftp://proxy")
+	if _, err = newHTTPClient(*u, nil); err != ErrInvalidProxy {
+		t.Errorf("Expected %v, got %v", ErrInvalidProxy, err)
+	}
+}
+
+func TestHTTPClientCACert(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for certificate: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cacert := filepath.Join(dir, "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err = ioutil.WriteFile(cacert, b, 0600); err != nil {
+		t.Errorf("Failed writing certificate: %s", err)
+		return
+	}
+
+	u, _ := url.Parse(ts.URL)
+	client, _ := newHTTPClient(*u, nil)
+	if _, err = client.Get(ts.URL); err == nil {
+		t.Errorf("Expected untrusted certificate to be rejected")
+	}
+
+	u, _ = url.Parse(ts.URL + "?cacert=" + url.QueryEscape(cacert))
+	client, err = newHTTPClient(*u, nil)
+	if err != nil {
+		t.Errorf("Failed creating client: %s", err)
+		return
+	}
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Errorf("Failed sending request: %s", err)
+		return
+	}
+	res.Body.Close()
+
+	u, _ = url.Parse(ts.URL + "?cert=" + url.QueryEscape(cacert))
+	if _, err = newHTTPClient(*u, nil); err != ErrMissingClientCert {
+		t.Errorf("Expected %v, got %v", ErrMissingClientCert, err)
+	}
+}
+
+func TestHTTPClientLimits(t *testing.T) {
+	data := make([]byte, 128*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	limits := newTransferLimits()
+	client, err := newHTTPClient(*u, limits)
+	if err != nil {
+		t.Errorf("Failed creating client: %s", err)
+		return
+	}
+
+	// Both scopes apply, the lower limit wins
+	limits.set(limitBackend, newRateLimiter(256*1024), nil)
+	limits.set(limitShared, newRateLimiter(1024*1024), newRateLimiter(256*1024))
+
+	start := time.Now()
+	res, err := client.Post(server.URL, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("Failed sending request: %s", err)
+		return
+	}
+	uploaded := time.Since(start)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if len(b) != len(data) {
+		t.Errorf("Expected %d bytes, got %d", len(data), len(b))
+	}
+	if uploaded < 400*time.Millisecond {
+		t.Errorf("Upload wasn't throttled, took %v", uploaded)
+	}
+	if d := time.Since(start) - uploaded; d < 400*time.Millisecond {
+		t.Errorf("Download wasn't throttled, took %v", d)
+	}
+
+	// Backend options throttle the backend's connections
+	backend, err := BackendFromURL(server.URL + "?limit-upload=1M")
+	if err != nil {
+		t.Errorf("Failed creating backend: %s", err)
+		return
+	}
+	limits = backendLimits(backend)
+	if limits == nil || limits.upload[limitBackend] == nil || backend.(*storageWithOptions).upload != nil {
+		t.Errorf("Upload limit wasn't applied to the backend's connections")
+	}
+
+	// So do the combined limits of all backends
+	manager := BackendManager{}
+	manager.AddBackend(&backend)
+	manager.SetLimits(0, 1024*1024)
+	if limits.download[limitShared] == nil || limits.upload[limitShared] != nil {
+		t.Errorf("Combined limits weren't applied to the backend's connections")
+	}
+}
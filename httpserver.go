@@ -0,0 +1,211 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTPServer exposes a Backend over knoxite's REST protocol:
+//
+//	GET  /space             available space in bytes
+//	POST /init              initialize a new repository
+//	GET  /repository        load the repository metadata
+//	PUT  /repository        store the repository metadata
+//	GET  /snapshots/ID      load a snapshot
+//	PUT  /snapshots/ID      store a snapshot
+//	DELETE /snapshots/ID    delete a snapshot
+//	GET  /snapshots         list all snapshot IDs
+//	GET  /chunks/SHA.N_T    load part N of T of a chunk
+//	HEAD /chunks/SHA.N_T    returns the stored size in the X-Chunk-Size header
+//	PUT  /chunks/SHA.N_T    store part N of T of a chunk, returns the stored size
+//	DELETE /chunks/SHA.N_T  delete part N of T of a chunk
+//	GET  /chunks            list all chunk names
+//	GET  /repository-parts  list all repository metadata files
+//
+// Lists are returned as newline-separated names.
+// All requests need to be authenticated with HTTP basic auth
+type HTTPServer struct {
+	Backend  Backend
+	Username string
+	Password string
+}
+
+// maximum size of a single request body
+const httpMaxBodySize = 1 << 30
+
+// ServeHTTP handles a single REST request
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok ||
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.Username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.Password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="knoxite"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	p := strings.Trim(r.URL.Path, "/")
+	switch {
+	case p == "space" && r.Method == "GET":
+		space, err := s.Backend.AvailableSpace()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		w.Write([]byte(strconv.FormatUint(space, 10)))
+
+	case p == "init" && r.Method == "POST":
+		err := s.Backend.InitRepository()
+		if err == ErrRepositoryExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		s.respond(w, nil, err, http.StatusInternalServerError)
+
+	case p == "chunks" && r.Method == "GET":
+		names, err := s.Backend.ListChunks()
+		s.respond(w, []byte(strings.Join(names, "\n")), err, http.StatusInternalServerError)
+
+	case p == "snapshots" && r.Method == "GET":
+		names, err := s.Backend.ListSnapshots()
+		s.respond(w, []byte(strings.Join(names, "\n")), err, http.StatusInternalServerError)
+
+	case p == "repository-parts" && r.Method == "GET":
+		names, err := s.Backend.ListRepositoryParts()
+		s.respond(w, []byte(strings.Join(names, "\n")), err, http.StatusInternalServerError)
+
+	case p == "repository":
+		switch r.Method {
+		case "GET":
+			b, err := s.Backend.LoadRepository()
+			s.respond(w, b, err, http.StatusNotFound)
+		case "PUT":
+			b, err := s.readBody(w, r)
+			if err == nil {
+				err = s.Backend.SaveRepository(b)
+			}
+			s.respond(w, nil, err, http.StatusInternalServerError)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case strings.HasPrefix(p, "snapshots/"):
+		id := strings.TrimPrefix(p, "snapshots/")
+		if !validHTTPName(id) {
+			http.Error(w, "Invalid snapshot ID", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			b, err := s.Backend.LoadSnapshot(id)
+			s.respond(w, b, err, http.StatusNotFound)
+		case "PUT":
+			b, err := s.readBody(w, r)
+			if err == nil {
+				err = s.Backend.SaveSnapshot(id, b)
+			}
+			s.respond(w, nil, err, http.StatusInternalServerError)
+		case "DELETE":
+			err := s.Backend.DeleteSnapshot(id)
+			s.respond(w, nil, err, http.StatusInternalServerError)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case strings.HasPrefix(p, "chunks/"):
+		shasum, part, totalParts, ok := parseChunkName(strings.TrimPrefix(p, "chunks/"))
+		if !ok {
+			http.Error(w, "Invalid chunk name", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			b, err := s.Backend.LoadChunk(shasum, part, totalParts)
+			if err != nil {
+				s.respond(w, nil, err, http.StatusNotFound)
+				return
+			}
+			s.respond(w, *b, nil, http.StatusOK)
+		case "HEAD":
+			size, err := s.Backend.StatChunk(shasum, part, totalParts)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("X-Chunk-Size", strconv.FormatUint(size, 10))
+		case "PUT":
+			b, err := s.readBody(w, r)
+			if err != nil {
+				s.respond(w, nil, err, http.StatusBadRequest)
+				return
+			}
+			size, err := s.Backend.StoreChunk(shasum, part, totalParts, &b)
+			s.respond(w, []byte(strconv.FormatUint(size, 10)), err, http.StatusInternalServerError)
+		case "DELETE":
+			err := s.Backend.DeleteChunk(shasum, part, totalParts)
+			s.respond(w, nil, err, http.StatusInternalServerError)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *HTTPServer) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return ioutil.ReadAll(http.MaxBytesReader(w, r.Body, httpMaxBodySize))
+}
+
+// respond sends b to the client, or err with the given HTTP status code
+func (s *HTTPServer) respond(w http.ResponseWriter, b []byte, err error, errStatus int) {
+	if err != nil {
+		// We can't tell missing objects apart from other errors for all
+		// backends, so failed loads are always reported as not found
+		http.Error(w, err.Error(), errStatus)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b)
+}
+
+// validHTTPName checks that name can safely be used as an object name
+func validHTTPName(name string) bool {
+	return len(name) > 0 && !strings.ContainsAny(name, "/\\") && name != "." && name != ".."
+}
+
+// parseChunkName splits a chunk name (SHASUM.PART_TOTALPARTS) into its components
+func parseChunkName(name string) (string, uint, uint, bool) {
+	dot := strings.LastIndex(name, ".")
+	if dot < 4 || !validHTTPName(name) {
+		return "", 0, 0, false
+	}
+	parts := strings.Split(name[dot+1:], "_")
+	if len(parts) != 2 {
+		return "", 0, 0, false
+	}
+
+	part, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	totalParts, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, 0, false
+	}
+
+	return name[:dot], uint(part), uint(totalParts), true
+}
@@ -0,0 +1,95 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServer(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for repository: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	local, _ := NewStorageLocal(dir)
+	ts := httptest.NewServer(&HTTPServer{
+		Backend:  local,
+		Username: "user",
+		Password: "secret",
+	})
+	defer ts.Close()
+
+	u := strings.Replace(ts.URL, "http://", "http://user:secret@", 1)
+	_, err = NewRepository(u, testPassword)
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+
+	_, err = NewRepository(u, testPassword)
+	if err != ErrRepositoryExists {
+		t.Errorf("Expected %v, got %v", ErrRepositoryExists, err)
+	}
+
+	_, err = OpenRepository(u, testPassword)
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+	}
+
+	_, err = OpenRepository(strings.Replace(u, "secret", "wrong", 1), testPassword)
+	if err == nil {
+		t.Errorf("Expected authentication to fail")
+	}
+
+	data := []byte("1234567890")
+	shasum := "0123456789abcdef"
+	pu, _ := url.Parse(u)
+	be, _ := NewStorageHTTP(*pu)
+	if size, serr := be.StoreChunk(shasum, 0, 1, &data); serr != nil || size != uint64(len(data)) {
+		t.Errorf("Failed storing chunk: %v (%d bytes)", serr, size)
+	}
+	if size, serr := be.StatChunk(shasum, 0, 1); serr != nil || size != uint64(len(data)) {
+		t.Errorf("Failed stat'ing chunk: %v (%d bytes)", serr, size)
+	}
+	if size, serr := be.StoreChunk(shasum, 0, 1, &data); serr != nil || size != 0 {
+		t.Errorf("Expected already stored chunk to be skipped: %v (%d bytes)", serr, size)
+	}
+	b, err := be.LoadChunk(shasum, 0, 1)
+	if err != nil || string(*b) != string(data) {
+		t.Errorf("Failed loading chunk: %v", err)
+	}
+
+	chunks, err := be.ListChunks()
+	if err != nil || len(chunks) != 1 || chunks[0] != shasum+".0_1" {
+		t.Errorf("Failed listing chunks: %v %v", err, chunks)
+	}
+	parts, err := be.ListRepositoryParts()
+	if err != nil || len(parts) != 1 || parts[0] != repoFilename {
+		t.Errorf("Failed listing repository parts: %v %v", err, parts)
+	}
+
+	if err = be.DeleteChunk(shasum, 0, 1); err != nil {
+		t.Errorf("Failed deleting chunk: %s", err)
+	}
+	if _, err = be.LoadChunk(shasum, 0, 1); err == nil {
+		t.Errorf("Expected loading a deleted chunk to fail")
+	}
+	if _, err = be.StatChunk(shasum, 0, 1); err != ErrChunkNotFound {
+		t.Errorf("Expected %v, got %v", ErrChunkNotFound, err)
+	}
+}
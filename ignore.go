@@ -0,0 +1,120 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the default name of per-directory ignore files
+const IgnoreFileName = ".knoxiteignore"
+
+// An ignoreRule is a single line of an ignore file. The syntax is the one of
+// .gitignore files: patterns are relative to the directory containing the
+// ignore file, a trailing slash only matches directories and a leading ! makes
+// a previously ignored item pass again
+type ignoreRule struct {
+	dir      string
+	patterns []string
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+// parseIgnoreRule parses a line of the ignore file in dir. ok is false for
+// empty lines and comments
+func parseIgnoreRule(dir, line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+
+	rule.dir = dir
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// Escapes a leading ! or #
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// Like with .gitignore, a slash anywhere but the end anchors a pattern to
+	// the directory of the ignore file
+	rule.anchored = strings.Contains(line, "/")
+	rule.patterns = strings.Split(strings.TrimLeft(line, "/"), "/")
+
+	for _, pattern := range rule.patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rule, false
+		}
+	}
+	return rule, line != ""
+}
+
+// match returns whether the rule applies to the item at p, an absolute path
+func (rule ignoreRule) match(p string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(rule.dir, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	segments := splitPath(rel)
+	if rule.anchored {
+		return matchSegments(rule.patterns, segments)
+	}
+	ok, _ := path.Match(rule.patterns[0], segments[len(segments)-1])
+	return ok
+}
+
+// ignoreRules are the rules of all ignore files found in a directory and its
+// parents, the later ones taking precedence
+type ignoreRules []ignoreRule
+
+// load returns the rules applying within dir: the ones of its parents plus
+// the ones of the ignore file called name within dir
+func (rules ignoreRules) load(dir, name string) (ignoreRules, error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return rules, err
+	}
+	defer f.Close()
+
+	// Don't modify the parent's rules
+	r := append(ignoreRules{}, rules...)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(dir, scanner.Text()); ok {
+			r = append(r, rule)
+		}
+	}
+	return r, scanner.Err()
+}
+
+// ignored returns whether the item at p is ignored. The last matching rule
+// decides
+func (rules ignoreRules) ignored(p string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(p, isDir) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		IgnoreFileName:                  "# build artifacts\nnode_modules/\n*.log\n!keep.log\n/build\n",
+		"main.go":                       "",
+		"error.log":                     "",
+		"keep.log":                      "",
+		"build/main":                    "",
+		"node_modules/lib/index.js":     "",
+		"src/" + IgnoreFileName:         "secret\n",
+		"src/build/main.go":             "",
+		"src/secret":                    "",
+		"src/debug.log":                 "",
+		"other/secret":                  "",
+		"other/node_modules/lib/lib.js": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Errorf("Failed writing file: %s", err)
+			return
+		}
+	}
+
+	find := func(ignoreFile string) string {
+		paths := []string{}
+		for item := range findFiles(dir, dir, StoreOptions{IgnoreFile: ignoreFile}, func(string, error) {}) {
+			if item.Type == File {
+				paths = append(paths, filepath.ToSlash(item.Path))
+			}
+		}
+		sort.Strings(paths)
+		return strings.Join(paths, " ")
+	}
+
+	expected := ".knoxiteignore keep.log main.go other/secret src/.knoxiteignore src/build/main.go"
+	if paths := find(IgnoreFileName); paths != expected {
+		t.Errorf("Expected files %s, got %s", expected, paths)
+	}
+	if paths := find(""); len(strings.Split(paths, " ")) != len(files) {
+		t.Errorf("Expected all files without ignore files, got %s", paths)
+	}
+}
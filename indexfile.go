@@ -0,0 +1,192 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// indexFilePrefix prefixes the names of index files. They get stored next to
+// the snapshots, so they end up on every backend
+const indexFilePrefix = "index-"
+
+// locationFilePrefix prefixes the names of location files, which record the
+// changes to the chunk part index. They get stored like index files
+const locationFilePrefix = "locations-"
+
+// indexCacheDir is where index files get cached locally. They never change
+// once written, so a cached copy stays valid. Empty disables the cache
+var indexCacheDir = defaultIndexCacheDir()
+
+func defaultIndexCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "knoxite", "index")
+}
+
+// saveIndexFile stores the chunks added to the dedup index since the last
+// save in a new index file
+func (r *Repository) saveIndexFile() error {
+	chunks := r.dedup.takePending()
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	name, err := r.storeIndexFile(indexFilePrefix, chunks)
+	if err != nil {
+		// Retry with the next save
+		r.dedup.restorePending(chunks)
+		return err
+	}
+
+	r.IndexFiles = append(r.IndexFiles, name)
+	return nil
+}
+
+// saveLocationFile stores the changes to the chunk part index since the last
+// save in a new location file
+func (r *Repository) saveLocationFile() error {
+	parts := r.Backend.index.takePending()
+	if len(parts) == 0 {
+		return nil
+	}
+
+	name, err := r.storeIndexFile(locationFilePrefix, parts)
+	if err != nil {
+		// Retry with the next save
+		r.Backend.index.restorePending(parts)
+		return err
+	}
+
+	r.LocationFiles = append(r.LocationFiles, name)
+	return nil
+}
+
+// storeIndexFile stores v encrypted in a new file named prefix and a random
+// suffix. Returns the name of the file
+func (r *Repository) storeIndexFile(prefix string, v interface{}) (string, error) {
+	u, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	name := prefix + strings.Replace(u.String(), "-", "", -1)
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encb, err := r.encrypt(b)
+	if err != nil {
+		return "", err
+	}
+	b = sign(r.secret(), name, encb)
+	if err = r.Backend.SaveSnapshot(name, b); err != nil {
+		return "", err
+	}
+	cacheIndexFile(name, b)
+
+	return name, nil
+}
+
+// loadIndexFiles loads all index files of the repository into its dedup
+// index and all location files into its chunk part index
+func (r *Repository) loadIndexFiles() error {
+	for _, name := range r.IndexFiles {
+		var chunks map[string]Chunk
+		if err := r.loadIndexFile(name, &chunks); err != nil {
+			return err
+		}
+		r.dedup.merge(chunks)
+	}
+
+	for _, name := range r.LocationFiles {
+		var parts map[string][]string
+		if err := r.loadIndexFile(name, &parts); err != nil {
+			return err
+		}
+		r.Backend.index.merge(parts)
+	}
+
+	return nil
+}
+
+// loadIndexFile decodes the index file name into v, preferring the local
+// cache over the backends
+func (r *Repository) loadIndexFile(name string, v interface{}) error {
+	b, err := ioutil.ReadFile(indexCachePath(name))
+	if err == nil {
+		err = r.decodeIndexFile(name, b, v)
+	}
+	if err != nil {
+		b, err = r.Backend.LoadSnapshot(name)
+		if err != nil {
+			return err
+		}
+		if err = r.decodeIndexFile(name, b, v); err != nil {
+			return err
+		}
+		cacheIndexFile(name, b)
+	}
+
+	return nil
+}
+
+// decodeIndexFile verifies and decrypts the index file name into v
+func (r *Repository) decodeIndexFile(name string, b []byte, v interface{}) error {
+	signature, b := splitSignature(b)
+	if err := checkSignature(r.secret(), name, signature, b); err != nil {
+		return err
+	}
+	decb, err := r.decrypt(b)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(decb, v)
+}
+
+// resetDedupIndex deletes all index files and empties the dedup index, e.g.
+// after chunks changed their IDs. It gets rebuilt on the next store
+func (r *Repository) resetDedupIndex() error {
+	for _, name := range r.IndexFiles {
+		if err := r.Backend.DeleteSnapshot(name); err != nil {
+			return err
+		}
+		os.Remove(indexCachePath(name))
+	}
+
+	r.IndexFiles = nil
+	r.dedup = newDedupIndex()
+	return nil
+}
+
+func indexCachePath(name string) string {
+	if indexCacheDir == "" {
+		return ""
+	}
+	return filepath.Join(indexCacheDir, name)
+}
+
+// cacheIndexFile stores a copy of an index file in the local cache. The cache
+// is merely an optimization, so failures are ignored
+func cacheIndexFile(name string, b []byte) {
+	if indexCacheDir == "" {
+		return
+	}
+	if err := os.MkdirAll(indexCacheDir, 0700); err == nil {
+		ioutil.WriteFile(indexCachePath(name), b, 0600)
+	}
+}
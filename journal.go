@@ -0,0 +1,169 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A Journal records the progress of a backup in a local file: the files
+// stored completely and the chunks uploaded so far. A backup that got
+// interrupted can be resumed with the same journal, skipping all the work
+// done before
+type Journal struct {
+	// Snapshot is the snapshot getting stored, nil for a new journal
+	Snapshot *Snapshot
+	// Items are the files stored completely
+	Items []ItemData
+	// Chunks are the chunks stored on the backends
+	Chunks []Chunk
+
+	sync.Mutex
+	path       string
+	file       *os.File
+	repository *Repository
+}
+
+// journalEntry is a single line of a journal
+type journalEntry struct {
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	Item     *ItemData `json:"item,omitempty"`
+	Chunk    *Chunk    `json:"chunk,omitempty"`
+}
+
+// OpenJournal opens the journal at path, creating it if it doesn't exist.
+// Entries are encrypted with the repository's key
+func (r *Repository) OpenJournal(path string) (*Journal, error) {
+	j := &Journal{
+		path:       path,
+		repository: r,
+	}
+
+	var valid int64
+	if f, err := os.Open(path); err == nil {
+		valid = j.load(f)
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Drop invalid entries, new ones get appended to the valid ones
+	if err = f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.file = f
+
+	return j, nil
+}
+
+// load reads all entries of a journal and returns the size of the valid ones.
+// The last entry may have been cut off by a crash, so reading stops at the
+// first invalid entry
+func (j *Journal) load(f *os.File) int64 {
+	var valid int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxChunkSize)
+	for scanner.Scan() {
+		b, err := base64.StdEncoding.DecodeString(scanner.Text())
+		if err != nil {
+			return valid
+		}
+		b, err = j.repository.decrypt(b)
+		if err != nil {
+			return valid
+		}
+		var entry journalEntry
+		if err = json.Unmarshal(b, &entry); err != nil {
+			return valid
+		}
+		valid += int64(len(scanner.Bytes())) + 1
+
+		switch {
+		case entry.Snapshot != nil:
+			j.Snapshot = entry.Snapshot
+		case entry.Item != nil:
+			j.Items = append(j.Items, *entry.Item)
+		case entry.Chunk != nil:
+			j.Chunks = append(j.Chunks, *entry.Chunk)
+		}
+	}
+	return valid
+}
+
+// Begin records the snapshot getting stored. If the journal belongs to an
+// interrupted backup, snapshot continues where that one left off
+func (j *Journal) Begin(snapshot *Snapshot) error {
+	if j.Snapshot != nil {
+		snapshot.ID = j.Snapshot.ID
+		snapshot.Date = j.Snapshot.Date
+		snapshot.Description = j.Snapshot.Description
+		return nil
+	}
+
+	j.Snapshot = &Snapshot{
+		ID:          snapshot.ID,
+		Date:        snapshot.Date,
+		Description: snapshot.Description,
+	}
+	return j.write(journalEntry{Snapshot: j.Snapshot})
+}
+
+// addItem records that a file got stored completely
+func (j *Journal) addItem(item ItemData) error {
+	return j.write(journalEntry{Item: &item})
+}
+
+// addChunk records that a chunk got stored
+func (j *Journal) addChunk(chunk Chunk) error {
+	if chunk.Data == nil {
+		// Stored already, it's in the dedup index
+		return nil
+	}
+	chunk.Data = nil
+	return j.write(journalEntry{Chunk: &chunk})
+}
+
+func (j *Journal) write(entry journalEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b, err = j.repository.encrypt(b)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	_, err = j.file.WriteString(base64.StdEncoding.EncodeToString(b) + "\n")
+	return err
+}
+
+// Close closes the journal, keeping it around to resume the backup later
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Remove closes and deletes the journal once the backup completed
+func (j *Journal) Remove() error {
+	j.file.Close()
+	return os.Remove(j.path)
+}
@@ -0,0 +1,153 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	if err = ioutil.WriteFile(path, []byte("some data to back up"), 0644); err != nil {
+		t.Errorf("Failed writing file: %s", err)
+		return
+	}
+	journalPath := filepath.Join(dir, "journal", "backup")
+
+	r, err := NewRepository("memory://journal", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	store := func() Snapshot {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		journal, err := r.OpenJournal(journalPath)
+		if err != nil {
+			t.Errorf("Failed opening journal: %s", err)
+			return snapshot
+		}
+		defer journal.Close()
+		if err = journal.Begin(&snapshot); err != nil {
+			t.Errorf("Failed beginning journal: %s", err)
+			return snapshot
+		}
+
+		progress, err := snapshot.Add(dir, []string{path}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1, Journal: journal})
+		if err != nil {
+			t.Errorf("Failed adding to snapshot: %s", err)
+			return snapshot
+		}
+		for range progress {
+		}
+		return snapshot
+	}
+
+	// Never removing the journal is like getting interrupted before saving
+	first := store()
+
+	// A crash may leave an incomplete entry behind
+	f, _ := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString("incomplete")
+	f.Close()
+
+	journal, err := r.OpenJournal(journalPath)
+	if err != nil {
+		t.Errorf("Failed opening journal: %s", err)
+		return
+	}
+	if journal.Snapshot == nil || journal.Snapshot.ID != first.ID {
+		t.Errorf("Journal did not record snapshot %s", first.ID)
+	}
+	if len(journal.Items) != 1 || len(journal.Chunks) != 1 {
+		t.Errorf("Expected 1 item and 1 chunk in journal, got %d and %d", len(journal.Items), len(journal.Chunks))
+	}
+	journal.Close()
+
+	second := store()
+	if second.ID != first.ID {
+		t.Errorf("Resumed snapshot got ID %s, expected %s", second.ID, first.ID)
+	}
+	if len(second.Items) != 1 || second.Items[0].Chunks[0].ShaSum != first.Items[0].Chunks[0].ShaSum {
+		t.Errorf("Resumed snapshot did not reuse the stored chunks")
+	}
+
+	journal, _ = r.OpenJournal(journalPath)
+	if err = journal.Remove(); err != nil {
+		t.Errorf("Failed removing journal: %s", err)
+	}
+	if _, err = os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Errorf("Journal still exists after removing it")
+	}
+}
+
+func TestJournalFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	if err = ioutil.WriteFile(path, []byte("some data to back up"), 0644); err != nil {
+		t.Errorf("Failed writing file: %s", err)
+		return
+	}
+	journalPath := filepath.Join(dir, "journal", "backup")
+
+	r, err := NewRepository("memory://journal-failure", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	journal, err := r.OpenJournal(journalPath)
+	if err != nil {
+		t.Errorf("Failed opening journal: %s", err)
+		return
+	}
+	// Writing to a closed journal fails
+	journal.Close()
+
+	progress, err := snapshot.Add(dir, []string{path}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1, Journal: journal})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	errs := 0
+	for p := range progress {
+		if p.Err != nil {
+			errs++
+			if p.Path != journalPath {
+				t.Errorf("Expected error for %s, got %s: %s", journalPath, p.Path, p.Err)
+			}
+		}
+	}
+	if errs != 1 {
+		t.Errorf("Expected a single journal error, got %d", errs)
+	}
+	if len(snapshot.Items) != 1 {
+		t.Errorf("Expected the backup to continue without journal, got %d items", len(snapshot.Items))
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// keychainService is the service name of all entries knoxite stores in the
+// OS keychain
+const keychainService = "knoxite"
+
+// Error declarations
+var (
+	ErrKeychainNotFound    = errors.New("No matching entry found in keychain")
+	ErrKeychainUnsupported = errors.New("No supported keychain found on this system")
+)
+
+// passwordAccount returns the keychain account for a repository's password
+func passwordAccount(repository string) string {
+	return "repository:" + repository
+}
+
+// credentialsAccount returns the keychain account for a backend's credentials
+func credentialsAccount(u url.URL) string {
+	u.User = nil
+	u.RawQuery = ""
+	return "backend:" + u.String()
+}
+
+// LoadPasswordFromKeychain returns the password stored for a repository
+func LoadPasswordFromKeychain(repository string) (string, error) {
+	return keychainGet(passwordAccount(repository))
+}
+
+// StorePasswordInKeychain stores the password of a repository
+func StorePasswordInKeychain(repository, password string) error {
+	return keychainSet(passwordAccount(repository), password)
+}
+
+// DeletePasswordFromKeychain removes the stored password of a repository
+func DeletePasswordFromKeychain(repository string) error {
+	return keychainDelete(passwordAccount(repository))
+}
+
+// StoreCredentialsInKeychain stores the credentials for a backend, which are
+// used whenever its URL doesn't contain any
+func StoreCredentialsInKeychain(location, username, password string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	return keychainSet(credentialsAccount(*u), username+":"+password)
+}
+
+// DeleteCredentialsFromKeychain removes the stored credentials for a backend
+func DeleteCredentialsFromKeychain(location string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	return keychainDelete(credentialsAccount(*u))
+}
+
+// credentialsFromKeychain is a credentialSource. The keychain is optional, so
+// any failure to access it just means there are no credentials
+func credentialsFromKeychain(u url.URL) (*url.Userinfo, error) {
+	secret, err := keychainGet(credentialsAccount(u))
+	if err != nil {
+		return nil, nil
+	}
+
+	kv := strings.SplitN(secret, ":", 2)
+	if len(kv) != 2 {
+		return userinfo(secret, ""), nil
+	}
+	return userinfo(kv[0], kv[1]), nil
+}
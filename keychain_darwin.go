@@ -0,0 +1,48 @@
+//go:build darwin
+// +build darwin
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// The macOS Keychain is accessed with the security tool
+
+// securityNotFound is the exit code of security for missing items
+const securityNotFound = 44
+
+func keychainError(err error) error {
+	if exiterr, ok := err.(*exec.ExitError); ok && exiterr.ExitCode() == securityNotFound {
+		return ErrKeychainNotFound
+	}
+	return err
+}
+
+func keychainGet(account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", keychainService, "-a", account, "-w").Output()
+	if err != nil {
+		return "", keychainError(err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func keychainSet(account, secret string) error {
+	return exec.Command("security", "add-generic-password", "-U",
+		"-s", keychainService, "-a", account, "-w", secret).Run()
+}
+
+func keychainDelete(account string) error {
+	err := exec.Command("security", "delete-generic-password",
+		"-s", keychainService, "-a", account).Run()
+	return keychainError(err)
+}
@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+func keychainGet(account string) (string, error) {
+	return "", ErrKeychainUnsupported
+}
+
+func keychainSet(account, secret string) error {
+	return ErrKeychainUnsupported
+}
+
+func keychainDelete(account string) error {
+	return ErrKeychainUnsupported
+}
@@ -0,0 +1,53 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build dragonfly freebsd linux netbsd openbsd solaris
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// The Secret Service (e.g. GNOME Keyring or KWallet) is accessed with
+// secret-tool from libsecret
+
+func keychainGet(account string) (string, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", ErrKeychainUnsupported
+	}
+
+	out, err := exec.Command("secret-tool", "lookup",
+		"service", keychainService, "account", account).Output()
+	if err != nil || len(out) == 0 {
+		// secret-tool doesn't distinguish missing items from other failures
+		return "", ErrKeychainNotFound
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func keychainSet(account, secret string) error {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return ErrKeychainUnsupported
+	}
+
+	cmd := exec.Command("secret-tool", "store", "--label", keychainService+" "+account,
+		"service", keychainService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	return cmd.Run()
+}
+
+func keychainDelete(account string) error {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return ErrKeychainUnsupported
+	}
+
+	return exec.Command("secret-tool", "clear",
+		"service", keychainService, "account", account).Run()
+}
@@ -0,0 +1,61 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// Error declarations
+var (
+	ErrEmptyKeyFile = errors.New("Key file is empty")
+)
+
+// GenerateKeyFile writes a new key file with random content to path, which
+// must not exist yet
+func GenerateKeyFile(path string) error {
+	b := make([]byte, 64)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// KeyFilePassword returns the password unlocking a repository with the key
+// file at path. The key file can be used on its own, with password being
+// empty, or in addition to a password
+func KeyFilePassword(password, path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", ErrEmptyKeyFile
+	}
+
+	sum := sha256.Sum256(b)
+	key := "keyfile:" + hex.EncodeToString(sum[:])
+	if password == "" {
+		return key, nil
+	}
+	return password + "\x00" + key, nil
+}
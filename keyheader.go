@@ -0,0 +1,278 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation functions
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+// keyHeaderVersion is the version of newly created key headers. Version 1
+// headers only had a single key slot
+const keyHeaderVersion = 2
+
+// keyHeaderMagic prefixes repository metadata that starts with a key header
+var keyHeaderMagic = []byte("KNXK")
+
+// Default argon2id parameters for new key slots. Slots with weaker parameters
+// get upgraded when the repository is saved
+var (
+	kdfTime    uint32 = 3
+	kdfMemory  uint32 = 64 * 1024
+	kdfThreads uint8  = 4
+)
+
+// Error declarations
+var (
+	ErrWrongPassword    = errors.New("Wrong password")
+	ErrInvalidKeyHeader = errors.New("Invalid key header")
+	ErrUnknownKDF       = errors.New("Unknown key derivation function")
+	ErrKeyHeaderVersion = errors.New("Key header version is not supported, please upgrade knoxite")
+	ErrNoKeyHeader      = errors.New("Repository was created without key management support")
+	ErrKeyNotFound      = errors.New("Key not found")
+	ErrLastKey          = errors.New("Can't remove the last key of a repository")
+)
+
+// KeySlot stores the master key of a repository, encrypted with a key derived
+// from one of its passwords
+type KeySlot struct {
+	ID          string    `json:"id,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Created     time.Time `json:"created"`
+
+	KDF  string `json:"kdf,omitempty"`
+	Salt []byte `json:"salt,omitempty"`
+
+	// argon2id parameters
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// scrypt parameters
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+
+	// Key is the encrypted master key
+	Key []byte `json:"key,omitempty"`
+}
+
+// KeyHeader stores how the key of a repository gets derived from its
+// passwords. The data gets encrypted with a random master key, which is stored
+// once per password in a key slot. Strengthening the parameters, adding or
+// removing passwords only requires re-encrypting the master key
+type KeyHeader struct {
+	Version int       `json:"version"`
+	Slots   []KeySlot `json:"slots,omitempty"`
+}
+
+// newKeyHeader creates a header for a new random master key, which gets
+// returned alongside
+func newKeyHeader(password string) (KeyHeader, string, error) {
+	master := make([]byte, 32)
+	if _, err := rand.Read(master); err != nil {
+		return KeyHeader{}, "", err
+	}
+	key := hex.EncodeToString(master)
+
+	header := KeyHeader{Version: keyHeaderVersion}
+	_, err := header.add(password, key, "")
+	return header, key, err
+}
+
+// derive returns the key derived from password with the slot's parameters
+func (slot KeySlot) derive(password string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, ErrInvalidPassword
+	}
+
+	switch slot.KDF {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(password), slot.Salt, slot.Time, slot.Memory, slot.Threads, 32), nil
+	case KDFScrypt:
+		return scrypt.Key([]byte(password), slot.Salt, slot.N, slot.R, slot.P, 32)
+	}
+
+	return nil, ErrUnknownKDF
+}
+
+// wrap stores the master key encrypted with a key derived from password,
+// using a fresh salt and the default parameters
+func (slot *KeySlot) wrap(password, master string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	slot.KDF = KDFArgon2id
+	slot.Salt = salt
+	slot.Time = kdfTime
+	slot.Memory = kdfMemory
+	slot.Threads = kdfThreads
+	slot.N, slot.R, slot.P = 0, 0, 0
+
+	kek, err := slot.derive(password)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(EncryptionAESGCM, kek)
+	if err != nil {
+		return err
+	}
+	slot.Key = sealAEAD(aead, nonceKey(kek), []byte(master), nil)
+	return nil
+}
+
+// unlock returns the master key, decrypted with a key derived from password
+func (slot KeySlot) unlock(password string) (string, error) {
+	kek, err := slot.derive(password)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(EncryptionAESGCM, kek)
+	if err != nil {
+		return "", err
+	}
+	master, err := openAEAD(aead, slot.Key, nil)
+	if err != nil {
+		return "", ErrWrongPassword
+	}
+	return string(master), nil
+}
+
+// weak returns true if the slot's parameters are weaker than the defaults
+func (slot KeySlot) weak() bool {
+	return slot.KDF != KDFArgon2id || slot.Time < kdfTime || slot.Memory < kdfMemory
+}
+
+// unlock tries password on all key slots. Returns the master key and the ID
+// of the slot it unlocked
+func (header KeyHeader) unlock(password string) (string, string, error) {
+	if header.Version > keyHeaderVersion {
+		return "", "", ErrKeyHeaderVersion
+	}
+
+	for _, slot := range header.Slots {
+		master, err := slot.unlock(password)
+		if err == nil {
+			return master, slot.ID, nil
+		}
+		if err != ErrWrongPassword {
+			return "", "", err
+		}
+	}
+
+	return "", "", ErrWrongPassword
+}
+
+// add creates a new key slot for password. Returns the slot's ID
+func (header *KeyHeader) add(password, master, description string) (string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	slot := KeySlot{
+		ID:          hex.EncodeToString(id),
+		Description: description,
+		Created:     time.Now(),
+	}
+	if err := slot.wrap(password, master); err != nil {
+		return "", err
+	}
+	header.Slots = append(header.Slots, slot)
+	return slot.ID, nil
+}
+
+// remove deletes the key slot with the given ID
+func (header *KeyHeader) remove(id string) error {
+	for i, slot := range header.Slots {
+		if slot.ID != id {
+			continue
+		}
+		if len(header.Slots) == 1 {
+			return ErrLastKey
+		}
+		header.Slots = append(header.Slots[:i], header.Slots[i+1:]...)
+		return nil
+	}
+
+	return ErrKeyNotFound
+}
+
+// strengthen re-wraps the key slot with the given ID, if its parameters are
+// weaker than the defaults
+func (header *KeyHeader) strengthen(id, password, master string) error {
+	for i := range header.Slots {
+		if header.Slots[i].ID == id && header.Slots[i].weak() {
+			return header.Slots[i].wrap(password, master)
+		}
+	}
+
+	return nil
+}
+
+// marshalKeyHeader prefixes data with header
+func marshalKeyHeader(header KeyHeader, data []byte) ([]byte, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.Write(keyHeaderMagic)
+	binary.Write(&b, binary.BigEndian, uint32(len(h)))
+	b.Write(h)
+	b.Write(data)
+	return b.Bytes(), nil
+}
+
+// unmarshalKeyHeader splits b into its key header and the remaining data.
+// Returns a nil header if b doesn't start with one
+func unmarshalKeyHeader(b []byte) (*KeyHeader, []byte, error) {
+	if !bytes.HasPrefix(b, keyHeaderMagic) {
+		return nil, b, nil
+	}
+	b = b[len(keyHeaderMagic):]
+	if len(b) < 4 {
+		return nil, nil, ErrInvalidKeyHeader
+	}
+	l := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint64(len(b)) < uint64(l) {
+		return nil, nil, ErrInvalidKeyHeader
+	}
+
+	header := KeyHeader{}
+	if err := json.Unmarshal(b[:l], &header); err != nil {
+		return nil, nil, ErrInvalidKeyHeader
+	}
+	if header.Version == 1 {
+		// Version 1 headers consist of a single key slot
+		slot := KeySlot{}
+		if err := json.Unmarshal(b[:l], &slot); err != nil || len(slot.Salt) < 4 {
+			return nil, nil, ErrInvalidKeyHeader
+		}
+		slot.ID = hex.EncodeToString(slot.Salt[:4])
+		header = KeyHeader{Version: keyHeaderVersion, Slots: []KeySlot{slot}}
+	}
+	return &header, b[l:], nil
+}
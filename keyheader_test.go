@@ -0,0 +1,193 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyHeader(t *testing.T) {
+	// Start out with weak parameters, which get upgraded later on
+	defer func(time uint32) { kdfTime = time }(kdfTime)
+	kdfTime = 1
+
+	r, err := NewRepository("memory://keyheader", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	b, _ := MemoryStorage("keyheader").LoadRepository()
+	_, b = splitSignature(b)
+	if !bytes.HasPrefix(b, keyHeaderMagic) {
+		t.Errorf("Repository is missing its key header")
+	}
+
+	if _, err = OpenRepository("memory://keyheader", "wrong"); err != ErrWrongPassword {
+		t.Errorf("Expected %v, got %v", ErrWrongPassword, err)
+	}
+
+	kdfTime = 3
+	r, err = OpenRepository("memory://keyheader", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	key := r.key
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://keyheader", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if r.header.Slots[0].Time != 3 || r.key != key {
+		t.Errorf("Expected upgraded header with unchanged key, got time %d", r.header.Slots[0].Time)
+	}
+}
+
+func TestKeySlots(t *testing.T) {
+	r, err := NewRepository("memory://keyslots", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	first := r.CurrentKey()
+	second, err := r.AddKey("other", "backup server")
+	if err != nil {
+		t.Errorf("Failed adding key: %s", err)
+		return
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://keyslots", "other")
+	if err != nil {
+		t.Errorf("Failed opening repository with added key: %s", err)
+		return
+	}
+	if r.CurrentKey() != second {
+		t.Errorf("Expected key %s, got %s", second, r.CurrentKey())
+	}
+	keys, _ := r.Keys()
+	if len(keys) != 2 || keys[1].Description != "backup server" || keys[1].Key != nil {
+		t.Errorf("Unexpected key slots: %+v", keys)
+	}
+
+	if err = r.RemoveKey(first); err != nil {
+		t.Errorf("Failed removing key: %s", err)
+		return
+	}
+	if err = r.RemoveKey(second); err != ErrLastKey {
+		t.Errorf("Expected %v, got %v", ErrLastKey, err)
+	}
+	if err = r.RemoveKey(first); err != ErrKeyNotFound {
+		t.Errorf("Expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	if _, err = OpenRepository("memory://keyslots", "password"); err != ErrWrongPassword {
+		t.Errorf("Expected %v, got %v", ErrWrongPassword, err)
+	}
+	if _, err = OpenRepository("memory://keyslots", "other"); err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+	}
+}
+
+func TestKeyHeaderVersion1(t *testing.T) {
+	slot := KeySlot{}
+	if err := slot.wrap("password", "master"); err != nil {
+		t.Errorf("Failed wrapping key: %s", err)
+		return
+	}
+	h, _ := json.Marshal(struct {
+		Version int `json:"version"`
+		KeySlot
+	}{1, slot})
+	var buf bytes.Buffer
+	buf.Write(keyHeaderMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(len(h)))
+	buf.Write(h)
+	buf.WriteString("data")
+	b := buf.Bytes()
+
+	header, data, err := unmarshalKeyHeader(b)
+	if err != nil || string(data) != "data" {
+		t.Errorf("Failed unmarshaling key header: %v", err)
+		return
+	}
+	master, _, err := header.unlock("password")
+	if err != nil || master != "master" {
+		t.Errorf("Failed unlocking version 1 header: %v", err)
+	}
+}
+
+func TestKeyHeaderScrypt(t *testing.T) {
+	slot := KeySlot{KDF: KDFScrypt, Salt: []byte("salt"), N: 1 << 10, R: 8, P: 1}
+	kek, err := slot.derive("password")
+	if err != nil || len(kek) != 32 {
+		t.Errorf("Failed deriving key: %v", err)
+	}
+
+	slot.KDF = "unknown"
+	if _, err = slot.derive("password"); err != ErrUnknownKDF {
+		t.Errorf("Expected %v, got %v", ErrUnknownKDF, err)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keyfile")
+	if err = GenerateKeyFile(path); err != nil {
+		t.Errorf("Failed generating key file: %s", err)
+		return
+	}
+	if err = GenerateKeyFile(path); err == nil {
+		t.Errorf("Existing key file got overwritten")
+	}
+
+	keyOnly, err := KeyFilePassword("", path)
+	if err != nil {
+		t.Errorf("Failed reading key file: %s", err)
+		return
+	}
+	combined, _ := KeyFilePassword("password", path)
+	if keyOnly == combined || keyOnly == "" {
+		t.Errorf("Expected distinct passwords, got %q and %q", keyOnly, combined)
+	}
+
+	if _, err = NewRepository("memory://keyfile", combined); err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	if _, err = OpenRepository("memory://keyfile", keyOnly); err != ErrWrongPassword {
+		t.Errorf("Expected %v, got %v", ErrWrongPassword, err)
+	}
+	if _, err = OpenRepository("memory://keyfile", combined); err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/knoxite/knoxite"
+)
+
+// catBlockSize is how much data gets read from a file at once
+const catBlockSize = 1 << 20
+
+// CmdCat describes the command
+type CmdCat struct {
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("cat",
+		"print a file",
+		"The cat command decodes a single file stored in a snapshot and writes it to stdout",
+		&CmdCat{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdCat) Usage() string {
+	return "SNAPSHOT-ID PATH"
+}
+
+// Execute this command
+func (cmd CmdCat) Execute(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	_, snapshot, err := repository.FindSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+	item, err := snapshot.FindItem(args[1])
+	if err != nil {
+		return err
+	}
+	return writeFile(repository, *item, os.Stdout)
+}
+
+// writeFile decodes the file item and writes its content to w
+func writeFile(repository knoxite.Repository, item knoxite.ItemData, w io.Writer) error {
+	if item.Type != knoxite.File {
+		return knoxite.ErrItemNotFound
+	}
+
+	for offset := uint64(0); offset < item.Size; {
+		size := item.Size - offset
+		if size > catBlockSize {
+			size = catBlockSize
+		}
+		d, err := knoxite.ReadArchive(repository, item, int(offset), int(size))
+		if err != nil {
+			return err
+		}
+		if len(*d) == 0 {
+			break
+		}
+		if _, err = w.Write(*d); err != nil {
+			return err
+		}
+		offset += uint64(len(*d))
+	}
+
+	return nil
+}
@@ -46,6 +46,12 @@ func (cmd CmdClone) Execute(args []string) error {
 
 	// filter here? exclude/include?
 
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err != nil {
 		return err
@@ -58,7 +64,7 @@ func (cmd CmdClone) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
-	err = cmd.store.store(&repository, snapshot, targets)
+	err = cmd.store.store(&repository, snapshot, s, nil, targets)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/knoxite/knoxite"
+)
+
+// Error declarations
+var (
+	ErrConfigSyntax = errors.New("invalid syntax")
+	ErrConfigValue  = errors.New("invalid value")
+	ErrConfigKey    = errors.New("unknown key")
+)
+
+// RepositoryConfig holds the settings of a repository defined in the config
+// file. Its name can be passed to -r instead of its URL
+type RepositoryConfig struct {
+	URL             string
+	PasswordCommand string
+	// Volume is used by store if no volume is given
+	Volume string
+	// Defaults for the store command
+	Compression string
+	Encryption  string
+	Tolerance   uint
+	Excludes    []string
+}
+
+// ScheduleConfig describes the jobs the daemon runs for a volume. Store,
+// Forget and Verify are cron expressions, an empty one disables the job
+type ScheduleConfig struct {
+	// Repository is the name of a configured repository or a URL
+	Repository string
+	Volume     string
+	Paths      []string
+	Store      string
+	Forget     string
+	Verify     string
+	// Keep is the retention policy forget applies
+	Keep knoxite.RetentionPolicy
+}
+
+// Config is the content of the config file, a small subset of TOML:
+//
+//	[repositories.mylaptop]
+//	url = "sftp://user@host/backup"
+//	volume = "home"
+//	compression = "zstd"
+//	excludes = ["*.tmp", "**/node_modules"]
+//
+//	[schedules.home]
+//	repository = "mylaptop"
+//	volume = "home"
+//	paths = ["/home/user"]
+//	store = "0 * * * *"
+type Config struct {
+	Repositories map[string]RepositoryConfig
+	Schedules    map[string]ScheduleConfig
+}
+
+// configPath returns the default location of the config file
+func configPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "knoxite", "config.toml")
+}
+
+// loadConfig reads the config file at path. A missing file results in an
+// empty config
+func loadConfig(path string) (Config, error) {
+	config := Config{
+		Repositories: make(map[string]RepositoryConfig),
+		Schedules:    make(map[string]ScheduleConfig),
+	}
+	if path == "" {
+		return config, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if err = config.parse(string(b)); err != nil {
+		return config, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+func (config *Config) parse(s string) error {
+	section, table := "", ""
+	lines := strings.Split(s, "\n")
+	for i := 0; i < len(lines); i++ {
+		num := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: %v", num, ErrConfigSyntax)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			section = strings.SplitN(table, ".", 2)[0]
+			if section != "repositories" && section != "schedules" {
+				return fmt.Errorf("line %d: %v [%s]", num, ErrConfigKey, table)
+			}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			return fmt.Errorf("line %d: %v", num, ErrConfigSyntax)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		// Arrays may span multiple lines
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		name := strings.Trim(strings.TrimPrefix(table, section), ".")
+		if name == "" {
+			return fmt.Errorf("line %d: %v %s", num, ErrConfigKey, key)
+		}
+		if n, err := unquote(name); err == nil {
+			name = n
+		}
+		var err error
+		if section == "schedules" {
+			schedule := config.Schedules[name]
+			err = schedule.set(key, value)
+			config.Schedules[name] = schedule
+		} else {
+			repo := config.Repositories[name]
+			err = repo.set(key, value)
+			config.Repositories[name] = repo
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", num, err)
+		}
+	}
+
+	return nil
+}
+
+// set assigns the TOML value to the setting key
+func (repo *RepositoryConfig) set(key, value string) error {
+	switch key {
+	case "url", "password_command", "volume", "compression", "encryption":
+		s, err := unquote(value)
+		if err != nil {
+			return fmt.Errorf("%v for %s", err, key)
+		}
+		switch key {
+		case "url":
+			repo.URL = s
+		case "password_command":
+			repo.PasswordCommand = s
+		case "volume":
+			repo.Volume = s
+		case "compression":
+			repo.Compression = s
+		case "encryption":
+			repo.Encryption = s
+		}
+	case "tolerance":
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%v for %s", ErrConfigValue, key)
+		}
+		repo.Tolerance = uint(n)
+	case "excludes":
+		a, err := unquoteArray(value)
+		if err != nil {
+			return fmt.Errorf("%v for %s", err, key)
+		}
+		repo.Excludes = a
+	default:
+		return fmt.Errorf("%v %s", ErrConfigKey, key)
+	}
+
+	return nil
+}
+
+// set assigns the TOML value to the setting key
+func (schedule *ScheduleConfig) set(key, value string) error {
+	switch key {
+	case "repository", "volume", "store", "forget", "verify":
+		s, err := unquote(value)
+		if err != nil {
+			return fmt.Errorf("%v for %s", err, key)
+		}
+		switch key {
+		case "repository":
+			schedule.Repository = s
+		case "volume":
+			schedule.Volume = s
+		case "store":
+			schedule.Store = s
+		case "forget":
+			schedule.Forget = s
+		case "verify":
+			schedule.Verify = s
+		}
+	case "paths":
+		a, err := unquoteArray(value)
+		if err != nil {
+			return fmt.Errorf("%v for %s", err, key)
+		}
+		schedule.Paths = a
+	case "keep_last", "keep_hourly", "keep_daily", "keep_weekly", "keep_monthly", "keep_yearly":
+		n, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return fmt.Errorf("%v for %s", ErrConfigValue, key)
+		}
+		keep := map[string]*int{
+			"keep_last":    &schedule.Keep.Last,
+			"keep_hourly":  &schedule.Keep.Hourly,
+			"keep_daily":   &schedule.Keep.Daily,
+			"keep_weekly":  &schedule.Keep.Weekly,
+			"keep_monthly": &schedule.Keep.Monthly,
+			"keep_yearly":  &schedule.Keep.Yearly,
+		}
+		*keep[key] = int(n)
+	default:
+		return fmt.Errorf("%v %s", ErrConfigKey, key)
+	}
+
+	return nil
+}
+
+// unquoteArray returns the strings of a TOML array
+func unquoteArray(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, ErrConfigValue
+	}
+	a := []string{}
+	for _, v := range splitArray(value[1 : len(value)-1]) {
+		s, err := unquote(v)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, s)
+	}
+	return a, nil
+}
+
+// unquote returns the content of a basic ("...") or literal ('...') string
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	return "", ErrConfigValue
+}
+
+// stripComment removes a comment from line, unless the # is within a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitArray splits the elements of an array at commas outside of strings
+func splitArray(s string) []string {
+	elems := []string{}
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			if quote != 0 {
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+				continue
+			}
+			if c != ',' {
+				continue
+			}
+		}
+		if e := strings.TrimSpace(s[start:i]); e != "" {
+			elems = append(elems, e)
+		}
+		start = i + 1
+	}
+	return elems
+}
+
+// resolve returns the URL of the repository called name, or name itself if
+// there's no such repository
+func (config Config) resolve(name string) string {
+	if repo, ok := config.Repositories[name]; ok && repo.URL != "" {
+		return repo.URL
+	}
+	return name
+}
+
+// applyConfig resolves repository names given with -r and fills in the
+// defaults of the repository for flags that weren't given
+func applyConfig(command flags.Commander, args []string) ([]string, error) {
+	config, err := loadConfig(globalOpts.Config)
+	if err != nil {
+		return args, err
+	}
+	if cmd, ok := command.(*CmdCopy); ok {
+		cmd.From = config.resolve(cmd.From)
+		cmd.To = config.resolve(cmd.To)
+	}
+	repo, ok := config.Repositories[globalOpts.Repo]
+	if !ok {
+		return args, nil
+	}
+	if repo.URL != "" {
+		globalOpts.Repo = repo.URL
+	}
+	if globalOpts.PasswordCommand == "" {
+		globalOpts.PasswordCommand = repo.PasswordCommand
+	}
+	if cmd, ok := command.(*CmdRepository); ok {
+		cmd.defaults = repo
+	}
+
+	cmd, ok := command.(*CmdStore)
+	if watch, wok := command.(*CmdWatch); wok {
+		cmd, ok = &watch.CmdStore, true
+	}
+	if ok {
+		if cmd.Compression == "" {
+			cmd.Compression = repo.Compression
+		}
+		if cmd.Encryption == "" {
+			cmd.Encryption = repo.Encryption
+		}
+		if cmd.FailureTolerance == 0 {
+			cmd.FailureTolerance = repo.Tolerance
+		}
+		cmd.Exclude = append(cmd.Exclude, repo.Excludes...)
+		if repo.Volume != "" && len(args) == 1 {
+			args = append([]string{repo.Volume}, args...)
+		}
+	}
+
+	return args, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/knoxite/knoxite"
+)
+
+// Error declarations
+var (
+	ErrMissingCopyDestination = errors.New("missing destination repository, pass it with --to")
+	ErrCopySameRepository     = errors.New("source and destination repository are the same")
+)
+
+// CmdCopy describes the command
+type CmdCopy struct {
+	From             string `long:"from"          description:"repository to copy from (default: --repo)"`
+	FromPassword     string `long:"from-password" description:"password of the source repository (default: --password)"`
+	To               string `long:"to"            description:"repository to copy to"`
+	ToPassword       string `long:"to-password"   description:"password of the destination repository"`
+	FailureTolerance uint   `short:"t" long:"tolerance" description:"failure tolerance against n backend failures in the destination repository"`
+	Encryption       string `short:"e" long:"encryption" description:"encryption algo to use in the destination repository: aes (default), chacha20, none"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("copy",
+		"copy a snapshot to another repository",
+		"The copy command copies a snapshot to another repository, e.g. for off-site replication. Only chunks the destination doesn't hold already get transferred. The snapshot ends up in a volume of the same ID, which gets created if necessary",
+		&CmdCopy{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdCopy) Usage() string {
+	return "--from REPOSITORY --to REPOSITORY SNAPSHOT-ID"
+}
+
+// Execute this command
+func (cmd CmdCopy) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	from, fromPassword := cmd.From, cmd.FromPassword
+	if from == "" {
+		from = cmd.global.Repo
+	}
+	if fromPassword == "" {
+		fromPassword = cmd.global.Password
+	}
+	if from == "" {
+		return ErrMissingRepoLocation
+	}
+	if cmd.To == "" {
+		return ErrMissingCopyDestination
+	}
+	if cmd.To == from {
+		return ErrCopySameRepository
+	}
+
+	encryption, err := encryptionAlgo(cmd.Encryption)
+	if err != nil {
+		return err
+	}
+
+	src, err := openRepository(from, fromPassword)
+	if err != nil {
+		return err
+	}
+	srcVolume, snapshot, err := src.FindSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepository(cmd.To)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	dst, err := openRepository(cmd.To, cmd.ToPassword)
+	if err != nil {
+		return err
+	}
+	if uint(len(dst.Backend.Backends))-cmd.FailureTolerance <= 0 {
+		return ErrRedundancyAmount
+	}
+	volume, err := dst.FindVolume(srcVolume.ID)
+	if err != nil {
+		volume = &knoxite.Volume{
+			ID:          srcVolume.ID,
+			Name:        srcVolume.Name,
+			Description: srcVolume.Description,
+		}
+		if err = dst.AddVolume(volume); err != nil {
+			return err
+		}
+		messagef("Volume %s (Name: %s) created\n", volume.ID, volume.Name)
+	}
+
+	copied, stats, err := dst.CopySnapshot(&src, *snapshot, volume, knoxite.StoreOptions{
+		Encryption:  encryption,
+		DataParts:   uint(len(dst.Backend.Backends)) - cmd.FailureTolerance,
+		ParityParts: cmd.FailureTolerance,
+	})
+	if err != nil {
+		return err
+	}
+	if err = copied.Save(&dst); err != nil {
+		return err
+	}
+	if err = volume.AddSnapshot(copied.ID); err != nil {
+		return err
+	}
+	if err = dst.Save(); err != nil {
+		return err
+	}
+
+	messagef("Snapshot %s copied: %d of %d chunks transferred, %s\n", copied.ID, stats.Copied, stats.Chunks, knoxite.SizeToString(stats.Size))
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error declarations
+var (
+	ErrInvalidCron = errors.New("invalid cron expression")
+)
+
+// cronSchedule is a parsed cron expression with the five fields minute, hour,
+// day of month, month and day of week. Each field is a set of bits
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set if a day field starts with *. With both days
+	// restricted, either of them has to match
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression like "30 2 * * 1-5", or one of the
+// macros like @daily
+func parseCron(spec string) (cronSchedule, error) {
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSchedule{}, ErrInvalidCron
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return s, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return s, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return s, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return s, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return s, err
+	}
+	// Sunday is 0 as well as 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (1-5) and
+// steps (*/15, 0-30/10) within min and max
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidCron
+			}
+			step = n
+			part = part[:i]
+		}
+
+		first, last := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, ErrInvalidCron
+			}
+			first, last = n, n
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, ErrInvalidCron
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 on, every 15
+				last = max
+			}
+		}
+		if first < min || last > max || first > last {
+			return 0, ErrInvalidCron
+		}
+
+		for i := first; i <= last; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t the schedule matches, or the zero time
+// if it never does, like for February 30th
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s cronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if !s.domAny && !s.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
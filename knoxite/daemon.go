@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/knoxite/knoxite"
+)
+
+// Error declarations
+var (
+	ErrNoSchedules = errors.New("no schedules found in the config file")
+	ErrNoPaths     = errors.New("please specify the volume and paths to store")
+)
+
+// CmdDaemon describes the command
+type CmdDaemon struct {
+	Listen string `short:"l" long:"listen" default:"localhost:42025" description:"address the status gets served on, empty to disable"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("daemon",
+		"run scheduled jobs",
+		"The daemon command runs the stores, forgets and verifies scheduled in the config file and serves their status as JSON over HTTP",
+		&CmdDaemon{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdDaemon) Usage() string {
+	return "[--listen ADDRESS]"
+}
+
+// job is a command the daemon runs on a schedule
+type job struct {
+	Schedule string     `json:"schedule"`
+	Command  string     `json:"command"`
+	Cron     string     `json:"cron"`
+	Next     time.Time  `json:"next"`
+	Running  bool       `json:"running"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	Duration float64    `json:"duration,omitempty"`
+	ExitCode int        `json:"exit_code"`
+	Output   string     `json:"output,omitempty"`
+
+	args     []string
+	env      []string
+	schedule cronSchedule
+}
+
+// daemon runs jobs one after another, so they can't get in each other's way
+type daemon struct {
+	sync.Mutex
+	exe  string
+	jobs []*job
+}
+
+// Execute this command
+func (cmd CmdDaemon) Execute(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+
+	config, err := loadConfig(cmd.global.Config)
+	if err != nil {
+		return err
+	}
+	if len(config.Schedules) == 0 {
+		return ErrNoSchedules
+	}
+	d := &daemon{}
+	if d.jobs, err = cmd.jobs(config); err != nil {
+		return err
+	}
+	if d.exe, err = os.Executable(); err != nil {
+		return err
+	}
+
+	if cmd.Listen != "" {
+		l, lerr := net.Listen("tcp", cmd.Listen)
+		if lerr != nil {
+			return lerr
+		}
+		defer l.Close()
+		go http.Serve(l, d)
+		messagef("Serving status on http://%s/\n", l.Addr())
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		d.Lock()
+		next := d.jobs[0]
+		for _, j := range d.jobs {
+			if j.Next.Before(next.Next) {
+				next = j
+			}
+		}
+		d.Unlock()
+
+		select {
+		case s := <-sig:
+			messagef("Got signal %s, stopping\n", s)
+			return nil
+		case <-time.After(time.Until(next.Next)):
+			d.run(next)
+		}
+	}
+}
+
+// jobs returns the jobs of all schedules
+func (cmd CmdDaemon) jobs(config Config) ([]*job, error) {
+	names := []string{}
+	for name := range config.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	jobs := []*job{}
+	now := time.Now()
+	for _, name := range names {
+		schedule := config.Schedules[name]
+		global, err := cmd.globalArgs(schedule)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %v", name, err)
+		}
+
+		commands := []struct {
+			name string
+			cron string
+			args []string
+		}{
+			{"store", schedule.Store, append([]string{"store", schedule.Volume}, schedule.Paths...)},
+			{"forget", schedule.Forget, append([]string{"forget", schedule.Volume}, keepArgs(schedule.Keep)...)},
+			{"verify", schedule.Verify, []string{"verify"}},
+		}
+		for _, c := range commands {
+			if c.cron == "" {
+				continue
+			}
+			switch {
+			case c.name == "store" && (schedule.Volume == "" || len(schedule.Paths) == 0):
+				return nil, fmt.Errorf("schedule %s: %v", name, ErrNoPaths)
+			case c.name == "forget" && schedule.Keep == (knoxite.RetentionPolicy{}):
+				return nil, fmt.Errorf("schedule %s: %v", name, ErrNoRetentionPolicy)
+			}
+			s, err := parseCron(c.cron)
+			if err != nil {
+				return nil, fmt.Errorf("schedule %s: %v \"%s\"", name, err, c.cron)
+			}
+			next := s.next(now)
+			if next.IsZero() {
+				return nil, fmt.Errorf("schedule %s: %s never runs", name, c.cron)
+			}
+
+			jobs = append(jobs, &job{
+				Schedule: name,
+				Command:  c.name,
+				Cron:     c.cron,
+				Next:     next,
+				args:     append(append([]string{}, global...), c.args...),
+				env:      cmd.globalEnv(schedule),
+				schedule: s,
+			})
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil, ErrNoSchedules
+	}
+	return jobs, nil
+}
+
+// globalArgs returns the global flags a job gets run with. Credentials given
+// on the command-line only get passed on for the repository given with -r,
+// configured repositories use their password_command or the keychain
+func (cmd CmdDaemon) globalArgs(schedule ScheduleConfig) ([]string, error) {
+	args := []string{"--quiet"}
+	if cmd.global.Config != "" {
+		args = append(args, "--config", cmd.global.Config)
+	}
+	if schedule.Repository != "" {
+		return append(args, "-r", schedule.Repository), nil
+	}
+	if cmd.global.Repo == "" {
+		return nil, ErrMissingRepoLocation
+	}
+
+	args = append(args, "-r", cmd.global.Repo)
+	if cmd.global.PasswordCommand != "" {
+		args = append(args, "--password-command", cmd.global.PasswordCommand)
+	}
+	if cmd.global.KeyFile != "" {
+		args = append(args, "--keyfile", cmd.global.KeyFile)
+	}
+	return args, nil
+}
+
+// globalEnv returns the environment a job gets run with. The password gets
+// passed on as $KNOXITE_PASSWORD rather than a flag, as any user can read the
+// arguments of a process
+func (cmd CmdDaemon) globalEnv(schedule ScheduleConfig) []string {
+	env := []string{}
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "KNOXITE_PASSWORD=") {
+			env = append(env, v)
+		}
+	}
+	if schedule.Repository == "" && cmd.global.Password != "" {
+		env = append(env, "KNOXITE_PASSWORD="+cmd.global.Password)
+	}
+	return env
+}
+
+// keepArgs returns the flags of the forget command for policy
+func keepArgs(policy knoxite.RetentionPolicy) []string {
+	args := []string{}
+	for _, keep := range []struct {
+		flag string
+		n    int
+	}{
+		{"--keep-last", policy.Last},
+		{"--keep-hourly", policy.Hourly},
+		{"--keep-daily", policy.Daily},
+		{"--keep-weekly", policy.Weekly},
+		{"--keep-monthly", policy.Monthly},
+		{"--keep-yearly", policy.Yearly},
+	} {
+		if keep.n > 0 {
+			args = append(args, keep.flag, strconv.Itoa(keep.n))
+		}
+	}
+	return args
+}
+
+// run executes j as a knoxite process of its own and records the outcome
+func (d *daemon) run(j *job) {
+	start := time.Now()
+	d.Lock()
+	j.Running = true
+	d.Unlock()
+
+	var stderr bytes.Buffer
+	c := exec.Command(d.exe, j.args...)
+	c.Env = j.env
+	c.Stderr = &stderr
+	err := c.Run()
+	code := ExitOK
+	if e, ok := err.(*exec.ExitError); ok {
+		code = e.ExitCode()
+	} else if err != nil {
+		code = ExitError
+		stderr.WriteString(err.Error())
+	}
+
+	d.Lock()
+	j.Running = false
+	j.LastRun = &start
+	j.Duration = time.Since(start).Seconds()
+	j.ExitCode = code
+	j.Output = strings.TrimSpace(stderr.String())
+	j.Next = j.schedule.next(time.Now())
+	d.Unlock()
+
+	status := "done"
+	if code != ExitOK {
+		status = fmt.Sprintf("failed with exit code %d", code)
+	}
+	messagef("%s %s of %s %s\n", start.Format(timeFormat), j.Command, j.Schedule, status)
+}
+
+// ServeHTTP serves the state of all jobs as JSON
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	defer d.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.jobs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/knoxite/knoxite"
+)
+
+// CmdDebug describes the command
+type CmdDebug struct {
+	Part uint `long:"part" description:"part of the chunk to print with cat-chunk"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("debug",
+		"print raw repository objects",
+		"The debug command prints the decrypted metadata of a snapshot, the chunk index, a chunk as it got stored or its decoded data. It helps diagnosing damaged repositories",
+		&CmdDebug{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdDebug) Usage() string {
+	return "[dump-snapshot SNAPSHOT-ID | dump-chunk-index | cat-chunk SHASUM [--part N] | decode-chunk SHASUM]"
+}
+
+// Execute this command
+func (cmd CmdDebug) Execute(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	switch args[0] {
+	case "dump-chunk-index":
+		return cmd.dumpChunkIndex()
+	case "dump-snapshot":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.dumpSnapshot(args[1])
+	case "cat-chunk":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.catChunk(args[1])
+	case "decode-chunk":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.decodeChunk(args[1])
+	default:
+		return fmt.Errorf(TUnknownCommand, cmd.Usage())
+	}
+}
+
+// dump prints v as indented JSON
+func dump(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (cmd CmdDebug) dumpSnapshot(snapshotID string) error {
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	_, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	return dump(snapshot)
+}
+
+func (cmd CmdDebug) dumpChunkIndex() error {
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	return dump(repository.Backend.Index())
+}
+
+func (cmd CmdDebug) catChunk(shasum string) error {
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	chunk, err := repository.FindChunk(shasum)
+	if err != nil {
+		return err
+	}
+	if cmd.Part >= chunk.DataParts+chunk.ParityParts {
+		return fmt.Errorf("chunk %s consists of %d parts", chunk.ShaSum, chunk.DataParts+chunk.ParityParts)
+	}
+
+	data, err := repository.Backend.LoadChunk(chunk, cmd.Part)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func (cmd CmdDebug) decodeChunk(shasum string) error {
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	chunk, err := repository.FindChunk(shasum)
+	if err != nil {
+		return err
+	}
+
+	data, err := knoxite.DecodeChunk(repository, chunk)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
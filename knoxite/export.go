@@ -0,0 +1,72 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CmdExport describes the command
+type CmdExport struct {
+	Output string `short:"o" long:"output" description:"file to write the archive to, compressed with gzip if it ends in .gz or .tgz (default: stdout)"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("export",
+		"export a snapshot as tar archive",
+		"The export command writes all files of a snapshot to a tar archive, so they can be restored with standard tools",
+		&CmdExport{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdExport) Usage() string {
+	return "SNAPSHOT-ID [-o FILE]"
+}
+
+// Execute this command
+func (cmd CmdExport) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	_, snapshot, err := repository.FindSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+
+	if cmd.Output == "" || cmd.Output == "-" {
+		return snapshot.WriteTar(repository, os.Stdout)
+	}
+
+	f, err := os.Create(cmd.Output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(cmd.Output, ".gz") || strings.HasSuffix(cmd.Output, ".tgz") {
+		gw := gzip.NewWriter(f)
+		if err = snapshot.WriteTar(repository, gw); err != nil {
+			return err
+		}
+		if err = gw.Close(); err != nil {
+			return err
+		}
+	} else if err = snapshot.WriteTar(repository, f); err != nil {
+		return err
+	}
+	return f.Close()
+}
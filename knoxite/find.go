@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/knoxite/knoxite"
+	"github.com/muesli/gotable"
+)
+
+// Error declarations
+var (
+	ErrInvalidDate = errors.New("invalid date, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
+)
+
+// CmdFind describes the command
+type CmdFind struct {
+	LargerThan  string `long:"larger-than"  description:"only find files of at least this size, e.g. 10M"`
+	SmallerThan string `long:"smaller-than" description:"only find files of at most this size, e.g. 1G"`
+	NewerThan   string `long:"newer-than"   description:"only find files modified after this date, e.g. 2016-07-29"`
+	OlderThan   string `long:"older-than"   description:"only find files modified before this date, e.g. 2016-07-29"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("find",
+		"find files in snapshots",
+		"The find command searches all snapshots of a volume for files matching a pattern and lists which snapshots contain which version of them",
+		&CmdFind{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdFind) Usage() string {
+	return "VOLUME-ID PATTERN"
+}
+
+// Execute this command
+func (cmd CmdFind) Execute(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	q := knoxite.FindQuery{Pattern: args[1]}
+	var err error
+	if cmd.LargerThan != "" {
+		if q.MinSize, err = knoxite.ParseSize(cmd.LargerThan); err != nil {
+			return err
+		}
+	}
+	if cmd.SmallerThan != "" {
+		if q.MaxSize, err = knoxite.ParseSize(cmd.SmallerThan); err != nil {
+			return err
+		}
+	}
+	if q.NewerThan, err = parseDate(cmd.NewerThan); err != nil {
+		return err
+	}
+	if q.OlderThan, err = parseDate(cmd.OlderThan); err != nil {
+		return err
+	}
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	volume, err := repository.FindVolume(args[0])
+	if err != nil {
+		return err
+	}
+	found, err := volume.Find(&repository, q)
+	if err != nil {
+		return err
+	}
+
+	tab := gotable.NewTable([]string{"Path", "Size", "ModTime", "Snapshots"},
+		[]int64{-48, 12, -19, -35},
+		"No files found.")
+	for _, f := range found {
+		tab.AppendRow([]interface{}{
+			f.Item.Path,
+			knoxite.SizeToString(f.Item.Size),
+			f.Item.ModTime.Format(timeFormat),
+			strings.Join(f.Snapshots, " ")})
+	}
+
+	tab.Print()
+	return nil
+}
+
+// parseDate parses a date given on the command-line in local time. An empty
+// string results in the zero time
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{timeFormat, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, ErrInvalidDate
+}
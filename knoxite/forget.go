@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/knoxite/knoxite"
+	"github.com/muesli/gotable"
+)
+
+// Error declarations
+var (
+	ErrNoRetentionPolicy = errors.New("please specify which snapshots to keep, e.g. --keep-last 7")
+)
+
+// CmdForget describes the command
+type CmdForget struct {
+	KeepLast    int      `long:"keep-last"    description:"keep the n latest snapshots"`
+	KeepHourly  int      `long:"keep-hourly"  description:"keep the latest snapshot of each of the last n hours"`
+	KeepDaily   int      `long:"keep-daily"   description:"keep the latest snapshot of each of the last n days"`
+	KeepWeekly  int      `long:"keep-weekly"  description:"keep the latest snapshot of each of the last n weeks"`
+	KeepMonthly int      `long:"keep-monthly" description:"keep the latest snapshot of each of the last n months"`
+	KeepYearly  int      `long:"keep-yearly"  description:"keep the latest snapshot of each of the last n years"`
+	DryRun      bool     `short:"n" long:"dry-run" description:"only show which snapshots would be forgotten"`
+	Tags        []string `long:"tag"     description:"only consider snapshots carrying this tag"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("forget",
+		"forget snapshots according to a retention policy",
+		"The forget command removes all snapshots of a volume which the given retention policy doesn't keep",
+		&CmdForget{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdForget) Usage() string {
+	return "VOLUME-ID --keep-last N [--keep-daily N] [...]"
+}
+
+// Execute this command
+func (cmd CmdForget) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	policy := knoxite.RetentionPolicy{
+		Last:    cmd.KeepLast,
+		Hourly:  cmd.KeepHourly,
+		Daily:   cmd.KeepDaily,
+		Weekly:  cmd.KeepWeekly,
+		Monthly: cmd.KeepMonthly,
+		Yearly:  cmd.KeepYearly,
+	}
+	if policy == (knoxite.RetentionPolicy{}) {
+		return ErrNoRetentionPolicy
+	}
+
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	volume, err := repository.FindVolume(args[0])
+	if err != nil {
+		return err
+	}
+
+	snapshots := []knoxite.Snapshot{}
+	for _, id := range volume.Snapshots {
+		snapshot, err := volume.LoadSnapshot(id, &repository)
+		if err != nil {
+			return err
+		}
+		if snapshot.HasTags(cmd.Tags) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	keep, forget := policy.Apply(snapshots)
+
+	tab := gotable.NewTable([]string{"ID", "Date", "Action", "Description"},
+		[]int64{-8, -19, -6, -48}, "No snapshots found. This volume is empty.")
+	for _, l := range []struct {
+		snapshots []knoxite.Snapshot
+		action    string
+	}{{keep, "keep"}, {forget, "forget"}} {
+		for _, snapshot := range l.snapshots {
+			tab.AppendRow([]interface{}{
+				snapshot.ID,
+				snapshot.Date.Format(timeFormat),
+				l.action,
+				snapshot.Description})
+		}
+	}
+	if !cmd.global.Quiet || cmd.DryRun {
+		tab.Print()
+	}
+
+	if cmd.DryRun || len(forget) == 0 {
+		return nil
+	}
+	ids := []string{}
+	for _, snapshot := range forget {
+		ids = append(ids, snapshot.ID)
+	}
+	if err = repository.ForgetSnapshots(volume, ids); err != nil {
+		return err
+	}
+	messagef("Forgot %d snapshots, kept %d\n", len(forget), len(keep))
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CmdImport describes the command
+type CmdImport struct {
+	Description      string   `short:"d" long:"desc"        description:"a description or comment for this snapshot"`
+	Tags             []string `long:"tag"                   description:"tag the snapshot, e.g. weekly"`
+	Compression      string   `short:"c" long:"compression" description:"compression algo to use: none (default), gzip[:level], zstd[:level] (recommended), lz4, xz[:level]"`
+	Encryption       string   `short:"e" long:"encryption"  description:"encryption algo to use: aes (default), chacha20, none"`
+	FailureTolerance uint     `short:"t" long:"tolerance"   description:"failure tolerance against n backend failures"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("import",
+		"import a tar archive as snapshot",
+		"The import command stores the content of a tar archive as a new snapshot, keeping the modes, owners and modification times of its entries. The archive is read from stdin unless a file is given, gzip compressed archives are supported",
+		&CmdImport{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdImport) Usage() string {
+	return "VOLUME-ID [ARCHIVE]"
+}
+
+// Execute this command
+func (cmd CmdImport) Execute(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	var rd io.Reader = os.Stdin
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rd = f
+	}
+	rd, err := decompressArchive(rd)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	volume, err := repository.FindVolume(args[0])
+	if err != nil {
+		return err
+	}
+	snapshot, err := volume.NewSnapshot(cmd.Description)
+	if err != nil {
+		return err
+	}
+	snapshot.Tags = cmd.Tags
+
+	store := CmdStore{
+		Compression:      cmd.Compression,
+		Encryption:       cmd.Encryption,
+		FailureTolerance: cmd.FailureTolerance,
+		InlineSize:       "2K",
+	}
+	opts, err := store.storeOptions(&repository)
+	if err != nil {
+		return err
+	}
+	if err = snapshot.AddTar(rd, repository, opts); err != nil {
+		return err
+	}
+
+	err = snapshot.Save(&repository)
+	if err != nil {
+		return err
+	}
+	err = volume.AddSnapshot(snapshot.ID)
+	if err != nil {
+		return err
+	}
+	err = repository.Save()
+	if err != nil {
+		return err
+	}
+
+	messagef("Snapshot %s created: %s\n", snapshot.ID, snapshot.Stats.String())
+	if snapshot.Stats.Errors > 0 {
+		warnf("only directories, symlinks and regular files can be imported, other entries got skipped\n")
+		return ErrPartial
+	}
+	return nil
+}
+
+// decompressArchive detects gzip compressed archives and decompresses them
+func decompressArchive(rd io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(rd)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/knoxite/knoxite"
+)
+
+// The structures below define what the commands print with --json. Their
+// fields are part of knoxite's interface to scripts, don't rename them
+
+type volumeJSON struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Snapshots   []string `json:"snapshots"`
+}
+
+type snapshotJSON struct {
+	ID          string        `json:"id"`
+	Date        time.Time     `json:"date"`
+	Description string        `json:"description"`
+	Tags        []string      `json:"tags"`
+	Stats       knoxite.Stats `json:"stats"`
+}
+
+type itemJSON struct {
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	PointsTo string    `json:"points_to,omitempty"`
+	Mode     string    `json:"mode"`
+	ModTime  time.Time `json:"modtime"`
+	Size     uint64    `json:"size"`
+	UID      uint32    `json:"uid"`
+	GID      uint32    `json:"gid"`
+	User     string    `json:"user"`
+	Group    string    `json:"group"`
+}
+
+type snapshotStatsJSON struct {
+	ID               string        `json:"id"`
+	Volume           string        `json:"volume,omitempty"`
+	Date             time.Time     `json:"date"`
+	Stats            knoxite.Stats `json:"stats"`
+	Chunks           uint64        `json:"chunks"`
+	DataSize         uint64        `json:"data_size"`
+	StorageSize      uint64        `json:"storage_size"`
+	CompressionRatio float64       `json:"compression_ratio"`
+	Growth           uint64        `json:"growth"`
+}
+
+type volumeStatsJSON struct {
+	ID               string              `json:"id"`
+	Name             string              `json:"name"`
+	Snapshots        []snapshotStatsJSON `json:"snapshots"`
+	Chunks           uint64              `json:"chunks"`
+	DataSize         uint64              `json:"data_size"`
+	StorageSize      uint64              `json:"storage_size"`
+	CompressionRatio float64             `json:"compression_ratio"`
+}
+
+// progressEvent is printed as a line of its own while storing or restoring.
+// Done and Total are the bytes of the current item, Stats sums up all items
+// handled so far. The remaining fields describe the overall progress, with
+// Elapsed and ETA in seconds and Speed in bytes per second
+type progressEvent struct {
+	Event      string        `json:"event"`
+	Path       string        `json:"path"`
+	Done       uint64        `json:"done"`
+	Total      uint64        `json:"total"`
+	Stats      knoxite.Stats `json:"stats"`
+	BytesDone  uint64        `json:"bytes_done"`
+	BytesTotal uint64        `json:"bytes_total"`
+	ItemsDone  uint64        `json:"items_done"`
+	ItemsTotal uint64        `json:"items_total"`
+	Elapsed    float64       `json:"elapsed"`
+	Speed      float64       `json:"speed"`
+	ETA        float64       `json:"eta"`
+}
+
+// errorEvent reports an item that got skipped
+type errorEvent struct {
+	Event string `json:"event"`
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// resultEvent is the last line printed by store and restore
+type resultEvent struct {
+	Event    string        `json:"event"`
+	Snapshot string        `json:"snapshot"`
+	Stats    knoxite.Stats `json:"stats"`
+}
+
+type chunkStateJSON struct {
+	Event     string        `json:"event"`
+	Chunk     string        `json:"chunk"`
+	State     string        `json:"state"`
+	Parts     uint          `json:"parts"`
+	Available uint          `json:"available"`
+	Error     string        `json:"error,omitempty"`
+	Files     []fileRefJSON `json:"files"`
+}
+
+type fileRefJSON struct {
+	Snapshot string `json:"snapshot"`
+	Path     string `json:"path"`
+}
+
+type verifySummaryJSON struct {
+	Event    string `json:"event"`
+	Chunks   int    `json:"chunks"`
+	Damaged  int    `json:"damaged"`
+	Degraded int    `json:"degraded"`
+}
+
+type inconsistencyJSON struct {
+	Location string `json:"location"`
+	Name     string `json:"name"`
+	Problem  string `json:"problem"`
+}
+
+type repositoryInfoJSON struct {
+	Version     int               `json:"version"`
+	Chunker     string            `json:"chunker"`
+	Encryption  string            `json:"encryption"`
+	Compression string            `json:"compression"`
+	Volumes     int               `json:"volumes"`
+	Snapshots   int               `json:"snapshots"`
+	Size        uint64            `json:"size"`
+	StorageSize uint64            `json:"storage_size"`
+	Backends    []backendInfoJSON `json:"backends"`
+}
+
+type backendInfoJSON struct {
+	URL            string `json:"url"`
+	AvailableSpace uint64 `json:"available_space"`
+}
+
+// printJSON prints v as a single line of JSON
+func printJSON(v interface{}) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+func newSnapshotJSON(snapshot knoxite.Snapshot) snapshotJSON {
+	tags := snapshot.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return snapshotJSON{
+		ID:          snapshot.ID,
+		Date:        snapshot.Date,
+		Description: snapshot.Description,
+		Tags:        tags,
+		Stats:       snapshot.Stats,
+	}
+}
+
+func newProgressEvent(p knoxite.Progress, done, total uint64, stats knoxite.Stats) progressEvent {
+	return progressEvent{
+		Event:      "progress",
+		Path:       p.Path,
+		Done:       done,
+		Total:      total,
+		Stats:      stats,
+		BytesDone:  p.Done,
+		BytesTotal: p.Total,
+		ItemsDone:  p.Items,
+		ItemsTotal: p.TotalItems,
+		Elapsed:    p.Elapsed.Seconds(),
+		Speed:      p.Speed,
+		ETA:        p.ETA.Seconds(),
+	}
+}
+
+func newSnapshotStatsJSON(stats knoxite.SnapshotStats) snapshotStatsJSON {
+	return snapshotStatsJSON{
+		ID:               stats.ID,
+		Date:             stats.Date,
+		Stats:            stats.Stats,
+		Chunks:           stats.Chunks,
+		DataSize:         stats.DataSize,
+		StorageSize:      stats.StorageSize,
+		CompressionRatio: stats.CompressionRatio(),
+		Growth:           stats.Growth,
+	}
+}
+
+func newVolumeStatsJSON(volume *knoxite.Volume, stats knoxite.VolumeStats) volumeStatsJSON {
+	snapshots := []snapshotStatsJSON{}
+	for _, s := range stats.Snapshots {
+		snapshots = append(snapshots, newSnapshotStatsJSON(s))
+	}
+	return volumeStatsJSON{
+		ID:               volume.ID,
+		Name:             volume.Name,
+		Snapshots:        snapshots,
+		Chunks:           stats.Chunks,
+		DataSize:         stats.DataSize,
+		StorageSize:      stats.StorageSize,
+		CompressionRatio: stats.CompressionRatio(),
+	}
+}
+
+func newChunkStateJSON(state knoxite.ChunkState, status string) chunkStateJSON {
+	files := []fileRefJSON{}
+	for _, f := range state.Files {
+		files = append(files, fileRefJSON{f.Snapshot, f.Path})
+	}
+	s := chunkStateJSON{
+		Event:     "chunk",
+		Chunk:     state.Chunk.ShaSum,
+		State:     status,
+		Parts:     state.Parts,
+		Available: state.Available,
+		Files:     files,
+	}
+	if state.Err != nil {
+		s.Error = state.Err.Error()
+	}
+	return s
+}
+
+// itemType returns the name of an item's type
+func itemType(item knoxite.ItemData) string {
+	switch item.Type {
+	case knoxite.Directory:
+		return "dir"
+	case knoxite.SymLink:
+		return "symlink"
+	case knoxite.CharDevice:
+		return "chardev"
+	case knoxite.BlockDevice:
+		return "blockdev"
+	case knoxite.NamedPipe:
+		return "fifo"
+	case knoxite.Socket:
+		return "socket"
+	default:
+		return "file"
+	}
+}
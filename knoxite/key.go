@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/knoxite/knoxite"
+	"github.com/muesli/gotable"
+)
+
+// CmdKey describes the command
+type CmdKey struct {
+	Description string `short:"d" long:"desc" description:"a description or comment for the new key"`
+	NewKeyFile  string `long:"new-keyfile"   description:"key file the new key uses instead of a password, gets generated if missing"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("key",
+		"manage repository keys",
+		"The key command manages the passwords and key files that can unlock a repository",
+		&CmdKey{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdKey) Usage() string {
+	return "[add|remove KEY-ID|list]"
+}
+
+// Execute this command
+func (cmd CmdKey) Execute(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	switch args[0] {
+	case "add":
+		return cmd.add()
+	case "remove":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.remove(args[1])
+	case "list":
+		return cmd.list()
+	default:
+		return fmt.Errorf(TUnknownCommand, cmd.Usage())
+	}
+}
+
+func (cmd CmdKey) add() error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	var password string
+	if cmd.NewKeyFile != "" {
+		if _, err = os.Stat(cmd.NewKeyFile); os.IsNotExist(err) {
+			if err = knoxite.GenerateKeyFile(cmd.NewKeyFile); err != nil {
+				return err
+			}
+			fmt.Printf("Generated new key file %s, keep it safe!\n", cmd.NewKeyFile)
+		}
+		password, err = knoxite.KeyFilePassword("", cmd.NewKeyFile)
+	} else {
+		password, err = readPasswordTwice("Enter new password:", "Confirm new password:")
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := r.AddKey(password, cmd.Description)
+	if err != nil {
+		return err
+	}
+	err = r.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key %s added\n", id)
+
+	return nil
+}
+
+func (cmd CmdKey) remove(id string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	err = r.RemoveKey(id)
+	if err != nil {
+		return err
+	}
+	err = r.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key %s removed\n", id)
+
+	return nil
+}
+
+func (cmd CmdKey) list() error {
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	keys, err := r.Keys()
+	if err != nil {
+		return err
+	}
+
+	tab := gotable.NewTable([]string{"ID", "Created", "KDF", "Description"},
+		[]int64{-8, -19, -8, -50},
+		"No keys found.")
+	for _, key := range keys {
+		id := key.ID
+		if id == r.CurrentKey() {
+			id += "*"
+		}
+		tab.AppendRow([]interface{}{
+			id,
+			key.Created.Format(timeFormat),
+			key.KDF,
+			key.Description})
+	}
+
+	tab.Print()
+	return nil
+}
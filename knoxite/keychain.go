@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/knoxite/knoxite"
+)
+
+// CmdKeychain describes the command
+type CmdKeychain struct {
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("keychain",
+		"manage passwords and credentials in the OS keychain",
+		"The keychain command stores repository passwords and backend credentials in the OS keychain",
+		&CmdKeychain{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdKeychain) Usage() string {
+	return "[store-password|forget-password|store-credentials URL|forget-credentials URL]"
+}
+
+// Execute this command
+func (cmd CmdKeychain) Execute(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+
+	switch args[0] {
+	case "store-password":
+		return cmd.storePassword()
+	case "forget-password":
+		if cmd.global.Repo == "" {
+			return ErrMissingRepoLocation
+		}
+		return knoxite.DeletePasswordFromKeychain(cmd.global.Repo)
+	case "store-credentials":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.storeCredentials(args[1])
+	case "forget-credentials":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return knoxite.DeleteCredentialsFromKeychain(args[1])
+	default:
+		return fmt.Errorf(TUnknownCommand, cmd.Usage())
+	}
+}
+
+func (cmd CmdKeychain) storePassword() error {
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	// Make sure the password is correct before storing it
+	password := cmd.global.Password
+	if password == "" {
+		var err error
+		password, err = readPassword("Enter password:")
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := knoxite.OpenRepository(cmd.global.Repo, password); err != nil {
+		return err
+	}
+
+	if err := knoxite.StorePasswordInKeychain(cmd.global.Repo, password); err != nil {
+		return err
+	}
+	fmt.Printf("Stored password for %s in keychain\n", cmd.global.Repo)
+	return nil
+}
+
+func (cmd CmdKeychain) storeCredentials(url string) error {
+	fmt.Print("Enter username: ")
+	username, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	password, err := readPassword("Enter password:")
+	if err != nil {
+		return err
+	}
+
+	if err = knoxite.StoreCredentialsInKeychain(url, strings.TrimSpace(username), password); err != nil {
+		return err
+	}
+	fmt.Printf("Stored credentials for %s in keychain\n", url)
+	return nil
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"os/user"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/knoxite/knoxite"
 	"github.com/muesli/gotable"
@@ -13,13 +16,15 @@ const timeFormat = "2006-01-02 15:04:05"
 
 // CmdLs describes the command
 type CmdLs struct {
+	Tree bool `long:"tree" description:"show the files as a tree"`
+
 	global *GlobalOptions
 }
 
 func init() {
 	_, err := parser.AddCommand("ls",
 		"list files",
-		"The ls command lists all files stored in a snapshot",
+		"The ls command lists all files, directories and symlinks stored in a snapshot, optionally only the ones within a path",
 		&CmdLs{global: &globalOpts})
 	if err != nil {
 		panic(err)
@@ -28,12 +33,12 @@ func init() {
 
 // Usage describes this command's usage help-text
 func (cmd CmdLs) Usage() string {
-	return "SNAPSHOT-ID" // "[DIR/FILE] [...]"
+	return "SNAPSHOT-ID [PATH]"
 }
 
 // Execute this command
 func (cmd CmdLs) Execute(args []string) error {
-	if len(args) != 1 {
+	if len(args) < 1 || len(args) > 2 {
 		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
 	}
 	if cmd.global.Repo == "" {
@@ -42,29 +47,44 @@ func (cmd CmdLs) Execute(args []string) error {
 
 	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err == nil {
-		tab := gotable.NewTable([]string{"Perms", "User", "Group", "Size", "ModTime", "Name"},
-			[]int64{-10, -8, -5, 12, -19, -48},
-			"No files found.")
-
 		_, snapshot, ferr := repository.FindSnapshot(args[0])
 		if ferr != nil {
 			return ferr
 		}
-
-		for _, archive := range snapshot.Items {
-			username := strconv.FormatInt(int64(archive.UID), 10)
-			u, uerr := user.LookupId(username)
-			if uerr == nil {
-				username = u.Username
+		items := snapshot.Items
+		if len(args) > 1 {
+			filter := knoxite.PathFilter{Include: []string{args[1]}}
+			if ferr = filter.Check(); ferr != nil {
+				return ferr
 			}
-			groupname := strconv.FormatInt(int64(archive.GID), 10)
+			items = []knoxite.ItemData{}
+			for _, item := range snapshot.Items {
+				if filter.Match(item.Path) {
+					items = append(items, item)
+				}
+			}
+		}
+
+		if cmd.global.JSON {
+			return printItems(items)
+		}
+		if cmd.Tree {
+			printTree(items)
+			return nil
+		}
+
+		tab := gotable.NewTable([]string{"Perms", "User", "Group", "Size", "ModTime", "Name"},
+			[]int64{-10, -8, -5, 12, -19, -48},
+			"No files found.")
+		for _, archive := range items {
+			username, groupname := owner(archive)
 			tab.AppendRow([]interface{}{
 				archive.Mode,
 				username,
 				groupname,
 				knoxite.SizeToString(archive.Size),
 				archive.ModTime.Format(timeFormat),
-				archive.Path})
+				itemName(archive, archive.Path)})
 		}
 
 		tab.Print()
@@ -72,3 +92,97 @@ func (cmd CmdLs) Execute(args []string) error {
 
 	return err
 }
+
+// owner returns the names of an item's user and group, or their IDs if
+// they're unknown on this system
+func owner(item knoxite.ItemData) (string, string) {
+	username := strconv.FormatInt(int64(item.UID), 10)
+	if u, err := user.LookupId(username); err == nil {
+		username = u.Username
+	}
+	groupname := strconv.FormatInt(int64(item.GID), 10)
+	if g, err := user.LookupGroupId(groupname); err == nil {
+		groupname = g.Name
+	}
+	return username, groupname
+}
+
+// printItems prints the items as JSON
+func printItems(items []knoxite.ItemData) error {
+	list := []itemJSON{}
+	for _, item := range items {
+		username, groupname := owner(item)
+		list = append(list, itemJSON{
+			Path:     item.Path,
+			Type:     itemType(item),
+			PointsTo: item.PointsTo,
+			Mode:     item.Mode.String(),
+			ModTime:  item.ModTime,
+			Size:     item.Size,
+			UID:      item.UID,
+			GID:      item.GID,
+			User:     username,
+			Group:    groupname,
+		})
+	}
+	return printJSON(list)
+}
+
+// itemName returns how an item gets listed, symlinks show their target
+func itemName(item knoxite.ItemData, name string) string {
+	if item.Type == knoxite.SymLink {
+		return name + " -> " + item.PointsTo
+	}
+	return name
+}
+
+// treeNode is a directory entry in the tree view
+type treeNode struct {
+	item     *knoxite.ItemData
+	children map[string]*treeNode
+}
+
+// printTree prints the items as a tree, like the tree command does
+func printTree(items []knoxite.ItemData) {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for i := range items {
+		node := root
+		for _, name := range strings.Split(strings.Trim(filepath.ToSlash(items[i].Path), "/"), "/") {
+			child, ok := node.children[name]
+			if !ok {
+				child = &treeNode{children: make(map[string]*treeNode)}
+				node.children[name] = child
+			}
+			node = child
+		}
+		node.item = &items[i]
+	}
+
+	root.print("")
+}
+
+func (node *treeNode) print(indent string) {
+	names := []string{}
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		child := node.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		line := name
+		if child.item != nil {
+			line = itemName(*child.item, name)
+			if child.item.Type == knoxite.File {
+				line += " (" + knoxite.SizeToString(child.item.Size) + ")"
+			}
+		}
+		fmt.Println(indent + branch + line)
+		child.print(indent + next)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/knoxite/knoxite"
 )
 
 // Translations
@@ -17,15 +18,35 @@ const (
 	TSpecifyRepoLocation = "Please specify repository location (-r)"
 )
 
+// Exit codes, so scripts can tell what happened
+const (
+	ExitOK = 0
+	// ExitError means the command failed
+	ExitError = 1
+	// ExitPartial means the command completed, but some items got skipped
+	ExitPartial = 2
+	// ExitVerifyFailed means damaged or missing data was found
+	ExitVerifyFailed = 3
+	// ExitLocked means another process holds a lock on the repository
+	ExitLocked = 4
+)
+
 // Error declarations
 var (
 	ErrMissingRepoLocation = errors.New(TSpecifyRepoLocation)
+	ErrPartial             = errors.New("some items failed and got skipped")
 )
 
 // GlobalOptions holds all those options that can be set for every command
 type GlobalOptions struct {
 	Repo     string `short:"r" long:"repo"     description:"Repository directory to backup to/restore from"`
-	Password string `short:"p" long:"password" description:"Password to use for data encryption"`
+	Password string `short:"p" long:"password" description:"Password to use for data encryption" env:"KNOXITE_PASSWORD"`
+	KeyFile  string `long:"keyfile"            description:"Key file to use for data encryption, instead of or in addition to a password"`
+
+	PasswordCommand string `long:"password-command" description:"Command printing the password, e.g. \"pass show backup/repo\""`
+	Config          string `long:"config"           description:"Config file defining repositories and their defaults (default: ~/.config/knoxite/config.toml)"`
+	JSON            bool   `long:"json"             description:"Print machine-readable JSON instead of tables and progress bars"`
+	Quiet           bool   `short:"q" long:"quiet"  description:"Don't print progress bars and informational messages"`
 }
 
 var (
@@ -33,6 +54,44 @@ var (
 	parser     = flags.NewParser(&globalOpts, flags.HelpFlag|flags.PassDoubleDash)
 )
 
+// messagef prints an informational message. With --json it goes to stderr,
+// so it can't get mixed up with the output, with --quiet it gets dropped
+func messagef(format string, a ...interface{}) {
+	if globalOpts.Quiet {
+		return
+	}
+	if globalOpts.JSON {
+		fmt.Fprintf(os.Stderr, format, a...)
+		return
+	}
+	fmt.Printf(format, a...)
+}
+
+// warnf prints a warning to stderr, even with --quiet
+func warnf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format, a...)
+}
+
+// showProgress returns whether progress counters should be printed
+func showProgress() bool {
+	return !globalOpts.Quiet && !globalOpts.JSON
+}
+
+// exitCode returns the exit code a command failing with err ends with
+func exitCode(err error) int {
+	switch err {
+	case nil:
+		return ExitOK
+	case ErrPartial:
+		return ExitPartial
+	case ErrVerifyFailed, ErrRepairFailed:
+		return ExitVerifyFailed
+	case knoxite.ErrRepositoryLocked:
+		return ExitLocked
+	}
+	return ExitError
+}
+
 func handleSignals() {
 	// Wait for signals
 	ch := make(chan os.Signal, 1)
@@ -59,6 +118,17 @@ func main() {
 		handleSignals()
 	}()
 
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if globalOpts.Config == "" {
+			globalOpts.Config = configPath()
+		}
+		args, err := applyConfig(command, args)
+		if err != nil {
+			return err
+		}
+		return command.Execute(args)
+	}
+
 	_, err := parser.Parse()
 	if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
 		parser.WriteHelp(os.Stdout)
@@ -67,8 +137,8 @@ func main() {
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(0)
 	}
+	os.Exit(exitCode(err))
 
 	//	fmt.Println("Exiting.")
 }
@@ -8,7 +8,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 
 	"golang.org/x/net/context"
@@ -31,8 +33,8 @@ type CmdMount struct {
 
 func init() {
 	_, err := parser.AddCommand("mount",
-		"mount a snapshot",
-		"The mount command mounts a repository read-only to a given directory",
+		"mount a repository",
+		"The mount command mounts a repository read-only to a given directory. Its volumes and their snapshots show up as directories. Pass a snapshot ID to mount a single snapshot",
 		&CmdMount{
 			global: &globalOpts,
 			ready:  make(chan struct{}, 1),
@@ -45,12 +47,12 @@ func init() {
 
 // Usage describes this command's usage help-text
 func (cmd CmdMount) Usage() string {
-	return "SNAPSHOT-ID MOUNTPOINT"
+	return "[SNAPSHOT-ID] MOUNTPOINT"
 }
 
 // Execute this command
 func (cmd CmdMount) Execute(args []string) error {
-	if len(args) < 2 {
+	if len(args) < 1 || len(args) > 2 {
 		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
 	}
 	if cmd.global.Repo == "" {
@@ -62,7 +64,17 @@ func (cmd CmdMount) Execute(args []string) error {
 		return err
 	}
 
-	mountpoint := args[1]
+	var root fs.Node = &repositoryDir{repository: &repository}
+	mountpoint := args[0]
+	if len(args) == 2 {
+		_, snapshot, ferr := repository.FindSnapshot(args[0])
+		if ferr != nil {
+			return ferr
+		}
+		root = newSnapshotDir(&repository, snapshot)
+		mountpoint = args[1]
+	}
+
 	if _, serr := os.Stat(mountpoint); os.IsNotExist(serr) {
 		fmt.Printf("Mountpoint %s doesn't exist, creating it\n", mountpoint)
 		err = os.Mkdir(mountpoint, os.ModeDir|0700)
@@ -79,24 +91,11 @@ func (cmd CmdMount) Execute(args []string) error {
 		return err
 	}
 
-	roottree := fs.Tree{}
-
-	_, snapshot, err := repository.FindSnapshot(args[0])
-	if err != nil {
-		return err
-	}
-	fmt.Println("Updating index")
-	updateIndex(&repository, snapshot)
-	fmt.Println("Updating index done")
-	for _, arc := range root.Items {
-		roottree.Add(arc.Item.Path, arc)
-	}
-
 	cmd.ready <- struct{}{}
 
 	errServe := make(chan error)
 	go func() {
-		err = fs.Serve(c, &roottree)
+		err = fs.Serve(c, mountFS{root})
 		if err != nil {
 			errServe <- err
 		}
@@ -117,97 +116,245 @@ func (cmd CmdMount) Execute(args []string) error {
 	}
 }
 
-// Node in our virtual filesystem
-type Node struct {
-	Items      map[string]*Node
-	Item       knoxite.ItemData
-	Repository *knoxite.Repository
-	//	sync.RWMutex
+// mountFS is the filesystem served by the mount command
+type mountFS struct {
+	root fs.Node
 }
 
-var (
-	root *Node
-)
+// Root returns the root directory of the filesystem
+func (f mountFS) Root() (fs.Node, error) {
+	return f.root, nil
+}
+
+// direntType returns the type of a directory entry for node
+func direntType(node fs.Node) fuse.DirentType {
+	switch node.(type) {
+	case *fileNode:
+		return fuse.DT_File
+	case *symlinkNode:
+		return fuse.DT_Link
+	}
+	return fuse.DT_Dir
+}
+
+// repositoryDir lists the volumes of a repository
+type repositoryDir struct {
+	repository *knoxite.Repository
+
+	sync.Mutex
+	volumes map[string]*volumeDir
+}
+
+// Attr returns this node's filesystem attr's
+func (dir *repositoryDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+// Lookup returns the volume with the given ID
+func (dir *repositoryDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	dir.Lock()
+	defer dir.Unlock()
+
+	if v, ok := dir.volumes[name]; ok {
+		return v, nil
+	}
+	for _, volume := range dir.repository.Volumes {
+		if volume.ID == name {
+			if dir.volumes == nil {
+				dir.volumes = make(map[string]*volumeDir)
+			}
+			v := &volumeDir{repository: dir.repository, volume: volume}
+			dir.volumes[name] = v
+			return v, nil
+		}
+	}
+
+	return nil, fuse.ENOENT
+}
 
-func node(name string) *Node {
-	l := strings.Split(name, string(filepath.Separator))
+// ReadDirAll lists all volumes
+func (dir *repositoryDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	dirents := []fuse.Dirent{}
+	for _, volume := range dir.repository.Volumes {
+		dirents = append(dirents, fuse.Dirent{Name: volume.ID, Type: fuse.DT_Dir})
+	}
+	return dirents, nil
+}
+
+// volumeDir lists the snapshots of a volume. Snapshots get loaded when
+// they're first accessed
+type volumeDir struct {
+	repository *knoxite.Repository
+	volume     *knoxite.Volume
+
+	sync.Mutex
+	snapshots map[string]*dirNode
+}
+
+// Attr returns this node's filesystem attr's
+func (dir *volumeDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
 
-	item := root
-	for _, s := range l {
-		if len(s) == 0 {
+// Lookup returns the snapshot with the given ID
+func (dir *volumeDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	dir.Lock()
+	defer dir.Unlock()
+
+	if s, ok := dir.snapshots[name]; ok {
+		return s, nil
+	}
+	for _, id := range dir.volume.Snapshots {
+		if id == name {
+			snapshot, err := dir.volume.LoadSnapshot(id, dir.repository)
+			if err != nil {
+				return nil, err
+			}
+			if dir.snapshots == nil {
+				dir.snapshots = make(map[string]*dirNode)
+			}
+			s := newSnapshotDir(dir.repository, &snapshot)
+			dir.snapshots[name] = s
+			return s, nil
+		}
+	}
+
+	return nil, fuse.ENOENT
+}
+
+// ReadDirAll lists all snapshots
+func (dir *volumeDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	dirents := []fuse.Dirent{}
+	for _, id := range dir.volume.Snapshots {
+		dirents = append(dirents, fuse.Dirent{Name: id, Type: fuse.DT_Dir})
+	}
+	return dirents, nil
+}
+
+// dirNode is a directory within a snapshot
+type dirNode struct {
+	item     knoxite.ItemData
+	children map[string]fs.Node
+}
+
+// newSnapshotDir returns the root directory of a snapshot, containing all
+// its items
+func newSnapshotDir(repository *knoxite.Repository, snapshot *knoxite.Snapshot) *dirNode {
+	root := newDirNode(knoxite.ItemData{Mode: os.ModeDir | 0555, ModTime: snapshot.Date})
+	for _, item := range snapshot.Items {
+		names := strings.Split(strings.Trim(filepath.ToSlash(item.Path), "/"), "/")
+		if names[0] == "" {
 			continue
 		}
-		fmt.Println("Finding:", s)
-		v, ok := item.Items[s]
-		if !ok {
-			fmt.Println("Adding to tree:", s)
-			v = &Node{}
-			v.Items = make(map[string]*Node)
-			item.Items[s] = v
+
+		// Parent directories may not be part of the snapshot
+		parent := root
+		for _, name := range names[:len(names)-1] {
+			dir, ok := parent.children[name].(*dirNode)
+			if !ok {
+				dir = newDirNode(knoxite.ItemData{Mode: os.ModeDir | 0555, ModTime: snapshot.Date})
+				parent.children[name] = dir
+			}
+			parent = dir
 		}
 
-		item = v
+		name := names[len(names)-1]
+		switch item.Type {
+		case knoxite.Directory:
+			if dir, ok := parent.children[name].(*dirNode); ok {
+				dir.item = item
+			} else {
+				parent.children[name] = newDirNode(item)
+			}
+		case knoxite.SymLink:
+			parent.children[name] = &symlinkNode{item: item}
+		default:
+			parent.children[name] = &fileNode{repository: repository, item: item}
+		}
 	}
 
-	return item
+	return root
 }
 
-func updateIndex(repository *knoxite.Repository, snapshot *knoxite.Snapshot) {
-	root = &Node{}
-	root.Items = make(map[string]*Node)
-	for _, arc := range snapshot.Items {
-		fmt.Println("Adding to index:", arc.Path)
-		i := node(arc.Path)
-		i.Item = arc
-		i.Repository = repository
+func newDirNode(item knoxite.ItemData) *dirNode {
+	return &dirNode{
+		item:     item,
+		children: make(map[string]fs.Node),
 	}
 }
 
 // Attr returns this node's filesystem attr's
-func (node *Node) Attr(ctx context.Context, a *fuse.Attr) error {
-	a.Inode = 1
-	a.Mode = node.Item.Mode
-
-	if node.Item.Type == knoxite.File {
-		a.Size = node.Item.Size
-	}
+func (dir *dirNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = dir.item.Mode | os.ModeDir
+	a.Mtime = dir.item.ModTime
+	a.Uid = dir.item.UID
+	a.Gid = dir.item.GID
 	return nil
 }
 
 // Lookup is used to stat items
-func (node *Node) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	item, ok := node.Items[name]
-	if ok {
-		return item, nil
+func (dir *dirNode) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if node, ok := dir.children[name]; ok {
+		return node, nil
 	}
-
 	return nil, fuse.ENOENT
 }
 
-// ReadDirAll returns all directories directly below this node
-func (node *Node) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	dirDirs := []fuse.Dirent{}
+// ReadDirAll returns all items directly below this directory
+func (dir *dirNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	names := []string{}
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	for k := range node.Items {
-		ent := fuse.Dirent{Inode: 2, Name: k, Type: fuse.DT_File}
-		dirDirs = append(dirDirs, ent)
+	dirents := []fuse.Dirent{}
+	for _, name := range names {
+		dirents = append(dirents, fuse.Dirent{Name: name, Type: direntType(dir.children[name])})
 	}
+	return dirents, nil
+}
+
+// fileNode is a file within a snapshot
+type fileNode struct {
+	repository *knoxite.Repository
+	item       knoxite.ItemData
+}
 
-	return dirDirs, nil
+// Attr returns this node's filesystem attr's
+func (file *fileNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = file.item.Mode
+	a.Size = file.item.Size
+	a.Mtime = file.item.ModTime
+	a.Uid = file.item.UID
+	a.Gid = file.item.GID
+	return nil
 }
 
 // Open opens a file
-func (node *Node) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+func (file *fileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	if !req.Flags.IsReadOnly() {
 		return nil, fuse.Errno(syscall.EACCES)
 	}
 	resp.Flags |= fuse.OpenKeepCache
-	return node, nil
+	return file, nil
 }
 
 // Read reads from a file
-func (node *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	d, err := knoxite.ReadArchive(*node.Repository, node.Item, int(req.Offset), req.Size)
+func (file *fileNode) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	// ReadArchive can't read beyond the end of a file
+	if uint64(req.Offset) >= file.item.Size {
+		resp.Data = nil
+		return nil
+	}
+	size := req.Size
+	if uint64(req.Offset)+uint64(size) > file.item.Size {
+		size = int(file.item.Size - uint64(req.Offset))
+	}
+
+	d, err := knoxite.ReadArchive(*file.repository, file.item, int(req.Offset), size)
 	if err != nil {
 		if err != io.EOF {
 			return err
@@ -220,11 +367,21 @@ func (node *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.Re
 	return nil
 }
 
-// ReadAll reads an entire archive's content
-/*func (node *Node) ReadAll(ctx context.Context) ([]byte, error) {
-	d, _, err := knoxite.DecodeArchiveData(*node.Repository, node.Item)
-	if err != nil {
-		return d, err
-	}
-	return d, nil
-}*/
+// symlinkNode is a symlink within a snapshot
+type symlinkNode struct {
+	item knoxite.ItemData
+}
+
+// Attr returns this node's filesystem attr's
+func (link *symlinkNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeSymlink | 0777
+	a.Mtime = link.item.ModTime
+	a.Uid = link.item.UID
+	a.Gid = link.item.GID
+	return nil
+}
+
+// Readlink returns the target of the symlink
+func (link *symlinkNode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return link.item.PointsTo, nil
+}
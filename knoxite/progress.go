@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/knoxite/knoxite"
+)
+
+// progressText describes the overall progress of a store or restore, e.g.
+// "1.2 MiB / 3.0 MiB, 4.1 MiB/s, 12 items left, 0:12 elapsed, ETA 0:30"
+func progressText(p knoxite.Progress) string {
+	s := fmt.Sprintf("%s / %s, %s/s, %d items left, %s elapsed",
+		knoxite.SizeToString(p.Done),
+		knoxite.SizeToString(p.Total),
+		knoxite.SizeToString(uint64(p.Speed)),
+		p.TotalItems-p.Items,
+		durationToString(p.Elapsed))
+	if p.ETA > 0 {
+		s += ", ETA " + durationToString(p.ETA)
+	}
+	return s
+}
+
+// durationToString formats d like a clock, e.g. 1:02:03 or 2:03
+func durationToString(d time.Duration) string {
+	secs := int64(d.Round(time.Second) / time.Second)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
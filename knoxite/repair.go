@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/knoxite/knoxite"
+	"github.com/muesli/gotable"
+)
+
+// Error declarations
+var (
+	ErrRepairFailed = errors.New("some chunks could not be repaired")
+)
+
+// CmdRepair describes the command
+type CmdRepair struct {
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("repair",
+		"repair chunk parts",
+		"The repair command checks the parts of all chunks of a snapshot, or of the whole repository, on every backend. Missing and corrupt parts get reconstructed from the remaining data and parity parts and stored again, restoring the redundancy",
+		&CmdRepair{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdRepair) Usage() string {
+	return "[SNAPSHOT-ID]"
+}
+
+// Execute this command
+func (cmd CmdRepair) Execute(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	var chunks []knoxite.Chunk
+	if len(args) > 0 {
+		_, snapshot, ferr := repository.FindSnapshot(args[0])
+		if ferr != nil {
+			return ferr
+		}
+		seen := make(map[string]bool)
+		for _, item := range snapshot.Items {
+			for _, chunk := range item.Chunks {
+				if !seen[chunk.ShaSum] {
+					seen[chunk.ShaSum] = true
+					chunks = append(chunks, chunk)
+				}
+			}
+		}
+	} else {
+		if chunks, err = repository.Chunks(); err != nil {
+			return err
+		}
+	}
+
+	tab := gotable.NewTable([]string{"Chunk", "Part", "Storage URL", "Problem"},
+		[]int64{-16, 4, -48, -20},
+		"All chunk parts are intact.")
+	failed := 0
+	repaired := 0
+	for i, chunk := range chunks {
+		if showProgress() {
+			fmt.Printf("\rChecked %d of %d chunks", i+1, len(chunks))
+		}
+		repairs, rerr := repository.RepairChunk(chunk)
+		for _, r := range repairs {
+			tab.AppendRow([]interface{}{
+				chunk.ShaSum[:16],
+				r.Part,
+				r.Location,
+				r.Problem})
+			repaired++
+		}
+		if rerr != nil {
+			fmt.Printf("\rChunk %s can't be repaired: %v\n", chunk.ShaSum, rerr)
+			failed++
+		}
+	}
+	if !cmd.global.Quiet || repaired > 0 {
+		if showProgress() {
+			fmt.Println()
+			fmt.Println()
+		}
+		tab.Print()
+	}
+
+	// The part index changed
+	if err = repository.Save(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return ErrRepairFailed
+	}
+	return nil
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"golang.org/x/crypto/ssh/terminal"
@@ -16,11 +20,32 @@ import (
 // Error declarations
 var (
 	ErrPasswordMismatch = errors.New("Passwords did not match")
+	ErrBackendCheck     = errors.New("Some backends failed the check")
+	ErrBackendExists    = errors.New("Backend is already part of the repository")
+	ErrBackendNotFound  = errors.New("Backend is not part of the repository")
+	ErrChunkerUnknown   = errors.New("Unknown chunker, expected fastcdc, rabin or fixed")
 )
 
 // CmdRepository describes the command
 type CmdRepository struct {
+	LimitUpload   string `long:"limit-upload"   description:"limit the upload rate of the backend, e.g. 2M (bytes per second)"`
+	LimitDownload string `long:"limit-download" description:"limit the download rate of the backend, e.g. 10M (bytes per second)"`
+	Connections   uint   `long:"connections"    description:"amount of chunks to transfer concurrently with the backend"`
+	ReadOnly      bool   `long:"read-only"      description:"only read from the backend, never write to it"`
+	Weight        uint   `long:"weight"         description:"relative share of chunks stored on the backend"`
+	ReadPriority  int    `long:"read-priority"  description:"load data from backends with a higher priority first"`
+	Tier          string `long:"tier"           description:"storage tier of the backend (hot or cold)"`
+	Backends      bool   `long:"backends"       description:"show statistics per backend"`
+	Compression   string `short:"c" long:"compression" description:"compression algo to convert chunks to, see store"`
+	Encryption    string `short:"e" long:"encryption"  description:"encryption algo to convert chunks to, see store"`
+	Chunker       string `long:"chunker"        description:"chunker to split files with: fastcdc (default), rabin, fixed"`
+	MinChunkSize  string `long:"min-chunk-size" description:"default minimum size of chunks, e.g. 512K"`
+	ChunkSize     string `long:"chunk-size"     description:"default average size of chunks, e.g. 1M"`
+	MaxChunkSize  string `long:"max-chunk-size" description:"default maximum size of chunks, e.g. 8M"`
+
 	global *GlobalOptions
+	// defaults are the settings of the repository in the config file
+	defaults RepositoryConfig
 }
 
 func init() {
@@ -35,7 +60,7 @@ func init() {
 
 // Usage describes this command's usage help-text
 func (cmd CmdRepository) Usage() string {
-	return "[init|add|cat|info]"
+	return "[init|add-backend|remove-backend|cat|info|stats|check-backends|rebalance|repair|migrate|convert|dedup-stats]"
 }
 
 // Execute this command
@@ -50,15 +75,34 @@ func (cmd CmdRepository) Execute(args []string) error {
 	switch args[0] {
 	case "init":
 		return cmd.init()
-	case "add":
+	case "add", "add-backend":
 		if len(args) < 2 {
 			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
 		}
 		return cmd.add(args[1])
+	case "remove-backend":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.remove(args[1])
 	case "cat":
 		return cmd.cat()
 	case "info":
 		return cmd.info()
+	case "stats":
+		return cmd.stats()
+	case "check-backends":
+		return cmd.checkBackends()
+	case "rebalance":
+		return cmd.rebalance()
+	case "repair":
+		return cmd.repair()
+	case "migrate":
+		return cmd.migrate()
+	case "convert":
+		return cmd.convert()
+	case "dedup-stats":
+		return cmd.dedupStats()
 	default:
 		return fmt.Errorf(TUnknownCommand, cmd.Usage())
 	}
@@ -75,25 +119,86 @@ func (cmd CmdRepository) init() error {
 			hostname = "unknown"
 		}*/
 
-	r, err := newRepository(cmd.global.Repo, cmd.global.Password)
+	chunker, err := chunkerAlgo(cmd.Chunker)
+	if err != nil {
+		return err
+	}
+	size, err := chunkSize(cmd.MinChunkSize, cmd.ChunkSize, cmd.MaxChunkSize)
+	if err != nil {
+		return err
+	}
+	if err = knoxite.CheckChunkSize(size); err != nil {
+		return err
+	}
+
+	r, err := newRepository(cmd.backendURL(cmd.global.Repo), cmd.global.Password)
 	if err != nil {
 		return fmt.Errorf("Creating repository at %s failed: %v", cmd.global.Repo, err)
 	}
+	if chunker != r.Chunker || size != r.ChunkSize {
+		r.Chunker = chunker
+		r.ChunkSize = size
+		if err = r.Save(); err != nil {
+			return err
+		}
+	}
 
 	fmt.Printf("Created new repository at %s\n", (*r.Backend.Backends[0]).Location())
 	return nil
 }
 
+func chunkerAlgo(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "fastcdc":
+		return knoxite.ChunkerFastCDC, nil
+	case "rabin":
+		return knoxite.ChunkerRabin, nil
+	case "fixed":
+		return knoxite.ChunkerFixed, nil
+	}
+
+	return 0, ErrChunkerUnknown
+}
+
 func (cmd CmdRepository) add(url string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err != nil {
 		return err
 	}
 
-	backend, err := knoxite.BackendFromURL(url)
+	backend, err := knoxite.BackendFromURL(cmd.backendURL(url))
 	if err != nil {
 		return err
 	}
+	if findBackend(r, backend.Location()) != nil {
+		return ErrBackendExists
+	}
+
+	err = backend.InitRepository()
+	if err != nil && err != knoxite.ErrRepositoryExists {
+		return err
+	}
+	// Every backend carries all snapshots, index and location files
+	ids := append([]string{}, r.IndexFiles...)
+	ids = append(ids, r.LocationFiles...)
+	for _, volume := range r.Volumes {
+		ids = append(ids, volume.Snapshots...)
+	}
+	for _, id := range ids {
+		b, err := r.Backend.LoadSnapshot(id)
+		if err != nil {
+			return err
+		}
+		if err = backend.SaveSnapshot(id, b); err != nil {
+			return err
+		}
+	}
 	r.Backend.AddBackend(&backend)
 
 	err = r.Save()
@@ -101,6 +206,68 @@ func (cmd CmdRepository) add(url string) error {
 		return err
 	}
 	fmt.Printf("Added %s to repository\n", backend.Location())
+	fmt.Println("Run 'repo rebalance' to move existing data onto it")
+
+	return nil
+}
+
+func (cmd CmdRepository) remove(url string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	backend, err := knoxite.BackendFromURL(url)
+	if err != nil {
+		return err
+	}
+	be := findBackend(r, backend.Location())
+	if be == nil {
+		return ErrBackendNotFound
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+
+	var moved uint64
+	for i, chunk := range chunks {
+		n, err := r.Backend.EvacuateChunk(chunk, be)
+		if err != nil {
+			return fmt.Errorf("Migrating chunk %s failed: %v", chunk.ShaSum, err)
+		}
+		moved += n
+		fmt.Printf("\rMigrated %d of %d chunks, moved %s", i+1, len(chunks), knoxite.SizeToString(moved))
+	}
+	fmt.Println()
+
+	location := (*be).Location()
+	r.Backend.RemoveBackend(be)
+	delete(r.BackendStats, location)
+
+	err = r.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s from repository\n", location)
+
+	return nil
+}
+
+// findBackend returns the backend of r with the given location
+func findBackend(r knoxite.Repository, location string) *knoxite.Backend {
+	for _, be := range r.Backend.Backends {
+		if (*be).Location() == location {
+			return be
+		}
+	}
 
 	return nil
 }
@@ -125,25 +292,396 @@ func (cmd CmdRepository) info() error {
 	if err != nil {
 		return err
 	}
+	stats, err := r.DedupStats()
+	if err != nil {
+		return err
+	}
 
+	// The store command's defaults, unless the config file overrides them
+	encryption, err := encryptionAlgo(cmd.defaults.Encryption)
+	if err != nil {
+		return err
+	}
+	compression, level, err := compressionAlgo(cmd.defaults.Compression)
+	if err != nil {
+		return err
+	}
+	info := repositoryInfoJSON{
+		Version:     r.Version,
+		Chunker:     knoxite.ChunkerText(r.Chunker),
+		Encryption:  knoxite.EncryptionText(encryption),
+		Compression: knoxite.CompressionText(compression),
+		Volumes:     len(r.Volumes),
+		Size:        stats.Size,
+		StorageSize: stats.StorageSize,
+		Backends:    []backendInfoJSON{},
+	}
+	if level > 0 {
+		info.Compression += ":" + strconv.Itoa(level)
+	}
+	for _, volume := range r.Volumes {
+		info.Snapshots += len(volume.Snapshots)
+	}
+	for _, be := range r.Backend.Backends {
+		// Not all backends know their available space
+		space, _ := (*be).AvailableSpace()
+		info.Backends = append(info.Backends, backendInfoJSON{(*be).Location(), space})
+	}
+
+	if cmd.global.JSON {
+		return printJSON(info)
+	}
+
+	fmt.Printf("Format version: %d\n", info.Version)
+	fmt.Printf("Chunker: %s\n", info.Chunker)
+	fmt.Printf("Default encryption: %s\n", info.Encryption)
+	fmt.Printf("Default compression: %s\n", info.Compression)
+	fmt.Printf("Volumes: %d\n", info.Volumes)
+	fmt.Printf("Snapshots: %d\n", info.Snapshots)
+	fmt.Printf("Original size: %s\n", knoxite.SizeToString(info.Size))
+	fmt.Printf("Storage size: %s\n\n", knoxite.SizeToString(info.StorageSize))
 	tab := gotable.NewTable([]string{"Storage URL", "Available Space"},
 		[]int64{-48, 15},
 		"No backends found.")
 
+	for _, be := range info.Backends {
+		tab.AppendRow([]interface{}{
+			be.URL,
+			knoxite.SizeToString(be.AvailableSpace)})
+	}
+
+	tab.Print()
+	return nil
+}
+
+func (cmd CmdRepository) stats() error {
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	if !cmd.Backends {
+		total := knoxite.BackendStats{}
+		for _, stats := range r.BackendStats {
+			total.Add(stats)
+		}
+		fmt.Println(total.String())
+		return nil
+	}
+
+	tab := gotable.NewTable([]string{"Storage URL", "Uploaded", "Downloaded", "Operations", "Errors", "Avg. Latency"},
+		[]int64{-48, 12, 12, 10, 8, 12},
+		"No backends found.")
+
 	for _, be := range r.Backend.Backends {
-		space, _ := (*be).AvailableSpace()
+		stats := r.BackendStats[(*be).Location()]
 		tab.AppendRow([]interface{}{
 			(*be).Location(),
-			knoxite.SizeToString(space)})
+			knoxite.SizeToString(stats.Uploaded),
+			knoxite.SizeToString(stats.Downloaded),
+			stats.Operations,
+			stats.Errors,
+			stats.AverageLatency().String()})
 	}
 
 	tab.Print()
 	return nil
 }
 
+func (cmd CmdRepository) dedupStats() error {
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	stats, err := r.DedupStats()
+	if err != nil {
+		return err
+	}
+
+	tab := gotable.NewTable([]string{"ID", "Date", "Original Size", "Unique Size"},
+		[]int64{-8, -19, 15, 15},
+		"No snapshots found.")
+	for _, s := range stats.Snapshots {
+		tab.AppendRow([]interface{}{
+			s.ID,
+			s.Date.Format(timeFormat),
+			knoxite.SizeToString(s.Size),
+			knoxite.SizeToString(s.UniqueSize)})
+	}
+	tab.Print()
+
+	fmt.Println()
+	fmt.Printf("Original size: %s\n", knoxite.SizeToString(stats.Size))
+	fmt.Printf("Deduplicated size: %s\n", knoxite.SizeToString(stats.UniqueSize))
+	fmt.Printf("Storage size: %s\n", knoxite.SizeToString(stats.StorageSize))
+	fmt.Printf("Dedup ratio: %.2f\n", stats.Ratio())
+	return nil
+}
+
+func (cmd CmdRepository) checkBackends() error {
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	tab := gotable.NewTable([]string{"Storage URL", "Status"},
+		[]int64{-48, -30},
+		"No backends found.")
+
+	failed := false
+	for _, be := range r.Backend.Backends {
+		status := "OK"
+		if err := knoxite.CheckBackend(*be); err != nil {
+			status = err.Error()
+			failed = true
+		}
+		tab.AppendRow([]interface{}{
+			(*be).Location(),
+			status})
+	}
+
+	tab.Print()
+	if failed {
+		return ErrBackendCheck
+	}
+	return nil
+}
+
+func (cmd CmdRepository) rebalance() error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+
+	var moved uint64
+	for i, chunk := range chunks {
+		n, err := r.Backend.RebalanceChunk(chunk)
+		if err != nil {
+			return fmt.Errorf("Rebalancing chunk %s failed: %v", chunk.ShaSum, err)
+		}
+		moved += n
+		fmt.Printf("\rRebalanced %d of %d chunks, moved %s", i+1, len(chunks), knoxite.SizeToString(moved))
+	}
+	fmt.Println()
+
+	return r.Save()
+}
+
+func (cmd CmdRepository) migrate() error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	if r.ChunkIDsKeyed() {
+		fmt.Println("Repository is up to date")
+		return nil
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]string)
+	for i, chunk := range chunks {
+		id, err := r.MigrateChunkID(chunk)
+		if err != nil {
+			return fmt.Errorf("Migrating chunk %s failed: %v", chunk.ShaSum, err)
+		}
+		ids[chunk.ShaSum] = id
+		fmt.Printf("\rMigrated %d of %d chunks", i+1, len(chunks))
+	}
+	fmt.Println()
+
+	err = r.CompleteChunkIDMigration(ids)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Repository migrated to keyed chunk IDs")
+
+	return nil
+}
+
+func (cmd CmdRepository) convert() error {
+	compression, level, err := compressionAlgo(cmd.Compression)
+	if err != nil {
+		return err
+	}
+	encryption, err := encryptionAlgo(cmd.Encryption)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	if len(r.Conversion) > 0 {
+		fmt.Printf("Resuming conversion, %d chunks got converted already\n", len(r.Conversion))
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+
+	converted := 0
+	for i, chunk := range chunks {
+		ok, err := r.ConvertChunk(chunk, compression, level, encryption)
+		if err != nil {
+			return fmt.Errorf("Converting chunk %s failed: %v", chunk.ShaSum, err)
+		}
+		if ok {
+			converted++
+			// Remember the progress every now and then, so an interrupted
+			// conversion doesn't start from scratch
+			if converted%100 == 0 {
+				if err = r.Save(); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Printf("\rConverted %d of %d chunks", i+1, len(chunks))
+	}
+	fmt.Println()
+
+	err = r.CompleteConversion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Converted %d chunks to %s compression and %s encryption\n", converted,
+		knoxite.CompressionText(compression), knoxite.EncryptionText(encryption))
+
+	return nil
+}
+
+func (cmd CmdRepository) repair() error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	r, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	ids := append([]string{}, r.IndexFiles...)
+	ids = append(ids, r.LocationFiles...)
+	for _, volume := range r.Volumes {
+		ids = append(ids, volume.Snapshots...)
+	}
+	repaired, err := r.Backend.RepairSnapshots(ids)
+	if err != nil {
+		return err
+	}
+
+	// Cold backends may have missed copies of some chunks
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+	var copied uint64
+	for _, chunk := range chunks {
+		n, err := r.Backend.RepairColdChunk(chunk)
+		if err != nil {
+			return fmt.Errorf("Repairing chunk %s failed: %v", chunk.ShaSum, err)
+		}
+		copied += n
+	}
+
+	// Push the repository metadata to all backends again
+	err = r.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Repaired %d snapshots\n", repaired)
+	if copied > 0 {
+		fmt.Printf("Copied %s of chunks to the cold tier\n", knoxite.SizeToString(copied))
+	}
+
+	return nil
+}
+
+// backendURL appends the backend options given on the command-line to u
+func (cmd CmdRepository) backendURL(u string) string {
+	options := url.Values{}
+	if cmd.LimitUpload != "" {
+		options.Set("limit-upload", cmd.LimitUpload)
+	}
+	if cmd.LimitDownload != "" {
+		options.Set("limit-download", cmd.LimitDownload)
+	}
+	if cmd.Connections > 1 {
+		options.Set("connections", strconv.FormatUint(uint64(cmd.Connections), 10))
+	}
+	if cmd.ReadOnly {
+		options.Set("readonly", "true")
+	}
+	if cmd.Weight > 1 {
+		options.Set("weight", strconv.FormatUint(uint64(cmd.Weight), 10))
+	}
+	if cmd.ReadPriority != 0 {
+		options.Set("read-priority", strconv.Itoa(cmd.ReadPriority))
+	}
+	if cmd.Tier != "" {
+		options.Set("tier", cmd.Tier)
+	}
+	if len(options) == 0 {
+		return u
+	}
+
+	if strings.Contains(u, "?") {
+		return u + "&" + options.Encode()
+	}
+	return u + "?" + options.Encode()
+}
+
 func openRepository(path, password string) (knoxite.Repository, error) {
+	password, err := commandPassword(password)
+	if err != nil {
+		return knoxite.Repository{}, err
+	}
+
+	if globalOpts.KeyFile != "" {
+		// The key file replaces the password, unless both are given
+		password, err := knoxite.KeyFilePassword(password, globalOpts.KeyFile)
+		if err != nil {
+			return knoxite.Repository{}, err
+		}
+		return knoxite.OpenRepository(path, password)
+	}
+
+	if password == "" {
+		// Fall back to prompting when there's no password in the keychain
+		password, _ = knoxite.LoadPasswordFromKeychain(path)
+	}
 	if password == "" {
-		var err error
 		password, err = readPassword("Enter password:")
 		if err != nil {
 			return knoxite.Repository{}, err
@@ -153,9 +691,44 @@ func openRepository(path, password string) (knoxite.Repository, error) {
 	return knoxite.OpenRepository(path, password)
 }
 
+// lockRepository locks the repository at path for a command changing its
+// metadata. It has to happen before the repository gets opened, otherwise
+// changes saved by another process meanwhile would get overwritten
+func lockRepository(path string) (*knoxite.Lock, error) {
+	lock, err := knoxite.LockRepository(path)
+	if err == knoxite.ErrRepositoryLocked {
+		if locks, lerr := knoxite.Locks(path); lerr == nil {
+			for _, l := range locks {
+				if !l.Stale() {
+					warnf("locked by process %d on %s since %s\n", l.PID, l.Hostname, l.Created.Format(timeFormat))
+				}
+			}
+		}
+	}
+	return lock, err
+}
+
 func newRepository(path, password string) (knoxite.Repository, error) {
+	password, err := commandPassword(password)
+	if err != nil {
+		return knoxite.Repository{}, err
+	}
+
+	if globalOpts.KeyFile != "" {
+		if _, err := os.Stat(globalOpts.KeyFile); os.IsNotExist(err) {
+			if err = knoxite.GenerateKeyFile(globalOpts.KeyFile); err != nil {
+				return knoxite.Repository{}, err
+			}
+			fmt.Printf("Generated new key file %s, keep it safe!\n", globalOpts.KeyFile)
+		}
+		password, err := knoxite.KeyFilePassword(password, globalOpts.KeyFile)
+		if err != nil {
+			return knoxite.Repository{}, err
+		}
+		return knoxite.NewRepository(path, password)
+	}
+
 	if password == "" {
-		var err error
 		password, err = readPasswordTwice("Enter password:", "Confirm password:")
 		if err != nil {
 			return knoxite.Repository{}, err
@@ -165,6 +738,14 @@ func newRepository(path, password string) (knoxite.Repository, error) {
 	return knoxite.NewRepository(path, password)
 }
 
+// commandPassword runs the password command if no password was given
+func commandPassword(password string) (string, error) {
+	if password != "" || globalOpts.PasswordCommand == "" {
+		return password, nil
+	}
+	return knoxite.PasswordFromCommand(globalOpts.PasswordCommand)
+}
+
 func readPassword(prompt string) (string, error) {
 	fmt.Print(prompt + " ")
 	buf, err := terminal.ReadPassword(int(syscall.Stdin))
@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/knoxite/knoxite"
 	"github.com/muesli/goprogressbar"
@@ -10,12 +12,19 @@ import (
 
 // Error declarations
 var (
-	ErrTargetMissing = errors.New("please specify a directory to restore to (--target)")
+	ErrTargetMissing = errors.New("please specify a directory to restore to")
+	ErrStdoutPath    = errors.New("please specify the file to write to stdout with --path")
 )
 
 // CmdRestore describes the command
 type CmdRestore struct {
-	Target string `short:"t" long:"target" description:"Directory to restore to"`
+	Target        string   `short:"t" long:"target" description:"Directory to restore to"`
+	Include       []string `long:"include"          description:"only restore paths matching this pattern, e.g. 'home/**/*.jpg'"`
+	Exclude       []string `long:"exclude"          description:"don't restore paths matching this pattern, e.g. '**/cache/**'"`
+	Tags          []string `long:"tag"              description:"restore the latest snapshot of the given volume carrying this tag"`
+	Path          string   `long:"path"             description:"only restore this file or directory, e.g. 'var/backups/db.sql'"`
+	Stdout        bool     `long:"stdout"           description:"write the file given with --path to stdout"`
+	LimitDownload string   `long:"limit-download"   description:"limit the download rate of all backends combined, e.g. 2M per second"`
 
 	global *GlobalOptions
 }
@@ -23,7 +32,7 @@ type CmdRestore struct {
 func init() {
 	_, err := parser.AddCommand("restore",
 		"restore a snapshot",
-		"The restore command restores a snapshot to a directory",
+		"The restore command restores a snapshot to a directory, or writes a single file of it to stdout",
 		&CmdRestore{global: &globalOpts})
 	if err != nil {
 		panic(err)
@@ -32,43 +41,129 @@ func init() {
 
 // Usage describes this command's usage help-text
 func (cmd CmdRestore) Usage() string {
-	return "SNAPSHOT-ID"
+	return "SNAPSHOT-ID|VOLUME-ID [DIRECTORY] [--path PATH [--stdout]]"
 }
 
 // Execute this command
 func (cmd CmdRestore) Execute(args []string) error {
-	if len(args) != 1 {
+	if len(args) < 1 || len(args) > 2 {
 		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
 	}
+	if len(args) == 2 {
+		cmd.Target = args[1]
+	}
 	if cmd.global.Repo == "" {
 		return ErrMissingRepoLocation
 	}
-	if cmd.Target == "" {
+	if cmd.Stdout && cmd.Path == "" {
+		return ErrStdoutPath
+	}
+	if cmd.Target == "" && !cmd.Stdout {
 		return ErrTargetMissing
 	}
 
+	var limit uint64
+	if cmd.LimitDownload != "" {
+		var err error
+		if limit, err = knoxite.ParseSize(cmd.LimitDownload); err != nil {
+			return err
+		}
+	}
+
 	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err == nil {
-		_, snapshot, ferr := repository.FindSnapshot(args[0])
+		repository.Backend.SetLimits(0, limit)
+		var snapshot *knoxite.Snapshot
+		if len(cmd.Tags) > 0 {
+			volume, ferr := repository.FindVolume(args[0])
+			if ferr != nil {
+				return ferr
+			}
+			latest, ferr := volume.LatestTaggedSnapshot(&repository, cmd.Tags)
+			if ferr != nil {
+				return ferr
+			}
+			snapshot = &latest
+		} else {
+			_, s, ferr := repository.FindSnapshot(args[0])
+			if ferr != nil {
+				return ferr
+			}
+			snapshot = s
+		}
+
+		filtered, ferr := snapshot.Filter(knoxite.PathFilter{
+			Include: cmd.Include,
+			Exclude: cmd.Exclude,
+		})
 		if ferr != nil {
 			return ferr
 		}
+		if cmd.Path != "" {
+			item, ferr := snapshot.FindItem(cmd.Path)
+			if ferr != nil {
+				return ferr
+			}
+			if cmd.Stdout {
+				return writeFile(repository, *item, os.Stdout)
+			}
 
-		progress, derr := knoxite.DecodeSnapshot(repository, *snapshot, cmd.Target)
+			// Restore the item and, if it's a directory, everything within it
+			items := []knoxite.ItemData{}
+			for _, i := range filtered.Items {
+				if i.Path == item.Path || strings.HasPrefix(i.Path, item.Path+"/") {
+					items = append(items, i)
+				}
+			}
+			filtered.Items = items
+		}
+
+		progress, derr := knoxite.DecodeSnapshot(repository, filtered, cmd.Target)
 		if derr != nil {
 			return derr
 		}
+		if cmd.global.JSON {
+			return cmd.printProgress(filtered.ID, progress)
+		}
+		if cmd.global.Quiet {
+			failed := 0
+			for p := range progress {
+				if p.Err != nil {
+					warnf("could not restore %s: %v\n", p.Path, p.Err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return ErrPartial
+			}
+			return nil
+		}
+
+		fmt.Println()
+		overallProgressBar := goprogressbar.NewProgressBar("Overall Progress", 0, 0, 60)
 		pb := goprogressbar.NewProgressBar("", 0, 0, 60)
 		stats := knoxite.Stats{}
 		lastPath := ""
+		failed := 0
 
 		for p := range progress {
+			if p.Err != nil {
+				overallProgressBar.Clear()
+				warnf("could not restore %s: %v\n", p.Path, p.Err)
+				stats.Add(p.Statistics)
+				failed++
+				continue
+			}
 			pb.Total = int64(p.StorageSize)
 			pb.Current = int64(p.Size)
 			pb.RightAlignedText = fmt.Sprintf("%s / %s",
 				knoxite.SizeToString(uint64(pb.Current)),
 				knoxite.SizeToString(uint64(pb.Total)))
 
+			overallProgressBar.Total = int64(p.Total)
+			overallProgressBar.Current = int64(p.Done)
+			overallProgressBar.RightAlignedText = progressText(p)
+
 			if p.Path != lastPath {
 				// We have just started restoring a new item
 				if len(lastPath) > 0 {
@@ -82,12 +177,45 @@ func (cmd CmdRestore) Execute(args []string) error {
 				stats.Add(p.Statistics)
 			}
 
+			goprogressbar.MoveCursorUp(1)
 			pb.Print()
+			goprogressbar.MoveCursorDown(1)
+			overallProgressBar.Print()
 		}
 		fmt.Println()
 		fmt.Println("Restore done:", stats.String())
+		if failed > 0 {
+			return ErrPartial
+		}
 		return nil
 	}
 
 	return err
 }
+
+func (cmd CmdRestore) printProgress(id string, progress <-chan knoxite.Progress) error {
+	stats := knoxite.Stats{}
+	failed := 0
+	for p := range progress {
+		if p.Size == p.StorageSize {
+			stats.Add(p.Statistics)
+		}
+		var err error
+		if p.Err != nil {
+			failed++
+			err = printJSON(errorEvent{"error", p.Path, p.Err.Error()})
+		} else if !cmd.global.Quiet {
+			err = printJSON(newProgressEvent(p, p.Size, p.StorageSize, stats))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := printJSON(resultEvent{"restored", id, stats}); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return ErrPartial
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/knoxite/knoxite"
+)
+
+// Error declarations
+var (
+	ErrInvalidAuth = errors.New("please specify the credentials clients need to authenticate with (--auth USER:PASSWORD)")
+)
+
+// CmdServe describes the command
+type CmdServe struct {
+	Listen string `short:"l" long:"listen" default:":42024" description:"address to listen on"`
+	Auth   string `short:"a" long:"auth"                    description:"credentials clients need to authenticate with (USER:PASSWORD), defaults to $KNOXITE_SERVE_AUTH"`
+	Cert   string `long:"cert"                              description:"TLS certificate file, enables HTTPS"`
+	Key    string `long:"key"                               description:"TLS key file"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("serve",
+		"serve a repository",
+		"The serve command exposes a repository's storage over knoxite's HTTP protocol",
+		&CmdServe{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdServe) Usage() string {
+	return "--auth USER:PASSWORD [--listen ADDRESS]"
+}
+
+// Execute this command
+func (cmd CmdServe) Execute(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	auth := cmd.Auth
+	if auth == "" {
+		auth = os.Getenv("KNOXITE_SERVE_AUTH")
+	}
+	creds := strings.SplitN(auth, ":", 2)
+	if len(creds) != 2 || creds[0] == "" || creds[1] == "" {
+		return ErrInvalidAuth
+	}
+
+	// The server only ever sees encrypted data, so we don't need to open
+	// (and decrypt) the repository itself
+	backend, err := knoxite.BackendFromURL(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	server := &knoxite.HTTPServer{
+		Backend:  backend,
+		Username: creds[0],
+		Password: creds[1],
+	}
+
+	fmt.Printf("Serving %s on %s\n", backend.Location(), cmd.Listen)
+	if cmd.Cert != "" {
+		return http.ListenAndServeTLS(cmd.Listen, cmd.Cert, cmd.Key, server)
+	}
+	return http.ListenAndServe(cmd.Listen, server)
+}
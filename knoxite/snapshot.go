@@ -1,21 +1,31 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/knoxite/knoxite"
 	"github.com/muesli/gotable"
 )
 
+// Error declarations
+var (
+	ErrMissingDescription = errors.New("please specify the new description with -d")
+)
+
 // CmdSnapshot describes the command
 type CmdSnapshot struct {
+	Tags        []string `long:"tag"            description:"only list snapshots carrying this tag"`
+	Description string   `short:"d" long:"desc" description:"the new description of the snapshot, see edit"`
+
 	global *GlobalOptions
 }
 
 func init() {
 	_, err := parser.AddCommand("snapshot",
-		"show snapshots",
-		"The snapshots command lists all snapshots stored in a repository",
+		"manage snapshots",
+		"The snapshot command lists all snapshots stored in a volume, changes the description of a snapshot, removes a snapshot or moves it to another volume. The data of removed snapshots stays in the repository",
 		&CmdSnapshot{global: &globalOpts})
 	if err != nil {
 		panic(err)
@@ -24,7 +34,7 @@ func init() {
 
 // Usage describes this command's usage help-text
 func (cmd CmdSnapshot) Usage() string {
-	return "[list] VOLUME-ID"
+	return "[list VOLUME-ID | edit SNAPSHOT-ID -d DESCRIPTION | remove SNAPSHOT-ID | move SNAPSHOT-ID VOLUME-ID]"
 }
 
 // Execute this command
@@ -39,6 +49,15 @@ func (cmd CmdSnapshot) Execute(args []string) error {
 	switch args[0] {
 	case "list":
 		return cmd.list(args[1])
+	case "edit":
+		return cmd.edit(args[1])
+	case "remove":
+		return cmd.remove(args[1])
+	case "move":
+		if len(args) < 3 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.move(args[1], args[2])
 	default:
 		return fmt.Errorf(TUnknownCommand, cmd.Usage())
 	}
@@ -55,27 +74,116 @@ func (cmd CmdSnapshot) list(volID string) error {
 		return err
 	}
 
-	tab := gotable.NewTable([]string{"ID", "Date", "Original Size", "Storage Size", "Description"},
-		[]int64{-8, -19, 13, 12, -48}, "No snapshots found. This volume is empty.")
+	tab := gotable.NewTable([]string{"ID", "Date", "Original Size", "Storage Size", "Tags", "Description"},
+		[]int64{-8, -19, 13, 12, -16, -48}, "No snapshots found. This volume is empty.")
 	totalSize := uint64(0)
 	totalStorageSize := uint64(0)
+	snapshots := []snapshotJSON{}
 
 	for _, snapshotID := range volume.Snapshots {
 		snapshot, err := volume.LoadSnapshot(snapshotID, &repository)
 		if err != nil {
 			return err
 		}
+		if !snapshot.HasTags(cmd.Tags) {
+			continue
+		}
+		snapshots = append(snapshots, newSnapshotJSON(snapshot))
 		tab.AppendRow([]interface{}{
 			snapshot.ID,
 			snapshot.Date.Format(timeFormat),
 			knoxite.SizeToString(snapshot.Stats.Size),
 			knoxite.SizeToString(snapshot.Stats.StorageSize),
+			strings.Join(snapshot.Tags, ","),
 			snapshot.Description})
 		totalSize += snapshot.Stats.Size
 		totalStorageSize += snapshot.Stats.StorageSize
 	}
 
-	tab.SetSummary([]interface{}{"", "", knoxite.SizeToString(totalSize), knoxite.SizeToString(totalStorageSize), ""})
+	if cmd.global.JSON {
+		return printJSON(snapshots)
+	}
+	tab.SetSummary([]interface{}{"", "", knoxite.SizeToString(totalSize), knoxite.SizeToString(totalStorageSize), "", ""})
 	tab.Print()
 	return nil
 }
+
+func (cmd CmdSnapshot) edit(snapshotID string) error {
+	if cmd.Description == "" {
+		return ErrMissingDescription
+	}
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	_, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Description = cmd.Description
+	if err = snapshot.Save(&repository); err != nil {
+		return err
+	}
+	messagef("Snapshot %s updated\n", snapshot.ID)
+	return nil
+}
+
+func (cmd CmdSnapshot) remove(snapshotID string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	volume, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if err = repository.ForgetSnapshots(volume, []string{snapshot.ID}); err != nil {
+		return err
+	}
+	messagef("Snapshot %s removed\n", snapshot.ID)
+	return nil
+}
+
+func (cmd CmdSnapshot) move(snapshotID, volID string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	from, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+	to, err := repository.FindVolume(volID)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+
+	if err = repository.MoveSnapshot(snapshot, from, to); err != nil {
+		return err
+	}
+	messagef("Snapshot %s moved to volume %s\n", snapshot.ID, to.ID)
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/knoxite/knoxite"
+	"github.com/muesli/gotable"
+)
+
+// CmdStats describes the command
+type CmdStats struct {
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("stats",
+		"show statistics",
+		"The stats command shows how many files a volume or snapshot contains, how much data it refers to and how well it got compressed. For volumes it also shows how much each snapshot grew the volume",
+		&CmdStats{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdStats) Usage() string {
+	return "[VOLUME-ID|SNAPSHOT-ID]"
+}
+
+// Execute this command
+func (cmd CmdStats) Execute(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return cmd.repositoryStats(&repository)
+	}
+	if volume, verr := repository.FindVolume(args[0]); verr == nil {
+		return cmd.volumeStats(&repository, volume)
+	}
+	volume, _, err := repository.FindSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+	stats, err := volume.SnapshotStats(&repository, args[0])
+	if err != nil {
+		return err
+	}
+
+	if cmd.global.JSON {
+		s := newSnapshotStatsJSON(stats)
+		s.Volume = volume.ID
+		return printJSON(s)
+	}
+
+	fmt.Printf("Snapshot: %s (volume %s)\n", stats.ID, volume.ID)
+	fmt.Printf("Date: %s\n", stats.Date.Format(timeFormat))
+	fmt.Printf("Items: %d files, %d dirs, %d symlinks\n", stats.Stats.Files, stats.Stats.Dirs, stats.Stats.SymLinks)
+	fmt.Printf("Original size: %s\n", knoxite.SizeToString(stats.Stats.Size))
+	fmt.Printf("Chunks: %d\n", stats.Chunks)
+	fmt.Printf("Deduplicated size: %s\n", knoxite.SizeToString(stats.DataSize))
+	fmt.Printf("Storage size: %s\n", knoxite.SizeToString(stats.StorageSize))
+	fmt.Printf("Compression ratio: %.2f\n", stats.CompressionRatio())
+	fmt.Printf("Growth: %s\n", knoxite.SizeToString(stats.Growth))
+	return nil
+}
+
+func (cmd CmdStats) repositoryStats(repository *knoxite.Repository) error {
+	volumes := []volumeStatsJSON{}
+	tab := gotable.NewTable([]string{"ID", "Name", "Snapshots", "Chunks", "Dedup. Size", "Storage Size", "Ratio"},
+		[]int64{-8, -32, 9, 10, 12, 12, 6},
+		"No volumes found. This repository is empty.")
+	for _, volume := range repository.Volumes {
+		stats, err := volume.Stats(repository)
+		if err != nil {
+			return err
+		}
+		volumes = append(volumes, newVolumeStatsJSON(volume, stats))
+		tab.AppendRow([]interface{}{
+			volume.ID,
+			volume.Name,
+			len(stats.Snapshots),
+			stats.Chunks,
+			knoxite.SizeToString(stats.DataSize),
+			knoxite.SizeToString(stats.StorageSize),
+			fmt.Sprintf("%.2f", stats.CompressionRatio())})
+	}
+
+	if cmd.global.JSON {
+		return printJSON(volumes)
+	}
+	tab.Print()
+	return nil
+}
+
+func (cmd CmdStats) volumeStats(repository *knoxite.Repository, volume *knoxite.Volume) error {
+	stats, err := volume.Stats(repository)
+	if err != nil {
+		return err
+	}
+	if cmd.global.JSON {
+		return printJSON(newVolumeStatsJSON(volume, stats))
+	}
+
+	tab := gotable.NewTable([]string{"ID", "Date", "Files", "Original Size", "Storage Size", "Ratio", "Growth"},
+		[]int64{-8, -19, 8, 13, 12, 6, 12},
+		"No snapshots found. This volume is empty.")
+	for _, s := range stats.Snapshots {
+		tab.AppendRow([]interface{}{
+			s.ID,
+			s.Date.Format(timeFormat),
+			s.Stats.Files,
+			knoxite.SizeToString(s.Stats.Size),
+			knoxite.SizeToString(s.StorageSize),
+			fmt.Sprintf("%.2f", s.CompressionRatio()),
+			knoxite.SizeToString(s.Growth)})
+	}
+	tab.Print()
+
+	fmt.Println()
+	fmt.Printf("Chunks: %d\n", stats.Chunks)
+	fmt.Printf("Deduplicated size: %s\n", knoxite.SizeToString(stats.DataSize))
+	fmt.Printf("Storage size: %s\n", knoxite.SizeToString(stats.StorageSize))
+	fmt.Printf("Compression ratio: %.2f\n", stats.CompressionRatio())
+	return nil
+}
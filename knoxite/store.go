@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/knoxite/knoxite"
@@ -13,15 +17,45 @@ import (
 
 // Error declarations
 var (
-	ErrRedundancyAmount = errors.New("failure tolerance can't be equal or higher as the number of storage backends")
+	ErrRedundancyAmount   = errors.New("failure tolerance can't be equal or higher as the number of storage backends")
+	ErrReplicationAmount  = errors.New("replication factor can't be higher than the number of storage backends")
+	ErrParityReplication  = errors.New("failure tolerance and replication can't be combined, every chunk part needs a backend of its own")
+	ErrEncryptionUnknown  = errors.New("unknown encryption algo, expected aes, chacha20 or none")
+	ErrCompressionUnknown = errors.New("unknown compression algo, expected none, gzip[:level], zstd[:level], lz4 or xz[:level]")
+	ErrCompressionLevel   = errors.New("invalid compression level, gzip and xz support levels 1 to 9, zstd 1 to 22")
+	ErrCompressionRule    = errors.New("invalid compression rule, expected PATTERN=ALGO[:LEVEL]")
 )
 
 // CmdStore describes the command
 type CmdStore struct {
-	Description      string `short:"d" long:"desc"        description:"a description or comment for this snapshot"`
-	Compression      string `short:"c" long:"compression" description:"compression algo to use: none (default), gzip"`
-	Encryption       string `short:"e" long:"encryption"  description:"encryption algo to use: aes (default), none"`
-	FailureTolerance uint   `short:"t" long:"tolerance"   description:"failure tolerance against n backend failures"`
+	Description      string   `short:"d" long:"desc"        description:"a description or comment for this snapshot"`
+	Tags             []string `long:"tag"                   description:"tag the snapshot, e.g. weekly"`
+	Compression      string   `short:"c" long:"compression" description:"compression algo to use: none (default), gzip[:level], zstd[:level] (recommended), lz4, xz[:level]"`
+	CompressionRules []string `long:"compression-rule"  description:"compression for files matching a pattern, e.g. \"*.sql=zstd:19\" or \"*.mp4=none\""`
+	CompressionFile  string   `long:"compression-rules" description:"file containing one compression rule per line"`
+	Include          []string `long:"include"               description:"only store files matching a pattern, e.g. \"*.jpg\""`
+	Exclude          []string `long:"exclude"               description:"skip files and directories matching a pattern, e.g. \"**/node_modules\""`
+	ExcludeFile      string   `long:"exclude-file"          description:"file containing one exclude pattern per line"`
+	NoIgnoreFiles    bool     `long:"no-ignore-files"       description:"store files listed in .knoxiteignore files as well"`
+	OneFileSystem    bool     `long:"one-file-system"       description:"don't descend into directories on other file systems"`
+	FollowSymlinks   bool     `long:"follow-symlinks"       description:"store the files and directories symlinks point to instead of the links"`
+	Encryption       string   `short:"e" long:"encryption"  description:"encryption algo to use: aes (default), chacha20, none"`
+	FailureTolerance uint     `short:"t" long:"tolerance"   description:"failure tolerance against n backend failures"`
+	Replication      uint     `long:"replication"           description:"store each chunk on n distinct backends"`
+	MinChunkSize     string   `long:"min-chunk-size"        description:"minimum size of chunks, e.g. 512K"`
+	ChunkSize        string   `long:"chunk-size"            description:"average size of chunks, e.g. 1M (default)"`
+	MaxChunkSize     string   `long:"max-chunk-size"        description:"maximum size of chunks, e.g. 8M"`
+	Full             bool     `long:"full"                  description:"read all files, even the ones unchanged since the last snapshot"`
+	InlineSize       string   `long:"inline-size"           default:"2K" description:"store files up to this size within the snapshot instead of in chunks"`
+	DeltaSize        string   `long:"delta-size"            description:"store changes of files of at least this size block by block, e.g. 256M"`
+	DeltaBlockSize   string   `long:"delta-block-size"      description:"size of the blocks used for --delta-size, e.g. 64K (default)"`
+	StdinName        string   `long:"stdin-name"            default:"stdin" description:"file name to store the data read from stdin (target -) as"`
+	WriteQuorum      uint     `long:"write-quorum"          description:"succeed once metadata got written to n backends"`
+	Hashers          int      `long:"hashers"               description:"amount of workers hashing chunks (default: number of CPUs)"`
+	Compressors      int      `long:"compressors"           description:"amount of workers compressing chunks (default: number of CPUs)"`
+	Encryptors       int      `long:"encryptors"            description:"amount of workers encrypting chunks (default: number of CPUs)"`
+	Uploaders        int      `long:"uploaders"             description:"amount of chunks getting uploaded concurrently (default: connections of all backends)"`
+	LimitUpload      string   `long:"limit-upload"          description:"limit the upload rate of all backends combined, e.g. 2M per second"`
 
 	global *GlobalOptions
 }
@@ -36,42 +70,172 @@ func init() {
 	}
 }
 
-func (cmd CmdStore) store(repository *knoxite.Repository, snapshot *knoxite.Snapshot, targets []string) error {
-	fmt.Println()
-	overallProgressBar := goprogressbar.NewProgressBar("Overall Progress", 0, 0, 60)
-	wd, gerr := os.Getwd()
-	if gerr != nil {
-		return gerr
+// storeOptions validates the options given on the command-line and
+// configures repository accordingly
+func (cmd CmdStore) storeOptions(repository *knoxite.Repository) (knoxite.StoreOptions, error) {
+	opts := knoxite.StoreOptions{}
+	if uint(len(repository.Backend.Backends))-cmd.FailureTolerance <= 0 {
+		return opts, ErrRedundancyAmount
+	}
+	if cmd.Replication > uint(len(repository.Backend.Backends)) {
+		return opts, ErrReplicationAmount
+	}
+	if cmd.Replication > 1 && cmd.FailureTolerance > 0 {
+		return opts, ErrParityReplication
+	}
+	repository.Backend.Replication = cmd.Replication
+	repository.Backend.WriteQuorum = cmd.WriteQuorum
+	if cmd.LimitUpload != "" {
+		limit, err := knoxite.ParseSize(cmd.LimitUpload)
+		if err != nil {
+			return opts, err
+		}
+		repository.Backend.SetLimits(limit, 0)
 	}
 
-	if uint(len(repository.Backend.Backends))-cmd.FailureTolerance <= 0 {
-		return ErrRedundancyAmount
+	encryption, err := encryptionAlgo(cmd.Encryption)
+	if err != nil {
+		return opts, err
 	}
 
-	progress, serr := snapshot.Add(wd, targets, *repository,
-		strings.ToLower(cmd.Compression) == "gzip", strings.ToLower(cmd.Encryption) != "none",
-		uint(len(repository.Backend.Backends))-cmd.FailureTolerance, cmd.FailureTolerance)
+	compression, level, err := compressionAlgo(cmd.Compression)
+	if err != nil {
+		return opts, err
+	}
+	if compression == knoxite.CompressionXZ {
+		warnf("xz compression is very slow, consider zstd unless storage space is all that matters\n")
+	}
+	rules, err := cmd.compressionRules()
+	if err != nil {
+		return opts, err
+	}
+	chunkSize, err := chunkSize(cmd.MinChunkSize, cmd.ChunkSize, cmd.MaxChunkSize)
+	if err != nil {
+		return opts, err
+	}
+	var inlineSize uint64
+	if cmd.InlineSize != "" {
+		if inlineSize, err = knoxite.ParseSize(cmd.InlineSize); err != nil {
+			return opts, err
+		}
+	}
+
+	filter, err := cmd.filter()
+	if err != nil {
+		return opts, err
+	}
+
+	ignoreFile := knoxite.IgnoreFileName
+	if cmd.NoIgnoreFiles {
+		ignoreFile = ""
+	}
+
+	var deltaSize, deltaBlockSize uint64
+	if cmd.DeltaSize != "" {
+		if deltaSize, err = knoxite.ParseSize(cmd.DeltaSize); err != nil {
+			return opts, err
+		}
+	}
+	if cmd.DeltaBlockSize != "" {
+		if deltaBlockSize, err = knoxite.ParseSize(cmd.DeltaBlockSize); err != nil {
+			return opts, err
+		}
+	}
+
+	return knoxite.StoreOptions{
+		Compression:      compression,
+		CompressionLevel: level,
+		Encryption:       encryption,
+		DataParts:        uint(len(repository.Backend.Backends)) - cmd.FailureTolerance,
+		ParityParts:      cmd.FailureTolerance,
+		ChunkSize:        chunkSize,
+		InlineSize:       inlineSize,
+		CompressionRules: rules,
+		DeltaSize:        deltaSize,
+		DeltaBlockSize:   int(deltaBlockSize),
+		Filter:           filter,
+		IgnoreFile:       ignoreFile,
+		OneFileSystem:    cmd.OneFileSystem,
+		FollowSymlinks:   cmd.FollowSymlinks,
+		Pipeline: knoxite.Pipeline{
+			Hashers:     cmd.Hashers,
+			Compressors: cmd.Compressors,
+			Encryptors:  cmd.Encryptors,
+			Uploaders:   cmd.Uploaders,
+		},
+	}, nil
+}
+
+func (cmd CmdStore) store(repository *knoxite.Repository, snapshot, parent *knoxite.Snapshot, journal *knoxite.Journal, targets []string) error {
+	opts, err := cmd.storeOptions(repository)
+	if err != nil {
+		return err
+	}
+	opts.Parent = parent
+	opts.Journal = journal
+
+	if len(targets) == 1 && targets[0] == "-" {
+		if err = snapshot.AddStream(cmd.StdinName, os.Stdin, *repository, opts); err != nil {
+			return err
+		}
+		return cmd.printResult(snapshot)
+	}
+
+	wd, gerr := os.Getwd()
+	if gerr != nil {
+		return gerr
+	}
+	progress, serr := snapshot.Add(wd, targets, *repository, opts)
 	if serr != nil {
 		return serr
 	}
 
+	if cmd.global.JSON {
+		for p := range progress {
+			if p.Err != nil {
+				err = printJSON(errorEvent{"error", p.Path, p.Err.Error()})
+			} else if !cmd.global.Quiet {
+				err = printJSON(newProgressEvent(p, p.StorageSize, p.Size, p.Statistics))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return cmd.printResult(snapshot)
+	}
+	if cmd.global.Quiet {
+		for p := range progress {
+			if p.Err != nil {
+				warnf("could not store %s: %v\n", p.Path, p.Err)
+			}
+		}
+		return cmd.printResult(snapshot)
+	}
+
+	fmt.Println()
+	overallProgressBar := goprogressbar.NewProgressBar("Overall Progress", 0, 0, 60)
+
 	fileProgressBar := goprogressbar.NewProgressBar("", 0, 0, 60)
 	lastPath := ""
 	for p := range progress {
 		if p.Path != lastPath && lastPath != "" {
 			fmt.Println()
 		}
+		if p.Err != nil {
+			overallProgressBar.Clear()
+			warnf("could not store %s: %v\n", p.Path, p.Err)
+			lastPath = p.Path
+			continue
+		}
 		fileProgressBar.Total = int64(p.Size)
 		fileProgressBar.Current = int64(p.StorageSize)
 		fileProgressBar.RightAlignedText = fmt.Sprintf("%s / %s",
 			knoxite.SizeToString(uint64(fileProgressBar.Current)),
 			knoxite.SizeToString(uint64(fileProgressBar.Total)))
 
-		overallProgressBar.Total = int64(p.Statistics.Size)
-		overallProgressBar.Current = int64(p.Statistics.StorageSize)
-		overallProgressBar.RightAlignedText = fmt.Sprintf("%s / %s",
-			knoxite.SizeToString(uint64(overallProgressBar.Current)),
-			knoxite.SizeToString(uint64(overallProgressBar.Total)))
+		overallProgressBar.Total = int64(p.Total)
+		overallProgressBar.Current = int64(p.Done)
+		overallProgressBar.RightAlignedText = progressText(p)
 
 		if p.Path != lastPath {
 			lastPath = p.Path
@@ -84,10 +248,171 @@ func (cmd CmdStore) store(repository *knoxite.Repository, snapshot *knoxite.Snap
 		overallProgressBar.Print()
 	}
 
-	fmt.Printf("\nSnapshot %s created: %s\n", snapshot.ID, snapshot.Stats.String())
+	fmt.Println()
+	return cmd.printResult(snapshot)
+}
+
+func (cmd CmdStore) printResult(snapshot *knoxite.Snapshot) error {
+	if cmd.global.JSON {
+		return printJSON(resultEvent{"snapshot", snapshot.ID, snapshot.Stats})
+	}
+	messagef("Snapshot %s created: %s\n", snapshot.ID, snapshot.Stats.String())
 	return nil
 }
 
+// journalPath returns where the journal of a backup of targets gets kept, or
+// an empty string if there's no cache directory
+func journalPath(repo, volumeID string, targets []string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	key := sha256.Sum256([]byte(repo + "\x00" + volumeID + "\x00" + strings.Join(targets, "\x00")))
+	return filepath.Join(dir, "knoxite", "journal", hex.EncodeToString(key[:]))
+}
+
+// chunkSize parses the given chunk sizes, empty strings select the defaults
+func chunkSize(min, avg, max string) (knoxite.ChunkSize, error) {
+	size := knoxite.ChunkSize{}
+	for _, v := range []struct {
+		s string
+		n *int
+	}{{min, &size.Min}, {avg, &size.Average}, {max, &size.Max}} {
+		if v.s == "" {
+			continue
+		}
+		n, err := knoxite.ParseSize(v.s)
+		if err != nil {
+			return size, err
+		}
+		*v.n = int(n)
+	}
+
+	return size, nil
+}
+
+// encryptionAlgo returns the encryption algo named s
+func encryptionAlgo(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "aes", "aes-gcm":
+		return knoxite.EncryptionAESGCM, nil
+	case "chacha20":
+		return knoxite.EncryptionChaCha20, nil
+	case "none":
+		return knoxite.EncryptionNone, nil
+	}
+
+	return 0, ErrEncryptionUnknown
+}
+
+func compressionAlgo(s string) (int, int, error) {
+	level := 0
+	if i := strings.Index(s, ":"); i >= 0 {
+		var err error
+		level, err = strconv.Atoi(s[i+1:])
+		if err != nil || level < 1 {
+			return 0, 0, ErrCompressionLevel
+		}
+		s = s[:i]
+	}
+
+	switch strings.ToLower(s) {
+	case "", "none":
+		if level == 0 {
+			return knoxite.CompressionNone, 0, nil
+		}
+	case "gzip":
+		if level <= 9 {
+			return knoxite.CompressionGZip, level, nil
+		}
+	case "zstd":
+		if level <= 22 {
+			return knoxite.CompressionZstd, level, nil
+		}
+	case "lz4":
+		if level == 0 {
+			return knoxite.CompressionLZ4, 0, nil
+		}
+	case "xz":
+		if level <= 9 {
+			return knoxite.CompressionXZ, level, nil
+		}
+	default:
+		return 0, 0, ErrCompressionUnknown
+	}
+
+	return 0, 0, ErrCompressionLevel
+}
+
+// compressionRules parses the compression rules given as flags and in the
+// rules file, which contains a rule per line. Empty lines and lines starting
+// with # get ignored
+func (cmd CmdStore) compressionRules() ([]knoxite.CompressionRule, error) {
+	lines := cmd.CompressionRules
+	if cmd.CompressionFile != "" {
+		l, err := readLines(cmd.CompressionFile)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, l...)
+	}
+
+	rules := []knoxite.CompressionRule{}
+	for _, line := range lines {
+		i := strings.LastIndex(line, "=")
+		if i <= 0 {
+			return nil, ErrCompressionRule
+		}
+		compression, level, err := compressionAlgo(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, knoxite.CompressionRule{
+			Pattern:     strings.TrimSpace(line[:i]),
+			Compression: compression,
+			Level:       level,
+		})
+	}
+
+	return rules, nil
+}
+
+// filter returns the filter selecting the files to store, made of the
+// patterns given as flags and in the exclude file
+func (cmd CmdStore) filter() (knoxite.PathFilter, error) {
+	filter := knoxite.PathFilter{
+		Include: cmd.Include,
+		Exclude: cmd.Exclude,
+	}
+	if cmd.ExcludeFile != "" {
+		lines, err := readLines(cmd.ExcludeFile)
+		if err != nil {
+			return filter, err
+		}
+		filter.Exclude = append(filter.Exclude, lines...)
+	}
+
+	return filter, filter.Check()
+}
+
+// readLines returns the lines of a file, skipping empty lines and lines
+// starting with #
+func readLines(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
 // Usage describes this command's usage help-text
 func (cmd CmdStore) Usage() string {
 	return "VOLUME-ID DIR/FILE [DIR/FILE] [...]"
@@ -104,13 +429,21 @@ func (cmd CmdStore) Execute(args []string) error {
 
 	targets := []string{}
 	for _, target := range args[1:] {
+		if target == "-" {
+			targets = append(targets, target)
+			continue
+		}
 		if absTarget, err := filepath.Abs(target); err == nil {
 			target = absTarget
 		}
 		targets = append(targets, target)
 	}
 
-	// filter here? exclude/include?
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 
 	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err != nil {
@@ -120,11 +453,40 @@ func (cmd CmdStore) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
-	snapshot, err := knoxite.NewSnapshot(cmd.Description)
+	snapshot, err := volume.NewSnapshot(cmd.Description)
 	if err != nil {
 		return err
 	}
-	err = cmd.store(&repository, &snapshot, targets)
+	snapshot.Tags = cmd.Tags
+
+	// Files unchanged since the last snapshot reuse its chunks
+	var parent *knoxite.Snapshot
+	if !cmd.Full {
+		latest, lerr := volume.LatestSnapshot(&repository)
+		if lerr == nil {
+			parent = &latest
+		} else if lerr != knoxite.ErrSnapshotNotFound {
+			return lerr
+		}
+	}
+
+	// Interrupted backups of the same targets get resumed
+	var journal *knoxite.Journal
+	if path := journalPath(cmd.global.Repo, volume.ID, targets); path != "" && targets[0] != "-" {
+		journal, err = repository.OpenJournal(path)
+		if err != nil {
+			return err
+		}
+		defer journal.Close()
+		if journal.Snapshot != nil {
+			messagef("Resuming interrupted snapshot %s, %d files stored already\n", journal.Snapshot.ID, len(journal.Items))
+		}
+		if err = journal.Begin(&snapshot); err != nil {
+			return err
+		}
+	}
+
+	err = cmd.store(&repository, &snapshot, parent, journal, targets)
 	if err != nil {
 		return err
 	}
@@ -136,5 +498,21 @@ func (cmd CmdStore) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
-	return repository.Save()
+	err = repository.Save()
+	if err != nil {
+		return err
+	}
+	if journal != nil {
+		if err = journal.Remove(); err != nil {
+			return err
+		}
+	}
+
+	for _, location := range repository.Backend.Stale() {
+		warnf("%s missed some data, run 'repo repair' to fix it\n", location)
+	}
+	if snapshot.Stats.Errors > 0 {
+		return ErrPartial
+	}
+	return nil
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/knoxite/knoxite"
+)
+
+// CmdUnlock describes the command
+type CmdUnlock struct {
+	All bool `long:"all" description:"remove all locks, not just stale ones"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("unlock",
+		"remove repository locks",
+		"The unlock command removes the locks left behind by processes that crashed or got killed. Stale locks get ignored anyway, with --all locks that are still valid get removed as well",
+		&CmdUnlock{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdUnlock) Usage() string {
+	return "[--all]"
+}
+
+// Execute this command
+func (cmd CmdUnlock) Execute(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	removed, err := knoxite.RemoveLocks(cmd.global.Repo, cmd.All)
+	if err != nil {
+		return err
+	}
+	messagef("Removed %d locks\n", removed)
+	return nil
+}
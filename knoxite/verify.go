@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/knoxite/knoxite"
+	"github.com/muesli/gotable"
+)
+
+// Error declarations
+var (
+	ErrVerifyFailed = errors.New("Verification failed")
+)
+
+// CmdVerify describes the command
+type CmdVerify struct {
+	Backends bool `long:"backends" description:"compare the data stored on each backend instead of decoding all chunks"`
+
+	global *GlobalOptions
+}
+
+func init() {
+	_, err := parser.AddCommand("verify",
+		"verify repository data",
+		"The verify command downloads and decodes all chunks of a snapshot, or of the whole repository, and reports damaged or missing data. With --backends it checks that all backends hold the data they should",
+		&CmdVerify{global: &globalOpts})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdVerify) Usage() string {
+	return "[SNAPSHOT-ID] [--backends]"
+}
+
+// Execute this command
+func (cmd CmdVerify) Execute(args []string) error {
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Backends {
+		return cmd.verifyBackends(&repository)
+	}
+
+	snapshots := []knoxite.Snapshot{}
+	if len(args) > 0 {
+		_, snapshot, ferr := repository.FindSnapshot(args[0])
+		if ferr != nil {
+			return ferr
+		}
+		snapshots = append(snapshots, *snapshot)
+	} else {
+		for _, volume := range repository.Volumes {
+			for _, id := range volume.Snapshots {
+				snapshot, lerr := volume.LoadSnapshot(id, &repository)
+				if lerr != nil {
+					return lerr
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+	}
+	return cmd.verifyChunks(repository, snapshots)
+}
+
+func (cmd CmdVerify) verifyChunks(repository knoxite.Repository, snapshots []knoxite.Snapshot) error {
+	tab := gotable.NewTable([]string{"Chunk", "State", "Parts", "Files"},
+		[]int64{-16, -8, 5, -64},
+		"All chunks are intact.")
+
+	damaged, degraded := 0, 0
+	total, states := knoxite.VerifySnapshots(repository, snapshots)
+	i := 0
+	for state := range states {
+		i++
+		if showProgress() {
+			fmt.Printf("\rVerified %d of %d chunks", i, total)
+		}
+		if !state.Damaged() && !state.Degraded() {
+			continue
+		}
+
+		status := "degraded"
+		if state.Damaged() {
+			status = "damaged"
+			damaged++
+		} else {
+			degraded++
+		}
+		if cmd.global.JSON {
+			if err := printJSON(newChunkStateJSON(state, status)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		files := fmt.Sprintf("%s (snapshot %s)", state.Files[0].Path, state.Files[0].Snapshot)
+		if len(state.Files) > 1 {
+			files += fmt.Sprintf(" and %d more", len(state.Files)-1)
+		}
+		tab.AppendRow([]interface{}{
+			state.Chunk.ShaSum[:16],
+			status,
+			fmt.Sprintf("%d/%d", state.Available, state.Parts),
+			files})
+		if state.Damaged() {
+			fmt.Printf("\rChunk %s: %v\n", state.Chunk.ShaSum, state.Err)
+		}
+	}
+	if cmd.global.JSON {
+		if err := printJSON(verifySummaryJSON{"summary", total, damaged, degraded}); err != nil {
+			return err
+		}
+		if damaged > 0 {
+			return ErrVerifyFailed
+		}
+		return nil
+	}
+	if cmd.global.Quiet {
+		if damaged+degraded > 0 {
+			tab.Print()
+		}
+	} else {
+		fmt.Println()
+		fmt.Println()
+		tab.Print()
+		fmt.Printf("%d chunks verified: %d damaged, %d degraded\n", total, damaged, degraded)
+	}
+	if damaged > 0 {
+		return ErrVerifyFailed
+	}
+	return nil
+}
+
+func (cmd CmdVerify) verifyBackends(repository *knoxite.Repository) error {
+	problems, err := knoxite.VerifyBackends(repository)
+	if err != nil {
+		return err
+	}
+
+	if cmd.global.JSON {
+		list := []inconsistencyJSON{}
+		for _, p := range problems {
+			list = append(list, inconsistencyJSON{p.Location, p.Name, p.Problem})
+		}
+		if err = printJSON(list); err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return ErrVerifyFailed
+		}
+		return nil
+	}
+
+	tab := gotable.NewTable([]string{"Storage URL", "Name", "Problem"},
+		[]int64{-48, -70, -34},
+		"All backends are consistent.")
+
+	for _, p := range problems {
+		location := p.Location
+		if location == "" {
+			location = "all backends"
+		}
+		tab.AppendRow([]interface{}{
+			location,
+			p.Name,
+			p.Problem})
+	}
+
+	if !cmd.global.Quiet || len(problems) > 0 {
+		tab.Print()
+	}
+	if len(problems) > 0 {
+		return ErrVerifyFailed
+	}
+	return nil
+}
@@ -9,7 +9,8 @@ import (
 
 // CmdVolume describes the command
 type CmdVolume struct {
-	Description string `short:"d" long:"desc" description:"a description or comment for this volume"`
+	Description string `short:"d" long:"desc"    description:"a description or comment for this volume"`
+	OwnKey      bool   `long:"own-key"           description:"encrypt the volume's data with a key of its own"`
 
 	global *GlobalOptions
 }
@@ -17,7 +18,7 @@ type CmdVolume struct {
 func init() {
 	_, err := parser.AddCommand("volume",
 		"manage volumes",
-		"The volume command manages volumes",
+		"The volume command creates, lists, renames and removes volumes. Removing a volume removes all of its snapshots, their data stays in the repository",
 		&CmdVolume{global: &globalOpts})
 	if err != nil {
 		panic(err)
@@ -26,7 +27,7 @@ func init() {
 
 // Usage describes this command's usage help-text
 func (cmd CmdVolume) Usage() string {
-	return "[list|init]"
+	return "[list | init NAME | rename VOLUME-ID NAME | remove VOLUME-ID]"
 }
 
 // Execute this command
@@ -44,6 +45,16 @@ func (cmd CmdVolume) Execute(args []string) error {
 			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
 		}
 		return cmd.init(args[1])
+	case "rename":
+		if len(args) < 3 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.rename(args[1], args[2])
+	case "remove":
+		if len(args) < 2 {
+			return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+		}
+		return cmd.remove(args[1])
 	case "list":
 		return cmd.list()
 	default:
@@ -52,11 +63,22 @@ func (cmd CmdVolume) Execute(args []string) error {
 }
 
 func (cmd CmdVolume) init(name string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err == nil {
 		vol, verr := knoxite.NewVolume(name, cmd.Description)
 		if verr == nil {
-			verr = repository.AddVolume(vol)
+			if cmd.OwnKey {
+				verr = repository.GenerateVolumeKey(vol)
+			}
+			if verr == nil {
+				verr = repository.AddVolume(vol)
+			}
 			if verr != nil {
 				return fmt.Errorf("Creating volume %s failed: %v", name, verr)
 			}
@@ -68,12 +90,70 @@ func (cmd CmdVolume) init(name string) error {
 	return err
 }
 
+func (cmd CmdVolume) rename(volID, name string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	volume, err := repository.FindVolume(volID)
+	if err != nil {
+		return err
+	}
+
+	volume.Name = name
+	if cmd.Description != "" {
+		volume.Description = cmd.Description
+	}
+	if err = repository.Save(); err != nil {
+		return err
+	}
+	messagef("Volume %s renamed to %s\n", volume.ID, name)
+	return nil
+}
+
+func (cmd CmdVolume) remove(volID string) error {
+	lock, err := lockRepository(cmd.global.Repo)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
+	if err != nil {
+		return err
+	}
+	volume, err := repository.FindVolume(volID)
+	if err != nil {
+		return err
+	}
+
+	if err = repository.RemoveVolume(volume); err != nil {
+		return err
+	}
+	messagef("Volume %s and its %d snapshot(s) removed\n", volume.ID, len(volume.Snapshots))
+	return nil
+}
+
 func (cmd CmdVolume) list() error {
 	repository, err := openRepository(cmd.global.Repo, cmd.global.Password)
 	if err != nil {
 		return err
 	}
 
+	if cmd.global.JSON {
+		volumes := []volumeJSON{}
+		for _, volume := range repository.Volumes {
+			volumes = append(volumes, volumeJSON{volume.ID, volume.Name, volume.Description, append([]string{}, volume.Snapshots...)})
+		}
+		return printJSON(volumes)
+	}
+
 	tab := gotable.NewTable([]string{"ID", "Name", "Description"},
 		[]int64{-8, -32, -48}, "No volumes found. This repository is empty.")
 	for _, volume := range repository.Volumes {
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CmdWatch describes the command
+type CmdWatch struct {
+	CmdStore
+
+	QuietPeriod time.Duration `long:"quiet-period" default:"30s" description:"store once nothing changed for this long"`
+	Interval    time.Duration `long:"interval"     default:"10m" description:"store at least this often while changes keep coming in"`
+}
+
+func init() {
+	_, err := parser.AddCommand("watch",
+		"store changes continuously",
+		"The watch command monitors files and directories and stores a snapshot of them once they changed. It waits until nothing changed for a while, or stores the changes at a regular interval while they keep coming in. It accepts all flags of the store command",
+		&CmdWatch{CmdStore: CmdStore{global: &globalOpts}})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Usage describes this command's usage help-text
+func (cmd CmdWatch) Usage() string {
+	return "VOLUME-ID DIR [DIR] [...] [--quiet-period 30s] [--interval 10m]"
+}
+
+// Execute this command
+func (cmd CmdWatch) Execute(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf(TWrongNumArgs, cmd.Usage())
+	}
+	if cmd.global.Repo == "" {
+		return ErrMissingRepoLocation
+	}
+	filter, err := cmd.filter()
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Writes of knoxite itself mustn't trigger another snapshot
+	ignored := []string{}
+	if dir, derr := os.UserCacheDir(); derr == nil {
+		ignored = append(ignored, filepath.Join(dir, "knoxite"))
+	}
+	if !strings.Contains(cmd.global.Repo, "://") {
+		if repo, aerr := filepath.Abs(cmd.global.Repo); aerr == nil {
+			ignored = append(ignored, repo)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	skip := func(path string) bool {
+		for _, dir := range ignored {
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		if rel, rerr := filepath.Rel(wd, path); rerr == nil && !strings.HasPrefix(rel, "../") {
+			path = rel
+		}
+		return filter.Excluded(path)
+	}
+
+	// fsnotify doesn't watch subdirectories, so each of them gets added
+	watch := func(root string) error {
+		return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if skip(path) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		})
+	}
+	for _, target := range args[1:] {
+		target, err = filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+		if err = watch(target); err != nil {
+			return err
+		}
+	}
+	messagef("Watching %s for changes\n", strings.Join(args[1:], ", "))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	// first and last are the times of the first and the latest change since
+	// the last snapshot
+	var first, last time.Time
+	for {
+		var store <-chan time.Time
+		if !first.IsZero() {
+			deadline := last.Add(cmd.QuietPeriod)
+			if limit := first.Add(cmd.Interval); limit.Before(deadline) {
+				deadline = limit
+			}
+			store = time.After(time.Until(deadline))
+		}
+
+		select {
+		case s := <-sig:
+			messagef("Got signal %s, stopping\n", s)
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if skip(event.Name) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if fi, serr := os.Lstat(event.Name); serr == nil && fi.IsDir() {
+					if werr := watch(event.Name); werr != nil {
+						warnf("can't watch %s: %v\n", event.Name, werr)
+					}
+				}
+			}
+			last = time.Now()
+			if first.IsZero() {
+				first = last
+			}
+
+		case werr, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			warnf("%v\n", werr)
+
+		case <-store:
+			first = time.Time{}
+			messagef("%s storing changes\n", time.Now().Format(timeFormat))
+			if serr := cmd.CmdStore.Execute(args); serr != nil {
+				// Keep watching, the next changes may get stored just fine
+				warnf("%v\n", serr)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// lockFilePrefix prefixes the names of lock files. Like index files they get
+// stored next to the snapshots
+const lockFilePrefix = "lock-"
+
+// LockTimeout is how long a lock stays valid without getting refreshed. Locks
+// of crashed processes become stale after it passed and get ignored
+var LockTimeout = 30 * time.Minute
+
+// lockRefreshInterval is how often held locks get refreshed
+var lockRefreshInterval = 5 * time.Minute
+
+// Error declarations
+var (
+	ErrRepositoryLocked = errors.New("Repository is locked by another process")
+)
+
+// A Lock is held while a process changes the metadata of a repository, so
+// processes on other machines can't overwrite its changes. Locks are merely
+// cooperative, they only keep out processes asking for one as well
+type Lock struct {
+	ID        string    `json:"-"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	Created   time.Time `json:"created"`
+	Refreshed time.Time `json:"refreshed"`
+
+	backend Backend
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Stale returns whether the lock hasn't been refreshed within LockTimeout
+func (l Lock) Stale() bool {
+	return time.Since(l.Refreshed) > LockTimeout
+}
+
+// LockRepository locks the repository at url until Unlock gets called. Lock
+// files are plain JSON, so a repository can be locked before its metadata
+// gets loaded. Backends that can't list their content don't support locks,
+// the returned lock doesn't keep anyone out then
+func LockRepository(url string) (*Lock, error) {
+	backend, err := BackendFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	locks, err := listLocks(backend)
+	if err == ErrListingUnsupported {
+		backend.Close()
+		return &Lock{}, nil
+	}
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	if held(locks, "") {
+		backend.Close()
+		return nil, ErrRepositoryLocked
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	lock := &Lock{
+		ID:        lockFilePrefix + strings.Replace(u.String(), "-", "", -1),
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		Created:   now,
+		Refreshed: now,
+		backend:   backend,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err = lock.save(); err != nil {
+		backend.Close()
+		return nil, err
+	}
+
+	// Another process may have created its lock meanwhile, in which case
+	// both of them step back
+	locks, err = listLocks(backend)
+	if err == nil && held(locks, lock.ID) {
+		err = ErrRepositoryLocked
+	}
+	if err != nil {
+		backend.DeleteSnapshot(lock.ID)
+		backend.Close()
+		return nil, err
+	}
+
+	go lock.refresh()
+	return lock, nil
+}
+
+// Unlock releases the lock
+func (l *Lock) Unlock() error {
+	if l.backend == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+
+	err := l.backend.DeleteSnapshot(l.ID)
+	l.backend.Close()
+	l.backend = nil
+	return err
+}
+
+func (l *Lock) save() error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return l.backend.SaveSnapshot(l.ID, b)
+}
+
+// refresh keeps the lock from getting stale until it gets released
+func (l *Lock) refresh() {
+	defer close(l.done)
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Refreshed = time.Now()
+			// A failed refresh gets retried with the next tick
+			l.save()
+		}
+	}
+}
+
+// Locks returns all locks of the repository at url, including stale ones
+func Locks(url string) ([]Lock, error) {
+	backend, err := BackendFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+
+	return listLocks(backend)
+}
+
+// RemoveLocks removes the stale locks of the repository at url, or all of
+// them, e.g. after a process got killed. Returns the amount of locks removed
+func RemoveLocks(url string, all bool) (int, error) {
+	backend, err := BackendFromURL(url)
+	if err != nil {
+		return 0, err
+	}
+	defer backend.Close()
+
+	locks, err := listLocks(backend)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, lock := range locks {
+		if !all && !lock.Stale() {
+			continue
+		}
+		if err = backend.DeleteSnapshot(lock.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func listLocks(backend Backend) ([]Lock, error) {
+	names, err := backend.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	locks := []Lock{}
+	for _, name := range names {
+		if objectKind(name) != objectLock {
+			continue
+		}
+		b, err := backend.LoadSnapshot(name)
+		if err != nil {
+			// Removed meanwhile
+			continue
+		}
+		var lock Lock
+		if err = json.Unmarshal(b, &lock); err != nil {
+			return nil, err
+		}
+		lock.ID = name
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// held returns whether any of the locks, other than the one called own, is
+// still valid
+func held(locks []Lock, own string) bool {
+	for _, lock := range locks {
+		if lock.ID != own && !lock.Stale() {
+			return true
+		}
+	}
+	return false
+}
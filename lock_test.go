@@ -0,0 +1,77 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockRepository(t *testing.T) {
+	url := "memory://locked"
+	lock, err := LockRepository(url)
+	if err != nil {
+		t.Errorf("Failed locking repository: %s", err)
+		return
+	}
+	if _, err = LockRepository(url); err != ErrRepositoryLocked {
+		t.Errorf("Expected %v while locked, got %v", ErrRepositoryLocked, err)
+	}
+
+	if err = lock.Unlock(); err != nil {
+		t.Errorf("Failed unlocking repository: %s", err)
+	}
+	lock, err = LockRepository(url)
+	if err != nil {
+		t.Errorf("Failed locking unlocked repository: %s", err)
+		return
+	}
+	defer lock.Unlock()
+
+	locks, err := Locks(url)
+	if err != nil || len(locks) != 1 {
+		t.Errorf("Expected 1 lock, got %d: %v", len(locks), err)
+		return
+	}
+	if locks[0].ID != lock.ID || locks[0].PID != lock.PID {
+		t.Errorf("Expected lock %s, got %s", lock.ID, locks[0].ID)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	url := "memory://stalelock"
+	lock, err := LockRepository(url)
+	if err != nil {
+		t.Errorf("Failed locking repository: %s", err)
+		return
+	}
+	defer lock.Unlock()
+
+	removed, err := RemoveLocks(url, false)
+	if err != nil || removed != 0 {
+		t.Errorf("Expected no stale locks to be removed, got %d: %v", removed, err)
+	}
+
+	timeout := LockTimeout
+	LockTimeout = 0
+	defer func() { LockTimeout = timeout }()
+	time.Sleep(time.Millisecond)
+
+	// The crashed process' lock doesn't keep anyone out anymore
+	other, err := LockRepository(url)
+	if err != nil {
+		t.Errorf("Failed locking repository with a stale lock: %s", err)
+		return
+	}
+	other.Unlock()
+
+	removed, err = RemoveLocks(url, false)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected 1 stale lock to be removed, got %d: %v", removed, err)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Error declarations
+var (
+	ErrEmptyPasswordCommand = errors.New("Password command printed no password")
+)
+
+// PasswordFromCommand runs command with the system's shell and returns the
+// first line it prints as password, e.g. for "pass show backup/repo"
+func PasswordFromCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if !scanner.Scan() || len(bytes.TrimRight(scanner.Bytes(), "\r")) == 0 {
+		if err = scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", ErrEmptyPasswordCommand
+	}
+	return string(bytes.TrimRight(scanner.Bytes(), "\r")), nil
+}
@@ -0,0 +1,31 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestPasswordFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test relies on a POSIX shell")
+	}
+
+	password, err := PasswordFromCommand("printf 'my password\\nsecond line\\n'")
+	if err != nil || password != "my password" {
+		t.Errorf("Expected password %q, got %q (%v)", "my password", password, err)
+	}
+
+	if _, err = PasswordFromCommand("true"); err != ErrEmptyPasswordCommand {
+		t.Errorf("Expected %v, got %v", ErrEmptyPasswordCommand, err)
+	}
+	if _, err = PasswordFromCommand("exit 1"); err == nil {
+		t.Errorf("Expected failing command to return an error")
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import "sync"
+
+// placement distributes chunk parts over backends proportionally to their
+// weight, which is the user-assigned weight times the available space
+type placement struct {
+	backends []*Backend
+	weights  []uint64
+	total    uint64
+	once     sync.Once
+}
+
+func newPlacement(backends []*Backend) *placement {
+	return &placement{backends: backends}
+}
+
+// init queries the available space of all backends. Backends that can't
+// tell get the average space of the others, so only their weight counts
+func (p *placement) init() {
+	spaces := make([]uint64, len(p.backends))
+	var known, sum uint64
+	for i, be := range p.backends {
+		if space, err := (*be).AvailableSpace(); err == nil {
+			// Count in GiB so the weights can't overflow
+			spaces[i] = space>>30 + 1
+			sum += spaces[i]
+			known++
+		}
+	}
+
+	avg := uint64(1)
+	if known > 0 {
+		avg = sum / known
+	}
+
+	p.weights = make([]uint64, len(p.backends))
+	for i, be := range p.backends {
+		space := spaces[i]
+		if space == 0 {
+			space = avg
+		}
+		p.weights[i] = backendWeight(*be) * space
+		p.total += p.weights[i]
+	}
+}
+
+// pick returns the index of the backend to store the n-th chunk part on. n
+// gets scrambled, so consecutive parts don't all end up on the same backend
+func (p *placement) pick(n uint64) int {
+	p.once.Do(p.init)
+
+	if p.total == 0 {
+		return int(n % uint64(len(p.backends)))
+	}
+
+	n = (n * 0x9e3779b97f4a7c15) % p.total
+	for i, weight := range p.weights {
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+	return 0
+}
@@ -1,11 +1,31 @@
 package knoxite
 
+import "time"
+
 // Progress contains stats and current path
 type Progress struct {
 	Path        string
 	Size        uint64
 	StorageSize uint64
 	Statistics  Stats
+	// Err is set if the item at Path couldn't be read, stored or restored and
+	// got skipped. If the journal at Path couldn't be written to, the backup
+	// continues without it
+	Err error
+
+	// Done and Total are the bytes of all items handled so far and of all
+	// items to handle, Items and TotalItems count them. While storing, the
+	// totals keep growing until all files got found
+	Done       uint64
+	Total      uint64
+	Items      uint64
+	TotalItems uint64
+	// Elapsed is the time since the operation started
+	Elapsed time.Duration
+	// Speed is the smoothed throughput in bytes per second
+	Speed float64
+	// ETA is the estimated time left, zero while unknown
+	ETA time.Duration
 }
 
 func newProgress(item *ItemData) Progress {
@@ -16,3 +36,49 @@ func newProgress(item *ItemData) Progress {
 		Statistics:  Stats{},
 	}
 }
+
+// speedInterval is how often the throughput gets sampled, speedWeight how
+// much each sample counts towards the smoothed throughput
+const (
+	speedInterval = 500 * time.Millisecond
+	speedWeight   = 0.3
+)
+
+// progressMeter measures the throughput of an operation and estimates its end
+type progressMeter struct {
+	start time.Time
+	// last and done are the time and bytes of the latest sample
+	last  time.Time
+	done  uint64
+	speed float64
+}
+
+func newProgressMeter(start time.Time) *progressMeter {
+	return &progressMeter{start: start, last: start}
+}
+
+// update fills in the elapsed time, throughput and ETA of p at now, from its
+// Done and Total bytes
+func (m *progressMeter) update(p *Progress, now time.Time) {
+	p.Elapsed = now.Sub(m.start)
+	if d := now.Sub(m.last); d >= speedInterval {
+		rate := float64(p.Done-m.done) / d.Seconds()
+		if m.speed == 0 {
+			m.speed = rate
+		} else {
+			m.speed = speedWeight*rate + (1-speedWeight)*m.speed
+		}
+		m.last = now
+		m.done = p.Done
+	}
+
+	p.Speed = m.speed
+	if p.Speed == 0 && p.Elapsed > 0 {
+		// Nothing sampled yet, go with the average
+		p.Speed = float64(p.Done) / p.Elapsed.Seconds()
+	}
+	p.ETA = 0
+	if p.Speed > 0 && p.Total > p.Done {
+		p.ETA = time.Duration(float64(p.Total-p.Done) / p.Speed * float64(time.Second))
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProgressMeter(t *testing.T) {
+	start := time.Now()
+	meter := newProgressMeter(start)
+
+	p := Progress{Done: 100, Total: 1000}
+	meter.update(&p, start.Add(time.Second))
+	if p.Elapsed != time.Second {
+		t.Errorf("Expected elapsed time of %s, got %s", time.Second, p.Elapsed)
+	}
+	if p.Speed != 100 {
+		t.Errorf("Expected speed of 100 bytes/s, got %f", p.Speed)
+	}
+	if p.ETA != 9*time.Second {
+		t.Errorf("Expected ETA of %s, got %s", 9*time.Second, p.ETA)
+	}
+
+	// The throughput gets smoothed
+	p = Progress{Done: 400, Total: 1000}
+	meter.update(&p, start.Add(2*time.Second))
+	if p.Speed <= 100 || p.Speed >= 300 {
+		t.Errorf("Expected speed between 100 and 300 bytes/s, got %f", p.Speed)
+	}
+
+	p = Progress{Done: 1000, Total: 1000}
+	meter.update(&p, start.Add(3*time.Second))
+	if p.ETA != 0 {
+		t.Errorf("Expected no ETA once done, got %s", p.ETA)
+	}
+}
+
+func TestAddProgress(t *testing.T) {
+	r, err := NewRepository("memory://addprogress", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"progress.go", "progress_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	var last Progress
+	for p := range progress {
+		if p.Done > p.Total {
+			t.Errorf("More bytes done than in total: %d > %d", p.Done, p.Total)
+		}
+		last = p
+	}
+	if last.TotalItems != 2 || last.Items != 1 {
+		t.Errorf("Expected progress of item 2 out of 2, got %d out of %d", last.Items+1, last.TotalItems)
+	}
+	if last.Total != snapshot.Stats.Size {
+		t.Errorf("Expected total of %d bytes, got %d", snapshot.Stats.Size, last.Total)
+	}
+}
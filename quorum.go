@@ -0,0 +1,151 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// QuorumError is returned when metadata couldn't be written to as many
+// backends as the write quorum demands
+type QuorumError struct {
+	Written int
+	Quorum  int
+	// Errs maps the locations of the failed backends to their errors
+	Errs map[string]error
+}
+
+func (e *QuorumError) Error() string {
+	failed := []string{}
+	for location, err := range e.Errs {
+		failed = append(failed, fmt.Sprintf("%s: %v", location, err))
+	}
+	sort.Strings(failed)
+
+	return fmt.Sprintf("Metadata written to %d backends, but %d required (%s)", e.Written, e.Quorum, strings.Join(failed, ", "))
+}
+
+// DeleteError is returned when a snapshot couldn't be deleted from all
+// writable backends holding it
+type DeleteError struct {
+	Deleted int
+	// Errs maps the locations of the failed backends to their errors
+	Errs map[string]error
+}
+
+func (e *DeleteError) Error() string {
+	failed := []string{}
+	for location, err := range e.Errs {
+		failed = append(failed, fmt.Sprintf("%s: %v", location, err))
+	}
+	sort.Strings(failed)
+
+	return fmt.Sprintf("Snapshot deleted from %d backends, but failed on %d (%s)", e.Deleted, len(e.Errs), strings.Join(failed, ", "))
+}
+
+// writeMetadata runs write for all writable backends. It succeeds if at
+// least WriteQuorum backends got written to, the others get flagged as stale
+func (backend *BackendManager) writeMetadata(write func(be *Backend) error) error {
+	backends := backend.writableBackends()
+	if len(backends) == 0 {
+		return ErrNoWritableBackend
+	}
+	quorum := int(backend.WriteQuorum)
+	if quorum == 0 || quorum > len(backends) {
+		quorum = len(backends)
+	}
+
+	errs := make(map[string]error)
+	for _, be := range backends {
+		if err := write(be); err != nil {
+			errs[(*be).Location()] = err
+			backend.setStale(be, true)
+		}
+	}
+
+	written := len(backends) - len(errs)
+	if written < quorum && len(backends) == 1 {
+		// Without other backends, there's nothing to report but the error
+		return errs[(*backends[0]).Location()]
+	}
+	if written < quorum {
+		return &QuorumError{written, quorum, errs}
+	}
+	return nil
+}
+
+// setStale flags be as missing some metadata
+func (backend *BackendManager) setStale(be *Backend, stale bool) {
+	if h, ok := backend.health[be]; ok {
+		var v uint32
+		if stale {
+			v = 1
+		}
+		atomic.StoreUint32(&h.stale, v)
+	}
+}
+
+// Stale returns the locations of all backends which missed a metadata write
+// and need to be repaired
+func (backend *BackendManager) Stale() []string {
+	locations := []string{}
+	for _, be := range backend.Backends {
+		if h, ok := backend.health[be]; ok && atomic.LoadUint32(&h.stale) == 1 {
+			locations = append(locations, (*be).Location())
+		}
+	}
+
+	return locations
+}
+
+// RepairSnapshots stores the snapshots with the given IDs on all writable
+// backends missing them. Index and location files among ids get copied as
+// well, but only snapshots are counted in the returned amount
+func (backend *BackendManager) RepairSnapshots(ids []string) (int, error) {
+	repaired := 0
+	for _, be := range backend.writableBackends() {
+		stored := make(map[string]bool)
+		list, err := (*be).ListSnapshots()
+		listed := err == nil
+		for _, id := range list {
+			stored[id] = true
+		}
+
+		for _, id := range ids {
+			if listed && stored[id] {
+				continue
+			}
+			if !listed {
+				if _, err := (*be).LoadSnapshot(id); err == nil {
+					continue
+				}
+			}
+
+			b, err := backend.LoadSnapshot(id)
+			if err != nil {
+				return repaired, err
+			}
+			start := time.Now()
+			err = (*be).SaveSnapshot(id, b)
+			backend.record(be, start, len(b), 0, err)
+			if err != nil {
+				return repaired, err
+			}
+			if isSnapshotID(id) {
+				repaired++
+			}
+		}
+		backend.setStale(be, false)
+	}
+
+	return repaired, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import "testing"
+
+// brokenStorage is a backend that can't store any metadata
+type brokenStorage struct {
+	*StorageMemory
+}
+
+func (backend brokenStorage) SaveSnapshot(id string, data []byte) error {
+	return ErrChunkNotFound
+}
+
+// undeletableStorage is a backend that can't delete any metadata
+type undeletableStorage struct {
+	*StorageMemory
+}
+
+func (backend undeletableStorage) DeleteSnapshot(id string) error {
+	return ErrDeleteSnapshotFailed
+}
+
+func TestWriteQuorum(t *testing.T) {
+	healthy := MemoryStorage("quorum-healthy")
+	broken := brokenStorage{MemoryStorage("quorum-broken")}
+	backends := []Backend{healthy, broken}
+
+	bm := BackendManager{}
+	for i := range backends {
+		bm.AddBackend(&backends[i])
+	}
+
+	err := bm.SaveSnapshot("snap", []byte("data"))
+	if qerr, ok := err.(*QuorumError); !ok || qerr.Written != 1 || qerr.Quorum != 2 {
+		t.Errorf("Expected quorum error, got %v", err)
+	}
+
+	bm.WriteQuorum = 1
+	if err = bm.SaveSnapshot("snap", []byte("data")); err != nil {
+		t.Errorf("Failed saving snapshot with quorum: %s", err)
+	}
+	if stale := bm.Stale(); len(stale) != 1 || stale[0] != "memory://quorum-broken" {
+		t.Errorf("Expected broken backend to be stale, got %v", stale)
+	}
+
+	// Once the backend recovered, a repair brings it up to date
+	backends[1] = broken.StorageMemory
+	repaired, err := bm.RepairSnapshots([]string{"snap"})
+	if err != nil || repaired != 1 {
+		t.Errorf("Failed repairing snapshots: %v %d", err, repaired)
+	}
+	if _, err = broken.LoadSnapshot("snap"); err != nil {
+		t.Errorf("Snapshot missing on repaired backend: %s", err)
+	}
+	if stale := bm.Stale(); len(stale) != 0 {
+		t.Errorf("Expected no stale backends, got %v", stale)
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	first := MemoryStorage("delete-first")
+	stale := MemoryStorage("delete-stale")
+	undeletable := undeletableStorage{MemoryStorage("delete-undeletable")}
+	last := MemoryStorage("delete-last")
+	backends := []Backend{first, stale, undeletable, last}
+
+	bm := BackendManager{}
+	for i := range backends {
+		bm.AddBackend(&backends[i])
+	}
+
+	// The stale backend missed the snapshot, which doesn't count as failure
+	for _, be := range []Backend{first, undeletable, last} {
+		be.SaveSnapshot("snap", []byte("data"))
+	}
+	err := bm.DeleteSnapshot("snap")
+	if derr, ok := err.(*DeleteError); !ok || derr.Deleted != 2 || derr.Errs["memory://delete-undeletable"] != ErrDeleteSnapshotFailed {
+		t.Errorf("Expected delete error, got %v", err)
+	}
+	for _, be := range []Backend{first, last} {
+		if _, err = be.LoadSnapshot("snap"); err != ErrSnapshotNotFound {
+			t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+		}
+	}
+
+	// A missing snapshot fails with ErrDeleteSnapshotFailed on the
+	// undeletable backend, but it isn't listed there
+	undeletable.StorageMemory.DeleteSnapshot("snap")
+	if err = bm.DeleteSnapshot("snap"); err != ErrSnapshotNotFound {
+		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+}
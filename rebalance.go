@@ -0,0 +1,213 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// partHash returns a stable number for a chunk part, used to pick its
+// backend when rebalancing
+func partHash(shasum string, part uint) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(shasum))
+	return h.Sum64() + uint64(part)
+}
+
+// RebalanceChunk moves the parts of chunk to the backends the placement policy
+// picks for them, e.g. after adding a backend. Each part keeps its amount of
+// replicas and no backend ends up with more than one of them. Parts only
+// stored on read-only backends are left alone, so are the copies on the cold
+// tier. Returns the amount of bytes
+// moved
+func (backend *BackendManager) RebalanceChunk(chunk Chunk) (uint64, error) {
+	backends := backend.chunkBackends()
+	if len(backends) == 0 {
+		return 0, ErrNoWritableBackend
+	}
+
+	parts := chunk.DataParts + chunk.ParityParts
+	holding := make([]map[int]bool, parts)
+	copies := make([]int, parts)
+	for part := range holding {
+		holding[part] = make(map[int]bool)
+		for idx, be := range backends {
+			if _, err := (*be).StatChunk(chunk.ShaSum, uint(part), chunk.DataParts); err == nil {
+				holding[part][idx] = true
+			}
+		}
+		copies[part] = len(holding[part])
+	}
+
+	holders, err := backend.assign(backends, copies, func(part int) uint64 {
+		return partHash(chunk.ShaSum, uint(part))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var moved uint64
+	for part := range holders {
+		targets := make(map[int]bool)
+		for _, idx := range holders[part] {
+			targets[idx] = true
+		}
+
+		var data *[]byte
+		for idx := range targets {
+			if holding[part][idx] {
+				continue
+			}
+			if data == nil {
+				if data, err = backend.loadPart(backends, holding[part], chunk, uint(part)); err != nil {
+					return moved, err
+				}
+			}
+
+			if err = backend.storePart(backends[idx], &chunk, part, data); err != nil {
+				return moved, err
+			}
+			moved += uint64(len(*data))
+		}
+
+		// Only remove the surplus parts once all targets got them
+		for idx := range holding[part] {
+			if !targets[idx] {
+				start := time.Now()
+				err = (*backends[idx]).DeleteChunk(chunk.ShaSum, uint(part), chunk.DataParts)
+				backend.record(backends[idx], start, 0, 0, err)
+				if err != nil {
+					return moved, err
+				}
+				backend.index.remove(partName(chunk.ShaSum, uint(part), chunk.DataParts), (*backends[idx]).Location())
+			}
+		}
+	}
+
+	return moved, nil
+}
+
+// loadPart loads a part of chunk from any of the backends holding it
+func (backend *BackendManager) loadPart(backends []*Backend, holding map[int]bool, chunk Chunk, part uint) (*[]byte, error) {
+	for idx := range holding {
+		start := time.Now()
+		b, err := (*backends[idx]).LoadChunk(chunk.ShaSum, part, chunk.DataParts)
+		if err == nil {
+			backend.record(backends[idx], start, 0, len(*b), nil)
+			return b, nil
+		}
+		backend.record(backends[idx], start, 0, 0, err)
+	}
+
+	return nil, ErrLoadChunkFailed
+}
+
+// EvacuateChunk copies all parts of chunk held by be to the other writable
+// backends, so be can get removed without losing data. Parts that can't be
+// loaded from be anymore get re-encoded from the remaining parts, if the chunk
+// has parity. Returns the amount of bytes moved
+func (backend *BackendManager) EvacuateChunk(chunk Chunk, be *Backend) (uint64, error) {
+	backends := []*Backend{}
+	for _, b := range backend.writableBackends() {
+		if b != be {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		return 0, ErrNoWritableBackend
+	}
+
+	parts := chunk.DataParts + chunk.ParityParts
+	holding := make([]map[int]bool, parts)
+	used := make(map[int]bool)
+	for part := range holding {
+		holding[part] = make(map[int]bool)
+		for idx, b := range backends {
+			if _, err := (*b).StatChunk(chunk.ShaSum, uint(part), chunk.DataParts); err == nil {
+				holding[part][idx] = true
+				used[idx] = true
+			}
+		}
+	}
+
+	var moved uint64
+	for part := range holding {
+		// Parts missing everywhere else were most likely stored on be, which
+		// may not be reachable anymore
+		_, err := (*be).StatChunk(chunk.ShaSum, uint(part), chunk.DataParts)
+		if err != nil && len(holding[part]) > 0 {
+			continue
+		}
+
+		holders := []int{}
+		for idx := range holding[part] {
+			holders = append(holders, idx)
+		}
+		idx, _ := failover(len(backends), holders, used, map[int]bool{})
+		if idx < 0 {
+			if len(holders) > 0 {
+				// The part is still replicated on the remaining backends
+				continue
+			}
+			return moved, ErrNotEnoughBackends
+		}
+
+		data, err := (*be).LoadChunk(chunk.ShaSum, uint(part), chunk.DataParts)
+		if err != nil && len(holders) > 0 {
+			data, err = backend.loadPart(backends, holding[part], chunk, uint(part))
+		}
+		if err != nil {
+			if data, err = backend.reconstructPart(backends, holding, chunk, part); err != nil {
+				return moved, err
+			}
+		}
+
+		if err = backend.storePart(backends[idx], &chunk, part, data); err != nil {
+			return moved, err
+		}
+		holding[part][idx] = true
+		used[idx] = true
+		moved += uint64(len(*data))
+	}
+
+	return moved, nil
+}
+
+// reconstructPart re-encodes a lost part of chunk from its other parts
+func (backend *BackendManager) reconstructPart(backends []*Backend, holding []map[int]bool, chunk Chunk, part int) (*[]byte, error) {
+	if chunk.ParityParts == 0 {
+		return nil, ErrLoadChunkFailed
+	}
+	enc, err := reedsolomon.New(int(chunk.DataParts), int(chunk.ParityParts))
+	if err != nil {
+		return nil, err
+	}
+
+	pars := make([][]byte, len(holding))
+	found := uint(0)
+	for i := range holding {
+		if i == part || found == chunk.DataParts {
+			continue
+		}
+		if b, err := backend.loadPart(backends, holding[i], chunk, uint(i)); err == nil {
+			pars[i] = *b
+			found++
+		}
+	}
+	if found < chunk.DataParts {
+		return nil, &DataReconstructionError{chunk, found, chunk.DataParts - found}
+	}
+
+	if err = enc.Reconstruct(pars); err != nil {
+		return nil, err
+	}
+	return &pars[part], nil
+}
@@ -0,0 +1,214 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"bytes"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// ProblemCorruptPart is a chunk part whose content got damaged
+const ProblemCorruptPart = "Corrupt chunk part"
+
+// PartRepair describes a chunk part that got stored again
+type PartRepair struct {
+	Chunk Chunk
+	Part  uint
+	// Location of the backend the part got stored on
+	Location string
+	// Problem is either ProblemMissingPart or ProblemCorruptPart
+	Problem string
+}
+
+// RepairChunk checks every copy of every part of chunk on the writable
+// backends. Copies that are corrupt or missing on a backend supposed to hold
+// them get stored again, parts missing everywhere go to a backend not holding
+// any other part of the chunk. Lost parts are reconstructed from the remaining
+// data and parity parts, which allows locating a single corrupt part per
+// chunk. Returns the parts that got repaired
+func (r *Repository) RepairChunk(chunk Chunk) ([]PartRepair, error) {
+	backends := r.Backend.writableBackends()
+	if len(backends) == 0 {
+		return nil, ErrNoWritableBackend
+	}
+	parts := chunk.DataParts + chunk.ParityParts
+	if chunk.ParityParts == 0 {
+		parts = 1
+	}
+
+	// copies[part][idx] is the part as stored on backends[idx]
+	copies := make([]map[int][]byte, parts)
+	used := make(map[int]bool)
+	for part := range copies {
+		copies[part] = make(map[int][]byte)
+		for idx, be := range backends {
+			if _, err := (*be).StatChunk(chunk.ShaSum, uint(part), chunk.DataParts); err != nil {
+				continue
+			}
+			start := time.Now()
+			b, err := (*be).LoadChunk(chunk.ShaSum, uint(part), chunk.DataParts)
+			if err != nil {
+				r.Backend.record(be, start, 0, 0, err)
+				continue
+			}
+			r.Backend.record(be, start, 0, len(*b), nil)
+			copies[part][idx] = *b
+			used[idx] = true
+		}
+	}
+
+	intact, err := r.intactParts(chunk, copies)
+	if err != nil {
+		return nil, err
+	}
+
+	repairs := []PartRepair{}
+	for part, data := range intact {
+		name := partName(chunk.ShaSum, uint(part), chunk.DataParts)
+		targets := make(map[int]string)
+		for idx, b := range copies[part] {
+			if !bytes.Equal(b, data) {
+				targets[idx] = ProblemCorruptPart
+			}
+		}
+		for _, location := range r.Backend.index.locations(name) {
+			for idx, be := range backends {
+				if _, ok := copies[part][idx]; !ok && (*be).Location() == location {
+					targets[idx] = ProblemMissingPart
+				}
+			}
+		}
+		if len(copies[part]) == 0 && len(targets) == 0 {
+			idx, _ := failover(len(backends), nil, used, map[int]bool{})
+			if idx < 0 {
+				return repairs, ErrNotEnoughBackends
+			}
+			targets[idx] = ProblemMissingPart
+			used[idx] = true
+		}
+
+		for idx, problem := range targets {
+			if problem == ProblemCorruptPart {
+				// Backends don't overwrite parts of the same size
+				start := time.Now()
+				err = (*backends[idx]).DeleteChunk(chunk.ShaSum, uint(part), chunk.DataParts)
+				r.Backend.record(backends[idx], start, 0, 0, err)
+				if err != nil {
+					return repairs, err
+				}
+			}
+			d := data
+			if err = r.Backend.storePart(backends[idx], &chunk, part, &d); err != nil {
+				return repairs, err
+			}
+			repairs = append(repairs, PartRepair{
+				Chunk:    chunk,
+				Part:     uint(part),
+				Location: (*backends[idx]).Location(),
+				Problem:  problem,
+			})
+		}
+	}
+
+	return repairs, nil
+}
+
+// intactParts finds the intact content of all parts of chunk among the
+// copies found on the backends. Every combination leaving out or replacing a
+// single part gets tried until the chunk decodes correctly
+func (r *Repository) intactParts(chunk Chunk, copies []map[int][]byte) ([][]byte, error) {
+	// versions[part] are the distinct contents found for a part
+	versions := make([][][]byte, len(copies))
+	for part, c := range copies {
+		for _, b := range c {
+			known := false
+			for _, v := range versions[part] {
+				known = known || bytes.Equal(v, b)
+			}
+			if !known {
+				versions[part] = append(versions[part], b)
+			}
+		}
+	}
+
+	pars := make([][]byte, len(copies))
+	for part := range versions {
+		if len(versions[part]) > 0 {
+			pars[part] = versions[part][0]
+		}
+	}
+	intact, err := r.decodeParts(chunk, pars)
+	if err == nil {
+		return intact, nil
+	}
+
+	for part := range versions {
+		// Try the other versions of this part, or none at all
+		alternatives := append([][]byte{nil}, versions[part]...)
+		for _, v := range alternatives {
+			p := make([][]byte, len(pars))
+			copy(p, pars)
+			p[part] = v
+			if intact, derr := r.decodeParts(chunk, p); derr == nil {
+				return intact, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// decodeParts reconstructs missing parts of chunk and checks the chunk
+// against its checksum. Returns all parts
+func (r *Repository) decodeParts(chunk Chunk, pars [][]byte) ([][]byte, error) {
+	if chunk.ParityParts == 0 {
+		if pars[0] == nil {
+			return nil, ErrLoadChunkFailed
+		}
+		_, err := decodeChunk(*r, chunk, pars[0])
+		return pars, err
+	}
+
+	found := uint(0)
+	for _, p := range pars {
+		if p != nil {
+			found++
+		}
+	}
+	if found < chunk.DataParts {
+		return nil, &DataReconstructionError{chunk, found, chunk.DataParts - found}
+	}
+
+	enc, err := reedsolomon.New(int(chunk.DataParts), int(chunk.ParityParts))
+	if err != nil {
+		return nil, err
+	}
+	shards := make([][]byte, len(pars))
+	copy(shards, pars)
+	if err = enc.Reconstruct(shards); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	bufWriter := bufio.NewWriter(&b)
+	if err = enc.Join(bufWriter, shards, chunk.Size); err != nil {
+		return nil, err
+	}
+	bufWriter.Flush()
+	if _, err = decodeChunk(*r, chunk, b.Bytes()); err != nil {
+		return nil, err
+	}
+	// A corrupt parity part doesn't affect the data, so check the parity too
+	if ok, verr := enc.Verify(shards); verr != nil || !ok {
+		return nil, ErrLoadChunkFailed
+	}
+	return shards, nil
+}
@@ -0,0 +1,78 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRepairChunk(t *testing.T) {
+	r, err := NewRepository("memory://repair-0", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	for _, url := range []string{"memory://repair-1", "memory://repair-2"} {
+		be, _ := BackendFromURL(url)
+		r.Backend.AddBackend(&be)
+	}
+
+	snapshot, _ := NewSnapshot("test")
+	err = snapshot.AddStream("file", bytes.NewReader([]byte("some data to repair")), r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 2, ParityParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding stream: %s", err)
+		return
+	}
+	chunk := snapshot.Items[0].Chunks[0]
+
+	holder := func(part uint) Backend {
+		for _, be := range r.Backend.Backends {
+			if _, err := (*be).StatChunk(chunk.ShaSum, part, chunk.DataParts); err == nil {
+				return *be
+			}
+		}
+		t.Errorf("No backend holds part %d", part)
+		return nil
+	}
+	corrupt := func(part uint) {
+		be := holder(part)
+		b, _ := be.LoadChunk(chunk.ShaSum, part, chunk.DataParts)
+		data := append([]byte{}, *b...)
+		data[0] ^= 0xff
+		be.DeleteChunk(chunk.ShaSum, part, chunk.DataParts)
+		be.StoreChunk(chunk.ShaSum, part, chunk.DataParts, &data)
+	}
+	repair := func(part uint, problem string) {
+		repairs, err := r.RepairChunk(chunk)
+		if err != nil {
+			t.Errorf("Failed repairing chunk: %s", err)
+			return
+		}
+		if len(repairs) != 1 || repairs[0].Part != part || repairs[0].Problem != problem {
+			t.Errorf("Expected part %d to be repaired (%s), got %v", part, problem, repairs)
+		}
+		if state := VerifyChunkParts(r, chunk); state.Damaged() || state.Degraded() {
+			t.Errorf("Chunk still broken after repair, %d of %d parts: %v", state.Available, state.Parts, state.Err)
+		}
+	}
+
+	if repairs, err := r.RepairChunk(chunk); err != nil || len(repairs) != 0 {
+		t.Errorf("Expected intact chunk, got %v: %v", repairs, err)
+	}
+
+	be := holder(0)
+	be.DeleteChunk(chunk.ShaSum, 0, chunk.DataParts)
+	repair(0, ProblemMissingPart)
+
+	corrupt(1)
+	repair(1, ProblemCorruptPart)
+
+	corrupt(2)
+	repair(2, ProblemCorruptPart)
+}
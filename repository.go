@@ -10,31 +10,76 @@ package knoxite
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 )
 
 // A Repository is a collection of backup snapshots
 // MUST BE encrypted
 type Repository struct {
+	// Version is the format version of the repository
+	Version int `json:"version,omitempty"`
 	//	Owner   string    `json:"owner"`
 	Volumes []*Volume `json:"volumes"`
 	Paths   []string  `json:"storage"`
+	// BackendStats accumulates the transfer statistics of all backends
+	BackendStats map[string]BackendStats `json:"backend_stats,omitempty"`
+	// Signed is set once all snapshots of the repository carry a signature
+	Signed bool `json:"signed,omitempty"`
+	// Chunker is the algo files get split into chunks with, e.g.
+	// ChunkerFastCDC. Changing it prevents deduplication against the data
+	// stored before
+	Chunker int `json:"chunker,omitempty"`
+	// ChunkSize is the default size of chunks, see StoreOptions
+	ChunkSize ChunkSize `json:"chunk_size"`
+	// IndexFiles are the names of the files the dedup index is stored in
+	IndexFiles []string `json:"index_files,omitempty"`
+	// LocationFiles are the names of the files the index of which backends
+	// hold which chunk parts is stored in
+	LocationFiles []string `json:"location_files,omitempty"`
+	// Conversion maps the IDs of chunks getting converted to new algos to
+	// their converted counterparts, see ConvertChunk
+	Conversion map[string]Chunk `json:"conversion,omitempty"`
 
 	Backend  BackendManager `json:"-"`
 	Password string         `json:"-"`
 
 	RawJSON []byte `json:"-"`
+
+	// header describes how key gets derived from Password. Repositories
+	// without a header use their password as key
+	header *KeyHeader
+	key    string
+	// slot is the ID of the key slot Password unlocked
+	slot string
+	// dedup is the dedup index, new chunks get written to an index file on
+	// Save
+	dedup *dedupIndex
 }
 
+// repositoryVersion is the format version of new repositories. Version 1
+// repositories identify chunks by keyed hashes, see chunkID
+const repositoryVersion = 1
+
+// latestSnapshot is the alias FindSnapshot accepts for the latest snapshot
+const latestSnapshot = "latest"
+
 // Error declarations
 var (
-	ErrVolumeNotFound   = errors.New("Volume not found")
-	ErrSnapshotNotFound = errors.New("Snapshot not found")
+	ErrVolumeNotFound    = errors.New("Volume not found")
+	ErrSnapshotNotFound  = errors.New("Snapshot not found")
+	ErrItemNotFound      = errors.New("File not found in snapshot")
+	ErrRepositoryVersion = errors.New("Repository format is not supported, please upgrade knoxite")
+	ErrChunkUnreferenced = errors.New("Chunk is not referenced by any snapshot")
 )
 
 // NewRepository returns a new repository
 func NewRepository(path, password string) (Repository, error) {
 	repository := Repository{
+		Version:  repositoryVersion,
+		Chunker:  ChunkerFastCDC,
 		Password: password,
+		dedup:    newDedupIndex(),
 	}
 	backend, err := BackendFromURL(path)
 	if err != nil {
@@ -42,6 +87,14 @@ func NewRepository(path, password string) (Repository, error) {
 	}
 	repository.Backend.AddBackend(&backend)
 
+	header, key, err := newKeyHeader(password)
+	if err != nil {
+		return repository, err
+	}
+	repository.header = &header
+	repository.key = key
+	repository.slot = header.Slots[0].ID
+
 	err = repository.init()
 	return repository, err
 }
@@ -57,11 +110,34 @@ func OpenRepository(path, password string) (Repository, error) {
 	}
 
 	b, err := backend.LoadRepository()
+	if err != nil {
+		return repository, err
+	}
+	signature, b := splitSignature(b)
+	header, data, err := unmarshalKeyHeader(b)
+	if err != nil {
+		return repository, err
+	}
+	if header != nil {
+		if repository.key, repository.slot, err = header.unlock(password); err != nil {
+			return repository, err
+		}
+		repository.header = header
+	}
+	if signature != nil || header != nil {
+		// Only metadata of repositories predating signatures may lack one
+		if err = checkSignature(repository.secret(), "repository", signature, b); err != nil {
+			return repository, err
+		}
+	}
 
-	decb, err := Decrypt(b, password)
+	decb, err := repository.decrypt(data)
 	if err == nil {
 		err = json.Unmarshal(decb, &repository)
 	}
+	if err == nil && repository.Version > repositoryVersion {
+		err = ErrRepositoryVersion
+	}
 	repository.RawJSON = decb
 
 	for _, url := range repository.Paths {
@@ -71,6 +147,10 @@ func OpenRepository(path, password string) (Repository, error) {
 		}
 		repository.Backend.AddBackend(&backend)
 	}
+	repository.dedup = newDedupIndex()
+	if err == nil {
+		err = repository.loadIndexFiles()
+	}
 
 	return repository, err
 }
@@ -81,19 +161,76 @@ func (r *Repository) AddVolume(volume *Volume) error {
 	return nil
 }
 
-// FindVolume finds a volume within a repository
+// RemoveVolume removes volume and all of its snapshots from the repository.
+// Like with forgotten snapshots, their data stays in the repository
+func (r *Repository) RemoveVolume(volume *Volume) error {
+	for i, v := range r.Volumes {
+		if v != volume {
+			continue
+		}
+		r.Volumes = append(r.Volumes[:i], r.Volumes[i+1:]...)
+		// Save first, so the repository never refers to missing snapshots
+		if err := r.Save(); err != nil {
+			return err
+		}
+
+		for _, id := range volume.Snapshots {
+			if err := r.Backend.DeleteSnapshot(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return ErrVolumeNotFound
+}
+
+// MoveSnapshot moves snapshot from one volume to another. Chunks remember the
+// key they got encrypted with, so only volumes using the repository's key can
+// exchange snapshots
+func (r *Repository) MoveSnapshot(snapshot *Snapshot, from, to *Volume) error {
+	if from.Key != nil || to.Key != nil {
+		return ErrVolumeKeyMismatch
+	}
+	if err := from.RemoveSnapshot(snapshot.ID); err != nil {
+		return err
+	}
+	to.AddSnapshot(snapshot.ID)
+
+	snapshot.volume = to
+	if err := snapshot.Save(r); err != nil {
+		return err
+	}
+	return r.Save()
+}
+
+// FindVolume finds a volume within a repository by its ID, or by its name if
+// no other volume carries the same name
 func (r *Repository) FindVolume(id string) (*Volume, error) {
+	var named []*Volume
 	for _, volume := range r.Volumes {
 		if volume.ID == id {
 			return volume, nil
 		}
+		if volume.Name == id {
+			named = append(named, volume)
+		}
+	}
+	if len(named) == 1 {
+		return named[0], nil
 	}
 
 	return &Volume{}, ErrVolumeNotFound
 }
 
-// FindSnapshot finds a snapshot within a repository
+// FindSnapshot finds a snapshot within a repository. The alias "latest" finds
+// the most recent snapshot of all volumes, "latest:VOLUME" the one last added
+// to a volume
 func (r *Repository) FindSnapshot(id string) (*Volume, *Snapshot, error) {
+	if id == latestSnapshot || strings.HasPrefix(id, latestSnapshot+":") {
+		return r.findLatestSnapshot(strings.TrimPrefix(id[len(latestSnapshot):], ":"))
+	}
+
 	for _, volume := range r.Volumes {
 		snapshot, err := volume.LoadSnapshot(id, r)
 		if err == nil {
@@ -104,6 +241,83 @@ func (r *Repository) FindSnapshot(id string) (*Volume, *Snapshot, error) {
 	return &Volume{}, &Snapshot{}, ErrSnapshotNotFound
 }
 
+// findLatestSnapshot finds the snapshot last added to the volume volID, or
+// with an empty volID the most recent of those of all volumes
+func (r *Repository) findLatestSnapshot(volID string) (*Volume, *Snapshot, error) {
+	if volID != "" {
+		volume, err := r.FindVolume(volID)
+		if err != nil {
+			return volume, &Snapshot{}, err
+		}
+		snapshot, err := volume.LatestSnapshot(r)
+		return volume, &snapshot, err
+	}
+
+	latest := &Snapshot{}
+	found := &Volume{}
+	for _, volume := range r.Volumes {
+		snapshot, err := volume.LatestSnapshot(r)
+		if err == ErrSnapshotNotFound {
+			continue
+		}
+		if err != nil {
+			return volume, &snapshot, err
+		}
+		if latest.ID == "" || snapshot.Date.After(latest.Date) {
+			latest = &snapshot
+			found = volume
+		}
+	}
+	if latest.ID == "" {
+		return found, latest, ErrSnapshotNotFound
+	}
+
+	return found, latest, nil
+}
+
+// Chunks returns all chunks referenced by any snapshot in the repository
+func (r *Repository) Chunks() ([]Chunk, error) {
+	chunks := []Chunk{}
+	seen := make(map[string]bool)
+	for _, volume := range r.Volumes {
+		for _, id := range volume.Snapshots {
+			snapshot, err := volume.LoadSnapshot(id, r)
+			if err != nil {
+				return chunks, err
+			}
+
+			for _, item := range snapshot.Items {
+				for _, chunk := range item.Chunks {
+					// Parts are stored per shasum and amount of data parts
+					key := chunk.ShaSum + "_" + strconv.FormatUint(uint64(chunk.DataParts), 10)
+					if !seen[key] {
+						seen[key] = true
+						chunks = append(chunks, chunk)
+					}
+				}
+			}
+		}
+	}
+
+	return chunks, nil
+}
+
+// FindChunk finds a chunk referenced by any snapshot in the repository by its
+// shasum
+func (r *Repository) FindChunk(shasum string) (Chunk, error) {
+	chunks, err := r.Chunks()
+	if err != nil {
+		return Chunk{}, err
+	}
+	for _, chunk := range chunks {
+		if chunk.ShaSum == shasum {
+			return chunk, nil
+		}
+	}
+
+	return Chunk{}, ErrChunkUnreferenced
+}
+
 // Init creates a new repository
 func (r *Repository) init() error {
 	err := r.Backend.InitRepository()
@@ -117,6 +331,27 @@ func (r *Repository) init() error {
 // Save writes a repository's metadata
 func (r *Repository) Save() error {
 	r.Paths = r.Backend.Locations()
+	if err := r.saveIndexFile(); err != nil {
+		return err
+	}
+	if err := r.saveLocationFile(); err != nil {
+		return err
+	}
+	if !r.Signed {
+		if err := r.signSnapshots(); err != nil {
+			return err
+		}
+		r.Signed = true
+	}
+
+	if r.BackendStats == nil {
+		r.BackendStats = make(map[string]BackendStats)
+	}
+	for _, be := range r.Backend.Backends {
+		stats := r.BackendStats[(*be).Location()]
+		stats.Add(r.Backend.TakeStats(be))
+		r.BackendStats[(*be).Location()] = stats
+	}
 
 	//	b, err := json.MarshalIndent(*r, "", "    ")
 	b, err := json.Marshal(*r)
@@ -124,9 +359,105 @@ func (r *Repository) Save() error {
 		return err
 	}
 
-	encb, err := Encrypt(b, r.Password)
+	if r.header != nil {
+		// Upgrade the key derivation to the current defaults
+		if err = r.header.strengthen(r.slot, r.Password, r.key); err != nil {
+			return err
+		}
+	}
+
+	encb, err := r.encrypt(b)
+	if err == nil && r.header != nil {
+		encb, err = marshalKeyHeader(*r.header, encb)
+	}
 	if err == nil {
-		err = r.Backend.SaveRepository(encb)
+		err = r.Backend.SaveRepository(sign(r.secret(), "repository", encb))
 	}
 	return err
 }
+
+// signSnapshots adds a signature to all snapshots stored before knoxite
+// started signing its metadata
+func (r *Repository) signSnapshots() error {
+	for _, volume := range r.Volumes {
+		for _, id := range volume.Snapshots {
+			snapshot, err := openSnapshot(id, volume, r)
+			if err != nil {
+				return err
+			}
+			if err = snapshot.Save(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ChunkIDsKeyed returns true if the repository identifies chunks by keyed
+// hashes. Older repositories can be migrated with MigrateChunkID
+func (r *Repository) ChunkIDsKeyed() bool {
+	return r.Version >= 1
+}
+
+// Keys returns the key slots of the repository, without their key material
+func (r *Repository) Keys() ([]KeySlot, error) {
+	if r.header == nil {
+		return nil, ErrNoKeyHeader
+	}
+
+	slots := []KeySlot{}
+	for _, slot := range r.header.Slots {
+		slot.Key = nil
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// CurrentKey returns the ID of the key slot the repository got unlocked with
+func (r *Repository) CurrentKey() string {
+	return r.slot
+}
+
+// AddKey lets password unlock the repository as well. Returns the ID of the
+// new key slot. The change only persists once the repository gets saved
+func (r *Repository) AddKey(password, description string) (string, error) {
+	if r.header == nil {
+		return "", ErrNoKeyHeader
+	}
+	return r.header.add(password, r.key, description)
+}
+
+// RemoveKey removes the key slot with the given ID. The change only persists
+// once the repository gets saved
+func (r *Repository) RemoveKey(id string) error {
+	if r.header == nil {
+		return ErrNoKeyHeader
+	}
+	return r.header.remove(id)
+}
+
+// secret returns the key the repository's data gets encrypted with
+func (r *Repository) secret() string {
+	if r.header == nil {
+		return r.Password
+	}
+	return r.key
+}
+
+// encrypt encrypts the repository's metadata. Repositories with a key header
+// use authenticated encryption
+func (r *Repository) encrypt(b []byte) ([]byte, error) {
+	if r.header == nil {
+		return Encrypt(b, r.Password)
+	}
+	return EncryptWith(b, r.key, EncryptionAESGCM)
+}
+
+// decrypt decrypts the repository's metadata
+func (r *Repository) decrypt(b []byte) ([]byte, error) {
+	if r.header == nil {
+		return Decrypt(b, r.Password)
+	}
+	return DecryptWith(b, r.key, EncryptionAESGCM)
+}
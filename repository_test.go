@@ -44,3 +44,94 @@ func TestCreateRepositoryError(t *testing.T) {
 		t.Errorf("Expected %v, got %v", ErrInvalidRepositoryURL, err)
 	}
 }
+
+func TestFindChunk(t *testing.T) {
+	r, err := NewRepository("memory://findchunk", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"repository_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+	if err = snapshot.Save(&r); err != nil {
+		t.Errorf("Failed saving snapshot: %s", err)
+		return
+	}
+	vol.AddSnapshot(snapshot.ID)
+
+	stored := snapshot.Items[0].Chunks[0]
+	chunk, err := r.FindChunk(stored.ShaSum)
+	if err != nil {
+		t.Errorf("Failed finding chunk: %s", err)
+		return
+	}
+	data, err := DecodeChunk(r, chunk)
+	if err != nil {
+		t.Errorf("Failed decoding chunk: %s", err)
+		return
+	}
+	original, _ := ioutil.ReadFile("repository_test.go")
+	if string(data) != string(original) {
+		t.Errorf("Decoded chunk doesn't match the original data")
+	}
+
+	if _, err = r.FindChunk("unknown"); err != ErrChunkUnreferenced {
+		t.Errorf("Expected %v, got %v", ErrChunkUnreferenced, err)
+	}
+}
+
+func TestFindLatestSnapshot(t *testing.T) {
+	r, err := NewRepository("memory://findlatest", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	if _, _, err = r.FindSnapshot("latest"); err != ErrSnapshotNotFound {
+		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	other, _ := NewVolume("other", "")
+	r.AddVolume(other)
+
+	ids := []string{}
+	for i, v := range []*Volume{vol, other, vol} {
+		snapshot, _ := v.NewSnapshot("test_snapshot")
+		snapshot.Date = snapshot.Date.AddDate(0, 0, i)
+		if err = snapshot.Save(&r); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+			return
+		}
+		v.AddSnapshot(snapshot.ID)
+		ids = append(ids, snapshot.ID)
+	}
+
+	for alias, id := range map[string]string{
+		"latest":           ids[2],
+		"latest:other":     ids[1],
+		"latest:" + vol.ID: ids[2],
+	} {
+		_, snapshot, err := r.FindSnapshot(alias)
+		if err != nil {
+			t.Errorf("Failed finding snapshot %s: %s", alias, err)
+			continue
+		}
+		if snapshot.ID != id {
+			t.Errorf("Expected %s to find snapshot %s, got %s", alias, id, snapshot.ID)
+		}
+	}
+	if _, _, err = r.FindSnapshot("latest:unknown"); err != ErrVolumeNotFound {
+		t.Errorf("Expected %v, got %v", ErrVolumeNotFound, err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A RetentionPolicy decides which snapshots of a volume to keep. Besides the
+// Last snapshots it keeps the latest snapshot of each of the last Hourly
+// hours, Daily days and so on that have snapshots. A policy keeping nothing
+// keeps all snapshots
+type RetentionPolicy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+// empty returns whether the policy doesn't keep any snapshot
+func (p RetentionPolicy) empty() bool {
+	return p.Last <= 0 && p.Hourly <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0 && p.Yearly <= 0
+}
+
+// Apply sorts the snapshots into the ones to keep and the ones to forget,
+// both ordered from newest to oldest
+func (p RetentionPolicy) Apply(snapshots []Snapshot) (keep, forget []Snapshot) {
+	sorted := make([]Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+	if p.empty() {
+		return sorted, nil
+	}
+
+	buckets := []struct {
+		count  int
+		period func(t time.Time) string
+		last   string
+	}{
+		{p.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }, ""},
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }, ""},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}, ""},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }, ""},
+		{p.Yearly, func(t time.Time) string { return t.Format("2006") }, ""},
+	}
+
+	last := p.Last
+	for _, snapshot := range sorted {
+		kept := false
+		if last > 0 {
+			last--
+			kept = true
+		}
+		for i := range buckets {
+			b := &buckets[i]
+			if b.count <= 0 {
+				continue
+			}
+			// The newest snapshot of each period gets kept
+			if period := b.period(snapshot.Date.Local()); period != b.last {
+				b.last = period
+				b.count--
+				kept = true
+			}
+		}
+
+		if kept {
+			keep = append(keep, snapshot)
+		} else {
+			forget = append(forget, snapshot)
+		}
+	}
+
+	return keep, forget
+}
+
+// ForgetSnapshots removes the snapshots from volume and deletes them from
+// the backends. Their chunks stay in the repository
+func (r *Repository) ForgetSnapshots(volume *Volume, ids []string) error {
+	for _, id := range ids {
+		if err := volume.RemoveSnapshot(id); err != nil {
+			return err
+		}
+	}
+	// Save first, so the repository never refers to missing snapshots
+	if err := r.Save(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := r.Backend.DeleteSnapshot(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	// Daily snapshots, the newest on Sunday March 31st
+	newest := time.Date(2024, 3, 31, 12, 0, 0, 0, time.Local)
+	snapshots := []Snapshot{}
+	for i := 29; i >= 0; i-- {
+		snapshots = append(snapshots, Snapshot{ID: strconv.Itoa(i), Date: newest.AddDate(0, 0, -i)})
+	}
+
+	keep, forget := RetentionPolicy{Last: 2, Daily: 7, Weekly: 2}.Apply(snapshots)
+	if len(keep) != 8 || len(forget) != 22 {
+		t.Errorf("Expected to keep 8 and forget 22 snapshots, got %d and %d", len(keep), len(forget))
+		return
+	}
+	for i, s := range keep {
+		// The last 7 days plus the newest of the previous week
+		if s.ID != strconv.Itoa(i) {
+			t.Errorf("Expected to keep snapshot %d, got %s", i, s.ID)
+		}
+	}
+
+	keep, forget = RetentionPolicy{}.Apply(snapshots)
+	if len(keep) != 30 || len(forget) != 0 {
+		t.Errorf("Empty policy should keep all snapshots")
+	}
+}
+
+func TestForgetSnapshots(t *testing.T) {
+	r, err := NewRepository("memory://forget", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		if err = snapshot.Save(&r); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+			return
+		}
+		vol.AddSnapshot(snapshot.ID)
+		ids = append(ids, snapshot.ID)
+	}
+
+	if err = r.ForgetSnapshots(vol, ids[:2]); err != nil {
+		t.Errorf("Failed forgetting snapshots: %s", err)
+		return
+	}
+	if len(vol.Snapshots) != 1 || vol.Snapshots[0] != ids[2] {
+		t.Errorf("Unexpected snapshots left: %v", vol.Snapshots)
+	}
+	if _, err = vol.LoadSnapshot(ids[0], &r); err == nil {
+		t.Errorf("Forgotten snapshot can still be loaded")
+	}
+	if err = r.ForgetSnapshots(vol, ids[:1]); err != ErrSnapshotNotFound {
+		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+}
@@ -12,71 +12,138 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Which type
 const (
-	File      = iota // A File
-	Directory        // A Directory
-	SymLink          // A SymLink
+	File        = iota // A File
+	Directory          // A Directory
+	SymLink            // A SymLink
+	CharDevice         // A character device
+	BlockDevice        // A block device
+	NamedPipe          // A FIFO
+	Socket             // A unix domain socket
 )
 
 // ItemData contains all metadata belonging to a file/directory
 // MUST BE encrypted
 type ItemData struct {
-	Path        string      `json:"path"`               // Where in filesystem does this belong to
-	Type        uint        `json:"type"`               // Is this a File, Directory or SymLink
-	PointsTo    string      `json:"pointsto,omitempty"` // If this is a SymLink, where does it point to
-	Mode        os.FileMode `json:"mode"`               // file mode bits
-	ModTime     time.Time   `json:"modtime"`            // modification time
-	Size        uint64      `json:"size"`               // size
-	StorageSize uint64      `json:"storagesize"`        // size in storage
-	UID         uint32      `json:"uid"`                // owner
-	GID         uint32      `json:"gid"`                // group
-	Chunks      []Chunk     `json:"chunks,omitempty"`
-	AbsPath     string      `json:"-"`
-	FileInfo    os.FileInfo `json:"-"`
+	Path        string            `json:"path"`               // Where in filesystem does this belong to
+	Type        uint              `json:"type"`               // Is this a File, Directory, SymLink or special file
+	PointsTo    string            `json:"pointsto,omitempty"` // If this is a SymLink, where does it point to
+	Mode        os.FileMode       `json:"mode"`               // file mode bits
+	ModTime     time.Time         `json:"modtime"`            // modification time
+	Size        uint64            `json:"size"`               // size
+	StorageSize uint64            `json:"storagesize"`        // size in storage
+	UID         uint32            `json:"uid"`                // owner
+	GID         uint32            `json:"gid"`                // group
+	Inode       uint64            `json:"inode,omitempty"`    // inode number, used to detect changes
+	Rdev        uint64            `json:"rdev,omitempty"`     // If this is a device, its device number
+	LinkTo      string            `json:"linkto,omitempty"`   // If this is a hardlink, the path of the item it links to
+	Xattrs      map[string][]byte `json:"xattrs,omitempty"`   // extended attributes
+	Chunks      []Chunk           `json:"chunks,omitempty"`
+	Data        []byte            `json:"data,omitempty"` // content of small files, stored inline instead of in chunks
+	AbsPath     string            `json:"-"`
+	FileInfo    os.FileInfo       `json:"-"`
 }
 
-func findFiles(rootPath string) chan ItemData {
+// findFiles walks rootPath and returns all items passing the filter of opts.
+// Their paths are relative to cwd, unless they're outside of it. Excluded
+// directories don't get walked at all. Ignore files called opts.IgnoreFile
+// found along the way exclude items as well. Items that can't be read get
+// skipped and passed to failed
+func findFiles(cwd, rootPath string, opts StoreOptions, failed func(path string, err error)) chan ItemData {
 	c := make(chan ItemData)
 	go func() {
-		err := filepath.Walk(rootPath, func(path string, fi os.FileInfo, err error) error {
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Could not find %s\n", path)
-				return err
+		dirRules := make(map[string]ignoreRules)
+		// visited holds the device and inode of all directories walked, so
+		// followed symlinks can't lead into a loop
+		visited := make(map[[2]uint64]bool)
+		var rootDev uint64
+
+		var walkFn filepath.WalkFunc
+		walkFn = func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi == nil {
+				err = fmt.Errorf("error for %v: FileInfo is nil", path)
 			}
-			if fi == nil {
-				fmt.Fprintf(os.Stderr, "Could not read %s\n", path)
-				return fmt.Errorf("error for %v: FileInfo is nil", path)
+			if err != nil {
+				failed(path, err)
+				if fi != nil && fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
 			}
 
-			/* if !isExcluded(str, fi) {
+			relPath := path
+			if rel, rerr := filepath.Rel(cwd, path); rerr == nil && !strings.HasPrefix(rel, "../") {
+				relPath = rel
+			}
+			excluded := !isSpecialPath(relPath) && opts.Filter.Excluded(relPath)
+			if excluded || (path != rootPath && dirRules[filepath.Dir(path)].ignored(path, fi.IsDir())) {
 				if fi.IsDir() {
 					return filepath.SkipDir
 				}
 				return nil
-			}*/
+			}
+
+			if opts.FollowSymlinks && isSymLink(fi) {
+				// Dangling links and links leading into a loop get stored
+				// as links
+				if target, serr := os.Stat(path); serr == nil {
+					statT, ok := toStatT(target.Sys())
+					if !target.IsDir() {
+						fi = target
+					} else if ok && !visited[[2]uint64{statT.dev(), statT.ino()}] {
+						return walkLink(path, walkFn)
+					}
+				}
+			}
 
 			statT, ok := toStatT(fi.Sys())
 			if !ok {
-				return &os.PathError{Op: "stat", Path: path, Err: errors.New("error reading metadata")}
+				failed(path, &os.PathError{Op: "stat", Path: path, Err: errors.New("error reading metadata")})
+				return nil
+			}
+			if path == rootPath {
+				rootDev = statT.dev()
+			}
+			// Mount points get stored, but not what's mounted on them
+			var skip error
+			if fi.IsDir() {
+				visited[[2]uint64{statT.dev(), statT.ino()}] = true
+				if opts.OneFileSystem && statT.dev() != rootDev {
+					skip = filepath.SkipDir
+				}
 			}
+
+			if fi.IsDir() && opts.IgnoreFile != "" && skip == nil {
+				rules, rerr := dirRules[filepath.Dir(path)].load(path, opts.IgnoreFile)
+				if rerr != nil {
+					fmt.Fprintf(os.Stderr, "Could not read %s: %v\n", filepath.Join(path, opts.IgnoreFile), rerr)
+				}
+				dirRules[path] = rules
+			}
+			if !isSpecialPath(relPath) && !opts.Filter.Match(relPath) {
+				// Items within it may still be included
+				return skip
+			}
+
 			id := ItemData{
-				Path:     path,
+				Path:     relPath,
 				AbsPath:  path,
 				Mode:     fi.Mode(),
 				ModTime:  fi.ModTime(),
 				UID:      statT.uid(),
 				GID:      statT.gid(),
+				Inode:    statT.ino(),
 				FileInfo: fi,
 			}
 			if isSymLink(fi) {
 				symlink, lerr := os.Readlink(path)
 				if lerr != nil {
-					//FIXME: we should probably even (re)store invalid symlinks
-					fmt.Fprintf(os.Stderr, "error resolving symlink for: %v - %v\n", path, lerr)
+					failed(path, lerr)
 					return nil
 				}
 
@@ -84,6 +151,11 @@ func findFiles(rootPath string) chan ItemData {
 				id.PointsTo = symlink
 			} else if fi.IsDir() {
 				id.Type = Directory
+			} else if typ, ok := specialType(fi); ok {
+				id.Type = typ
+				if typ == CharDevice || typ == BlockDevice {
+					id.Rdev = statT.rdev()
+				}
 			} else {
 				id.Type = File
 				if isRegularFile(fi) {
@@ -91,9 +163,17 @@ func findFiles(rootPath string) chan ItemData {
 				}
 			}
 
+			xattrs, xerr := readXattrs(path, id.Type != SymLink)
+			if xerr != nil {
+				failed(path, xerr)
+				return nil
+			}
+			id.Xattrs = xattrs
+
 			c <- id
-			return nil
-		})
+			return skip
+		}
+		err := filepath.Walk(rootPath, walkFn)
 
 		//FIXME: handle errors gracefully
 		if err != nil {
@@ -104,6 +184,35 @@ func findFiles(rootPath string) chan ItemData {
 	return c
 }
 
+// walkLink walks the directory the symlink at path points to, passing the
+// items within it to walkFn as if they were located below path
+func walkLink(path string, walkFn filepath.WalkFunc) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return walkFn(path, nil, err)
+	}
+	return filepath.Walk(target, func(p string, fi os.FileInfo, err error) error {
+		rel, rerr := filepath.Rel(target, p)
+		if rerr != nil {
+			return rerr
+		}
+		return walkFn(filepath.Join(path, rel), fi, err)
+	})
+}
+
+// hardlinkKey returns the device and inode of a regular file with more than
+// one link. ok is false for all other items
+func hardlinkKey(id ItemData) (key [2]uint64, ok bool) {
+	if id.Type != File || !isRegularFile(id.FileInfo) {
+		return key, false
+	}
+	statT, ok := toStatT(id.FileInfo.Sys())
+	if !ok || statT.nlink() < 2 {
+		return key, false
+	}
+	return [2]uint64{statT.dev(), statT.ino()}, true
+}
+
 func isSpecialPath(path string) bool {
 	return path == "."
 }
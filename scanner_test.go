@@ -0,0 +1,64 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Symlinks require extra privileges on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "data", "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, "other"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "data", "sub", "file"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "other", "file"), []byte("other"), 0644)
+	// A link to a file, one to a directory, one leading into a loop and a
+	// dangling one
+	os.Symlink(filepath.Join(dir, "other", "file"), filepath.Join(dir, "data", "file-link"))
+	os.Symlink(filepath.Join(dir, "other"), filepath.Join(dir, "data", "dir-link"))
+	os.Symlink("..", filepath.Join(dir, "data", "sub", "loop"))
+	os.Symlink("missing", filepath.Join(dir, "data", "dangling"))
+
+	find := func(opts StoreOptions) string {
+		items := []string{}
+		for item := range findFiles(dir, filepath.Join(dir, "data"), opts, func(string, error) {}) {
+			items = append(items, filepath.ToSlash(item.Path)+":"+[]string{"file", "dir", "link"}[item.Type])
+		}
+		sort.Strings(items)
+		return strings.Join(items, " ")
+	}
+
+	expected := "data/dangling:link data/dir-link:link data/file-link:link data/sub/file:file data/sub/loop:link data/sub:dir data:dir"
+	if items := find(StoreOptions{}); items != expected {
+		t.Errorf("Expected %s, got %s", expected, items)
+	}
+	expected = "data/dangling:link data/dir-link/file:file data/dir-link:dir data/file-link:file data/sub/file:file data/sub/loop:link data/sub:dir data:dir"
+	if items := find(StoreOptions{FollowSymlinks: true}); items != expected {
+		t.Errorf("Expected %s, got %s", expected, items)
+	}
+	expected = "data/dangling:link data/dir-link:link data/file-link:link data/sub/file:file data/sub/loop:link data/sub:dir data:dir"
+	if items := find(StoreOptions{OneFileSystem: true}); items != expected {
+		t.Errorf("Expected %s, got %s", expected, items)
+	}
+}
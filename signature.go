@@ -0,0 +1,71 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// signatureMagic prefixes signed metadata, followed by its HMAC-SHA256
+var signatureMagic = []byte("KNXS")
+
+// Error declarations
+var (
+	ErrInvalidSignature = errors.New("Invalid metadata signature, the data has been tampered with")
+	ErrMissingSignature = errors.New("Metadata is not signed, the data has been tampered with")
+)
+
+// signingKey derives the key metadata gets signed with from secret, so it
+// differs from the key used for encryption
+func signingKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("knoxite metadata signature"))
+	return mac.Sum(nil)
+}
+
+// mac returns the HMAC of data. It also covers name, binding the data to the
+// place it gets stored at, so signed metadata can't be swapped
+func mac(secret, name string, data []byte) []byte {
+	mac := hmac.New(sha256.New, signingKey(secret))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// sign prefixes data with its signature
+func sign(secret, name string, data []byte) []byte {
+	b := make([]byte, 0, len(signatureMagic)+sha256.Size+len(data))
+	b = append(b, signatureMagic...)
+	b = append(b, mac(secret, name, data)...)
+	return append(b, data...)
+}
+
+// splitSignature splits b into its signature and the signed data. Returns a
+// nil signature if b isn't signed
+func splitSignature(b []byte) ([]byte, []byte) {
+	if !bytes.HasPrefix(b, signatureMagic) || len(b) < len(signatureMagic)+sha256.Size {
+		return nil, b
+	}
+	b = b[len(signatureMagic):]
+	return b[:sha256.Size], b[sha256.Size:]
+}
+
+// checkSignature verifies that signature matches data
+func checkSignature(secret, name string, signature, data []byte) error {
+	if signature == nil {
+		return ErrMissingSignature
+	}
+	if !hmac.Equal(signature, mac(secret, name, data)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import "testing"
+
+func TestMetadataSignature(t *testing.T) {
+	r, err := NewRepository("memory://signature", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	ids := []string{}
+	for i := 0; i < 2; i++ {
+		snapshot, _ := NewSnapshot("test_snapshot")
+		if err = snapshot.Save(&r); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+			return
+		}
+		vol.AddSnapshot(snapshot.ID)
+		ids = append(ids, snapshot.ID)
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	storage := MemoryStorage("signature")
+	first, _ := storage.LoadSnapshot(ids[0])
+	second, _ := storage.LoadSnapshot(ids[1])
+
+	// Swapping snapshots must be detected, even though both are signed
+	storage.SaveSnapshot(ids[0], second)
+	if _, err = openSnapshot(ids[0], vol, &r); err != ErrInvalidSignature {
+		t.Errorf("Expected %v, got %v", ErrInvalidSignature, err)
+	}
+
+	_, unsigned := splitSignature(first)
+	storage.SaveSnapshot(ids[0], unsigned)
+	if _, err = openSnapshot(ids[0], vol, &r); err != ErrMissingSignature {
+		t.Errorf("Expected %v, got %v", ErrMissingSignature, err)
+	}
+
+	storage.SaveSnapshot(ids[0], first)
+	if _, err = openSnapshot(ids[0], vol, &r); err != nil {
+		t.Errorf("Failed opening snapshot: %s", err)
+	}
+
+	b, _ := storage.LoadRepository()
+	tampered := append([]byte{}, b...)
+	tampered[len(tampered)-1] ^= 0xff
+	storage.SaveRepository(tampered)
+	if _, err = OpenRepository("memory://signature", "password"); err != ErrInvalidSignature {
+		t.Errorf("Expected %v, got %v", ErrInvalidSignature, err)
+	}
+	storage.SaveRepository(b)
+}
+
+func TestMetadataSignatureUpgrade(t *testing.T) {
+	r, err := NewRepository("memory://signatureupgrade", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	// Store a snapshot the way knoxite did before signing metadata
+	snapshot, _ := NewSnapshot("test_snapshot")
+	snapshot.Save(&r)
+	vol.AddSnapshot(snapshot.ID)
+	b, _ := r.Backend.LoadSnapshot(snapshot.ID)
+	_, unsigned := splitSignature(b)
+	r.Backend.SaveSnapshot(snapshot.ID, unsigned)
+	r.Signed = false
+
+	if _, err = openSnapshot(snapshot.ID, vol, &r); err != nil {
+		t.Errorf("Failed opening unsigned snapshot: %s", err)
+	}
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://signatureupgrade", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if !r.Signed {
+		t.Errorf("Repository did not get flagged as signed")
+	}
+	if _, _, err = r.FindSnapshot(snapshot.ID); err != nil {
+		t.Errorf("Failed opening upgraded snapshot: %s", err)
+	}
+}
@@ -9,7 +9,10 @@ package knoxite
 
 import (
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"math"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -24,8 +27,12 @@ type Snapshot struct {
 	ID          string     `json:"id"`
 	Date        time.Time  `json:"date"`
 	Description string     `json:"description"`
+	Tags        []string   `json:"tags,omitempty"`
 	Stats       Stats      `json:"stats"`
 	Items       []ItemData `json:"items"`
+
+	// volume is the volume whose key the snapshot gets encrypted with
+	volume *Volume
 }
 
 // NewSnapshot creates a new snapshot
@@ -44,27 +51,112 @@ func NewSnapshot(description string) (Snapshot, error) {
 	return snapshot, nil
 }
 
+// StoreOptions describe how Snapshot.Add stores data
+type StoreOptions struct {
+	// Compression is the compression algo, e.g. CompressionZstd
+	Compression int
+	// CompressionLevel trades speed for size, 0 selects the algo's default
+	CompressionLevel int
+	// Encryption is the encryption algo, e.g. EncryptionAESGCM
+	Encryption int
+	// DataParts and ParityParts configure how many parts each chunk gets
+	// split into, parity parts allow reconstructing missing data parts
+	DataParts   uint
+	ParityParts uint
+	// Parent is a previous snapshot of the same data. Files that didn't
+	// change since reuse its chunks instead of being read again
+	Parent *Snapshot
+	// InlineSize is the size up to which files get stored within the
+	// snapshot itself instead of in chunks, saving lots of tiny objects
+	InlineSize uint64
+	// ChunkSize overrides the repository's default chunk size
+	ChunkSize ChunkSize
+	// CompressionRules override the compression of matching files. The first
+	// matching rule applies
+	CompressionRules []CompressionRule
+	// Pipeline configures the amount of workers used to store data
+	Pipeline Pipeline
+	// DeltaSize enables the delta mode for files of at least this size, see
+	// Chunker.DeltaChunks. Zero disables it
+	DeltaSize uint64
+	// DeltaBlockSize is the size of the blocks changed data gets split into
+	// in delta mode, zero selects the default of 64KiB
+	DeltaBlockSize int
+	// Journal records the progress of Add, so it can be resumed if it gets
+	// interrupted. Files and chunks recorded in it already are skipped
+	Journal *Journal
+	// Filter selects the files to store. Excluded directories don't get
+	// read at all
+	Filter PathFilter
+	// IgnoreFile is the name of per-directory ignore files, see
+	// IgnoreFileName. Empty disables them
+	IgnoreFile string
+	// OneFileSystem keeps Add from descending into directories on other file
+	// systems than the one of the path being added
+	OneFileSystem bool
+	// FollowSymlinks stores the files and directories symlinks point to
+	// instead of the links themselves
+	FollowSymlinks bool
+}
+
 // Add adds a path to a Snapshot
-func (snapshot *Snapshot) Add(cwd string, paths []string, repository Repository, compress, encrypt bool, dataParts, parityParts uint) (chan Progress, error) {
+func (snapshot *Snapshot) Add(cwd string, paths []string, repository Repository, opts StoreOptions) (chan Progress, error) {
+	if err := opts.Filter.Check(); err != nil {
+		return nil, err
+	}
+	chunker, err := repository.NewChunker(snapshot.volume, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = repository.buildDedupIndex(); err != nil {
+		return nil, err
+	}
+	parentItems := make(map[string]ItemData)
+	if opts.Parent != nil {
+		for _, item := range opts.Parent.Items {
+			parentItems[item.Path] = item
+		}
+	}
+	if opts.Journal != nil {
+		// Resume an interrupted backup: stored files are treated like the
+		// ones unchanged since the parent, stored chunks get deduplicated
+		for _, item := range opts.Journal.Items {
+			parentItems[item.Path] = item
+		}
+		for _, chunk := range opts.Journal.Chunks {
+			repository.dedup.add(chunk)
+		}
+	}
+
 	progress := make(chan Progress)
 	fwd := make(chan ItemData, 256) // TODO: reconsider buffer size
 	m := new(sync.Mutex)
-	var totalSize uint64
+	var totalSize, totalItems uint64
+	var failures uint64
+
+	// failed reports an item that couldn't be read, it won't be part of the
+	// snapshot
+	failed := func(path string, err error) {
+		p := Progress{Path: path, Err: err}
+		m.Lock()
+		failures++
+		p.Statistics.Size = totalSize
+		p.Statistics.Errors = failures
+		m.Unlock()
+		progress <- p
+	}
 
 	go func() {
 		for _, path := range paths {
-			c := findFiles(path)
+			c := findFiles(cwd, path, opts, failed)
 
 			for id := range c {
-				rel, err := filepath.Rel(cwd, id.Path)
-				if err == nil && !strings.HasPrefix(rel, "../") {
-					id.Path = rel
-				}
 				if isSpecialPath(id.Path) {
 					continue
 				}
 				m.Lock()
 				totalSize += id.Size
+				totalItems++
 				m.Unlock()
 				fwd <- id
 			}
@@ -73,61 +165,272 @@ func (snapshot *Snapshot) Add(cwd string, paths []string, repository Repository,
 	}()
 
 	go func() {
-		var totalTransferredSize uint64
-		for id := range fwd {
-			rel, err := filepath.Rel(cwd, id.Path)
-			if err == nil && !strings.HasPrefix(rel, "../") {
-				id.Path = rel
-			}
-			if isSpecialPath(id.Path) {
-				continue
+		// journal stops being written to after the first error, the backup
+		// itself goes on without it
+		journal := opts.Journal
+		journaled := func(err error) {
+			if err != nil {
+				progress <- Progress{Path: journal.path, Err: err}
+				journal = nil
 			}
+		}
 
-			p := newProgress(&id)
+		var totalTransferredSize uint64
+		// All items started, except the current one, are handled. done
+		// includes the bytes read of the current item
+		var handled, started, done uint64
+		meter := newProgressMeter(time.Now())
+		// hardlinks holds the first stored link of each hardlinked file, the
+		// others refer to its data
+		hardlinks := make(map[[2]uint64]ItemData)
+		report := func(id *ItemData) {
+			p := newProgress(id)
 			m.Lock()
 			p.Statistics.Size = totalSize
 			p.Statistics.StorageSize = totalTransferredSize
+			p.Statistics.Errors = failures
+			p.Total = totalSize
+			p.TotalItems = totalItems
 			m.Unlock()
+			p.Done = done
+			p.Items = started - 1
+			meter.update(&p, time.Now())
 			progress <- p
+		}
+
+		for id := range fwd {
+			started++
+			done = handled
+			report(&id)
+			handled += id.Size
 
-			if isRegularFile(id.FileInfo) {
-				dataParts = uint(math.Max(1, float64(dataParts)))
-				chunkchan, err := chunkFile(id.AbsPath, compress, encrypt, repository.Password, int(dataParts), int(parityParts))
+			link, linked := hardlinkKey(id)
+			if first, ok := hardlinks[link]; linked && ok {
+				id.LinkTo = first.Path
+				id.Chunks = first.Chunks
+				id.Data = first.Data
+			} else if parent, ok := parentItems[id.Path]; ok && unchanged(parent, id, chunker.volume, opts) {
+				id.Chunks = parent.Chunks
+				id.Data = parent.Data
+			} else if isRegularFile(id.FileInfo) && id.Size > 0 && id.Size <= opts.InlineSize {
+				data, err := ioutil.ReadFile(id.AbsPath)
 				if err != nil {
-					panic(err)
+					failed(id.Path, err)
+					continue
 				}
-				for cd := range chunkchan {
-					// fmt.Printf("\tSplit %s (#%d, %d bytes), compression: %s, encryption: %s, sha256: %s\n", id.Path, cd.Num, cd.Size, CompressionText(cd.Compressed), EncryptionText(cd.Encrypted), cd.ShaSum)
-
-					// store this chunk
-					n, err := repository.Backend.StoreChunk(cd)
-					if err != nil {
-						panic(err)
+				id.Data = data
+				id.StorageSize = uint64(len(data))
+				totalTransferredSize += id.StorageSize
+			} else if isRegularFile(id.FileInfo) {
+				file, err := os.Open(id.AbsPath)
+				if err != nil {
+					failed(id.Path, err)
+					continue
+				}
+				c := chunker
+				c.compression, c.level = opts.compressionFor(id.Path)
+				var chunks <-chan Chunk
+				var errs <-chan error
+				if opts.DeltaSize > 0 && id.Size >= opts.DeltaSize {
+					parent := parentItems[id.Path]
+					if len(parent.Chunks) > 0 && !reusable(parent.Chunks[0], chunker.volume, opts) {
+						parent.Chunks = nil
+					}
+					chunks, errs = c.DeltaChunks(file, parent.Chunks)
+				} else {
+					chunks, errs = c.Chunks(file)
+				}
+				var storeErr error
+				for sc := range storeChunks(&repository.Backend, chunks, opts.Pipeline.Uploaders) {
+					// fmt.Printf("\tSplit %s (#%d, %d bytes), compression: %s, encryption: %s, sha256: %s\n", id.Path, sc.chunk.Num, sc.chunk.Size, CompressionText(sc.chunk.Compressed), EncryptionText(sc.chunk.Encrypted), sc.chunk.ShaSum)
+					if sc.err != nil || storeErr != nil {
+						// Keep draining the channel, so all workers finish
+						if storeErr == nil {
+							storeErr = sc.err
+						}
+						continue
 					}
 
-					// release the memory, we don't need the data anymore
-					cd.Data = &[][]byte{}
-
-					id.Chunks = append(id.Chunks, cd)
-					id.StorageSize += n
-					totalTransferredSize += n
-
-					p := newProgress(&id)
-					m.Lock()
-					p.Statistics.Size = totalSize
-					p.Statistics.StorageSize = totalTransferredSize
-					m.Unlock()
-					progress <- p
+					if journal != nil {
+						journaled(journal.addChunk(sc.chunk))
+					}
+					repository.dedup.add(sc.chunk)
+					id.Chunks = append(id.Chunks, sc.chunk)
+					id.StorageSize += sc.size
+					totalTransferredSize += sc.size
+					done += uint64(sc.chunk.OriginalSize)
+					report(&id)
+				}
+				file.Close()
+				if err := <-errs; err != nil || storeErr != nil {
+					if storeErr != nil {
+						err = storeErr
+					}
+					failed(id.Path, err)
+					continue
 				}
 			}
 
 			snapshot.AddItem(&id)
+			if _, ok := hardlinks[link]; linked && !ok {
+				hardlinks[link] = id
+			}
+			if journal != nil {
+				journaled(journal.addItem(id))
+			}
 		}
+		snapshot.Stats.Errors += failures
 		close(progress)
 	}()
 	return progress, nil
 }
 
+// AddStream stores the data read from rd until EOF as a file called name,
+// e.g. a database dump. Unlike Add it returns once all data got stored
+func (snapshot *Snapshot) AddStream(name string, rd io.Reader, repository Repository, opts StoreOptions) error {
+	chunker, err := repository.NewChunker(snapshot.volume, opts)
+	if err != nil {
+		return err
+	}
+	chunker.compression, chunker.level = opts.compressionFor(name)
+
+	id := ItemData{
+		Path:    name,
+		Type:    File,
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+	if err = storeStream(chunker, &id, rd, repository, opts); err != nil {
+		return err
+	}
+
+	snapshot.AddItem(&id)
+	return nil
+}
+
+// storeStream stores the data read from rd until EOF as the content of id
+func storeStream(chunker Chunker, id *ItemData, rd io.Reader, repository Repository, opts StoreOptions) error {
+	var err error
+	chunks, errs := chunker.Chunks(rd)
+	for sc := range storeChunks(&repository.Backend, chunks, opts.Pipeline.Uploaders) {
+		if sc.err != nil {
+			// Keep draining the channel, so all workers finish
+			if err == nil {
+				err = sc.err
+			}
+			continue
+		}
+
+		repository.dedup.add(sc.chunk)
+		id.Chunks = append(id.Chunks, sc.chunk)
+		id.Size += uint64(sc.chunk.OriginalSize)
+		id.StorageSize += sc.size
+	}
+	if cerr := <-errs; err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// unchanged returns whether the file id is unchanged since it got stored as
+// parent, so the chunks of parent can be reused. They also need to be
+// encrypted with the same algo and key and split into as many parts as
+// requested
+func unchanged(parent, id ItemData, volumeID string, opts StoreOptions) bool {
+	if !isRegularFile(id.FileInfo) || parent.Type != File || parent.Size != id.Size ||
+		!parent.ModTime.Equal(id.ModTime) || parent.Inode != id.Inode {
+		return false
+	}
+	if len(parent.Chunks) == 0 {
+		// Empty or inlined
+		return uint64(len(parent.Data)) == id.Size
+	}
+
+	return reusable(parent.Chunks[0], volumeID, opts)
+}
+
+// reusable returns whether chunk got encrypted with the requested algo and
+// the key of the volume and split into as many parts as requested
+func reusable(chunk Chunk, volumeID string, opts StoreOptions) bool {
+	dataParts := uint(math.Max(1, float64(opts.DataParts)))
+	if opts.ParityParts == 0 {
+		dataParts = 1
+	}
+	return chunk.Volume == volumeID && chunk.Encrypted == opts.Encryption &&
+		chunk.DataParts == dataParts && chunk.ParityParts == opts.ParityParts
+}
+
+// storedChunk is the result of storing a single chunk
+type storedChunk struct {
+	chunk Chunk
+	size  uint64
+	err   error
+}
+
+// storeChunks stores all chunks it receives, using the given amount of
+// concurrent connections or, if zero, as many as the backends allow. Chunks
+// without data are stored already. Results arrive in no particular order
+func storeChunks(backend *BackendManager, chunks <-chan Chunk, connections int) <-chan storedChunk {
+	results := make(chan storedChunk)
+	wg := &sync.WaitGroup{}
+
+	if connections <= 0 {
+		connections = backend.Connections()
+	}
+	for w := 0; w < connections; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cd := range chunks {
+				if cd.Data == nil {
+					results <- storedChunk{cd, 0, nil}
+					continue
+				}
+				n, err := backend.StoreChunk(&cd)
+
+				// release the memory, we don't need the data anymore
+				cd.Data = &[][]byte{}
+				results <- storedChunk{cd, n, err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// HasTags returns whether the snapshot carries all of the tags
+func (snapshot *Snapshot) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range snapshot.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FindItem returns the item stored at path
+func (snapshot *Snapshot) FindItem(path string) (*ItemData, error) {
+	path = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+	for i, item := range snapshot.Items {
+		if strings.Trim(filepath.ToSlash(item.Path), "/") == path {
+			return &snapshot.Items[i], nil
+		}
+	}
+
+	return nil, ErrItemNotFound
+}
+
 // Clone clones a snapshot
 func (snapshot *Snapshot) Clone() (*Snapshot, error) {
 	s, err := NewSnapshot(snapshot.Description)
@@ -137,16 +440,31 @@ func (snapshot *Snapshot) Clone() (*Snapshot, error) {
 
 	s.Stats = snapshot.Stats
 	s.Items = snapshot.Items
+	s.volume = snapshot.volume
 
 	return &s, nil
 }
 
 // OpenSnapshot opens an existing snapshot
-func openSnapshot(id string, repository *Repository) (Snapshot, error) {
-	snapshot := Snapshot{}
+func openSnapshot(id string, volume *Volume, repository *Repository) (Snapshot, error) {
+	snapshot := Snapshot{volume: volume}
 	b, err := repository.Backend.LoadSnapshot(id)
+	if err != nil {
+		return snapshot, err
+	}
+	secret, err := repository.volumeSecret(volume)
+	if err != nil {
+		return snapshot, err
+	}
 
-	decb, err := Decrypt(b, repository.Password)
+	signature, b := splitSignature(b)
+	if signature != nil || repository.Signed {
+		if err = checkSignature(secret, "snapshot/"+id, signature, b); err != nil {
+			return snapshot, err
+		}
+	}
+
+	decb, err := repository.decryptVolume(volume, b)
 	if err == nil {
 		err = json.Unmarshal(decb, &snapshot)
 	}
@@ -162,9 +480,13 @@ func (snapshot *Snapshot) Save(repository *Repository) error {
 	}
 	//	fmt.Printf("Repository created: %s\n", string(b))
 
-	encb, err := Encrypt(b, repository.Password)
+	secret, err := repository.volumeSecret(snapshot.volume)
+	if err != nil {
+		return err
+	}
+	encb, err := repository.encryptVolume(snapshot.volume, b)
 	if err == nil {
-		err = repository.Backend.SaveSnapshot(snapshot.ID, encb)
+		err = repository.Backend.SaveSnapshot(snapshot.ID, sign(secret, "snapshot/"+snapshot.ID, encb))
 	}
 	return err
 }
@@ -172,7 +494,7 @@ func (snapshot *Snapshot) Save(repository *Repository) error {
 // AddItem adds an item to a snapshot
 func (snapshot *Snapshot) AddItem(id *ItemData) {
 	items := []ItemData{}
-	stats := Stats{}
+	stats := Stats{Errors: snapshot.Stats.Errors}
 
 	found := false
 	for _, i := range snapshot.Items {
@@ -8,12 +8,15 @@
 package knoxite
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func shasumFile(path string) (string, error) {
@@ -65,7 +68,7 @@ func TestCreateSnapshot(t *testing.T) {
 			t.Errorf("Failed getting working dir: %s", err)
 			return
 		}
-		progress, err := snapshot.Add(wd, []string{"snapshot_test.go"}, r, false, true, 1, 0)
+		progress, err := snapshot.Add(wd, []string{"snapshot_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
 		if err != nil {
 			t.Errorf("Failed adding to snapshot: %s", err)
 		}
@@ -182,3 +185,421 @@ func TestFindUnknownSnapshot(t *testing.T) {
 		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
 	}
 }
+
+func TestParentSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	if err = ioutil.WriteFile(path, []byte("original data"), 0644); err != nil {
+		t.Errorf("Failed writing file: %s", err)
+		return
+	}
+	fi, _ := os.Stat(path)
+
+	r, err := NewRepository("memory://parent", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	encryption := EncryptionAESGCM
+	store := func(parent *Snapshot) Snapshot {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		progress, err := snapshot.Add(dir, []string{path}, r, StoreOptions{Encryption: encryption, DataParts: 1, Parent: parent})
+		if err != nil {
+			t.Errorf("Failed adding to snapshot: %s", err)
+			return snapshot
+		}
+		for range progress {
+		}
+		snapshot.Save(&r)
+		vol.AddSnapshot(snapshot.ID)
+		return snapshot
+	}
+	store(nil)
+
+	// Same size and modification time, the file gets considered unchanged
+	ioutil.WriteFile(path, []byte("modified data"), 0644)
+	os.Chtimes(path, fi.ModTime(), fi.ModTime())
+	parent, err := vol.LatestSnapshot(&r)
+	if err != nil {
+		t.Errorf("Failed loading latest snapshot: %s", err)
+		return
+	}
+	snapshot := store(&parent)
+	if snapshot.Items[0].Chunks[0].ShaSum != parent.Items[0].Chunks[0].ShaSum {
+		t.Errorf("Chunks of unchanged file did not get reused")
+	}
+
+	os.Chtimes(path, fi.ModTime(), fi.ModTime().Add(time.Second))
+	parent, _ = vol.LatestSnapshot(&r)
+	snapshot = store(&parent)
+	if snapshot.Items[0].Chunks[0].ShaSum == parent.Items[0].Chunks[0].ShaSum {
+		t.Errorf("Changed file did not get stored again")
+	}
+
+	// Unchanged files get stored again if a different encryption algo got
+	// requested
+	encryption = EncryptionChaCha20
+	parent, _ = vol.LatestSnapshot(&r)
+	snapshot = store(&parent)
+	if chunk := snapshot.Items[0].Chunks[0]; chunk.Encrypted != EncryptionChaCha20 {
+		t.Errorf("Expected chunk to be encrypted with %d, got %d", EncryptionChaCha20, chunk.Encrypted)
+	}
+}
+
+func TestInlineFiles(t *testing.T) {
+	r, err := NewRepository("memory://inline", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"snapshot_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1, InlineSize: 1 << 20})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+	snapshot.Save(&r)
+	vol.AddSnapshot(snapshot.ID)
+
+	if chunks, _ := r.Backend.ListChunks(); len(chunks) != 0 {
+		t.Errorf("Expected no chunks for inlined file, got %d", len(chunks))
+	}
+
+	s, err := vol.LoadSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Errorf("Failed loading snapshot: %s", err)
+		return
+	}
+	item := s.Items[0]
+	orig, _ := ioutil.ReadFile("snapshot_test.go")
+	data, _, err := DecodeArchiveData(r, item)
+	if err != nil || string(data) != string(orig) {
+		t.Errorf("Failed decoding inlined file: %v", err)
+	}
+	d, err := ReadArchive(r, item, 10, 20)
+	if err != nil || string(*d) != string(orig[10:30]) {
+		t.Errorf("Failed reading inlined file: %v", err)
+	}
+	if _, err = ReadArchive(r, item, len(orig), 20); err != io.EOF {
+		t.Errorf("Expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestAddStream(t *testing.T) {
+	r, err := NewRepository("memory://stream", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	orig, _ := ioutil.ReadFile("snapshot_test.go")
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	err = snapshot.AddStream("dump.sql", bytes.NewReader(orig), r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding stream: %s", err)
+		return
+	}
+
+	item := snapshot.Items[0]
+	if item.Path != "dump.sql" || item.Size != uint64(len(orig)) {
+		t.Errorf("Unexpected item %s of size %d", item.Path, item.Size)
+	}
+	data, _, err := DecodeArchiveData(r, item)
+	if err != nil || !bytes.Equal(data, orig) {
+		t.Errorf("Failed decoding stream: %v", err)
+	}
+}
+
+func TestFindItem(t *testing.T) {
+	snapshot := Snapshot{Items: []ItemData{
+		{Path: "src", Type: Directory},
+		{Path: "src/main.go", Type: File},
+	}}
+
+	for _, path := range []string{"src/main.go", "/src/main.go", "src//main.go"} {
+		item, err := snapshot.FindItem(path)
+		if err != nil || item.Path != "src/main.go" {
+			t.Errorf("Failed finding %s: %v", path, err)
+		}
+	}
+	if _, err := snapshot.FindItem("src/other.go"); err != ErrItemNotFound {
+		t.Errorf("Expected %v, got %v", ErrItemNotFound, err)
+	}
+}
+
+func TestAddFiltered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "src", "cache"), 0755)
+	for _, name := range []string{"src/main.go", "src/main.tmp", "src/cache/data"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Errorf("Failed writing file: %s", err)
+			return
+		}
+	}
+
+	r, err := NewRepository("memory://filtered", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	filter := PathFilter{Exclude: []string{"*.tmp", "cache"}}
+	progress, err := snapshot.Add(dir, []string{filepath.Join(dir, "src")}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1, Filter: filter})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+
+	paths := []string{}
+	for _, item := range snapshot.Items {
+		paths = append(paths, item.Path)
+	}
+	if len(paths) != 2 || paths[0] != "src" || paths[1] != filepath.Join("src", "main.go") {
+		t.Errorf("Expected src and src/main.go to be stored, got %v", paths)
+	}
+}
+
+func TestAddUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "readable"), []byte("data"), 0644); err != nil {
+		t.Errorf("Failed writing file: %s", err)
+		return
+	}
+
+	r, err := NewRepository("memory://unreadable", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	targets := []string{filepath.Join(dir, "readable"), filepath.Join(dir, "missing")}
+	progress, err := snapshot.Add(dir, targets, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	failed := []string{}
+	for p := range progress {
+		if p.Err != nil {
+			failed = append(failed, p.Path)
+		}
+	}
+
+	if len(failed) != 1 || failed[0] != targets[1] {
+		t.Errorf("Expected %s to fail, got %v", targets[1], failed)
+	}
+	if len(snapshot.Items) != 1 || snapshot.Items[0].Path != "readable" {
+		t.Errorf("Expected only the readable file to be stored, got %v", snapshot.Items)
+	}
+	if snapshot.Stats.Errors != 1 {
+		t.Errorf("Expected 1 error, got %d", snapshot.Stats.Errors)
+	}
+}
+
+func TestAddStoreFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	if err = ioutil.WriteFile(path, []byte("some data to back up"), 0644); err != nil {
+		t.Errorf("Failed writing file: %s", err)
+		return
+	}
+
+	r, err := NewRepository("memory://store-failure", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	var failing Backend = failingStorage{NewStorageMemory()}
+	r.Backend = BackendManager{}
+	r.Backend.AddBackend(&failing)
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	progress, err := snapshot.Add(dir, []string{path}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	failed := []string{}
+	for p := range progress {
+		if p.Err != nil {
+			failed = append(failed, p.Path)
+		}
+	}
+
+	if len(failed) != 1 || failed[0] != "data" {
+		t.Errorf("Expected data to fail, got %v", failed)
+	}
+	if len(snapshot.Items) != 0 || snapshot.Stats.Errors != 1 {
+		t.Errorf("Expected the file to be skipped, got %d items and %d errors", len(snapshot.Items), snapshot.Stats.Errors)
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("restore"), 1024)
+	ioutil.WriteFile(filepath.Join(dir, "a"), data, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b"), data, 0644)
+
+	r, err := NewRepository("memory://restore-failure", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	progress, err := snapshot.Add(dir, []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+
+	// A directory in the way of a file makes it fail, the others still get
+	// restored
+	targetdir, _ := ioutil.TempDir("", "knoxite.target")
+	defer os.RemoveAll(targetdir)
+	os.Mkdir(filepath.Join(targetdir, "a"), 0755)
+	progress, err = DecodeSnapshot(r, snapshot, targetdir)
+	if err != nil {
+		t.Errorf("Failed restoring snapshot: %s", err)
+		return
+	}
+	failed := []string{}
+	for p := range progress {
+		if p.Err != nil {
+			failed = append(failed, p.Path)
+		}
+	}
+
+	if len(failed) != 1 || failed[0] != "a" {
+		t.Errorf("Expected a to fail, got %v", failed)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(targetdir, "b"))
+	if err != nil || !bytes.Equal(b, data) {
+		t.Errorf("Failed restoring b: %v", err)
+	}
+}
+
+func TestHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("hardlink"), 1024)
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "src", "a"), data, 0644)
+	if err = os.Link(filepath.Join(dir, "src", "a"), filepath.Join(dir, "src", "b")); err != nil {
+		t.Skipf("Hardlinks not supported: %s", err)
+	}
+
+	r, err := NewRepository("memory://hardlinks", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	progress, err := snapshot.Add(dir, []string{filepath.Join(dir, "src")}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+
+	a, _ := snapshot.FindItem("src/a")
+	b, err := snapshot.FindItem("src/b")
+	if err != nil {
+		t.Errorf("Failed finding hardlink: %s", err)
+		return
+	}
+	if b.LinkTo != a.Path || b.StorageSize != 0 || len(b.Chunks) != len(a.Chunks) {
+		t.Errorf("Expected src/b to link to src/a without storing its data, got %s (%d bytes)", b.LinkTo, b.StorageSize)
+	}
+
+	targetdir, _ := ioutil.TempDir("", "knoxite.target")
+	defer os.RemoveAll(targetdir)
+	progress, err = DecodeSnapshot(r, snapshot, targetdir)
+	if err != nil {
+		t.Errorf("Failed restoring snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+	fa, _ := os.Stat(filepath.Join(targetdir, "src", "a"))
+	fb, err := os.Stat(filepath.Join(targetdir, "src", "b"))
+	if err != nil || !os.SameFile(fa, fb) {
+		t.Errorf("Expected src/b to be restored as a hardlink of src/a")
+	}
+
+	// Without the file it links to, the hardlink gets restored as a copy
+	filtered, _ := snapshot.Filter(PathFilter{Exclude: []string{"src/a"}})
+	targetdir, _ = ioutil.TempDir("", "knoxite.target")
+	defer os.RemoveAll(targetdir)
+	progress, _ = DecodeSnapshot(r, filtered, targetdir)
+	for range progress {
+	}
+	b2, err := ioutil.ReadFile(filepath.Join(targetdir, "src", "b"))
+	if err != nil || !bytes.Equal(b2, data) {
+		t.Errorf("Failed restoring hardlink without its target: %v", err)
+	}
+}
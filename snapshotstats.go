@@ -0,0 +1,169 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// SnapshotStats describes the data of a snapshot
+type SnapshotStats struct {
+	ID   string
+	Date time.Time
+	// Stats counts the items of the snapshot and their original size
+	Stats Stats
+	// Chunks is the amount of distinct chunks the snapshot refers to
+	Chunks uint64
+	// DataSize is the original size of these chunks plus all inlined files,
+	// i.e. the data left after deduplication
+	DataSize uint64
+	// StorageSize is the size of that data as stored, after compression and
+	// encryption
+	StorageSize uint64
+	// Growth is the original size of the data the previous snapshot of the
+	// volume didn't refer to, i.e. what this snapshot added
+	Growth uint64
+}
+
+// VolumeStats describes the data of all snapshots of a volume
+type VolumeStats struct {
+	Snapshots []SnapshotStats
+	// Chunks is the amount of distinct chunks of all snapshots
+	Chunks uint64
+	// DataSize and StorageSize are the original and the stored size of all
+	// distinct chunks and inlined files
+	DataSize    uint64
+	StorageSize uint64
+}
+
+// CompressionRatio returns the ratio of the original size to the stored size
+func (s SnapshotStats) CompressionRatio() float64 {
+	return compressionRatio(s.DataSize, s.StorageSize)
+}
+
+// CompressionRatio returns the ratio of the original size to the stored size
+func (s VolumeStats) CompressionRatio() float64 {
+	return compressionRatio(s.DataSize, s.StorageSize)
+}
+
+func compressionRatio(size, storageSize uint64) float64 {
+	if storageSize == 0 {
+		return 1
+	}
+	return float64(size) / float64(storageSize)
+}
+
+// Stats gathers the statistics of all snapshots of the volume
+func (v *Volume) Stats(repository *Repository) (VolumeStats, error) {
+	stats := VolumeStats{}
+	seen := make(map[string]bool)
+	var previous map[string]bool
+	for _, id := range v.Snapshots {
+		snapshot, err := v.LoadSnapshot(id, repository)
+		if err != nil {
+			return stats, err
+		}
+
+		s, chunks := snapshotStats(snapshot, previous)
+		stats.Snapshots = append(stats.Snapshots, s)
+		previous = chunks
+
+		for _, item := range snapshot.Items {
+			// Inlined data is stored within each snapshot
+			stats.DataSize += uint64(len(item.Data))
+			stats.StorageSize += uint64(len(item.Data))
+			for _, chunk := range item.Chunks {
+				key := chunkKey(chunk)
+				if !seen[key] {
+					seen[key] = true
+					stats.Chunks++
+					stats.DataSize += uint64(chunk.OriginalSize)
+					stats.StorageSize += uint64(chunk.Size)
+				}
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// SnapshotStats gathers the statistics of the snapshot with the given ID.
+// Its growth gets determined by comparing it to the previous snapshot
+func (v *Volume) SnapshotStats(repository *Repository, id string) (SnapshotStats, error) {
+	var previous map[string]bool
+	for i, sid := range v.Snapshots {
+		if sid != id {
+			continue
+		}
+		if i > 0 {
+			parent, err := v.LoadSnapshot(v.Snapshots[i-1], repository)
+			if err != nil {
+				return SnapshotStats{}, err
+			}
+			_, previous = snapshotStats(parent, nil)
+		}
+
+		snapshot, err := v.LoadSnapshot(id, repository)
+		if err != nil {
+			return SnapshotStats{}, err
+		}
+		s, _ := snapshotStats(snapshot, previous)
+		return s, nil
+	}
+
+	return SnapshotStats{}, ErrSnapshotNotFound
+}
+
+// snapshotStats gathers the statistics of a snapshot. It also returns the
+// keys of the snapshot's data, which get passed as previous for the snapshot
+// after it
+func snapshotStats(snapshot Snapshot, previous map[string]bool) (SnapshotStats, map[string]bool) {
+	s := SnapshotStats{ID: snapshot.ID, Date: snapshot.Date}
+	chunks := make(map[string]bool)
+	for i := range snapshot.Items {
+		item := &snapshot.Items[i]
+		s.Stats.AddItem(item)
+		if len(item.Data) > 0 {
+			s.DataSize += uint64(len(item.Data))
+			s.StorageSize += uint64(len(item.Data))
+			// Inlined files only count as growth if they changed
+			sum := sha256.Sum256(item.Data)
+			key := "inline_" + hex.EncodeToString(sum[:])
+			chunks[key] = true
+			if !previous[key] {
+				s.Growth += uint64(len(item.Data))
+			}
+		}
+
+		for _, chunk := range item.Chunks {
+			key := chunkKey(chunk)
+			if chunks[key] {
+				continue
+			}
+			chunks[key] = true
+			s.Chunks++
+			s.DataSize += uint64(chunk.OriginalSize)
+			s.StorageSize += uint64(chunk.Size)
+			if !previous[key] {
+				s.Growth += uint64(chunk.OriginalSize)
+			}
+		}
+	}
+	// The storage size recorded while storing only covers new data
+	s.Stats.StorageSize = s.StorageSize
+
+	return s, chunks
+}
+
+// chunkKey identifies the parts of a chunk in the storage backends
+func chunkKey(chunk Chunk) string {
+	return chunk.ShaSum + "_" + strconv.FormatUint(uint64(chunk.DataParts), 10)
+}
@@ -0,0 +1,77 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestVolumeStats(t *testing.T) {
+	r, err := NewRepository("memory://stats", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	a := make([]byte, 64*1024)
+	b := make([]byte, 32*1024)
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(a)
+	rnd.Read(b)
+
+	store := func(files map[string][]byte) {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		for name, data := range files {
+			err := snapshot.AddStream(name, bytes.NewReader(data), r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+			if err != nil {
+				t.Errorf("Failed adding stream: %s", err)
+			}
+		}
+		snapshot.Save(&r)
+		vol.AddSnapshot(snapshot.ID)
+	}
+	store(map[string][]byte{"a": a})
+	store(map[string][]byte{"a": a, "b": b})
+
+	stats, err := vol.Stats(&r)
+	if err != nil {
+		t.Errorf("Failed gathering volume stats: %s", err)
+		return
+	}
+	if len(stats.Snapshots) != 2 {
+		t.Errorf("Expected stats of 2 snapshots, got %d", len(stats.Snapshots))
+		return
+	}
+	if stats.DataSize != uint64(len(a)+len(b)) {
+		t.Errorf("Expected deduplicated size of %d, got %d", len(a)+len(b), stats.DataSize)
+	}
+	if stats.CompressionRatio() >= 1 {
+		t.Errorf("Random data can't be compressed, got ratio %.2f", stats.CompressionRatio())
+	}
+
+	first, second := stats.Snapshots[0], stats.Snapshots[1]
+	if first.Growth != uint64(len(a)) || second.Growth != uint64(len(b)) {
+		t.Errorf("Expected growth of %d and %d, got %d and %d", len(a), len(b), first.Growth, second.Growth)
+	}
+	if second.Stats.Files != 2 || second.Stats.Size != uint64(len(a)+len(b)) {
+		t.Errorf("Unexpected stats of second snapshot: %s", second.Stats.String())
+	}
+
+	s, err := vol.SnapshotStats(&r, second.ID)
+	if err != nil {
+		t.Errorf("Failed gathering snapshot stats: %s", err)
+		return
+	}
+	if s != second {
+		t.Errorf("Snapshot stats differ from the volume's: %+v vs %+v", s, second)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"os"
+)
+
+// Error declarations
+var (
+	ErrSpecialUnsupported = errors.New("Special files are not supported on this platform")
+)
+
+// specialType returns the type of character and block devices, FIFOs and
+// unix sockets. ok is false for all other items
+func specialType(fi os.FileInfo) (typ uint, ok bool) {
+	mode := fi.Mode()
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		return CharDevice, true
+	case mode&os.ModeDevice != 0:
+		return BlockDevice, true
+	case mode&os.ModeNamedPipe != 0:
+		return NamedPipe, true
+	case mode&os.ModeSocket != 0:
+		return Socket, true
+	}
+	return 0, false
+}
+
+// isSpecial returns whether items of type typ are special files
+func isSpecial(typ uint) bool {
+	return typ == CharDevice || typ == BlockDevice || typ == NamedPipe || typ == Socket
+}
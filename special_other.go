@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!linux,!netbsd,!openbsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+func mknod(path string, item ItemData) error {
+	return ErrSpecialUnsupported
+}
+
+func devNumbers(rdev uint64) (major, minor uint32) {
+	return 0, 0
+}
+
+func mkdev(major, minor uint32) uint64 {
+	return 0
+}
@@ -0,0 +1,107 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpecialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	err = mknod(filepath.Join(dir, "src", "fifo"), ItemData{Type: NamedPipe, Mode: 0640})
+	if err == ErrSpecialUnsupported {
+		t.Skip("Special files not supported")
+	}
+	if err != nil {
+		t.Errorf("Failed creating FIFO: %s", err)
+		return
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "src", "socket"))
+	if err != nil {
+		t.Errorf("Failed creating socket: %s", err)
+		return
+	}
+	defer l.Close()
+	// Creating devices requires root privileges
+	null := mkdev(1, 3)
+	device := mknod(filepath.Join(dir, "src", "null"), ItemData{Type: CharDevice, Mode: 0666, Rdev: null}) == nil
+
+	r, err := NewRepository("memory://special", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	progress, err := snapshot.Add(dir, []string{filepath.Join(dir, "src")}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+
+	expected := map[string]uint{"src/fifo": NamedPipe, "src/socket": Socket}
+	if device {
+		expected["src/null"] = CharDevice
+	}
+	for path, typ := range expected {
+		item, err := snapshot.FindItem(path)
+		if err != nil {
+			t.Errorf("Failed finding %s: %s", path, err)
+			continue
+		}
+		if item.Type != typ || len(item.Chunks) > 0 || item.Data != nil {
+			t.Errorf("Expected %s to be stored as type %d without data, got %+v", path, typ, item)
+		}
+		if typ == CharDevice && item.Rdev != null {
+			t.Errorf("Expected device number %d, got %d", null, item.Rdev)
+		}
+	}
+
+	targetdir, _ := ioutil.TempDir("", "knoxite.target")
+	defer os.RemoveAll(targetdir)
+	progress, err = DecodeSnapshot(r, snapshot, targetdir)
+	if err != nil {
+		t.Errorf("Failed restoring snapshot: %s", err)
+		return
+	}
+	for p := range progress {
+		if p.Err != nil {
+			t.Errorf("Failed restoring %s: %s", p.Path, p.Err)
+		}
+	}
+
+	modes := map[string]os.FileMode{"src/fifo": os.ModeNamedPipe, "src/socket": os.ModeSocket}
+	if device {
+		modes["src/null"] = os.ModeDevice | os.ModeCharDevice
+	}
+	for path, mode := range modes {
+		fi, err := os.Lstat(filepath.Join(targetdir, path))
+		if err != nil {
+			t.Errorf("Failed restoring %s: %s", path, err)
+			continue
+		}
+		if fi.Mode()&os.ModeType != mode {
+			t.Errorf("Expected %s to be restored with mode %v, got %v", path, mode, fi.Mode())
+		}
+	}
+}
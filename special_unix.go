@@ -0,0 +1,47 @@
+//go:build darwin || dragonfly || linux || netbsd || openbsd
+// +build darwin dragonfly linux netbsd openbsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates the special file item at path
+func mknod(path string, item ItemData) error {
+	mode := uint32(item.Mode.Perm())
+	switch item.Type {
+	case CharDevice:
+		mode |= unix.S_IFCHR
+	case BlockDevice:
+		mode |= unix.S_IFBLK
+	case NamedPipe:
+		mode |= unix.S_IFIFO
+	case Socket:
+		mode |= unix.S_IFSOCK
+	}
+
+	if err := unix.Mknod(path, mode, int(item.Rdev)); err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	return nil
+}
+
+// devNumbers splits a device number into its major and minor number
+func devNumbers(rdev uint64) (major, minor uint32) {
+	return unix.Major(rdev), unix.Minor(rdev)
+}
+
+// mkdev returns the device number of a major and minor number
+func mkdev(major, minor uint32) uint64 {
+	return unix.Mkdev(major, minor)
+}
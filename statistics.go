@@ -8,7 +8,15 @@
 package knoxite
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error declarations
+var (
+	ErrInvalidSize = errors.New("Invalid size, expected a number with an optional unit (K, M, G, T)")
 )
 
 // Stats contains a bunch of Stats counters
@@ -70,6 +78,36 @@ func SizeToString(size uint64) (str string) {
 	return
 }
 
+// ParseSize parses human-readable sizes like "512K" or "2MiB". Units are
+// binary, a plain number is taken as bytes
+func ParseSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	mult := uint64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	size, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSize
+	}
+	return size * mult, nil
+}
+
 // String returns human-readable Stats
 func (s Stats) String() string {
 	return fmt.Sprintf("%d files, %d dirs, %d symlinks, %d errors, %v Original Size, %v Storage Size",
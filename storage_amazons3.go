@@ -25,15 +25,21 @@ type StorageAmazonS3 struct {
 	snapshotBucket   string
 	repositoryBucket string
 	region           string
+	metadata         map[string][]string
 	client           *minio.Client
+	limits           *transferLimits
 }
 
 // Error declarations
 var (
-	ErrInvalidUsername = errors.New("Username wrong or missing")
+	ErrInvalidUsername             = errors.New("Username wrong or missing")
+	ErrInvalidServerSideEncryption = errors.New("Invalid server-side encryption, expected AES256 or aws:kms")
 )
 
 // NewStorageAmazonS3 returns a StorageAmazonS3 object
+// Server-side encryption can be requested with the "sse" URL parameter: use
+// sse=AES256 for S3-managed keys or sse=aws:kms for KMS-managed keys, where
+// "sse-kms-key-id" optionally selects a key other than the default one
 func NewStorageAmazonS3(URL url.URL) (*StorageAmazonS3, error) {
 	ssl := true
 	switch URL.Scheme {
@@ -59,13 +65,27 @@ func NewStorageAmazonS3(URL url.URL) (*StorageAmazonS3, error) {
 		return &StorageAmazonS3{}, ErrInvalidRepositoryURL
 	}
 
+	metadata, err := s3Metadata(URL.Query())
+	if err != nil {
+		return &StorageAmazonS3{}, err
+	}
+
+	limits := newTransferLimits()
+	transport, err := newHTTPTransport(URL, limits)
+	if err != nil {
+		return &StorageAmazonS3{}, err
+	}
+
 	cl, err := minio.New(URL.Host, URL.User.Username(), pw, ssl)
 	if err != nil {
 		return &StorageAmazonS3{}, err
 	}
+	cl.SetCustomTransport(transport)
 
 	return &StorageAmazonS3{url: URL,
 		client:           cl,
+		limits:           limits,
+		metadata:         metadata,
 		region:           regionAndBucketPrefix[1],
 		chunkBucket:      regionAndBucketPrefix[2] + "-chunks",
 		snapshotBucket:   regionAndBucketPrefix[2] + "-snapshots",
@@ -73,6 +93,42 @@ func NewStorageAmazonS3(URL url.URL) (*StorageAmazonS3, error) {
 	}, nil
 }
 
+// s3Metadata returns the headers sent along with every uploaded object
+func s3Metadata(query url.Values) (map[string][]string, error) {
+	metadata := map[string][]string{
+		"Content-Type": {"application/octet-stream"},
+	}
+
+	switch sse := query.Get("sse"); sse {
+	case "":
+		if query.Get("sse-kms-key-id") != "" {
+			return metadata, ErrInvalidServerSideEncryption
+		}
+	case "AES256":
+		metadata["X-Amz-Server-Side-Encryption"] = []string{sse}
+	case "aws:kms":
+		metadata["X-Amz-Server-Side-Encryption"] = []string{sse}
+		if key := query.Get("sse-kms-key-id"); key != "" {
+			metadata["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"] = []string{key}
+		}
+	default:
+		// Customer-supplied keys (SSE-C) would have to accompany every
+		// download as well, which the S3 client doesn't support
+		return metadata, ErrInvalidServerSideEncryption
+	}
+
+	return metadata, nil
+}
+
+// putObject uploads data, requesting server-side encryption if configured
+func (backend *StorageAmazonS3) putObject(bucket, name string, data []byte) (int64, error) {
+	return backend.client.PutObjectWithMetadata(bucket, name, bytes.NewReader(data), backend.metadata, nil)
+}
+
+func (backend *StorageAmazonS3) transferLimits() *transferLimits {
+	return backend.limits
+}
+
 // Location returns the type and location of the repository
 func (backend *StorageAmazonS3) Location() string {
 	return backend.url.String()
@@ -109,20 +165,40 @@ func (backend *StorageAmazonS3) LoadChunk(shasum string, part, totalParts uint)
 	return &data, err
 }
 
-// StoreChunk stores a single Chunk on network
-func (backend *StorageAmazonS3) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (size uint64, err error) {
+// StatChunk returns the size of a Chunk stored on network
+func (backend *StorageAmazonS3) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	info, err := backend.client.StatObject(backend.chunkBucket, fileName)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size), nil
+}
 
-	if _, err = backend.client.StatObject(backend.chunkBucket, fileName); err == nil {
+// StoreChunk stores a single Chunk on network
+func (backend *StorageAmazonS3) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (size uint64, err error) {
+	if size, err = backend.StatChunk(shasum, part, totalParts); err == nil && size == uint64(len(*data)) {
 		// Chunk is already stored
 		return 0, nil
 	}
 
-	buf := bytes.NewBuffer(*data)
-	i, err := backend.client.PutObject(backend.chunkBucket, fileName, buf, "application/octet-stream")
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+
+	i, err := backend.putObject(backend.chunkBucket, fileName, *data)
 	return uint64(i), err
 }
 
+// DeleteChunk deletes a single Chunk
+func (backend *StorageAmazonS3) DeleteChunk(shasum string, part, totalParts uint) error {
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	return backend.client.RemoveObject(backend.chunkBucket, fileName)
+}
+
+// ListChunks returns the names of all stored Chunks
+func (backend *StorageAmazonS3) ListChunks() ([]string, error) {
+	return backend.listObjects(backend.chunkBucket)
+}
+
 // LoadSnapshot loads a snapshot
 func (backend *StorageAmazonS3) LoadSnapshot(id string) ([]byte, error) {
 	obj, err := backend.client.GetObject(backend.snapshotBucket, id)
@@ -134,11 +210,20 @@ func (backend *StorageAmazonS3) LoadSnapshot(id string) ([]byte, error) {
 
 // SaveSnapshot stores a snapshot
 func (backend *StorageAmazonS3) SaveSnapshot(id string, data []byte) error {
-	buf := bytes.NewBuffer(data)
-	_, err := backend.client.PutObject(backend.snapshotBucket, id, buf, "application/octet-stream")
+	_, err := backend.putObject(backend.snapshotBucket, id, data)
 	return err
 }
 
+// DeleteSnapshot deletes a snapshot
+func (backend *StorageAmazonS3) DeleteSnapshot(id string) error {
+	return backend.client.RemoveObject(backend.snapshotBucket, id)
+}
+
+// ListSnapshots returns the IDs of all stored snapshots
+func (backend *StorageAmazonS3) ListSnapshots() ([]string, error) {
+	return backend.listObjects(backend.snapshotBucket)
+}
+
 // InitRepository creates a new repository
 func (backend *StorageAmazonS3) InitRepository() error {
 	chunkBucketExist, err := backend.client.BucketExists(backend.chunkBucket)
@@ -194,7 +279,26 @@ func (backend *StorageAmazonS3) LoadRepository() ([]byte, error) {
 
 // SaveRepository stores the metadata for a repository
 func (backend *StorageAmazonS3) SaveRepository(data []byte) error {
-	buf := bytes.NewBuffer(data)
-	_, err := backend.client.PutObject(backend.repositoryBucket, repoFilename, buf, "application/octet-stream")
+	_, err := backend.putObject(backend.repositoryBucket, repoFilename, data)
 	return err
 }
+
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend *StorageAmazonS3) ListRepositoryParts() ([]string, error) {
+	return backend.listObjects(backend.repositoryBucket)
+}
+
+// listObjects returns the keys of all objects stored in bucket
+func (backend *StorageAmazonS3) listObjects(bucket string) ([]string, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	keys := []string{}
+	for obj := range backend.client.ListObjects(bucket, "", true, done) {
+		if obj.Err != nil {
+			return keys, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
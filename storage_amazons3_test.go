@@ -57,6 +57,24 @@ func TestNewStorageAmazonS3(t *testing.T) {
 	if err == nil {
 		t.Error(ErrInvalidRepositoryURL)
 	}
+
+	kmsURL, err := url.Parse("s3://" + accesskey + ":" + secretkey + "@127.0.0.1:9000/us-east-1/test?sse=aws:kms&sse-kms-key-id=alias/knoxite")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = NewStorageAmazonS3(*kmsURL)
+	if err != nil {
+		t.Error(err)
+	}
+
+	invalidSSE, err := url.Parse("s3://" + accesskey + ":" + secretkey + "@127.0.0.1:9000/us-east-1/test?sse=DES")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = NewStorageAmazonS3(*invalidSSE)
+	if err != ErrInvalidServerSideEncryption {
+		t.Error(ErrInvalidServerSideEncryption)
+	}
 }
 
 func TestStorageAmazonS3Location(t *testing.T) {
@@ -0,0 +1,560 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *     Copyright (c) 2016, Nicolas Martin <penguwingithub@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// b2AuthorizeURL is where sessions with the native B2 API get started
+var b2AuthorizeURL = "https://api.backblazeb2.com/b2api/v1/b2_authorize_account"
+
+const (
+	// Parts of a large file must be at least 5MB, except for the last one
+	b2MinimumPartSize = 5 * (1 << 20)
+
+	// Chunk parts of at least b2LargeFileSize, e.g. with a raised
+	// --max-chunk-size, get uploaded in parts with the large file API.
+	// Smaller ones aren't worth its extra calls
+	b2LargeFileSize = 4 * b2MinimumPartSize
+)
+
+// Error declarations
+var (
+	ErrB2LargeFileFailed = errors.New("Uploading large file to B2 failed")
+)
+
+// StorageB2 stores data on a remote Backblaze B2 bucket
+type StorageB2 struct {
+	url            url.URL
+	accountID      string
+	applicationKey string
+	repositoryFile string
+	bucketName     string
+	bucket         *backblaze.Bucket
+	backblaze      *backblaze.B2
+	client         *http.Client
+
+	// mutex guards the session with the native API and the bucket ID, both
+	// get looked up once and reused
+	mutex    sync.Mutex
+	auth     *b2Authorization
+	bucketID string
+}
+
+// b2Authorization holds the session data returned by b2_authorize_account
+type b2Authorization struct {
+	AccountID          string `json:"accountId"`
+	APIURL             string `json:"apiUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+
+	client *http.Client
+}
+
+// b2Error is an error returned by the native B2 API
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("B2 API error (%d): %s", e.Status, e.Message)
+}
+
+// NewStorageB2 returns a StorageB2 object
+// The account ID and application key are taken from the URL or, when missing
+// there, from the B2_ACCOUNT_ID and B2_ACCOUNT_KEY environment variables
+func NewStorageB2(URL url.URL) (*StorageB2, error) {
+	// Checking username and password
+	accountID := os.Getenv("B2_ACCOUNT_ID")
+	applicationKey := os.Getenv("B2_ACCOUNT_KEY")
+	if URL.User != nil {
+		if URL.User.Username() != "" {
+			accountID = URL.User.Username()
+		}
+		if pw, pwexist := URL.User.Password(); pwexist {
+			applicationKey = pw
+		}
+	}
+	if accountID == "" {
+		return &StorageB2{}, ErrInvalidUsername
+	}
+	if applicationKey == "" {
+		return &StorageB2{}, ErrInvalidPassword
+	}
+
+	// The backblaze package only honors the proxy environment variables,
+	// our own calls to the native API use the configured proxy as well. Its
+	// transfers can't be throttled either, so they get paced afterwards
+	client, err := newHTTPClient(URL, nil)
+	if err != nil {
+		return &StorageB2{}, err
+	}
+
+	// Creating a new Client for accessing the B2 API
+	cl, err := backblaze.NewB2(backblaze.Credentials{
+		AccountID:      accountID,
+		ApplicationKey: applicationKey,
+	})
+	if err != nil {
+		return &StorageB2{}, err
+	}
+
+	// Creating the bucket prefixes
+	bucketPrefix := strings.Split(URL.Path, "/")
+	if len(bucketPrefix) != 2 {
+		return &StorageB2{}, ErrInvalidRepositoryURL
+	}
+
+	// Getting/Creating a bucket for backblaze
+	var bucket *backblaze.Bucket
+	bucket, err = cl.Bucket(bucketPrefix[1])
+	if err != nil || bucket == nil {
+		// Bucket probably doesn't exists
+		bucket, err = cl.CreateBucket(bucketPrefix[1], backblaze.AllPrivate)
+		if err != nil {
+			// Bucket exists but we don't have access on it
+			return &StorageB2{}, err
+		}
+	}
+	return &StorageB2{
+		url:            URL,
+		accountID:      accountID,
+		applicationKey: applicationKey,
+		repositoryFile: bucketPrefix[1] + "-repository",
+		bucketName:     bucketPrefix[1],
+		bucket:         bucket,
+		backblaze:      cl,
+		client:         client,
+	}, nil
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageB2) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend
+func (backend *StorageB2) Close() error {
+	return nil
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageB2) Protocols() []string {
+	return []string{"b2", "backblaze"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageB2) Description() string {
+	return "Backblaze B2 Storage"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageB2) AvailableSpace() (uint64, error) {
+	// Currently not supported
+	return 0, ErrAvailableSpaceUnknown
+}
+
+// LoadChunk loads a Chunk from backblaze
+func (backend *StorageB2) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	_, obj, err := backend.bucket.DownloadFileByName(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := ioutil.ReadAll(obj)
+	return &data, err
+}
+
+// StatChunk returns the size of a Chunk stored on backblaze
+func (backend *StorageB2) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	res, err := backend.bucket.ListFileNames(fileName, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Files) == 0 || res.Files[0].Name != fileName {
+		return 0, ErrChunkNotFound
+	}
+	return uint64(res.Files[0].Size), nil
+}
+
+// StoreChunk stores a single Chunk on backblaze
+func (backend *StorageB2) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (size uint64, err error) {
+	if size, err = backend.StatChunk(shasum, part, totalParts); err == nil && size == uint64(len(*data)) {
+		// Chunk is already stored
+		return 0, nil
+	}
+
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+
+	if len(*data) >= b2LargeFileSize {
+		if err = backend.uploadLargeFile(fileName, *data); err != nil {
+			return 0, err
+		}
+		return uint64(len(*data)), nil
+	}
+
+	buf := bytes.NewBuffer(*data)
+	metadata := make(map[string]string)
+	i, err := backend.bucket.UploadFile(fileName, metadata, buf)
+	if err != nil {
+		return 0, ErrStoreChunkFailed
+	}
+	file := backblaze.File(*i)
+	return uint64(file.ContentLength), err
+}
+
+// DeleteChunk deletes a single Chunk from backblaze
+func (backend *StorageB2) DeleteChunk(shasum string, part, totalParts uint) error {
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	return backend.deleteFile(fileName, ErrChunkNotFound)
+}
+
+// ListChunks returns the names of all stored Chunks
+func (backend *StorageB2) ListChunks() ([]string, error) {
+	names, err := backend.listFiles()
+	chunks := []string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "snapshot-") && name != backend.repositoryFile {
+			chunks = append(chunks, name)
+		}
+	}
+	return chunks, err
+}
+
+// LoadSnapshot loads a snapshot
+func (backend *StorageB2) LoadSnapshot(id string) ([]byte, error) {
+	_, obj, err := backend.bucket.DownloadFileByName("snapshot-" + id)
+	if err != nil {
+		return nil, ErrSnapshotNotFound
+	}
+	defer obj.Close()
+	return ioutil.ReadAll(obj)
+}
+
+// SaveSnapshot stores a snapshot
+func (backend *StorageB2) SaveSnapshot(id string, data []byte) error {
+	buf := bytes.NewBuffer(data)
+	metadata := make(map[string]string)
+	_, err := backend.bucket.UploadFile("snapshot-"+id, metadata, buf)
+	return err
+}
+
+// DeleteSnapshot deletes a snapshot
+func (backend *StorageB2) DeleteSnapshot(id string) error {
+	return backend.deleteFile("snapshot-"+id, ErrSnapshotNotFound)
+}
+
+// ListSnapshots returns the IDs of all stored snapshots
+func (backend *StorageB2) ListSnapshots() ([]string, error) {
+	names, err := backend.listFiles()
+	snapshots := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, "snapshot-") {
+			snapshots = append(snapshots, strings.TrimPrefix(name, "snapshot-"))
+		}
+	}
+	return snapshots, err
+}
+
+// listFiles returns the names of all files in the bucket
+func (backend *StorageB2) listFiles() ([]string, error) {
+	names := []string{}
+	start := ""
+	for {
+		res, err := backend.bucket.ListFileNames(start, 1000)
+		if err != nil {
+			return names, err
+		}
+		for _, file := range res.Files {
+			names = append(names, file.Name)
+		}
+		if res.NextFileName == "" {
+			return names, nil
+		}
+		start = res.NextFileName
+	}
+}
+
+// deleteFile deletes all versions of a file. B2 keeps previous versions when
+// a file gets overwritten, so we delete the latest one until none are left
+func (backend *StorageB2) deleteFile(fileName string, errNotFound error) error {
+	for deleted := false; ; deleted = true {
+		res, err := backend.bucket.ListFileNames(fileName, 1)
+		if err != nil {
+			return err
+		}
+		if len(res.Files) == 0 || res.Files[0].Name != fileName {
+			if !deleted {
+				return errNotFound
+			}
+			return nil
+		}
+
+		if _, err = backend.bucket.DeleteFileVersion(fileName, res.Files[0].ID); err != nil {
+			return err
+		}
+	}
+}
+
+// InitRepository creates a new repository
+func (backend *StorageB2) InitRepository() error {
+	var placeholder []byte
+	buf := bytes.NewBuffer(placeholder)
+
+	// Creating the files on backblaze
+	metadata := make(map[string]string)
+
+	if _, err := backend.bucket.UploadFile(backend.repositoryFile, metadata, buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadRepository reads the metadata for a repository
+func (backend *StorageB2) LoadRepository() ([]byte, error) {
+	_, obj, err := backend.bucket.DownloadFileByName(backend.repositoryFile)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return ioutil.ReadAll(obj)
+}
+
+// SaveRepository stores the metadata for a repository
+func (backend *StorageB2) SaveRepository(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	metadata := make(map[string]string)
+	_, err := backend.bucket.UploadFile(backend.repositoryFile, metadata, buf)
+	return err
+}
+
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend *StorageB2) ListRepositoryParts() ([]string, error) {
+	res, err := backend.bucket.ListFileNames(backend.repositoryFile, 1)
+	if err != nil {
+		return []string{}, err
+	}
+	if len(res.Files) == 0 || res.Files[0].Name != backend.repositoryFile {
+		return []string{}, nil
+	}
+	return []string{backend.repositoryFile}, nil
+}
+
+// b2Call sends a JSON request to the native B2 API and decodes the response into result
+func b2Call(auth b2Authorization, method string, request interface{}, result interface{}) error {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", auth.APIURL+"/b2api/v1/"+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth.AuthorizationToken)
+
+	return b2Do(auth.client, req, result)
+}
+
+// b2Do executes a B2 API request and decodes the response into result
+func b2Do(client *http.Client, req *http.Request, result interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		e := &b2Error{}
+		if json.Unmarshal(body, e) != nil || e.Message == "" {
+			e.Message = string(body)
+		}
+		e.Status = res.StatusCode
+		return e
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+// authorize starts a session with the native B2 API
+func (backend *StorageB2) authorize() (b2Authorization, error) {
+	auth := b2Authorization{client: backend.client}
+	req, err := http.NewRequest("GET", b2AuthorizeURL, nil)
+	if err != nil {
+		return auth, err
+	}
+	req.SetBasicAuth(backend.accountID, backend.applicationKey)
+
+	err = b2Do(backend.client, req, &auth)
+	return auth, err
+}
+
+// session returns the session with the native B2 API, authorizing only if
+// there's none yet
+func (backend *StorageB2) session() (b2Authorization, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if backend.auth == nil {
+		auth, err := backend.authorize()
+		if err != nil {
+			return auth, err
+		}
+		backend.auth = &auth
+	}
+	return *backend.auth, nil
+}
+
+// call sends a request to the native B2 API. Sessions expire after a day, in
+// which case it authorizes again and retries once
+func (backend *StorageB2) call(method string, request interface{}, result interface{}) error {
+	for retried := false; ; retried = true {
+		auth, err := backend.session()
+		if err != nil {
+			return err
+		}
+		err = b2Call(auth, method, request, result)
+		if e, ok := err.(*b2Error); !ok || e.Status != http.StatusUnauthorized || retried {
+			return err
+		}
+
+		backend.mutex.Lock()
+		backend.auth = nil
+		backend.mutex.Unlock()
+	}
+}
+
+// lookupBucketID returns the ID of the bucket this backend stores its data in
+func (backend *StorageB2) lookupBucketID() (string, error) {
+	backend.mutex.Lock()
+	id := backend.bucketID
+	backend.mutex.Unlock()
+	if id != "" {
+		return id, nil
+	}
+
+	auth, err := backend.session()
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Buckets []struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	err = backend.call("b2_list_buckets", map[string]string{
+		"accountId":  auth.AccountID,
+		"bucketName": backend.bucketName,
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+
+	for _, b := range res.Buckets {
+		if b.BucketName == backend.bucketName {
+			backend.mutex.Lock()
+			backend.bucketID = b.BucketID
+			backend.mutex.Unlock()
+			return b.BucketID, nil
+		}
+	}
+	return "", ErrB2LargeFileFailed
+}
+
+// uploadLargeFile uploads data in several parts using B2's large file API
+func (backend *StorageB2) uploadLargeFile(fileName string, data []byte) error {
+	bucketID, err := backend.lookupBucketID()
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		FileID string `json:"fileId"`
+	}
+	err = backend.call("b2_start_large_file", map[string]string{
+		"bucketId":    bucketID,
+		"fileName":    fileName,
+		"contentType": "application/octet-stream",
+	}, &file)
+	if err != nil {
+		return err
+	}
+
+	var uploadURL struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	err = backend.call("b2_get_upload_part_url", map[string]string{
+		"fileId": file.FileID,
+	}, &uploadURL)
+	if err != nil {
+		return err
+	}
+
+	partSize := b2MinimumPartSize
+	shasums := []string{}
+	for offset, partNum := 0, 1; offset < len(data); offset, partNum = offset+partSize, partNum+1 {
+		end := offset + partSize
+		// Don't leave a trailing part smaller than the minimum part size
+		if end > len(data) || len(data)-end < b2MinimumPartSize {
+			end = len(data)
+		}
+		part := data[offset:end]
+		shasum := sha1.Sum(part)
+		shasums = append(shasums, hex.EncodeToString(shasum[:]))
+
+		req, rerr := http.NewRequest("POST", uploadURL.UploadURL, bytes.NewReader(part))
+		if rerr != nil {
+			return rerr
+		}
+		req.ContentLength = int64(len(part))
+		req.Header.Set("Authorization", uploadURL.AuthorizationToken)
+		req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNum))
+		req.Header.Set("X-Bz-Content-Sha1", shasums[len(shasums)-1])
+
+		err = b2Do(backend.client, req, nil)
+		if err != nil {
+			backend.call("b2_cancel_large_file", map[string]string{"fileId": file.FileID}, nil)
+			return err
+		}
+		if end == len(data) {
+			break
+		}
+	}
+
+	return backend.call("b2_finish_large_file", map[string]interface{}{
+		"fileId":        file.FileID,
+		"partSha1Array": shasums,
+	}, nil)
+}
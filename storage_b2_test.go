@@ -0,0 +1,75 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStorageB2Session(t *testing.T) {
+	authorized := 0
+	expire := false
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			if _, pw, _ := r.BasicAuth(); pw != "key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(b2Error{Status: 401, Code: "unauthorized", Message: "bad key"})
+				return
+			}
+			authorized++
+			json.NewEncoder(w).Encode(b2Authorization{AccountID: "account", APIURL: ts.URL, AuthorizationToken: "token"})
+		case "/b2api/v1/b2_list_buckets":
+			if expire {
+				expire = false
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(b2Error{Status: 401, Code: "expired_auth_token", Message: "expired"})
+				return
+			}
+			w.Write([]byte(`{"buckets": [{"bucketId": "id", "bucketName": "bucket"}]}`))
+		}
+	}))
+	defer ts.Close()
+
+	url := b2AuthorizeURL
+	b2AuthorizeURL = ts.URL + "/auth"
+	defer func() { b2AuthorizeURL = url }()
+
+	backend := &StorageB2{accountID: "account", applicationKey: "key", bucketName: "bucket", client: http.DefaultClient}
+	for i := 0; i < 2; i++ {
+		if err := backend.call("b2_list_buckets", nil, nil); err != nil {
+			t.Errorf("Failed calling B2 API: %s", err)
+		}
+	}
+	if authorized != 1 {
+		t.Errorf("Expected 1 authorization, got %d", authorized)
+	}
+
+	// Expired sessions get renewed
+	expire = true
+	if err := backend.call("b2_list_buckets", nil, nil); err != nil {
+		t.Errorf("Failed calling B2 API with expired session: %s", err)
+	}
+	if authorized != 2 {
+		t.Errorf("Expected 2 authorizations, got %d", authorized)
+	}
+
+	id, err := backend.lookupBucketID()
+	if err != nil || id != "id" {
+		t.Errorf("Expected bucket ID id, got %s (%v)", id, err)
+	}
+
+	backend = &StorageB2{accountID: "account", applicationKey: "wrong", client: http.DefaultClient}
+	if err = backend.call("b2_list_buckets", nil, nil); err == nil {
+		t.Errorf("Expected authorization error")
+	}
+}
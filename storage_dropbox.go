@@ -10,48 +10,127 @@ package knoxite
 
 import (
 	"bytes"
-	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
-	"path/filepath"
+	"os"
+	"path"
 	"strconv"
+	"strings"
 
-	"github.com/stacktic/dropbox"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com/2/"
+	dropboxContentURL = "https://content.dropboxapi.com/2/"
+
+	// knoxite's own Dropbox app, used unless DROPBOX_APP_KEY is set
+	dropboxAppKey = "iqulk4knoj22tkm"
+
+	// Files bigger than this need to be uploaded in an upload session
+	dropboxSimpleUploadLimit = 150 * (1 << 20)
+	// Size of the fragments sent in an upload session
+	dropboxFragmentSize = 32 * (1 << 20)
 )
 
 // StorageDropbox stores data on a remote Dropbox
+//
+// URLs look like dropbox://APPKEY:REFRESHTOKEN@/path, which is what the
+// authorization flow generates when no credentials are given. Access tokens
+// get refreshed automatically. Long-lived access tokens are still supported as
+// dropbox://ACCESSTOKEN@/path
 type StorageDropbox struct {
 	url            url.URL
 	chunkPath      string
 	snapshotPath   string
 	repositoryPath string
-	db             *dropbox.Dropbox
+	client         *http.Client
+	limits         *transferLimits
+}
+
+// dropboxError is returned by the Dropbox API for failed requests
+type dropboxError struct {
+	Endpoint   string
+	StatusCode int
+	Summary    string
+}
+
+func (e *dropboxError) Error() string {
+	return fmt.Sprintf("Dropbox API error in %s (%d): %s", e.Endpoint, e.StatusCode, e.Summary)
 }
 
 // NewStorageDropbox returns a StorageDropbox object
-func NewStorageDropbox(u url.URL) *StorageDropbox {
+// Users can supply their own Dropbox app with the DROPBOX_APP_KEY and
+// DROPBOX_APP_SECRET environment variables
+func NewStorageDropbox(u url.URL) (*StorageDropbox, error) {
 	storage := StorageDropbox{
 		url:            u,
-		chunkPath:      filepath.Join(u.Path, "chunks"),
-		snapshotPath:   filepath.Join(u.Path, "snapshots"),
-		repositoryPath: filepath.Join(u.Path, repoFilename),
-		db:             dropbox.NewDropbox(),
+		chunkPath:      path.Join(u.Path, chunksDirname),
+		snapshotPath:   path.Join(u.Path, snapshotsDirname),
+		repositoryPath: path.Join(u.Path, repoFilename),
+		limits:         newTransferLimits(),
 	}
 
-	ak, _ := base64.StdEncoding.DecodeString("aXF1bGs0a25vajIydGtt")
-	as, _ := base64.StdEncoding.DecodeString("N3htbmlhcDV0cmE5NTE5")
-	storage.db.SetAppInfo(string(ak), string(as))
+	config := &oauth2.Config{
+		ClientID:     dropboxAppKey,
+		ClientSecret: os.Getenv("DROPBOX_APP_SECRET"),
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   "https://www.dropbox.com/oauth2/authorize",
+			TokenURL:  "https://api.dropboxapi.com/oauth2/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+	if key := os.Getenv("DROPBOX_APP_KEY"); key != "" {
+		config.ClientID = key
+	}
+
+	client, err := newHTTPClient(u, storage.limits)
+	if err != nil {
+		return &StorageDropbox{}, err
+	}
+	// The oauth2 package uses the client from the context for all requests
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	switch {
+	case u.User == nil || len(u.User.Username()) == 0:
+		token, err := dropboxAuthorize(ctx, config)
+		if err != nil {
+			return &StorageDropbox{}, err
+		}
+		storage.url.User = url.UserPassword(config.ClientID, token.RefreshToken)
+		storage.client = config.Client(ctx, token)
 
-	if storage.url.User == nil || len(storage.url.User.Username()) == 0 {
-		if err := storage.db.Auth(); err != nil {
-			panic(err)
+	default:
+		if refreshToken, ok := u.User.Password(); ok {
+			config.ClientID = u.User.Username()
+			storage.client = config.Client(ctx, &oauth2.Token{RefreshToken: refreshToken})
+		} else {
+			storage.client = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: u.User.Username()}))
 		}
-		storage.url.User = url.User(storage.db.AccessToken())
-	} else {
-		storage.db.SetAccessToken(storage.url.User.Username())
 	}
 
-	return &storage
+	return &storage, nil
+}
+
+// dropboxAuthorize runs the interactive OAuth2 authorization flow and returns
+// a token with offline access
+func dropboxAuthorize(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	verifier := oauth2.GenerateVerifier()
+	fmt.Printf("Please visit:\n%s\n\nEnter the authorization code: ",
+		config.AuthCodeURL("", oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("token_access_type", "offline")))
+
+	var code string
+	if _, err := fmt.Scanln(&code); err != nil {
+		return nil, err
+	}
+	return config.Exchange(ctx, strings.TrimSpace(code), oauth2.VerifierOption(verifier))
+}
+
+func (backend *StorageDropbox) transferLimits() *transferLimits {
+	return backend.limits
 }
 
 // Location returns the type and location of the repository
@@ -76,88 +155,278 @@ func (backend *StorageDropbox) Description() string {
 
 // AvailableSpace returns the free space on this backend
 func (backend *StorageDropbox) AvailableSpace() (uint64, error) {
-	account, err := backend.db.GetAccountInfo()
-	if err != nil {
-		return 0, err
+	var usage struct {
+		Used       uint64 `json:"used"`
+		Allocation struct {
+			Allocated uint64 `json:"allocated"`
+		} `json:"allocation"`
 	}
 
-	return uint64(account.QuotaInfo.Quota - account.QuotaInfo.Shared - account.QuotaInfo.Normal), nil
+	if err := backend.rpc("users/get_space_usage", nil, &usage); err != nil {
+		return 0, err
+	}
+	if usage.Used > usage.Allocation.Allocated {
+		return 0, nil
+	}
+	return usage.Allocation.Allocated - usage.Used, nil
 }
 
 // LoadChunk loads a Chunk from dropbox
 func (backend *StorageDropbox) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
-	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
-	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
-	obj, _, err := backend.db.Download(fileName, "", 0)
-	if err != nil {
-		return nil, err
-	}
-
-	data, err := ioutil.ReadAll(obj)
+	fileName := path.Join(backend.chunkPath, SubDirForChunk(shasum), shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+	data, err := backend.download(fileName)
 	return &data, err
 }
 
+// StatChunk returns the size of a Chunk stored on dropbox
+func (backend *StorageDropbox) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
+	fileName := path.Join(backend.chunkPath, SubDirForChunk(shasum), shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+	return backend.stat(fileName)
+}
+
 // StoreChunk stores a single Chunk on dropbox
 func (backend *StorageDropbox) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
-	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
-	backend.db.CreateFolder(path)
-
-	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
-	if entry, err := backend.db.Metadata(fileName, false, false, "", "", 1); err == nil {
+	if size, err := backend.StatChunk(shasum, part, totalParts); err == nil && size == uint64(len(*data)) {
 		// Chunk is already stored
-		if int(entry.Bytes) == len(*data) {
-			return 0, nil
-		}
+		return 0, nil
+	}
+
+	fileName := path.Join(backend.chunkPath, SubDirForChunk(shasum), shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+	if err := backend.upload(fileName, *data); err != nil {
+		return 0, err
 	}
+	return uint64(len(*data)), nil
+}
+
+// DeleteChunk deletes a single Chunk from dropbox
+func (backend *StorageDropbox) DeleteChunk(shasum string, part, totalParts uint) error {
+	fileName := path.Join(backend.chunkPath, SubDirForChunk(shasum), shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+	return backend.rpc("files/delete_v2", map[string]interface{}{"path": fileName}, nil)
+}
 
-	//FIXME: this doesn't really chunk anything - it always picks the full data block's size
-	entry, err := backend.db.UploadByChunk(ioutil.NopCloser(bytes.NewReader(*data)), len(*data), fileName, true, "")
-	return uint64(entry.Bytes), err
+// ListChunks returns the names of all stored Chunks
+func (backend *StorageDropbox) ListChunks() ([]string, error) {
+	return backend.listFiles(backend.chunkPath, true)
 }
 
 // LoadSnapshot loads a snapshot
 func (backend *StorageDropbox) LoadSnapshot(id string) ([]byte, error) {
-	path := filepath.Join(backend.snapshotPath, id)
-	// Getting obj as type io.ReadCloser and reading it out in order to get bytes returned
-	obj, _, err := backend.db.Download(path, "", 0)
-	if err != nil {
-		return nil, err
-	}
-	return ioutil.ReadAll(obj)
+	return backend.download(path.Join(backend.snapshotPath, id))
 }
 
 // SaveSnapshot stores a snapshot
 func (backend *StorageDropbox) SaveSnapshot(id string, data []byte) error {
-	path := filepath.Join(backend.snapshotPath, id)
-	_, err := backend.db.UploadByChunk(ioutil.NopCloser(bytes.NewReader(data)), len(data), path, true, "")
-	return err
+	return backend.upload(path.Join(backend.snapshotPath, id), data)
+}
+
+// DeleteSnapshot deletes a snapshot
+func (backend *StorageDropbox) DeleteSnapshot(id string) error {
+	return backend.rpc("files/delete_v2", map[string]interface{}{"path": path.Join(backend.snapshotPath, id)}, nil)
+}
+
+// ListSnapshots returns the IDs of all stored snapshots
+func (backend *StorageDropbox) ListSnapshots() ([]string, error) {
+	return backend.listFiles(backend.snapshotPath, false)
 }
 
 // InitRepository creates a new repository
 func (backend *StorageDropbox) InitRepository() error {
-	if _, err := backend.db.CreateFolder(backend.url.Path); err != nil {
-		return ErrRepositoryExists
-	}
-	if _, err := backend.db.CreateFolder(backend.snapshotPath); err != nil {
-		return ErrRepositoryExists
-	}
-	if _, err := backend.db.CreateFolder(backend.chunkPath); err != nil {
+	if _, err := backend.stat(backend.repositoryPath); err == nil {
+		// Repo seems to already exist
 		return ErrRepositoryExists
 	}
+
+	// Dropbox creates all missing parent folders on upload, so there's no
+	// need to create the directory structure in advance
 	return nil
 }
 
 // LoadRepository reads the metadata for a repository
 func (backend *StorageDropbox) LoadRepository() ([]byte, error) {
-	obj, _, err := backend.db.Download(backend.repositoryPath, "", 0)
+	return backend.download(backend.repositoryPath)
+}
+
+// SaveRepository stores the metadata for a repository
+func (backend *StorageDropbox) SaveRepository(data []byte) error {
+	return backend.upload(backend.repositoryPath, data)
+}
+
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend *StorageDropbox) ListRepositoryParts() ([]string, error) {
+	if _, err := backend.stat(backend.repositoryPath); err != nil {
+		return []string{}, nil
+	}
+	return []string{repoFilename}, nil
+}
+
+// dropboxArg encodes arg for the Dropbox-API-Arg header, which must not
+// contain any non-ASCII characters
+func dropboxArg(arg interface{}) (string, error) {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+
+	var s bytes.Buffer
+	for _, r := range string(b) {
+		if r > 0x7e {
+			if r > 0xffff {
+				// Encode as UTF-16 surrogate pair
+				r -= 0x10000
+				fmt.Fprintf(&s, "\\u%04x\\u%04x", 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+			} else {
+				fmt.Fprintf(&s, "\\u%04x", r)
+			}
+			continue
+		}
+		s.WriteRune(r)
+	}
+	return s.String(), nil
+}
+
+// do sends a request to the Dropbox API and returns the response body
+func (backend *StorageDropbox) do(req *http.Request, endpoint string) ([]byte, error) {
+	res, err := backend.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
-	return ioutil.ReadAll(obj)
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Summary string `json:"error_summary"`
+		}
+		if json.Unmarshal(b, &e) != nil || e.Summary == "" {
+			e.Summary = strings.TrimSpace(string(b))
+		}
+		return nil, &dropboxError{endpoint, res.StatusCode, e.Summary}
+	}
+
+	return b, nil
 }
 
-// SaveRepository stores the metadata for a repository
-func (backend *StorageDropbox) SaveRepository(data []byte) error {
-	_, err := backend.db.UploadByChunk(ioutil.NopCloser(bytes.NewReader(data)), len(data), backend.repositoryPath, true, "")
+// rpc calls an RPC endpoint and decodes the result into v, if not nil
+func (backend *StorageDropbox) rpc(endpoint string, arg interface{}, v interface{}) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", dropboxAPIURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	b, err := backend.do(req, endpoint)
+	if err != nil || v == nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// content calls a content endpoint, uploading data if not nil
+func (backend *StorageDropbox) content(endpoint string, arg interface{}, data []byte) ([]byte, error) {
+	a, err := dropboxArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", dropboxContentURL+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Dropbox-API-Arg", a)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	return backend.do(req, endpoint)
+}
+
+// stat returns the size of the file stored at p
+func (backend *StorageDropbox) stat(p string) (uint64, error) {
+	var metadata struct {
+		Size uint64 `json:"size"`
+	}
+	err := backend.rpc("files/get_metadata", map[string]interface{}{"path": p}, &metadata)
+	return metadata.Size, err
+}
+
+// download returns the content of the file stored at p
+func (backend *StorageDropbox) download(p string) ([]byte, error) {
+	return backend.content("files/download", map[string]interface{}{"path": p}, nil)
+}
+
+// upload stores data at p, overwriting any existing file
+func (backend *StorageDropbox) upload(p string, data []byte) error {
+	commit := map[string]interface{}{
+		"path": p,
+		"mode": "overwrite",
+		"mute": true,
+	}
+	if len(data) <= dropboxSimpleUploadLimit {
+		_, err := backend.content("files/upload", commit, data)
+		return err
+	}
+
+	b, err := backend.content("files/upload_session/start", map[string]interface{}{"close": false}, data[:dropboxFragmentSize])
+	if err != nil {
+		return err
+	}
+	var session struct {
+		ID string `json:"session_id"`
+	}
+	if err = json.Unmarshal(b, &session); err != nil {
+		return err
+	}
+
+	offset := dropboxFragmentSize
+	for ; len(data)-offset > dropboxFragmentSize; offset += dropboxFragmentSize {
+		cursor := map[string]interface{}{"session_id": session.ID, "offset": offset}
+		if _, err = backend.content("files/upload_session/append_v2", map[string]interface{}{"cursor": cursor}, data[offset:offset+dropboxFragmentSize]); err != nil {
+			return err
+		}
+	}
+
+	cursor := map[string]interface{}{"session_id": session.ID, "offset": offset}
+	_, err = backend.content("files/upload_session/finish", map[string]interface{}{"cursor": cursor, "commit": commit}, data[offset:])
 	return err
 }
+
+// listFiles returns the names of all files in folder p
+func (backend *StorageDropbox) listFiles(p string, recursive bool) ([]string, error) {
+	var res struct {
+		Entries []struct {
+			Tag  string `json:".tag"`
+			Name string `json:"name"`
+		} `json:"entries"`
+		Cursor  string `json:"cursor"`
+		HasMore bool   `json:"has_more"`
+	}
+
+	names := []string{}
+	err := backend.rpc("files/list_folder", map[string]interface{}{"path": p, "recursive": recursive}, &res)
+	if e, ok := err.(*dropboxError); ok && e.StatusCode == http.StatusConflict && strings.HasPrefix(e.Summary, "path/not_found") {
+		// Folders get created on upload, a fresh repository has none yet
+		return names, nil
+	}
+	for err == nil {
+		for _, entry := range res.Entries {
+			if entry.Tag == "file" {
+				names = append(names, entry.Name)
+			}
+		}
+		if !res.HasMore {
+			break
+		}
+
+		cursor := res.Cursor
+		res.Entries = nil
+		err = backend.rpc("files/list_folder/continue", map[string]interface{}{"cursor": cursor}, &res)
+	}
+
+	return names, err
+}
@@ -0,0 +1,40 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestStorageDropboxListMissing(t *testing.T) {
+	// Folders only get created on upload, so a fresh repository has none
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error_summary": "path/not_found/..", "error": {".tag": "path"}}`))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	backend := &StorageDropbox{
+		client:       &http.Client{Transport: redirectTransport{u}},
+		chunkPath:    "/knoxite/chunks",
+		snapshotPath: "/knoxite/snapshots",
+	}
+
+	snapshots, err := backend.ListSnapshots()
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots, got %v: %v", snapshots, err)
+	}
+	chunks, err := backend.ListChunks()
+	if err != nil || len(chunks) != 0 {
+		t.Errorf("Expected no chunks, got %v: %v", chunks, err)
+	}
+}
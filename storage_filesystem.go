@@ -29,6 +29,10 @@ type BackendFilesystem interface {
 	ReadFile(path string) (*[]byte, error)
 	// WriteFile writes a file to disk
 	WriteFile(path string, data *[]byte) (uint64, error)
+	// DeleteFile deletes a file from disk
+	DeleteFile(path string) error
+	// ReadDir returns the names of all entries in a dir
+	ReadDir(path string) ([]string, error)
 }
 
 // StorageFilesystem is bridging a BackendFilesystem to a Backend interface
@@ -61,8 +65,21 @@ func (backend StorageFilesystem) LoadChunk(shasum string, part, totalParts uint)
 	return (*backend.storage).ReadFile(fileName)
 }
 
+// StatChunk returns the size of a Chunk stored on disk
+func (backend StorageFilesystem) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
+	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
+	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+
+	return (*backend.storage).Stat(fileName)
+}
+
 // StoreChunk stores a single Chunk on disk
 func (backend StorageFilesystem) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (size uint64, err error) {
+	if size, err := backend.StatChunk(shasum, part, totalParts); err == nil && size == uint64(len(*data)) {
+		// Chunk is already stored
+		return 0, nil
+	}
+
 	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
 	(*backend.storage).CreatePath(path)
 
@@ -70,6 +87,40 @@ func (backend StorageFilesystem) StoreChunk(shasum string, part, totalParts uint
 	return (*backend.storage).WriteFile(fileName, data)
 }
 
+// DeleteChunk deletes a single Chunk from disk
+func (backend StorageFilesystem) DeleteChunk(shasum string, part, totalParts uint) error {
+	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
+	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+
+	return (*backend.storage).DeleteFile(fileName)
+}
+
+// ListChunks returns the names of all Chunks stored on disk
+func (backend StorageFilesystem) ListChunks() ([]string, error) {
+	chunks := []string{}
+
+	// Chunks are filed in two levels of sub-dirs, see SubDirForChunk
+	dirs, err := (*backend.storage).ReadDir(backend.chunkPath)
+	if err != nil {
+		return chunks, err
+	}
+	for _, dir := range dirs {
+		subdirs, err := (*backend.storage).ReadDir(filepath.Join(backend.chunkPath, dir))
+		if err != nil {
+			return chunks, err
+		}
+		for _, subdir := range subdirs {
+			files, err := (*backend.storage).ReadDir(filepath.Join(backend.chunkPath, dir, subdir))
+			if err != nil {
+				return chunks, err
+			}
+			chunks = append(chunks, files...)
+		}
+	}
+
+	return chunks, nil
+}
+
 // LoadSnapshot loads a snapshot
 func (backend StorageFilesystem) LoadSnapshot(id string) ([]byte, error) {
 	b, err := (*backend.storage).ReadFile(filepath.Join(backend.snapshotPath, id))
@@ -86,6 +137,16 @@ func (backend StorageFilesystem) SaveSnapshot(id string, b []byte) error {
 	return err
 }
 
+// DeleteSnapshot deletes a snapshot
+func (backend StorageFilesystem) DeleteSnapshot(id string) error {
+	return (*backend.storage).DeleteFile(filepath.Join(backend.snapshotPath, id))
+}
+
+// ListSnapshots returns the IDs of all snapshots stored on disk
+func (backend StorageFilesystem) ListSnapshots() ([]string, error) {
+	return (*backend.storage).ReadDir(backend.snapshotPath)
+}
+
 // InitRepository creates a new repository
 func (backend StorageFilesystem) InitRepository() error {
 	if _, err := (*backend.storage).Stat(backend.repositoryPath); err == nil {
@@ -127,6 +188,14 @@ func (backend StorageFilesystem) SaveRepository(b []byte) error {
 	return err
 }
 
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend StorageFilesystem) ListRepositoryParts() ([]string, error) {
+	if _, err := (*backend.storage).Stat(backend.repositoryPath); err != nil {
+		return []string{}, nil
+	}
+	return []string{repoFilename}, nil
+}
+
 // SubDirForChunk files a chunk into a subdir, based on the chunks name
 func SubDirForChunk(id string) string {
 	return filepath.Join(id[0:2], id[2:4])
@@ -0,0 +1,293 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+const (
+	ftpTimeout = 30 * time.Second
+	ftpRetries = 3
+)
+
+// StorageFTP stores data on a remote FTP server
+type StorageFTP struct {
+	url    url.URL
+	conn   *ftp.ServerConn
+	mut    sync.Mutex
+	limits *transferLimits
+
+	StorageFilesystem
+}
+
+// NewStorageFTP returns a StorageFTP object
+// The ftps scheme enables explicit TLS (AUTH TLS), optionally with a custom CA
+// or client certificate (see newTLSConfig). Data connections are always
+// opened in passive mode; "epsv=0" can be passed as URL parameter to fall back
+// to plain PASV for servers that don't support extended passive mode
+func NewStorageFTP(u url.URL) (*StorageFTP, error) {
+	storage := StorageFTP{
+		url:    u,
+		limits: newTransferLimits(),
+	}
+
+	if err := storage.connect(); err != nil {
+		return &StorageFTP{}, err
+	}
+
+	storagefs, _ := NewStorageFilesystem(u.Path, &storage)
+	storage.StorageFilesystem = storagefs
+	return &storage, nil
+}
+
+// connect establishes a new connection to the FTP server and logs in
+func (backend *StorageFTP) connect() error {
+	host := backend.url.Host
+	if backend.url.Port() == "" {
+		host = net.JoinHostPort(backend.url.Hostname(), "21")
+	}
+
+	var config *tls.Config
+	if backend.url.Scheme == "ftps" {
+		var err error
+		config, err = newTLSConfig(backend.url)
+		if err != nil {
+			return err
+		}
+		config.ServerName = backend.url.Hostname()
+	}
+
+	// Data connections get opened with dial as well, so transfers get
+	// throttled. It has to set up their TLS then, the control connection
+	// gets upgraded by the ftp package
+	dialer := net.Dialer{Timeout: ftpTimeout}
+	dial := backend.limits.dialer(dialer.DialContext)
+	opts := []ftp.DialOption{
+		ftp.DialWithDisabledEPSV(backend.url.Query().Get("epsv") == "0"),
+		ftp.DialWithDialFunc(func(network, addr string) (net.Conn, error) {
+			conn, err := dial(context.Background(), network, addr)
+			if err != nil || config == nil || addr == host {
+				return conn, err
+			}
+			return tls.Client(conn, config), nil
+		}),
+	}
+	if config != nil {
+		opts = append(opts, ftp.DialWithExplicitTLS(config))
+	}
+
+	conn, err := ftp.Dial(host, opts...)
+	if err != nil {
+		return err
+	}
+
+	username := "anonymous"
+	password := "anonymous"
+	if backend.url.User != nil {
+		username = backend.url.User.Username()
+		if pw, ok := backend.url.User.Password(); ok {
+			password = pw
+		}
+	}
+	if err = conn.Login(username, password); err != nil {
+		conn.Quit()
+		return err
+	}
+
+	backend.conn = conn
+	return nil
+}
+
+// isTransientFTPError returns true if err indicates a dropped connection
+// rather than a permanent failure reported by the server
+func isTransientFTPError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if perr, ok := err.(*textproto.Error); ok {
+		// 421: service not available, closing control connection
+		// 425/426: can't open data connection / connection closed
+		return perr.Code == 421 || perr.Code == 425 || perr.Code == 426
+	}
+	return false
+}
+
+// withRetry runs op and re-connects to the server to try again whenever the
+// connection got dropped
+func (backend *StorageFTP) withRetry(op func(conn *ftp.ServerConn) error) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	var err error
+	for i := 0; i < ftpRetries; i++ {
+		if backend.conn == nil {
+			if err = backend.connect(); err != nil {
+				time.Sleep(time.Duration(i+1) * time.Second)
+				continue
+			}
+		}
+
+		err = op(backend.conn)
+		if err == nil || !isTransientFTPError(err) {
+			return err
+		}
+
+		backend.conn.Quit()
+		backend.conn = nil
+	}
+
+	return err
+}
+
+func (backend *StorageFTP) transferLimits() *transferLimits {
+	return backend.limits
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageFTP) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend
+func (backend *StorageFTP) Close() error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	if backend.conn == nil {
+		return nil
+	}
+	err := backend.conn.Quit()
+	backend.conn = nil
+	return err
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageFTP) Protocols() []string {
+	return []string{"ftp", "ftps"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageFTP) Description() string {
+	return "FTP(S) Storage"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageFTP) AvailableSpace() (uint64, error) {
+	return 0, ErrAvailableSpaceUnknown
+}
+
+// CreatePath creates a dir including all its parents dirs, when required
+func (backend *StorageFTP) CreatePath(p string) error {
+	return backend.withRetry(func(conn *ftp.ServerConn) error {
+		dir := ""
+		if strings.HasPrefix(p, "/") {
+			dir = "/"
+		}
+		for _, s := range strings.Split(p, "/") {
+			if s == "" {
+				continue
+			}
+			dir = path.Join(dir, s)
+			// Ignore errors for existing directories, we check the final result below
+			conn.MakeDir(dir)
+		}
+
+		_, err := conn.List(p)
+		return err
+	})
+}
+
+// Stat stats a file on the server
+func (backend *StorageFTP) Stat(p string) (uint64, error) {
+	var size int64
+	err := backend.withRetry(func(conn *ftp.ServerConn) error {
+		var err error
+		size, err = conn.FileSize(p)
+		if err != nil {
+			// Directories have no size, but we still want to know they exist
+			entry, gerr := conn.GetEntry(p)
+			if gerr == nil && entry.Type == ftp.EntryTypeFolder {
+				return nil
+			}
+		}
+		return err
+	})
+	return uint64(size), err
+}
+
+// ReadFile reads a file from the server
+func (backend *StorageFTP) ReadFile(p string) (*[]byte, error) {
+	var b []byte
+	err := backend.withRetry(func(conn *ftp.ServerConn) error {
+		res, err := conn.Retr(p)
+		if err != nil {
+			return err
+		}
+		b, err = ioutil.ReadAll(res)
+		if cerr := res.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	return &b, err
+}
+
+// WriteFile writes a file to the server
+func (backend *StorageFTP) WriteFile(p string, data *[]byte) (size uint64, err error) {
+	err = backend.withRetry(func(conn *ftp.ServerConn) error {
+		return conn.Stor(p, bytes.NewReader(*data))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(*data)), nil
+}
+
+// DeleteFile deletes a file from the server
+func (backend *StorageFTP) DeleteFile(p string) error {
+	return backend.withRetry(func(conn *ftp.ServerConn) error {
+		return conn.Delete(p)
+	})
+}
+
+// ReadDir returns the names of all entries in a dir on the server
+func (backend *StorageFTP) ReadDir(p string) ([]string, error) {
+	names := []string{}
+	err := backend.withRetry(func(conn *ftp.ServerConn) error {
+		entries, err := conn.List(p)
+		if err != nil {
+			return err
+		}
+
+		names = names[:0]
+		for _, entry := range entries {
+			if entry.Name != "." && entry.Name != ".." {
+				names = append(names, path.Base(entry.Name))
+			}
+		}
+		return nil
+	})
+	return names, err
+}
@@ -70,3 +70,19 @@ func (backend StorageLocal) WriteFile(path string, data *[]byte) (size uint64, e
 	err = ioutil.WriteFile(path, *data, 0600)
 	return uint64(len(*data)), err
 }
+
+// DeleteFile deletes a file from disk
+func (backend StorageLocal) DeleteFile(path string) error {
+	return os.Remove(path)
+}
+
+// ReadDir returns the names of all entries in a dir
+func (backend StorageLocal) ReadDir(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return []string{}, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
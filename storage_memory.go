@@ -0,0 +1,232 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// StorageMemory keeps all data in memory. It's meant for tests and ephemeral
+// backups of applications embedding knoxite
+type StorageMemory struct {
+	name       string
+	chunks     map[string][]byte
+	snapshots  map[string][]byte
+	repository []byte
+	mut        sync.RWMutex
+}
+
+var (
+	memoryStorages   = make(map[string]*StorageMemory)
+	memoryStorageMut = &sync.Mutex{}
+)
+
+// NewStorageMemory returns a new, empty StorageMemory object
+func NewStorageMemory() *StorageMemory {
+	return &StorageMemory{
+		chunks:    make(map[string][]byte),
+		snapshots: make(map[string][]byte),
+	}
+}
+
+// MemoryStorage returns the StorageMemory registered as name, creating it if
+// required. All memory://NAME URLs refer to the same StorageMemory object for
+// as long as the process lives
+func MemoryStorage(name string) *StorageMemory {
+	memoryStorageMut.Lock()
+	defer memoryStorageMut.Unlock()
+
+	storage, ok := memoryStorages[name]
+	if !ok {
+		storage = NewStorageMemory()
+		storage.name = name
+		memoryStorages[name] = storage
+	}
+	return storage
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageMemory) Location() string {
+	return "memory://" + backend.name
+}
+
+// Close the backend
+func (backend *StorageMemory) Close() error {
+	return nil
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageMemory) Protocols() []string {
+	return []string{"memory"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageMemory) Description() string {
+	return "Memory Storage"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageMemory) AvailableSpace() (uint64, error) {
+	return 0, ErrAvailableSpaceUnknown
+}
+
+// LoadChunk loads a Chunk from memory
+func (backend *StorageMemory) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	data, ok := backend.chunks[shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10)]
+	if !ok {
+		return &[]byte{}, ErrChunkNotFound
+	}
+	b := append([]byte{}, data...)
+	return &b, nil
+}
+
+// StatChunk returns the size of a Chunk stored in memory
+func (backend *StorageMemory) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	data, ok := backend.chunks[shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10)]
+	if !ok {
+		return 0, ErrChunkNotFound
+	}
+	return uint64(len(data)), nil
+}
+
+// StoreChunk stores a single Chunk in memory
+func (backend *StorageMemory) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	if size, err := backend.StatChunk(shasum, part, totalParts); err == nil && size == uint64(len(*data)) {
+		// Chunk is already stored
+		return 0, nil
+	}
+
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+
+	backend.chunks[fileName] = append([]byte{}, *data...)
+	return uint64(len(*data)), nil
+}
+
+// DeleteChunk deletes a single Chunk from memory
+func (backend *StorageMemory) DeleteChunk(shasum string, part, totalParts uint) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	if _, ok := backend.chunks[fileName]; !ok {
+		return ErrChunkNotFound
+	}
+	delete(backend.chunks, fileName)
+	return nil
+}
+
+// ListChunks returns the names of all stored Chunks
+func (backend *StorageMemory) ListChunks() ([]string, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	return sortedKeys(backend.chunks), nil
+}
+
+// LoadSnapshot loads a snapshot
+func (backend *StorageMemory) LoadSnapshot(id string) ([]byte, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	data, ok := backend.snapshots[id]
+	if !ok {
+		return []byte{}, ErrSnapshotNotFound
+	}
+	return append([]byte{}, data...), nil
+}
+
+// SaveSnapshot stores a snapshot
+func (backend *StorageMemory) SaveSnapshot(id string, data []byte) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	backend.snapshots[id] = append([]byte{}, data...)
+	return nil
+}
+
+// DeleteSnapshot deletes a snapshot
+func (backend *StorageMemory) DeleteSnapshot(id string) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	if _, ok := backend.snapshots[id]; !ok {
+		return ErrSnapshotNotFound
+	}
+	delete(backend.snapshots, id)
+	return nil
+}
+
+// ListSnapshots returns the IDs of all stored snapshots
+func (backend *StorageMemory) ListSnapshots() ([]string, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	return sortedKeys(backend.snapshots), nil
+}
+
+// InitRepository creates a new repository
+func (backend *StorageMemory) InitRepository() error {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	if backend.repository != nil {
+		return ErrRepositoryExists
+	}
+	return nil
+}
+
+// LoadRepository reads the metadata for a repository
+func (backend *StorageMemory) LoadRepository() ([]byte, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	if backend.repository == nil {
+		return []byte{}, ErrRepositoryNotFound
+	}
+	return append([]byte{}, backend.repository...), nil
+}
+
+// SaveRepository stores the metadata for a repository
+func (backend *StorageMemory) SaveRepository(data []byte) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	backend.repository = append([]byte{}, data...)
+	return nil
+}
+
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend *StorageMemory) ListRepositoryParts() ([]string, error) {
+	backend.mut.RLock()
+	defer backend.mut.RUnlock()
+
+	if backend.repository == nil {
+		return []string{}, nil
+	}
+	return []string{repoFilename}, nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,70 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStorageMemory(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	r, err := NewRepository("memory://test", testPassword)
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	r, err = OpenRepository("memory://test", testPassword)
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if _, err = r.FindVolume(vol.ID); err != nil {
+		t.Errorf("Failed finding volume: %s", err)
+	}
+
+	_, err = NewRepository("memory://test", testPassword)
+	if err != ErrRepositoryExists {
+		t.Errorf("Expected %v, got %v", ErrRepositoryExists, err)
+	}
+
+	backend := NewStorageMemory()
+	data := []byte("chunk data")
+	if _, err = backend.StoreChunk("abcdef", 0, 1, &data); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+	data[0] = 'x'
+
+	b, err := backend.LoadChunk("abcdef", 0, 1)
+	if err != nil {
+		t.Errorf("Failed loading chunk: %s", err)
+		return
+	}
+	if !bytes.Equal(*b, []byte("chunk data")) {
+		t.Errorf("Chunk data mismatch, got %s", *b)
+	}
+	if _, err = backend.LoadChunk("abcdef", 1, 1); err != ErrChunkNotFound {
+		t.Errorf("Expected %v, got %v", ErrChunkNotFound, err)
+	}
+
+	if err = backend.DeleteChunk("abcdef", 0, 1); err != nil {
+		t.Errorf("Failed deleting chunk: %s", err)
+	}
+	if _, err = backend.LoadChunk("abcdef", 0, 1); err != ErrChunkNotFound {
+		t.Errorf("Expected %v, got %v", ErrChunkNotFound, err)
+	}
+}
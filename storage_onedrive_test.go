@@ -0,0 +1,110 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestStorageOneDriveRefresh(t *testing.T) {
+	refreshed := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.FormValue("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			refreshed++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token": "access%d", "token_type": "Bearer", "expires_in": 3600}`, refreshed)
+		case "/item":
+			// Only the second access token is accepted, as if the first one
+			// got revoked early
+			if r.Header.Get("Authorization") != "Bearer access2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"size": 42}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	backend := &StorageOneDrive{
+		client: http.DefaultClient,
+		ctx:    context.Background(),
+		config: &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: ts.URL + "/token"}},
+	}
+	backend.tokens = backend.config.TokenSource(backend.ctx, &oauth2.Token{RefreshToken: "refresh"})
+
+	res, err := backend.do("GET", ts.URL+"/item", nil, "")
+	if err != nil {
+		t.Errorf("Failed calling API with rejected token: %s", err)
+		return
+	}
+	res.Body.Close()
+	if refreshed != 2 {
+		t.Errorf("Expected 2 token refreshes, got %d", refreshed)
+	}
+
+	_, err = backend.do("GET", ts.URL+"/missing", nil, "")
+	if err = oneDriveNotFound(err, ErrSnapshotNotFound); err != ErrSnapshotNotFound {
+		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+
+	// Static access tokens don't get refreshed
+	backend = &StorageOneDrive{client: http.DefaultClient, tokens: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access1"})}
+	if _, err = backend.do("GET", ts.URL+"/item", nil, ""); err == nil {
+		t.Errorf("Expected static access token to get rejected")
+	}
+}
+
+// redirectTransport sends all requests to the test server at URL instead
+type redirectTransport struct {
+	URL *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme, r.URL.Host = t.URL.Scheme, t.URL.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestStorageOneDriveListMissing(t *testing.T) {
+	// Folders only get created on upload, so a fresh repository has none
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	backend := &StorageOneDrive{
+		client:       &http.Client{Transport: redirectTransport{u}},
+		drive:        "/me/drive",
+		chunkPath:    "/knoxite/chunks",
+		snapshotPath: "/knoxite/snapshots",
+		tokens:       oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}),
+	}
+
+	snapshots, err := backend.ListSnapshots()
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots, got %v: %v", snapshots, err)
+	}
+	chunks, err := backend.ListChunks()
+	if err != nil || len(chunks) != 0 {
+		t.Errorf("Expected no chunks, got %v: %v", chunks, err)
+	}
+}
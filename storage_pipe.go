@@ -0,0 +1,294 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version of the helper protocol
+const pipeProtocolVersion = "1"
+
+// Error declarations
+var (
+	ErrPipeHelperMissing  = errors.New("Please specify a helper, e.g. pipe://HELPER/path")
+	ErrPipeInvalidMessage = errors.New("Invalid message from external helper")
+)
+
+// StoragePipe delegates all storage operations to an external helper program,
+// similar to git's remote helpers. For a URL like pipe://foo/bar the program
+// knoxite-backend-foo is started (searched for in $PATH) with the full URL as
+// its only argument.
+//
+// knoxite and the helper talk over the helper's stdin and stdout. Every
+// message is a frame: a 4 byte big-endian length, followed by that many bytes
+// of payload. Each request consists of a command frame, which contains the
+// command and its space-separated arguments, followed by a data frame:
+//
+//	hello VERSION                 start of the session, data frame is empty
+//	available-space
+//	init
+//	load-repository
+//	save-repository               data frame holds the repository
+//	load-snapshot ID
+//	save-snapshot ID              data frame holds the snapshot
+//	load-chunk SHASUM PART TOTAL
+//	stat-chunk SHASUM PART TOTAL
+//	store-chunk SHASUM PART TOTAL data frame holds the chunk
+//	delete-chunk SHASUM PART TOTAL
+//	delete-snapshot ID
+//	list-chunks
+//	list-snapshots
+//	list-repository-parts
+//	quit                          end of the session
+//
+// Data frames of commands that don't carry data are empty. Every response
+// consists of a status frame, either "ok" or "error MESSAGE" (an error
+// "exists" is returned for init on an existing repository), followed by a
+// data frame containing the requested object. For available-space,
+// stat-chunk and store-chunk the data frame contains the respective size in bytes as a
+// decimal number, the list commands return newline-separated names
+type StoragePipe struct {
+	url    url.URL
+	helper string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mut    sync.Mutex
+}
+
+// pipeError is returned when the external helper reports an error
+type pipeError struct {
+	Helper  string
+	Message string
+}
+
+func (e *pipeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Helper, e.Message)
+}
+
+// NewStoragePipe returns a StoragePipe object
+func NewStoragePipe(u url.URL) (*StoragePipe, error) {
+	if u.Host == "" {
+		return &StoragePipe{}, ErrPipeHelperMissing
+	}
+
+	storage := StoragePipe{
+		url:    u,
+		helper: "knoxite-backend-" + u.Host,
+	}
+
+	storage.cmd = exec.Command(storage.helper, u.String())
+	storage.cmd.Stderr = os.Stderr
+
+	var err error
+	storage.stdin, err = storage.cmd.StdinPipe()
+	if err != nil {
+		return &StoragePipe{}, err
+	}
+	stdout, err := storage.cmd.StdoutPipe()
+	if err != nil {
+		return &StoragePipe{}, err
+	}
+	storage.stdout = bufio.NewReader(stdout)
+
+	if err = storage.cmd.Start(); err != nil {
+		return &StoragePipe{}, err
+	}
+
+	if _, err = storage.call(nil, "hello", pipeProtocolVersion); err != nil {
+		storage.stdin.Close()
+		storage.cmd.Wait()
+		return &StoragePipe{}, err
+	}
+
+	return &storage, nil
+}
+
+// writeFrame sends a single frame to the helper
+func (backend *StoragePipe) writeFrame(b []byte) error {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	if _, err := backend.stdin.Write(l[:]); err != nil {
+		return err
+	}
+	_, err := backend.stdin.Write(b)
+	return err
+}
+
+// readFrame receives a single frame from the helper
+func (backend *StoragePipe) readFrame() ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(backend.stdout, l[:]); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint32(l[:]))
+	_, err := io.ReadFull(backend.stdout, b)
+	return b, err
+}
+
+// call sends a command with its data to the helper and returns the response data
+func (backend *StoragePipe) call(data []byte, command string, args ...string) ([]byte, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	if err := backend.writeFrame([]byte(strings.Join(append([]string{command}, args...), " "))); err != nil {
+		return nil, err
+	}
+	if err := backend.writeFrame(data); err != nil {
+		return nil, err
+	}
+
+	status, err := backend.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	b, err := backend.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(status)
+	switch {
+	case s == "ok":
+		return b, nil
+	case s == "error exists":
+		return nil, ErrRepositoryExists
+	case strings.HasPrefix(s, "error "):
+		return nil, &pipeError{backend.helper, strings.TrimPrefix(s, "error ")}
+	default:
+		return nil, ErrPipeInvalidMessage
+	}
+}
+
+// Location returns the type and location of the repository
+func (backend *StoragePipe) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend
+func (backend *StoragePipe) Close() error {
+	backend.call(nil, "quit")
+
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+	backend.stdin.Close()
+	return backend.cmd.Wait()
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StoragePipe) Protocols() []string {
+	return []string{"pipe"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StoragePipe) Description() string {
+	return "External Helper Storage (" + backend.helper + ")"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StoragePipe) AvailableSpace() (uint64, error) {
+	b, err := backend.call(nil, "available-space")
+	if err != nil {
+		return 0, ErrAvailableSpaceUnknown
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// LoadChunk loads a Chunk via the helper
+func (backend *StoragePipe) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	b, err := backend.call(nil, "load-chunk", shasum, strconv.FormatUint(uint64(part), 10), strconv.FormatUint(uint64(totalParts), 10))
+	return &b, err
+}
+
+// StatChunk returns the size of a Chunk stored via the helper
+func (backend *StoragePipe) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
+	b, err := backend.call(nil, "stat-chunk", shasum, strconv.FormatUint(uint64(part), 10), strconv.FormatUint(uint64(totalParts), 10))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// StoreChunk stores a single Chunk via the helper
+func (backend *StoragePipe) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	b, err := backend.call(*data, "store-chunk", shasum, strconv.FormatUint(uint64(part), 10), strconv.FormatUint(uint64(totalParts), 10))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// DeleteChunk deletes a single Chunk via the helper
+func (backend *StoragePipe) DeleteChunk(shasum string, part, totalParts uint) error {
+	_, err := backend.call(nil, "delete-chunk", shasum, strconv.FormatUint(uint64(part), 10), strconv.FormatUint(uint64(totalParts), 10))
+	return err
+}
+
+// ListChunks returns the names of all stored Chunks
+func (backend *StoragePipe) ListChunks() ([]string, error) {
+	b, err := backend.call(nil, "list-chunks")
+	return splitList(b), err
+}
+
+// LoadSnapshot loads a snapshot
+func (backend *StoragePipe) LoadSnapshot(id string) ([]byte, error) {
+	return backend.call(nil, "load-snapshot", id)
+}
+
+// SaveSnapshot stores a snapshot
+func (backend *StoragePipe) SaveSnapshot(id string, data []byte) error {
+	_, err := backend.call(data, "save-snapshot", id)
+	return err
+}
+
+// DeleteSnapshot deletes a snapshot
+func (backend *StoragePipe) DeleteSnapshot(id string) error {
+	_, err := backend.call(nil, "delete-snapshot", id)
+	return err
+}
+
+// ListSnapshots returns the IDs of all stored snapshots
+func (backend *StoragePipe) ListSnapshots() ([]string, error) {
+	b, err := backend.call(nil, "list-snapshots")
+	return splitList(b), err
+}
+
+// InitRepository creates a new repository
+func (backend *StoragePipe) InitRepository() error {
+	_, err := backend.call(nil, "init")
+	return err
+}
+
+// LoadRepository reads the metadata for a repository
+func (backend *StoragePipe) LoadRepository() ([]byte, error) {
+	return backend.call(nil, "load-repository")
+}
+
+// SaveRepository stores the metadata for a repository
+func (backend *StoragePipe) SaveRepository(data []byte) error {
+	_, err := backend.call(data, "save-repository")
+	return err
+}
+
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend *StoragePipe) ListRepositoryParts() ([]string, error) {
+	b, err := backend.call(nil, "list-repository-parts")
+	return splitList(b), err
+}
@@ -0,0 +1,245 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Error declarations
+var (
+	ErrSFTPNoAuthMethod = errors.New("No SSH authentication method available (ssh-agent, key file or password)")
+)
+
+// StorageSFTP stores data on a remote machine via SFTP
+type StorageSFTP struct {
+	url    url.URL
+	ssh    *ssh.Client
+	sftp   *sftp.Client
+	limits *transferLimits
+
+	StorageFilesystem
+}
+
+// NewStorageSFTP returns a StorageSFTP object
+// Authentication is tried with the running ssh-agent, the key file given as
+// "key" URL parameter (or the user's default keys) and finally the password
+// contained in the URL. Host keys get verified against ~/.ssh/known_hosts or
+// the file given as "known_hosts" URL parameter
+func NewStorageSFTP(u url.URL) (*StorageSFTP, error) {
+	storage := StorageSFTP{
+		url:    u,
+		limits: newTransferLimits(),
+	}
+
+	username := ""
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	if username == "" {
+		username = os.Getenv("USER")
+	}
+
+	auths := sshAuthMethods(u)
+	if len(auths) == 0 {
+		return &StorageSFTP{}, ErrSFTPNoAuthMethod
+	}
+
+	knownHostsFile := u.Query().Get("known_hosts")
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return &StorageSFTP{}, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	var dialer net.Dialer
+	conn, err := storage.limits.dialer(dialer.DialContext)(context.Background(), "tcp", host)
+	if err != nil {
+		return &StorageSFTP{}, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		conn.Close()
+		return &StorageSFTP{}, err
+	}
+	storage.ssh = ssh.NewClient(c, chans, reqs)
+
+	storage.sftp, err = sftp.NewClient(storage.ssh)
+	if err != nil {
+		storage.ssh.Close()
+		return &StorageSFTP{}, err
+	}
+
+	storagefs, _ := NewStorageFilesystem(u.Path, &storage)
+	storage.StorageFilesystem = storagefs
+	return &storage, nil
+}
+
+// sshAuthMethods collects all available authentication methods for u
+func sshAuthMethods(u url.URL) []ssh.AuthMethod {
+	auths := []ssh.AuthMethod{}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	keyFiles := []string{u.Query().Get("key")}
+	if keyFiles[0] == "" {
+		sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
+		keyFiles = []string{
+			filepath.Join(sshDir, "id_ed25519"),
+			filepath.Join(sshDir, "id_ecdsa"),
+			filepath.Join(sshDir, "id_rsa"),
+		}
+	}
+	signers := []ssh.Signer{}
+	for _, keyFile := range keyFiles {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			// Encrypted keys need to be loaded into ssh-agent
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+
+	if u.User != nil {
+		if pw, ok := u.User.Password(); ok {
+			auths = append(auths, ssh.Password(pw))
+		}
+	}
+
+	return auths
+}
+
+func (backend *StorageSFTP) transferLimits() *transferLimits {
+	return backend.limits
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageSFTP) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend
+func (backend *StorageSFTP) Close() error {
+	err := backend.sftp.Close()
+	if cerr := backend.ssh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageSFTP) Protocols() []string {
+	return []string{"sftp"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageSFTP) Description() string {
+	return "SSH/SFTP Storage"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageSFTP) AvailableSpace() (uint64, error) {
+	stat, err := backend.sftp.StatVFS(backend.path)
+	if err != nil {
+		// Not all servers support the statvfs extension
+		return 0, ErrAvailableSpaceUnknown
+	}
+
+	return stat.FreeSpace(), nil
+}
+
+// CreatePath creates a dir including all its parents dirs, when required
+func (backend *StorageSFTP) CreatePath(path string) error {
+	return backend.sftp.MkdirAll(path)
+}
+
+// Stat stats a file on the remote machine
+func (backend *StorageSFTP) Stat(path string) (uint64, error) {
+	stat, err := backend.sftp.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Size()), err
+}
+
+// ReadFile reads a file from the remote machine
+func (backend *StorageSFTP) ReadFile(path string) (*[]byte, error) {
+	file, err := backend.sftp.Open(path)
+	if err != nil {
+		return &[]byte{}, err
+	}
+	defer file.Close()
+
+	b, err := ioutil.ReadAll(file)
+	return &b, err
+}
+
+// WriteFile writes a file to the remote machine
+func (backend *StorageSFTP) WriteFile(path string, data *[]byte) (size uint64, err error) {
+	file, err := backend.sftp.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := file.Write(*data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return uint64(n), err
+}
+
+// DeleteFile deletes a file from the remote machine
+func (backend *StorageSFTP) DeleteFile(path string) error {
+	return backend.sftp.Remove(path)
+}
+
+// ReadDir returns the names of all entries in a dir on the remote machine
+func (backend *StorageSFTP) ReadDir(path string) ([]string, error) {
+	names := []string{}
+	infos, err := backend.sftp.ReadDir(path)
+	if err != nil {
+		return names, err
+	}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+
+	return names, nil
+}
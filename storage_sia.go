@@ -0,0 +1,201 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StorageSia stores data on the Sia network, using the object API of a
+// renterd node. The network spreads each object over many hosts on its own,
+// knoxite's redundancy settings still apply on top of that
+type StorageSia struct {
+	url      url.URL
+	endpoint string
+	password string
+	client   *http.Client
+	limits   *transferLimits
+
+	StorageFilesystem
+}
+
+// siaError is returned for failed requests to the renterd API
+type siaError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *siaError) Error() string {
+	return fmt.Sprintf("renterd API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// NewStorageSia returns a StorageSia object
+// URLs look like sia://:apipassword@localhost:9980/path, the sias scheme
+// connects to renterd via HTTPS
+func NewStorageSia(u url.URL) (*StorageSia, error) {
+	scheme := "http"
+	if u.Scheme == "sias" {
+		scheme = "https"
+	}
+
+	limits := newTransferLimits()
+	client, err := newHTTPClient(u, limits)
+	if err != nil {
+		return &StorageSia{}, err
+	}
+
+	storage := StorageSia{
+		url:      u,
+		endpoint: scheme + "://" + u.Host + "/api/worker/objects",
+		client:   client,
+		limits:   limits,
+	}
+	if u.User != nil {
+		storage.password, _ = u.User.Password()
+	}
+
+	storagefs, _ := NewStorageFilesystem(u.Path, &storage)
+	storage.StorageFilesystem = storagefs
+	return &storage, nil
+}
+
+func (backend *StorageSia) transferLimits() *transferLimits {
+	return backend.limits
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageSia) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend
+func (backend *StorageSia) Close() error {
+	return nil
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageSia) Protocols() []string {
+	return []string{"sia", "sias"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageSia) Description() string {
+	return "Sia Decentralized Storage"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageSia) AvailableSpace() (uint64, error) {
+	// Storage on Sia is only limited by the renter's allowance
+	return 0, ErrAvailableSpaceUnknown
+}
+
+// request sends an authenticated request for the object stored at path
+func (backend *StorageSia) request(method, path string, body io.Reader) (*http.Response, error) {
+	u := backend.endpoint + (&url.URL{Path: "/" + strings.TrimPrefix(path, "/")}).EscapedPath()
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("", backend.password)
+
+	res, err := backend.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return nil, &siaError{res.StatusCode, string(b)}
+	}
+
+	return res, nil
+}
+
+// CreatePath creates a dir including all its parents dirs, when required
+func (backend *StorageSia) CreatePath(path string) error {
+	// Directories are implicit in renterd's object store
+	return nil
+}
+
+// Stat stats an object
+func (backend *StorageSia) Stat(path string) (uint64, error) {
+	res, err := backend.request("HEAD", path, nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	return uint64(res.ContentLength), nil
+}
+
+// ReadFile downloads an object
+func (backend *StorageSia) ReadFile(path string) (*[]byte, error) {
+	res, err := backend.request("GET", path, nil)
+	if err != nil {
+		return &[]byte{}, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	return &b, err
+}
+
+// WriteFile uploads an object
+func (backend *StorageSia) WriteFile(path string, data *[]byte) (uint64, error) {
+	res, err := backend.request("PUT", path, bytes.NewReader(*data))
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	return uint64(len(*data)), nil
+}
+
+// DeleteFile deletes an object
+func (backend *StorageSia) DeleteFile(path string) error {
+	res, err := backend.request("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// ReadDir returns the names of all entries in a dir
+func (backend *StorageSia) ReadDir(path string) ([]string, error) {
+	names := []string{}
+	res, err := backend.request("GET", strings.TrimSuffix(path, "/")+"/", nil)
+	if err != nil {
+		return names, err
+	}
+	defer res.Body.Close()
+
+	var dir struct {
+		Entries []struct {
+			Name string `json:"name"`
+		} `json:"entries"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&dir); err != nil {
+		return names, err
+	}
+	for _, entry := range dir.Entries {
+		// Entries carry their full path, dirs have a trailing slash
+		name := strings.TrimSuffix(entry.Name, "/")
+		names = append(names, name[strings.LastIndex(name, "/")+1:])
+	}
+
+	return names, nil
+}
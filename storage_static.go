@@ -0,0 +1,169 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Error declarations
+var (
+	ErrReadOnlyBackend = errors.New("This storage backend is read-only")
+)
+
+// StorageStatic loads data from a repository published on a static HTTP(S)
+// server, e.g. a directory served by nginx or an S3 website. The repository
+// needs the same layout as a local repository. This backend is read-only and
+// meant for restore mirrors
+type StorageStatic struct {
+	url      url.URL
+	endpoint url.URL
+	client   *http.Client
+	limits   *transferLimits
+
+	StorageFilesystem
+}
+
+// staticHTTPError is returned for failed requests
+type staticHTTPError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *staticHTTPError) Error() string {
+	return fmt.Sprintf("Fetching %s failed with HTTP status %d", e.URL, e.StatusCode)
+}
+
+// NewStorageStatic returns a StorageStatic object
+// URLs look like static+https://host/path/to/repository
+func NewStorageStatic(u url.URL) (*StorageStatic, error) {
+	limits := newTransferLimits()
+	client, err := newHTTPClient(u, limits)
+	if err != nil {
+		return &StorageStatic{}, err
+	}
+
+	storage := StorageStatic{
+		url:      u,
+		endpoint: u,
+		client:   client,
+		limits:   limits,
+	}
+	storage.endpoint.Scheme = strings.TrimPrefix(u.Scheme, "static+")
+	storage.endpoint.RawQuery = ""
+
+	storagefs, _ := NewStorageFilesystem(u.Path, &storage)
+	storage.StorageFilesystem = storagefs
+	return &storage, nil
+}
+
+func (backend *StorageStatic) transferLimits() *transferLimits {
+	return backend.limits
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageStatic) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend
+func (backend *StorageStatic) Close() error {
+	return nil
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageStatic) Protocols() []string {
+	return []string{"static+http", "static+https"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageStatic) Description() string {
+	return "Read-only HTTP(S) Mirror"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageStatic) AvailableSpace() (uint64, error) {
+	return 0, ErrAvailableSpaceUnknown
+}
+
+// request fetches the file stored at path
+func (backend *StorageStatic) request(method, path string) (*http.Response, error) {
+	u := backend.endpoint
+	u.Path = filepath.ToSlash(path)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.User != nil {
+		pw, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), pw)
+	}
+
+	res, err := backend.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		u.User = nil
+		return nil, &staticHTTPError{u.String(), res.StatusCode}
+	}
+
+	return res, nil
+}
+
+// CreatePath creates a dir including all its parents dirs, when required
+func (backend *StorageStatic) CreatePath(path string) error {
+	return ErrReadOnlyBackend
+}
+
+// Stat stats a file on the server
+func (backend *StorageStatic) Stat(path string) (uint64, error) {
+	res, err := backend.request("HEAD", path)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	return uint64(res.ContentLength), nil
+}
+
+// ReadFile downloads a file from the server
+func (backend *StorageStatic) ReadFile(path string) (*[]byte, error) {
+	res, err := backend.request("GET", path)
+	if err != nil {
+		return &[]byte{}, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	return &b, err
+}
+
+// WriteFile writes a file to the server
+func (backend *StorageStatic) WriteFile(path string, data *[]byte) (uint64, error) {
+	return 0, ErrReadOnlyBackend
+}
+
+// DeleteFile deletes a file from the server
+func (backend *StorageStatic) DeleteFile(path string) error {
+	return ErrReadOnlyBackend
+}
+
+// ReadDir returns the names of all entries in a dir
+func (backend *StorageStatic) ReadDir(path string) ([]string, error) {
+	// Static servers don't offer a reliable way to list directories
+	return []string{}, ErrListingUnsupported
+}
@@ -0,0 +1,58 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStorageStatic(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for repository: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer ts.Close()
+
+	u := strings.Replace(ts.URL, "http://", "static+http://", 1) + "/"
+	mirror, err := OpenRepository(u, testPassword)
+	if err != nil {
+		t.Errorf("Failed opening mirrored repository: %s", err)
+		return
+	}
+	if _, err = mirror.FindVolume(vol.ID); err != nil {
+		t.Errorf("Failed finding volume: %s", err)
+	}
+
+	_, err = NewRepository(u, testPassword)
+	if err != ErrRepositoryExists {
+		t.Errorf("Expected %v, got %v", ErrRepositoryExists, err)
+	}
+}
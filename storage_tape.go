@@ -0,0 +1,660 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Record types stored in a tape container
+const (
+	tapeRecordChunk = iota + 1
+	tapeRecordSnapshot
+	tapeRecordRepository
+	tapeRecordEnd
+	tapeRecordDelete
+)
+
+// tapeBlockSize is the size of the blocks written to and read from the
+// container. Tape drives in variable block mode can't read blocks bigger than
+// the buffer passed to them
+const tapeBlockSize = 64 * (1 << 10)
+
+var tapeRecordMagic = []byte("KNXR")
+
+// Error declarations
+var (
+	ErrTapeRecordNotFound = errors.New("Record not found in tape container")
+	ErrTapeCorrupted      = errors.New("Tape container is corrupted")
+)
+
+// tapeIndexEntry describes where a record's data is located in the container
+type tapeIndexEntry struct {
+	Offset int64
+	Size   uint64
+}
+
+// StorageTape stores data on sequential media. All records get appended to a
+// single container (a file or a tape device) in the order they get written,
+// nothing ever gets overwritten. Each session ends with an end record holding
+// the index of all records, tape drives add a filemark after it. Containers
+// supporting seeking load the index from there when opening them. Otherwise,
+// or if the last session got interrupted, the index gets rebuilt by reading
+// the container sequentially, records of interrupted sessions are recovered
+// as well.
+//
+// Records get read with a separate handle, which seeks if the container
+// supports it. Otherwise the container gets read sequentially up to the record,
+// and from its start again for records located before the previously read
+// one. Tape devices can only be opened once, so reading them requires their
+// rewinding device node (e.g. /dev/st0) and a session that doesn't write
+type StorageTape struct {
+	path string
+	mut  sync.Mutex
+
+	// file and w append records, offset is the end of the container
+	file   *os.File
+	w      *bufio.Writer
+	offset int64
+	dirty  bool
+
+	// reader and rd read records, see seekTo
+	reader *os.File
+	rd     *tapeReader
+
+	index map[string]tapeIndexEntry
+}
+
+// NewStorageTape returns a StorageTape object
+func NewStorageTape(path string) (*StorageTape, error) {
+	storage := StorageTape{
+		path:  path,
+		index: make(map[string]tapeIndexEntry),
+	}
+
+	var err error
+	storage.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return &StorageTape{}, err
+	}
+
+	if err = storage.readIndex(); err != nil {
+		// Reading the entire container leaves it positioned at its end,
+		// where new records get appended
+		storage.index = make(map[string]tapeIndexEntry)
+		storage.file.Seek(0, io.SeekStart)
+		if err = storage.scan(); err != nil {
+			storage.file.Close()
+			return &StorageTape{}, err
+		}
+	}
+	storage.w = bufio.NewWriterSize(storage.file, tapeBlockSize)
+	return &storage, nil
+}
+
+// readIndex loads the index from the end record of the last session. The
+// last 8 bytes of its data are the offset of the record itself, so it can be
+// found from the end of the container. This fails for containers which don't
+// support seeking, or if records got appended after the end record
+func (backend *StorageTape) readIndex() error {
+	end, err := backend.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end < 12 {
+		return ErrTapeCorrupted
+	}
+	trailer := make([]byte, 12)
+	if _, err = backend.file.ReadAt(trailer, end-12); err != nil {
+		return err
+	}
+	start := int64(binary.BigEndian.Uint64(trailer[:8]))
+	if start < 0 || start >= end {
+		return ErrTapeCorrupted
+	}
+
+	s := tapeScanner{rd: newTapeReader(io.NewSectionReader(backend.file, start, end-start), start), pos: start}
+	rec, consumed, err := s.record()
+	if err != nil {
+		return err
+	}
+	if rec.typ != tapeRecordEnd || s.pos != end || rec.entry.Size < 8 {
+		return ErrTapeCorrupted
+	}
+	data := consumed[rec.entry.Offset-start : rec.entry.Offset-start+int64(rec.entry.Size)-8]
+	if err = backend.decodeIndex(data); err != nil {
+		return err
+	}
+
+	backend.offset = end
+	return nil
+}
+
+// encodeIndex serializes the index for the end record
+func (backend *StorageTape) encodeIndex() []byte {
+	var buf bytes.Buffer
+	for key, entry := range backend.index {
+		binary.Write(&buf, binary.BigEndian, uint16(len(key)))
+		buf.WriteString(key)
+		binary.Write(&buf, binary.BigEndian, entry.Offset)
+		binary.Write(&buf, binary.BigEndian, entry.Size)
+	}
+	return buf.Bytes()
+}
+
+// decodeIndex adds the entries of a serialized index to the index
+func (backend *StorageTape) decodeIndex(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return ErrTapeCorrupted
+		}
+		keyLen := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+keyLen+16 {
+			return ErrTapeCorrupted
+		}
+		key := string(data[2 : 2+keyLen])
+		data = data[2+keyLen:]
+		backend.index[key] = tapeIndexEntry{
+			Offset: int64(binary.BigEndian.Uint64(data)),
+			Size:   binary.BigEndian.Uint64(data[8:]),
+		}
+		data = data[16:]
+	}
+	return nil
+}
+
+// scan rebuilds the index by reading all records in the container. Damaged
+// records, e.g. the last one of an interrupted session, get skipped
+func (backend *StorageTape) scan() error {
+	s := tapeScanner{rd: newTapeReader(backend.file, 0)}
+	for {
+		start := s.pos
+		rec, consumed, err := s.record()
+		if err == nil {
+			switch rec.typ {
+			case tapeRecordEnd:
+			case tapeRecordDelete:
+				delete(backend.index, rec.name)
+			default:
+				backend.index[tapeKey(rec.typ, rec.name)] = rec.entry
+			}
+			continue
+		}
+		if err == io.EOF && len(consumed) == 0 {
+			break
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF && err != ErrTapeCorrupted {
+			return err
+		}
+
+		// Look for the next record behind the start of the damaged one
+		if err = s.resync(start, consumed); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	backend.offset = s.pos
+	return nil
+}
+
+// tapeRecord is a record found while scanning a container
+type tapeRecord struct {
+	typ   byte
+	name  string
+	entry tapeIndexEntry
+}
+
+// tapeScanner reads records sequentially. Bytes of damaged records get
+// pushed back to pending, so records following them can still be found
+type tapeScanner struct {
+	rd      *tapeReader
+	pending []byte
+	// pos is the offset of the next byte read
+	pos int64
+}
+
+// read returns the next n bytes, or less and an error
+func (s *tapeScanner) read(n uint64) ([]byte, error) {
+	b := make([]byte, n)
+	c := copy(b, s.pending)
+	s.pending = s.pending[c:]
+
+	var err error
+	if uint64(c) < n {
+		var m int
+		m, err = io.ReadFull(s.rd, b[c:])
+		c += m
+	}
+	s.pos += int64(c)
+	return b[:c], err
+}
+
+// record reads the next record. consumed holds all bytes read, also if the
+// record turned out to be damaged
+func (s *tapeScanner) record() (rec tapeRecord, consumed []byte, err error) {
+	start := s.pos
+	consumed, err = s.read(7)
+	if err != nil {
+		return rec, consumed, err
+	}
+	if !bytes.Equal(consumed[:4], tapeRecordMagic) {
+		return rec, consumed, ErrTapeCorrupted
+	}
+	rec.typ = consumed[4]
+	nameLen := uint64(binary.BigEndian.Uint16(consumed[5:7]))
+
+	b, err := s.read(nameLen + 12)
+	consumed = append(consumed, b...)
+	if err != nil {
+		return rec, consumed, err
+	}
+	header := consumed[:len(consumed)-4]
+	if crc32.ChecksumIEEE(header) != binary.BigEndian.Uint32(consumed[len(header):]) {
+		return rec, consumed, ErrTapeCorrupted
+	}
+	rec.name = string(consumed[7 : 7+nameLen])
+	rec.entry = tapeIndexEntry{
+		Offset: start + int64(len(consumed)),
+		Size:   binary.BigEndian.Uint64(header[len(header)-8:]),
+	}
+
+	b, err = s.read(rec.entry.Size + 4)
+	consumed = append(consumed, b...)
+	if err != nil {
+		return rec, consumed, err
+	}
+	if crc32.ChecksumIEEE(b[:rec.entry.Size]) != binary.BigEndian.Uint32(b[rec.entry.Size:]) {
+		return rec, consumed, ErrTapeCorrupted
+	}
+	return rec, consumed, nil
+}
+
+// resync skips ahead to the next record magic behind the damaged record at
+// start. consumed are the bytes read for it
+func (s *tapeScanner) resync(start int64, consumed []byte) error {
+	s.pending = append(append([]byte{}, consumed[1:]...), s.pending...)
+	s.pos = start + 1
+
+	for {
+		if i := bytes.Index(s.pending, tapeRecordMagic); i >= 0 {
+			s.pending = s.pending[i:]
+			s.pos += int64(i)
+			return nil
+		}
+		// Keep what may be the beginning of a magic
+		if keep := len(tapeRecordMagic) - 1; len(s.pending) > keep {
+			s.pos += int64(len(s.pending) - keep)
+			s.pending = s.pending[len(s.pending)-keep:]
+		}
+
+		b := make([]byte, tapeBlockSize)
+		n, err := s.rd.Read(b)
+		s.pending = append(s.pending, b[:n]...)
+		if n == 0 && err != nil {
+			// Trailing garbage
+			s.pos += int64(len(s.pending))
+			s.pending = nil
+			return err
+		}
+	}
+}
+
+// tapeReader reads a container sequentially and keeps track of its position
+type tapeReader struct {
+	rd  *bufio.Reader
+	pos int64
+}
+
+func newTapeReader(rd io.Reader, pos int64) *tapeReader {
+	return &tapeReader{
+		rd:  bufio.NewReaderSize(filemarkReader{rd}, tapeBlockSize),
+		pos: pos,
+	}
+}
+
+func (r *tapeReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// filemarkReader reads across filemarks. Reading a filemark returns no data,
+// the following read continues with the data behind it. Two filemarks in a
+// row, like the end of a file, end the container
+type filemarkReader struct {
+	rd io.Reader
+}
+
+func (f filemarkReader) Read(p []byte) (int, error) {
+	n, err := f.rd.Read(p)
+	if n == 0 && err == io.EOF {
+		n, err = f.rd.Read(p)
+	}
+	return n, err
+}
+
+// writeRecord appends a record to the container and returns the offset of
+// its data
+func (backend *StorageTape) writeRecord(typ byte, name string, data []byte) (int64, error) {
+	var header bytes.Buffer
+	header.Write(tapeRecordMagic)
+	header.WriteByte(typ)
+	binary.Write(&header, binary.BigEndian, uint16(len(name)))
+	header.WriteString(name)
+	binary.Write(&header, binary.BigEndian, uint64(len(data)))
+	binary.Write(&header, binary.BigEndian, crc32.ChecksumIEEE(header.Bytes()))
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(data))
+	for _, b := range [][]byte{header.Bytes(), data, checksum} {
+		if _, err := backend.w.Write(b); err != nil {
+			return 0, err
+		}
+	}
+
+	dataOffset := backend.offset + int64(header.Len())
+	backend.offset = dataOffset + int64(len(data)+len(checksum))
+	backend.dirty = true
+	return dataOffset, nil
+}
+
+// store appends a record and adds it to the index
+func (backend *StorageTape) store(typ byte, name string, data []byte) error {
+	offset, err := backend.writeRecord(typ, name, data)
+	if err != nil {
+		return err
+	}
+
+	backend.index[tapeKey(typ, name)] = tapeIndexEntry{
+		Offset: offset,
+		Size:   uint64(len(data)),
+	}
+	return nil
+}
+
+// remove appends a record marking a previous record as deleted and drops it
+// from the index. Sequential media can't reclaim the space
+func (backend *StorageTape) remove(typ byte, name string) error {
+	key := tapeKey(typ, name)
+	if _, ok := backend.index[key]; !ok {
+		return ErrTapeRecordNotFound
+	}
+
+	if _, err := backend.writeRecord(tapeRecordDelete, key, nil); err != nil {
+		return err
+	}
+	delete(backend.index, key)
+	return nil
+}
+
+// list returns the names of all indexed records of a type
+func (backend *StorageTape) list(typ byte) []string {
+	prefix := tapeKey(typ, "")
+	names := []string{}
+	for key := range backend.index {
+		if strings.HasPrefix(key, prefix) {
+			names = append(names, strings.TrimPrefix(key, prefix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// load reads a record's data from the container
+func (backend *StorageTape) load(typ byte, name string) ([]byte, error) {
+	entry, ok := backend.index[tapeKey(typ, name)]
+	if !ok {
+		return nil, ErrTapeRecordNotFound
+	}
+	// Records written in this session may still be buffered
+	if err := backend.w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := backend.seekTo(entry.Offset); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, entry.Size+4)
+	if _, err := io.ReadFull(backend.rd, b); err != nil {
+		return nil, err
+	}
+	data := b[:entry.Size]
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(b[entry.Size:]) {
+		return nil, ErrTapeCorrupted
+	}
+	return data, nil
+}
+
+// seekTo positions the reader at offset. Containers supporting it seek there
+// directly. Otherwise they get read up to offset, from their start again if
+// offset lies before the current position
+func (backend *StorageTape) seekTo(offset int64) error {
+	if backend.reader != nil {
+		if _, err := backend.reader.Seek(offset, io.SeekStart); err == nil {
+			backend.rd = newTapeReader(backend.reader, offset)
+			return nil
+		}
+	}
+
+	if backend.reader == nil || offset < backend.rd.pos {
+		if backend.reader != nil {
+			backend.reader.Close()
+		}
+		f, err := os.Open(backend.path)
+		if err != nil {
+			backend.reader = nil
+			return err
+		}
+		backend.reader = f
+		backend.rd = newTapeReader(f, 0)
+		if _, err = f.Seek(offset, io.SeekStart); err == nil {
+			backend.rd = newTapeReader(f, offset)
+			return nil
+		}
+	}
+
+	_, err := io.CopyN(ioutil.Discard, backend.rd, offset-backend.rd.pos)
+	return err
+}
+
+func tapeKey(typ byte, name string) string {
+	return strconv.Itoa(int(typ)) + ":" + name
+}
+
+// Location returns the type and location of the repository
+func (backend *StorageTape) Location() string {
+	return "tape://" + backend.path
+}
+
+// Close the backend
+func (backend *StorageTape) Close() error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	var err error
+	if backend.dirty {
+		// The end record's offset follows the index, see readIndex
+		data := backend.encodeIndex()
+		offset := make([]byte, 8)
+		binary.BigEndian.PutUint64(offset, uint64(backend.offset))
+		_, err = backend.writeRecord(tapeRecordEnd, "", append(data, offset...))
+	}
+	if ferr := backend.w.Flush(); err == nil {
+		err = ferr
+	}
+	if backend.reader != nil {
+		backend.reader.Close()
+	}
+	if cerr := backend.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Protocols returns the Protocol Schemes supported by this backend
+func (backend *StorageTape) Protocols() []string {
+	return []string{"tape"}
+}
+
+// Description returns a user-friendly description for this backend
+func (backend *StorageTape) Description() string {
+	return "Sequential Tape Storage"
+}
+
+// AvailableSpace returns the free space on this backend
+func (backend *StorageTape) AvailableSpace() (uint64, error) {
+	return 0, ErrAvailableSpaceUnknown
+}
+
+// LoadChunk loads a Chunk from the container
+func (backend *StorageTape) LoadChunk(shasum string, part, totalParts uint) (*[]byte, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	data, err := backend.load(tapeRecordChunk, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+	return &data, err
+}
+
+// StatChunk returns the size of a Chunk in the container
+func (backend *StorageTape) StatChunk(shasum string, part, totalParts uint) (uint64, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.statChunk(shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10))
+}
+
+func (backend *StorageTape) statChunk(name string) (uint64, error) {
+	entry, ok := backend.index[tapeKey(tapeRecordChunk, name)]
+	if !ok {
+		return 0, ErrTapeRecordNotFound
+	}
+	return entry.Size, nil
+}
+
+// StoreChunk appends a single Chunk to the container
+func (backend *StorageTape) StoreChunk(shasum string, part, totalParts uint, data *[]byte) (uint64, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	name := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
+	if size, err := backend.statChunk(name); err == nil && size == uint64(len(*data)) {
+		// Chunk is already stored
+		return 0, nil
+	}
+
+	if err := backend.store(tapeRecordChunk, name, *data); err != nil {
+		return 0, err
+	}
+	return uint64(len(*data)), nil
+}
+
+// DeleteChunk deletes a single Chunk from the container
+func (backend *StorageTape) DeleteChunk(shasum string, part, totalParts uint) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.remove(tapeRecordChunk, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+}
+
+// ListChunks returns the names of all Chunks in the container
+func (backend *StorageTape) ListChunks() ([]string, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.list(tapeRecordChunk), nil
+}
+
+// LoadSnapshot loads a snapshot
+func (backend *StorageTape) LoadSnapshot(id string) ([]byte, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.load(tapeRecordSnapshot, id)
+}
+
+// SaveSnapshot stores a snapshot
+func (backend *StorageTape) SaveSnapshot(id string, data []byte) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.store(tapeRecordSnapshot, id, data)
+}
+
+// DeleteSnapshot deletes a snapshot
+func (backend *StorageTape) DeleteSnapshot(id string) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.remove(tapeRecordSnapshot, id)
+}
+
+// ListSnapshots returns the IDs of all snapshots in the container
+func (backend *StorageTape) ListSnapshots() ([]string, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.list(tapeRecordSnapshot), nil
+}
+
+// InitRepository creates a new repository
+func (backend *StorageTape) InitRepository() error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	if len(backend.index) > 0 {
+		return ErrRepositoryExists
+	}
+	return nil
+}
+
+// LoadRepository reads the metadata for a repository
+func (backend *StorageTape) LoadRepository() ([]byte, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.load(tapeRecordRepository, repoFilename)
+}
+
+// SaveRepository stores the metadata for a repository
+func (backend *StorageTape) SaveRepository(data []byte) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	if err := backend.store(tapeRecordRepository, repoFilename, data); err != nil {
+		return err
+	}
+
+	// Saving the repository concludes an operation, a good moment to make
+	// sure everything got written
+	if err := backend.w.Flush(); err != nil {
+		return err
+	}
+	return backend.file.Sync()
+}
+
+// ListRepositoryParts returns the names of all repository metadata files
+func (backend *StorageTape) ListRepositoryParts() ([]string, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return backend.list(tapeRecordRepository), nil
+}
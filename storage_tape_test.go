@@ -0,0 +1,221 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageTapeReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for container: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "container")
+
+	data := []byte("1234567890")
+	{
+		tape, err := NewStorageTape(path)
+		if err != nil {
+			t.Errorf("Failed creating container: %s", err)
+			return
+		}
+		if _, err = tape.StoreChunk("abcdef", 0, 1, &data); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+		}
+		if err = tape.SaveRepository([]byte("repository")); err != nil {
+			t.Errorf("Failed saving repository: %s", err)
+		}
+		if err = tape.SaveSnapshot("snap", []byte("snapshot")); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+		}
+		if err = tape.SaveSnapshot("deleted", []byte("snapshot")); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+		}
+		if err = tape.DeleteSnapshot("deleted"); err != nil {
+			t.Errorf("Failed deleting snapshot: %s", err)
+		}
+		// Crash without ending the session
+		tape.w.Flush()
+		tape.file.Close()
+	}
+
+	tape, err := NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed opening container: %s", err)
+		return
+	}
+	defer tape.Close()
+
+	b, err := tape.LoadChunk("abcdef", 0, 1)
+	if err != nil || string(*b) != string(data) {
+		t.Errorf("Failed loading chunk: %v %s", err, string(*b))
+	}
+	s, err := tape.LoadSnapshot("snap")
+	if err != nil || string(s) != "snapshot" {
+		t.Errorf("Failed loading snapshot: %v %s", err, string(s))
+	}
+	if _, err = tape.LoadSnapshot("deleted"); err != ErrTapeRecordNotFound {
+		t.Errorf("Expected %v, got %v", ErrTapeRecordNotFound, err)
+	}
+	r, err := tape.LoadRepository()
+	if err != nil || string(r) != "repository" {
+		t.Errorf("Failed loading repository: %v %s", err, string(r))
+	}
+	if err = tape.InitRepository(); err != ErrRepositoryExists {
+		t.Errorf("Expected %v, got %v", ErrRepositoryExists, err)
+	}
+}
+
+func TestStorageTapeTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for container: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "container")
+
+	data := []byte("1234567890")
+	tape, err := NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed creating container: %s", err)
+		return
+	}
+	tape.StoreChunk("first", 0, 1, &data)
+	tape.StoreChunk("truncated", 0, 1, &data)
+	tape.w.Flush()
+	tape.file.Close()
+
+	// An interrupted write leaves a partial record behind, the next session
+	// appends its records after it
+	fi, _ := os.Stat(path)
+	os.Truncate(path, fi.Size()-30)
+	for i, shasum := range []string{"second", "third"} {
+		tape, err = NewStorageTape(path)
+		if err != nil {
+			t.Errorf("Failed opening container: %s", err)
+			return
+		}
+		if i == 0 {
+			if _, err = tape.StatChunk("truncated", 0, 1); err != ErrTapeRecordNotFound {
+				t.Errorf("Expected %v, got %v", ErrTapeRecordNotFound, err)
+			}
+		}
+		if _, err = tape.StoreChunk(shasum, 0, 1, &data); err != nil {
+			t.Errorf("Failed storing chunk: %s", err)
+		}
+		tape.Close()
+	}
+
+	tape, err = NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed opening container: %s", err)
+		return
+	}
+	defer tape.Close()
+
+	chunks, _ := tape.ListChunks()
+	if len(chunks) != 3 {
+		t.Errorf("Expected 3 chunks, got %v", chunks)
+	}
+	// Read backwards, the reader has to go back for each of them
+	for _, shasum := range []string{"third", "second", "first"} {
+		b, err := tape.LoadChunk(shasum, 0, 1)
+		if err != nil || string(*b) != string(data) {
+			t.Errorf("Failed loading chunk %s: %v", shasum, err)
+		}
+	}
+}
+
+func TestStorageTapeIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for container: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "container")
+
+	data := []byte("1234567890")
+	tape, err := NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed creating container: %s", err)
+		return
+	}
+	tape.StoreChunk("first", 0, 1, &data)
+	tape.StoreChunk("deleted", 0, 1, &data)
+	tape.DeleteChunk("deleted", 0, 1)
+	tape.SaveSnapshot("snap", []byte("snapshot"))
+	tape.Close()
+
+	// Wipe the magic of all records but the end record, so they can only be
+	// found through its index
+	b, _ := ioutil.ReadFile(path)
+	last := bytes.LastIndex(b, tapeRecordMagic)
+	wiped := bytes.Replace(b[:last], tapeRecordMagic, []byte("XXXX"), -1)
+	ioutil.WriteFile(path, append(wiped, b[last:]...), 0600)
+
+	tape, err = NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed opening container: %s", err)
+		return
+	}
+	chunks, _ := tape.ListChunks()
+	if len(chunks) != 1 || chunks[0] != "first.0_1" {
+		t.Errorf("Expected chunk first.0_1, got %v", chunks)
+	}
+	s, err := tape.LoadSnapshot("snap")
+	if err != nil || string(s) != "snapshot" {
+		t.Errorf("Failed loading snapshot: %v %s", err, string(s))
+	}
+
+	// Records get appended behind the index, the next session's end record
+	// holds the index of all of them
+	if _, err = tape.StoreChunk("second", 0, 1, &data); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+	}
+	tape.Close()
+
+	tape, err = NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed opening container: %s", err)
+		return
+	}
+	chunks, _ = tape.ListChunks()
+	if len(chunks) != 2 {
+		t.Errorf("Expected 2 chunks, got %v", chunks)
+	}
+	c, err := tape.LoadChunk("second", 0, 1)
+	if err != nil || string(*c) != string(data) {
+		t.Errorf("Failed loading chunk: %v", err)
+	}
+	tape.Close()
+
+	// A damaged index falls back to scanning the container
+	b, _ = ioutil.ReadFile(path)
+	b[len(b)-20] ^= 0xff
+	ioutil.WriteFile(path, b, 0600)
+
+	tape, err = NewStorageTape(path)
+	if err != nil {
+		t.Errorf("Failed opening container: %s", err)
+		return
+	}
+	defer tape.Close()
+	chunks, _ = tape.ListChunks()
+	if len(chunks) != 1 || chunks[0] != "second.0_1" {
+		t.Errorf("Expected chunk second.0_1, got %v", chunks)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// xattrPAXPrefix prefixes the PAX records holding extended attributes
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// WriteTar writes all items of the snapshot to w as a tar archive, so they
+// can be restored with standard tools
+func (snapshot *Snapshot) WriteTar(repository Repository, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, item := range snapshot.Items {
+		if item.Type == Socket {
+			// tar archives can't hold sockets
+			continue
+		}
+		hdr := tarHeader(item)
+		if hdr.Name == "" || hdr.Name == "/" {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if err := WriteArchive(repository, item, tw); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// tarHeader returns the tar header describing item
+func tarHeader(item ItemData) *tar.Header {
+	mode := int64(item.Mode.Perm())
+	if item.Mode&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if item.Mode&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if item.Mode&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+
+	// Like tar, store absolute paths relative to the root
+	hdr := &tar.Header{
+		Name:    strings.TrimLeft(filepath.ToSlash(item.Path), "/"),
+		Mode:    mode,
+		Uid:     int(item.UID),
+		Gid:     int(item.GID),
+		ModTime: item.ModTime,
+		Format:  tar.FormatPAX,
+	}
+
+	// Extended attributes get stored the way GNU tar and star do
+	for name, value := range item.Xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[xattrPAXPrefix+name] = string(value)
+	}
+
+	switch item.Type {
+	case Directory:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case SymLink:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = item.PointsTo
+	case CharDevice, BlockDevice:
+		hdr.Typeflag = tar.TypeChar
+		if item.Type == BlockDevice {
+			hdr.Typeflag = tar.TypeBlock
+		}
+		major, minor := devNumbers(item.Rdev)
+		hdr.Devmajor, hdr.Devminor = int64(major), int64(minor)
+	case NamedPipe:
+		hdr.Typeflag = tar.TypeFifo
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(item.Size)
+	}
+	return hdr
+}
+
+// AddTar stores the content of the tar archive read from rd, keeping the
+// modes, owners and modification times of its entries. Hardlinks and other
+// unsupported entries get counted as errors
+func (snapshot *Snapshot) AddTar(rd io.Reader, repository Repository, opts StoreOptions) error {
+	if err := opts.Filter.Check(); err != nil {
+		return err
+	}
+	chunker, err := repository.NewChunker(snapshot.volume, opts)
+	if err != nil {
+		return err
+	}
+	if err = repository.buildDedupIndex(); err != nil {
+		return err
+	}
+
+	var skipped uint64
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path := strings.TrimPrefix(filepath.Clean(filepath.FromSlash(hdr.Name)), string(filepath.Separator))
+		if isSpecialPath(path) || !opts.Filter.Match(path) {
+			continue
+		}
+		id := ItemData{
+			Path:    path,
+			Mode:    hdr.FileInfo().Mode(),
+			ModTime: hdr.ModTime,
+			UID:     uint32(hdr.Uid),
+			GID:     uint32(hdr.Gid),
+		}
+
+		for key, value := range hdr.PAXRecords {
+			if !strings.HasPrefix(key, xattrPAXPrefix) {
+				continue
+			}
+			if id.Xattrs == nil {
+				id.Xattrs = make(map[string][]byte)
+			}
+			id.Xattrs[strings.TrimPrefix(key, xattrPAXPrefix)] = []byte(value)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			id.Type = Directory
+		case tar.TypeSymlink:
+			id.Type = SymLink
+			id.PointsTo = hdr.Linkname
+		case tar.TypeChar:
+			id.Type = CharDevice
+			id.Rdev = mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		case tar.TypeBlock:
+			id.Type = BlockDevice
+			id.Rdev = mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		case tar.TypeFifo:
+			id.Type = NamedPipe
+		case tar.TypeReg:
+			id.Type = File
+			if hdr.Size > 0 && uint64(hdr.Size) <= opts.InlineSize {
+				if id.Data, err = ioutil.ReadAll(tr); err != nil {
+					return err
+				}
+				id.Size = uint64(len(id.Data))
+				id.StorageSize = id.Size
+				break
+			}
+
+			c := chunker
+			c.compression, c.level = opts.compressionFor(path)
+			if err = storeStream(c, &id, tr, repository, opts); err != nil {
+				return err
+			}
+		default:
+			skipped++
+			continue
+		}
+
+		snapshot.AddItem(&id)
+	}
+
+	snapshot.Stats.Errors += skipped
+	return nil
+}
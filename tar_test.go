@@ -0,0 +1,138 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteTar(t *testing.T) {
+	r, err := NewRepository("memory://tar", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+
+	orig, _ := ioutil.ReadFile("tar_test.go")
+	snapshot, _ := NewSnapshot("test")
+	modTime := time.Date(2016, 7, 29, 2, 6, 4, 0, time.UTC)
+	snapshot.Items = []ItemData{
+		{Path: "src", Type: Directory, Mode: os.ModeDir | 0755, ModTime: modTime, Xattrs: map[string][]byte{"user.test": []byte("value")}},
+		{Path: "src/link", Type: SymLink, PointsTo: "tar_test.go", Mode: os.ModeSymlink | 0777, ModTime: modTime},
+		{Path: "src/fifo", Type: NamedPipe, Mode: os.ModeNamedPipe | 0600, ModTime: modTime},
+		{Path: "src/socket", Type: Socket, Mode: os.ModeSocket | 0755, ModTime: modTime},
+	}
+	err = snapshot.AddStream("src/tar_test.go", bytes.NewReader(orig), r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding stream: %s", err)
+		return
+	}
+
+	var b bytes.Buffer
+	if err = snapshot.WriteTar(r, &b); err != nil {
+		t.Errorf("Failed writing tar archive: %s", err)
+		return
+	}
+
+	tr := tar.NewReader(&b)
+	expected := []struct {
+		name     string
+		typeflag byte
+	}{{"src/", tar.TypeDir}, {"src/link", tar.TypeSymlink}, {"src/fifo", tar.TypeFifo}, {"src/tar_test.go", tar.TypeReg}}
+	for _, e := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Errorf("Failed reading tar archive: %s", err)
+			return
+		}
+		if hdr.Name != e.name || hdr.Typeflag != e.typeflag {
+			t.Errorf("Expected %s (%c), got %s (%c)", e.name, e.typeflag, hdr.Name, hdr.Typeflag)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if hdr.Mode != 0755 || !hdr.ModTime.Equal(modTime) {
+				t.Errorf("Unexpected mode %o or modification time %v", hdr.Mode, hdr.ModTime)
+			}
+			if hdr.PAXRecords["SCHILY.xattr.user.test"] != "value" {
+				t.Errorf("Expected extended attribute, got %v", hdr.PAXRecords)
+			}
+		case tar.TypeSymlink:
+			if hdr.Linkname != "tar_test.go" {
+				t.Errorf("Expected symlink to tar_test.go, got %s", hdr.Linkname)
+			}
+		case tar.TypeReg:
+			data, _ := ioutil.ReadAll(tr)
+			if !bytes.Equal(data, orig) {
+				t.Errorf("File content differs from original")
+			}
+		}
+	}
+	if _, err = tr.Next(); err != io.EOF {
+		t.Errorf("Expected end of tar archive, got %v", err)
+	}
+}
+
+func TestAddTar(t *testing.T) {
+	r, err := NewRepository("memory://tar-import", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+
+	orig, _ := ioutil.ReadFile("tar_test.go")
+	modTime := time.Date(2016, 7, 29, 2, 6, 4, 0, time.UTC)
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	tw.WriteHeader(&tar.Header{Name: "./src/", Typeflag: tar.TypeDir, Mode: 0750, Uid: 1000, Gid: 100, ModTime: modTime,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.test": "value"}})
+	tw.WriteHeader(&tar.Header{Name: "./src/tar_test.go", Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(orig)), ModTime: modTime})
+	tw.Write(orig)
+	tw.WriteHeader(&tar.Header{Name: "./src/small", Typeflag: tar.TypeReg, Mode: 0644, Size: 5, ModTime: modTime})
+	tw.Write([]byte("small"))
+	tw.WriteHeader(&tar.Header{Name: "./src/link", Typeflag: tar.TypeSymlink, Linkname: "small", ModTime: modTime})
+	tw.WriteHeader(&tar.Header{Name: "./src/fifo", Typeflag: tar.TypeFifo, Mode: 0600, ModTime: modTime})
+	tw.WriteHeader(&tar.Header{Name: "./src/hardlink", Typeflag: tar.TypeLink, Linkname: "src/small", ModTime: modTime})
+	tw.Close()
+
+	snapshot, _ := NewSnapshot("test")
+	err = snapshot.AddTar(&b, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1, InlineSize: 1024})
+	if err != nil {
+		t.Errorf("Failed adding tar archive: %s", err)
+		return
+	}
+	if len(snapshot.Items) != 5 || snapshot.Stats.Errors != 1 {
+		t.Errorf("Expected 5 items and 1 error, got %d and %d", len(snapshot.Items), snapshot.Stats.Errors)
+		return
+	}
+
+	dir := snapshot.Items[0]
+	if dir.Path != "src" || dir.Type != Directory || dir.Mode != os.ModeDir|0750 ||
+		dir.UID != 1000 || dir.GID != 100 || !dir.ModTime.Equal(modTime) || string(dir.Xattrs["user.test"]) != "value" {
+		t.Errorf("Unexpected directory %+v", dir)
+	}
+	file := snapshot.Items[1]
+	data, _, err := DecodeArchiveData(r, file)
+	if err != nil || !bytes.Equal(data, orig) || len(file.Chunks) == 0 || file.Mode != 0600 {
+		t.Errorf("Failed importing file %s: %v", file.Path, err)
+	}
+	if small := snapshot.Items[2]; string(small.Data) != "small" || len(small.Chunks) != 0 {
+		t.Errorf("Expected small file to be inlined")
+	}
+	if link := snapshot.Items[3]; link.Type != SymLink || link.PointsTo != "small" {
+		t.Errorf("Unexpected symlink %+v", link)
+	}
+	if fifo := snapshot.Items[4]; fifo.Type != NamedPipe || fifo.Mode != os.ModeNamedPipe|0600 {
+		t.Errorf("Unexpected FIFO %+v", fifo)
+	}
+}
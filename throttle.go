@@ -0,0 +1,171 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// maximum amount of data passed on in one go by throttled readers
+const throttleBlockSize = 32 * 1024
+
+// rateLimiter limits the rate of transfers. It's safe to share one limiter
+// between concurrent transfers, their combined rate gets limited then
+type rateLimiter struct {
+	rate uint64 // bytes per second
+	next time.Time
+	mut  sync.Mutex
+}
+
+func newRateLimiter(rate uint64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until transferring n bytes doesn't exceed the rate anymore
+func (l *rateLimiter) wait(n int) {
+	time.Sleep(l.reserve(n))
+}
+
+// reserve accounts for a transfer of n bytes and returns how long to wait
+// before it may start
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	return l.next.Sub(now)
+}
+
+// throttledReader is an io.Reader which limits the rate data can be read with
+type throttledReader struct {
+	rd      io.Reader
+	limiter *rateLimiter
+}
+
+func newThrottledReader(rd io.Reader, limiter *rateLimiter) io.Reader {
+	return &throttledReader{rd: rd, limiter: limiter}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleBlockSize {
+		p = p[:throttleBlockSize]
+	}
+
+	n, err := r.rd.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
+
+// Scopes of the limiters in transferLimits
+const (
+	// limitBackend is the limit of a single backend, see BackendOptions
+	limitBackend = iota
+	// limitShared is the combined limit of all backends, see
+	// BackendManager.SetLimits
+	limitShared
+)
+
+// transferLimits holds the rate limiters all connections of a backend are
+// subject to. They can be changed while connections are open
+type transferLimits struct {
+	mut      sync.RWMutex
+	upload   [2]*rateLimiter
+	download [2]*rateLimiter
+}
+
+func newTransferLimits() *transferLimits {
+	return &transferLimits{}
+}
+
+// set replaces the limiters of scope, nil removes a limit
+func (l *transferLimits) set(scope int, upload, download *rateLimiter) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.upload[scope] = upload
+	l.download[scope] = download
+}
+
+// wait blocks until transferring n bytes exceeds none of the limits anymore
+func (l *transferLimits) wait(upload bool, n int) {
+	if l == nil {
+		return
+	}
+	l.mut.RLock()
+	limiters := l.download
+	if upload {
+		limiters = l.upload
+	}
+	l.mut.RUnlock()
+
+	var d time.Duration
+	for _, limiter := range limiters {
+		if limiter != nil {
+			if r := limiter.reserve(n); r > d {
+				d = r
+			}
+		}
+	}
+	time.Sleep(d)
+}
+
+// dialer wraps dial, so all connections it establishes are throttled
+func (l *transferLimits) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &throttledConn{Conn: conn, limits: l}, nil
+	}
+}
+
+// throttledConn is a net.Conn which limits the rate data gets sent and
+// received with. Data is passed on in small blocks, so transfers never exceed
+// the limits in bursts
+type throttledConn struct {
+	net.Conn
+	limits *transferLimits
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if len(p) > throttleBlockSize {
+		p = p[:throttleBlockSize]
+	}
+
+	n, err := c.Conn.Read(p)
+	c.limits.wait(false, n)
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		block := p
+		if len(block) > throttleBlockSize {
+			block = block[:throttleBlockSize]
+		}
+
+		c.limits.wait(true, len(block))
+		n, err := c.Conn.Write(block)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,277 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Problems found by VerifyBackends
+const (
+	ProblemMissingPart      = "Missing chunk part"
+	ProblemLostPart         = "Chunk part missing on all backends"
+	ProblemOrphanedPart     = "Orphaned chunk part"
+	ProblemSizeMismatch     = "Chunk part size mismatch"
+	ProblemMissingSnapshot  = "Missing snapshot"
+	ProblemOrphanedSnapshot = "Orphaned snapshot"
+	ProblemUnlisted         = "Content can't be listed"
+)
+
+// Inconsistency describes a problem with the data stored on a backend
+type Inconsistency struct {
+	// Location of the backend, empty if the problem affects all backends
+	Location string
+	// Name of the chunk part (SHASUM.PART_TOTALPARTS) or snapshot ID
+	Name    string
+	Problem string
+}
+
+// VerifyChunk loads and decodes chunk, checking it against its checksum
+func VerifyChunk(repository Repository, chunk Chunk) error {
+	_, err := loadChunk(repository, chunk)
+	return err
+}
+
+// ChunkRef identifies a file referring to a chunk
+type ChunkRef struct {
+	Snapshot string
+	Path     string
+}
+
+// ChunkState is the result of verifying a single chunk
+type ChunkState struct {
+	Chunk Chunk
+	// Parts is the amount of data and parity parts, Available how many of
+	// them could be loaded
+	Parts     uint
+	Available uint
+	// Err is set if the chunk can't be restored
+	Err error
+	// Files are the files referring to the chunk
+	Files []ChunkRef
+}
+
+// Damaged returns whether the chunk can't be restored
+func (s ChunkState) Damaged() bool {
+	return s.Err != nil
+}
+
+// Degraded returns whether the chunk can be restored, but lost some of its
+// parts and thereby redundancy
+func (s ChunkState) Degraded() bool {
+	return s.Err == nil && s.Available < s.Parts
+}
+
+// VerifyChunkParts loads every part of chunk, including its parity parts, and
+// decodes the chunk, checking it against its checksum
+func VerifyChunkParts(repository Repository, chunk Chunk) ChunkState {
+	state := ChunkState{Chunk: chunk, Parts: chunk.DataParts + chunk.ParityParts}
+	if chunk.ParityParts == 0 {
+		state.Parts = 1
+	}
+
+	pars := make([][]byte, state.Parts)
+	for i := range pars {
+		if b, err := repository.Backend.LoadChunk(chunk, uint(i)); err == nil {
+			pars[i] = b
+			state.Available++
+		}
+	}
+
+	data := pars[0]
+	if chunk.ParityParts > 0 {
+		if state.Available < chunk.DataParts {
+			state.Err = &DataReconstructionError{chunk, state.Available, chunk.DataParts - state.Available}
+			return state
+		}
+		enc, err := reedsolomon.New(int(chunk.DataParts), int(chunk.ParityParts))
+		if err != nil {
+			state.Err = err
+			return state
+		}
+		if state.Available < state.Parts {
+			if err = enc.Reconstruct(pars); err != nil {
+				state.Err = err
+				return state
+			}
+		}
+		var b bytes.Buffer
+		bufWriter := bufio.NewWriter(&b)
+		if err = enc.Join(bufWriter, pars, chunk.Size); err != nil {
+			state.Err = err
+			return state
+		}
+		bufWriter.Flush()
+		data = b.Bytes()
+	} else if state.Available == 0 {
+		state.Err = ErrLoadChunkFailed
+		return state
+	}
+
+	_, state.Err = decodeChunk(repository, chunk, data)
+	return state
+}
+
+// VerifySnapshots verifies every chunk the snapshots refer to with
+// VerifyChunkParts. It returns the amount of chunks and a channel delivering
+// their states, which gets closed once all chunks got verified
+func VerifySnapshots(repository Repository, snapshots []Snapshot) (int, <-chan ChunkState) {
+	chunks := []Chunk{}
+	refs := make(map[string][]ChunkRef)
+	for _, snapshot := range snapshots {
+		for _, item := range snapshot.Items {
+			for _, chunk := range item.Chunks {
+				key := partName(chunk.ShaSum, 0, chunk.DataParts)
+				if _, ok := refs[key]; !ok {
+					chunks = append(chunks, chunk)
+				}
+				refs[key] = append(refs[key], ChunkRef{snapshot.ID, item.Path})
+			}
+		}
+	}
+
+	states := make(chan ChunkState)
+	go func() {
+		for _, chunk := range chunks {
+			state := VerifyChunkParts(repository, chunk)
+			state.Files = refs[partName(chunk.ShaSum, 0, chunk.DataParts)]
+			states <- state
+		}
+		close(states)
+	}()
+
+	return len(chunks), states
+}
+
+// partSize returns the size each part of chunk is expected to have
+func partSize(chunk Chunk) uint64 {
+	parts := uint64(chunk.DataParts)
+	if parts == 0 {
+		parts = 1
+	}
+	return (uint64(chunk.Size) + parts - 1) / parts
+}
+
+// VerifyBackends compares the chunks and snapshots stored on each backend
+// with the ones the repository references. It reports chunk parts and
+// snapshots missing on a backend, parts stored with an unexpected size and
+// data no snapshot refers to
+func VerifyBackends(repository *Repository) ([]Inconsistency, error) {
+	chunks, err := repository.Chunks()
+	if err != nil {
+		return nil, err
+	}
+
+	// All referenced chunk parts
+	type chunkPart struct {
+		chunk Chunk
+		part  uint
+	}
+	parts := make(map[string]chunkPart)
+	for _, chunk := range chunks {
+		for part := uint(0); part < chunk.DataParts+chunk.ParityParts; part++ {
+			parts[partName(chunk.ShaSum, part, chunk.DataParts)] = chunkPart{chunk, part}
+		}
+	}
+	snapshots := make(map[string]bool)
+	for _, volume := range repository.Volumes {
+		for _, id := range volume.Snapshots {
+			snapshots[id] = true
+		}
+	}
+	// Index and location files are stored alongside the snapshots
+	for _, name := range append(repository.IndexFiles, repository.LocationFiles...) {
+		snapshots[name] = true
+	}
+
+	problems := []Inconsistency{}
+	found := make(map[string]bool)
+	unlisted := false
+	index := repository.Backend.Index()
+	for _, be := range repository.Backend.Backends {
+		location := (*be).Location()
+		listedSnapshots, err := (*be).ListSnapshots()
+		if err != nil {
+			if err != ErrListingUnsupported {
+				return problems, err
+			}
+			problems = append(problems, Inconsistency{location, "", ProblemUnlisted})
+			unlisted = true
+			continue
+		}
+		listedParts, err := (*be).ListChunks()
+		if err != nil {
+			return problems, err
+		}
+
+		stored := make(map[string]bool)
+		for _, id := range listedSnapshots {
+			if objectKind(id) == objectLock {
+				// Locks come and go
+				continue
+			}
+			stored[id] = true
+			if !snapshots[id] {
+				problems = append(problems, Inconsistency{location, id, ProblemOrphanedSnapshot})
+			}
+		}
+		for id := range snapshots {
+			if !stored[id] {
+				problems = append(problems, Inconsistency{location, id, ProblemMissingSnapshot})
+			}
+		}
+
+		stored = make(map[string]bool)
+		for _, name := range listedParts {
+			stored[name] = true
+			p, ok := parts[name]
+			if !ok {
+				problems = append(problems, Inconsistency{location, name, ProblemOrphanedPart})
+				continue
+			}
+			found[name] = true
+
+			if n, err := (*be).StatChunk(p.chunk.ShaSum, p.part, p.chunk.DataParts); err != nil || n != partSize(p.chunk) {
+				problems = append(problems, Inconsistency{location, name, ProblemSizeMismatch})
+			}
+		}
+
+		// The index tells which parts should be stored on this backend
+		for name, locations := range index {
+			if _, ok := parts[name]; !ok || stored[name] {
+				continue
+			}
+			for _, l := range locations {
+				if l == location {
+					problems = append(problems, Inconsistency{location, name, ProblemMissingPart})
+				}
+			}
+		}
+	}
+
+	if !unlisted {
+		// Only if all backends could be listed we know a part got lost
+		for name := range parts {
+			if !found[name] {
+				problems = append(problems, Inconsistency{"", name, ProblemLostPart})
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Location != problems[j].Location {
+			return problems[i].Location < problems[j].Location
+		}
+		return problems[i].Name < problems[j].Name
+	})
+	return problems, nil
+}
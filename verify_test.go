@@ -0,0 +1,121 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVerifyBackends(t *testing.T) {
+	r, err := NewRepository("memory://verify-first", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	second, _ := BackendFromURL("memory://verify-second")
+	r.Backend.AddBackend(&second)
+	r.Backend.Replication = 2
+
+	data := []byte("1234567890")
+	chunk := Chunk{ShaSum: "abcdef", DataParts: 1, Size: len(data), Data: &[][]byte{data}}
+	if _, err = r.Backend.StoreChunk(&chunk); err != nil {
+		t.Errorf("Failed storing chunk: %s", err)
+		return
+	}
+	snapshot, _ := NewSnapshot("test")
+	snapshot.Items = []ItemData{{Path: "file", Chunks: []Chunk{chunk}}}
+	volume, _ := NewVolume("test", "")
+	volume.AddSnapshot(snapshot.ID)
+	r.AddVolume(volume)
+	if err = snapshot.Save(&r); err != nil {
+		t.Errorf("Failed saving snapshot: %s", err)
+		return
+	}
+
+	problems, err := VerifyBackends(&r)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected consistent backends: %v %v", err, problems)
+	}
+
+	// Break the second backend in all possible ways
+	short := []byte("123")
+	second.DeleteSnapshot(snapshot.ID)
+	second.StoreChunk("012345", 0, 1, &short)
+	second.DeleteChunk("abcdef", 0, 1)
+
+	problems, err = VerifyBackends(&r)
+	if err != nil {
+		t.Errorf("Failed verifying backends: %s", err)
+		return
+	}
+	expected := map[string]string{
+		"012345.0_1": ProblemOrphanedPart,
+		"abcdef.0_1": ProblemMissingPart,
+		snapshot.ID:  ProblemMissingSnapshot,
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %v", len(expected), problems)
+	}
+	for _, p := range problems {
+		if p.Location != "memory://verify-second" || expected[p.Name] != p.Problem {
+			t.Errorf("Unexpected problem: %v", p)
+		}
+	}
+}
+
+func TestVerifySnapshots(t *testing.T) {
+	r, err := NewRepository("memory://verify-parts-0", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	for _, url := range []string{"memory://verify-parts-1", "memory://verify-parts-2"} {
+		be, _ := BackendFromURL(url)
+		r.Backend.AddBackend(&be)
+	}
+
+	snapshot, _ := NewSnapshot("test")
+	err = snapshot.AddStream("file", bytes.NewReader([]byte("some data to verify")), r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 2, ParityParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding stream: %s", err)
+		return
+	}
+	chunk := snapshot.Items[0].Chunks[0]
+
+	verify := func() ChunkState {
+		n, states := VerifySnapshots(r, []Snapshot{snapshot})
+		if n != 1 {
+			t.Errorf("Expected 1 chunk to verify, got %d", n)
+		}
+		state := <-states
+		for range states {
+		}
+		if len(state.Files) != 1 || state.Files[0].Path != "file" {
+			t.Errorf("Unexpected files referring to chunk: %v", state.Files)
+		}
+		return state
+	}
+	deletePart := func(part uint) {
+		for _, be := range r.Backend.Backends {
+			(*be).DeleteChunk(chunk.ShaSum, part, chunk.DataParts)
+		}
+	}
+
+	if state := verify(); state.Damaged() || state.Degraded() || state.Available != 3 {
+		t.Errorf("Expected intact chunk, got %d of %d parts: %v", state.Available, state.Parts, state.Err)
+	}
+	deletePart(0)
+	if state := verify(); state.Damaged() || !state.Degraded() {
+		t.Errorf("Expected degraded chunk, got %d of %d parts: %v", state.Available, state.Parts, state.Err)
+	}
+	deletePart(2)
+	if state := verify(); !state.Damaged() {
+		t.Errorf("Expected damaged chunk, got %d of %d parts", state.Available, state.Parts)
+	}
+}
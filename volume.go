@@ -16,6 +16,9 @@ type Volume struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Snapshots   []string `json:"snapshots"`
+	// Key is the volume's own data key, encrypted with the repository's key.
+	// Volumes without a key use the repository's key
+	Key []byte `json:"key,omitempty"`
 }
 
 // NewVolume creates a new volume
@@ -40,11 +43,55 @@ func (v *Volume) AddSnapshot(id string) error {
 	return nil
 }
 
+// RemoveSnapshot removes a snapshot from a volume
+func (v *Volume) RemoveSnapshot(id string) error {
+	for i, snapshot := range v.Snapshots {
+		if snapshot == id {
+			v.Snapshots = append(v.Snapshots[:i], v.Snapshots[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrSnapshotNotFound
+}
+
+// NewSnapshot creates a new snapshot, which gets encrypted with the volume's
+// key
+func (v *Volume) NewSnapshot(description string) (Snapshot, error) {
+	snapshot, err := NewSnapshot(description)
+	snapshot.volume = v
+	return snapshot, err
+}
+
+// LatestSnapshot loads the snapshot last added to the volume
+func (v *Volume) LatestSnapshot(repository *Repository) (Snapshot, error) {
+	if len(v.Snapshots) == 0 {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	return openSnapshot(v.Snapshots[len(v.Snapshots)-1], v, repository)
+}
+
+// LatestTaggedSnapshot loads the snapshot last added to the volume which
+// carries all of the tags
+func (v *Volume) LatestTaggedSnapshot(repository *Repository, tags []string) (Snapshot, error) {
+	for i := len(v.Snapshots) - 1; i >= 0; i-- {
+		snapshot, err := openSnapshot(v.Snapshots[i], v, repository)
+		if err != nil {
+			return snapshot, err
+		}
+		if snapshot.HasTags(tags) {
+			return snapshot, nil
+		}
+	}
+
+	return Snapshot{}, ErrSnapshotNotFound
+}
+
 // LoadSnapshot loads a snapshot within a volume from a repository
 func (v *Volume) LoadSnapshot(id string, repository *Repository) (Snapshot, error) {
 	for _, snapshot := range v.Snapshots {
 		if snapshot == id {
-			snapshot, err := openSnapshot(id, repository)
+			snapshot, err := openSnapshot(id, v, repository)
 			return snapshot, err
 		}
 	}
@@ -88,3 +88,130 @@ func TestFindUnknownVolume(t *testing.T) {
 		t.Errorf("Expected %v, got %v", ErrVolumeNotFound, err)
 	}
 }
+
+func TestFindVolumeByName(t *testing.T) {
+	r, err := NewRepository("memory://volume-names", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	home, _ := NewVolume("home", "")
+	r.AddVolume(home)
+	for i := 0; i < 2; i++ {
+		vol, _ := NewVolume("work", "")
+		r.AddVolume(vol)
+	}
+
+	if volume, err := r.FindVolume("home"); err != nil || volume != home {
+		t.Errorf("Failed finding volume by name: %v", err)
+	}
+	// Ambiguous names don't match
+	if _, err = r.FindVolume("work"); err != ErrVolumeNotFound {
+		t.Errorf("Expected %v, got %v", ErrVolumeNotFound, err)
+	}
+}
+
+func TestLatestTaggedSnapshot(t *testing.T) {
+	r, err := NewRepository("memory://tags", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	ids := []string{}
+	for _, tags := range [][]string{{"weekly", "pre-upgrade"}, {"weekly"}, nil} {
+		snapshot, _ := vol.NewSnapshot("test_snapshot")
+		snapshot.Tags = tags
+		if err = snapshot.Save(&r); err != nil {
+			t.Errorf("Failed saving snapshot: %s", err)
+			return
+		}
+		vol.AddSnapshot(snapshot.ID)
+		ids = append(ids, snapshot.ID)
+	}
+
+	for i, tags := range [][]string{{"pre-upgrade", "weekly"}, {"weekly"}, nil} {
+		snapshot, err := vol.LatestTaggedSnapshot(&r, tags)
+		if err != nil || snapshot.ID != ids[i] {
+			t.Errorf("Expected snapshot %s for tags %v, got %s: %v", ids[i], tags, snapshot.ID, err)
+		}
+	}
+	if _, err = vol.LatestTaggedSnapshot(&r, []string{"daily"}); err != ErrSnapshotNotFound {
+		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+}
+
+func TestRemoveVolume(t *testing.T) {
+	r, err := NewRepository("memory://removevolume", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	other, _ := NewVolume("other", "")
+	r.AddVolume(other)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	if err = snapshot.Save(&r); err != nil {
+		t.Errorf("Failed saving snapshot: %s", err)
+		return
+	}
+	vol.AddSnapshot(snapshot.ID)
+
+	if err = r.RemoveVolume(vol); err != nil {
+		t.Errorf("Failed removing volume: %s", err)
+		return
+	}
+	if len(r.Volumes) != 1 || r.Volumes[0] != other {
+		t.Errorf("Unexpected volumes left: %v", r.Volumes)
+	}
+	if _, err = r.Backend.LoadSnapshot(snapshot.ID); err == nil {
+		t.Errorf("Snapshot of removed volume can still be loaded")
+	}
+	if err = r.RemoveVolume(vol); err != ErrVolumeNotFound {
+		t.Errorf("Expected %v, got %v", ErrVolumeNotFound, err)
+	}
+}
+
+func TestMoveSnapshot(t *testing.T) {
+	r, err := NewRepository("memory://movesnapshot", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	other, _ := NewVolume("other", "")
+	r.AddVolume(other)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	if err = snapshot.Save(&r); err != nil {
+		t.Errorf("Failed saving snapshot: %s", err)
+		return
+	}
+	vol.AddSnapshot(snapshot.ID)
+
+	if err = r.MoveSnapshot(&snapshot, vol, other); err != nil {
+		t.Errorf("Failed moving snapshot: %s", err)
+		return
+	}
+	if len(vol.Snapshots) != 0 {
+		t.Errorf("Snapshot is still part of its old volume")
+	}
+	if _, err = other.LoadSnapshot(snapshot.ID, &r); err != nil {
+		t.Errorf("Failed loading moved snapshot: %s", err)
+	}
+
+	keyed, _ := NewVolume("keyed", "")
+	r.AddVolume(keyed)
+	if err = r.GenerateVolumeKey(keyed); err != nil {
+		t.Errorf("Failed generating volume key: %s", err)
+		return
+	}
+	if err = r.MoveSnapshot(&snapshot, other, keyed); err != ErrVolumeKeyMismatch {
+		t.Errorf("Expected %v, got %v", ErrVolumeKeyMismatch, err)
+	}
+}
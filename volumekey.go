@@ -0,0 +1,93 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// Error declarations
+var (
+	ErrVolumeHasKey      = errors.New("Volume already has its own key")
+	ErrVolumeNotEmpty    = errors.New("Volume already contains snapshots")
+	ErrVolumeKeyMismatch = errors.New("Snapshots can't be moved between volumes with keys of their own")
+)
+
+// GenerateVolumeKey gives volume a random data key of its own, encrypted with
+// the repository's key. Knowing the key of one volume doesn't expose the data
+// of any other volume. Only empty volumes can get their own key
+func (r *Repository) GenerateVolumeKey(volume *Volume) error {
+	if volume.Key != nil {
+		return ErrVolumeHasKey
+	}
+	if len(volume.Snapshots) > 0 {
+		return ErrVolumeNotEmpty
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := EncryptWith([]byte(hex.EncodeToString(key)), r.secret(), EncryptionAESGCM)
+	if err != nil {
+		return err
+	}
+	volume.Key = wrapped
+	return nil
+}
+
+// volumeSecret returns the key the data of volume gets encrypted with
+func (r *Repository) volumeSecret(volume *Volume) (string, error) {
+	if volume == nil || volume.Key == nil {
+		return r.secret(), nil
+	}
+
+	key, err := DecryptWith(volume.Key, r.secret(), EncryptionAESGCM)
+	return string(key), err
+}
+
+// chunkSecret returns the key chunk got encrypted with
+func (r *Repository) chunkSecret(chunk Chunk) (string, error) {
+	if chunk.Volume == "" {
+		return r.secret(), nil
+	}
+
+	volume, err := r.FindVolume(chunk.Volume)
+	if err != nil {
+		return "", err
+	}
+	return r.volumeSecret(volume)
+}
+
+// encryptVolume encrypts snapshot metadata of volume
+func (r *Repository) encryptVolume(volume *Volume, b []byte) ([]byte, error) {
+	if volume == nil || volume.Key == nil {
+		return r.encrypt(b)
+	}
+
+	secret, err := r.volumeSecret(volume)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptWith(b, secret, EncryptionAESGCM)
+}
+
+// decryptVolume decrypts snapshot metadata of volume
+func (r *Repository) decryptVolume(volume *Volume, b []byte) ([]byte, error) {
+	if volume == nil || volume.Key == nil {
+		return r.decrypt(b)
+	}
+
+	secret, err := r.volumeSecret(volume)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptWith(b, secret, EncryptionAESGCM)
+}
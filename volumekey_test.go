@@ -0,0 +1,92 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVolumeKey(t *testing.T) {
+	r, err := NewRepository("memory://volumekey", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+	if err = r.GenerateVolumeKey(vol); err != nil {
+		t.Errorf("Failed generating volume key: %s", err)
+		return
+	}
+	if err = r.GenerateVolumeKey(vol); err != ErrVolumeHasKey {
+		t.Errorf("Expected %v, got %v", ErrVolumeHasKey, err)
+	}
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	progress, err := snapshot.Add(wd, []string{"volumekey_test.go"}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+	if err = snapshot.Save(&r); err != nil {
+		t.Errorf("Failed saving snapshot: %s", err)
+		return
+	}
+	vol.AddSnapshot(snapshot.ID)
+	if err = r.Save(); err != nil {
+		t.Errorf("Failed saving repository: %s", err)
+		return
+	}
+
+	other, _ := NewVolume("other", "")
+	other.Snapshots = []string{snapshot.ID}
+	if err = r.GenerateVolumeKey(other); err != ErrVolumeNotEmpty {
+		t.Errorf("Expected %v, got %v", ErrVolumeNotEmpty, err)
+	}
+
+	r, err = OpenRepository("memory://volumekey", "password")
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	_, s, err := r.FindSnapshot(snapshot.ID)
+	if err != nil {
+		t.Errorf("Failed finding snapshot: %s", err)
+		return
+	}
+
+	// Neither the snapshot nor its chunks can be read with the repository key
+	b, _ := r.Backend.LoadSnapshot(snapshot.ID)
+	_, b = splitSignature(b)
+	if _, err = r.decrypt(b); err == nil {
+		t.Errorf("Snapshot got encrypted with the repository key")
+	}
+	chunk := s.Items[0].Chunks[0]
+	if chunk.Volume != vol.ID {
+		t.Errorf("Expected chunk of volume %s, got %s", vol.ID, chunk.Volume)
+	}
+	data, _ := loadChunkData(r, chunk)
+	if _, err = DecryptWith(data, r.secret(), chunk.Encrypted); err == nil {
+		t.Errorf("Chunk got encrypted with the repository key")
+	}
+
+	restored, _, err := DecodeArchiveData(r, s.Items[0])
+	if err != nil {
+		t.Errorf("Failed restoring data: %s", err)
+		return
+	}
+	original, _ := ioutil.ReadFile("volumekey_test.go")
+	if string(restored) != string(original) {
+		t.Errorf("Restored data does not match the original")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !darwin && !freebsd && !linux && !netbsd
+// +build !darwin,!freebsd,!linux,!netbsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+// Extended attributes aren't supported on this platform, items don't carry
+// any and the stored ones get dropped when restoring
+
+func readXattrs(path string, follow bool) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func writeXattrs(path string, attrs map[string][]byte, follow bool) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "src", "file")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	ioutil.WriteFile(path, []byte("knoxite"), 0644)
+	attrs := map[string][]byte{"user.knoxite.test": []byte("value")}
+	if err = writeXattrs(path, attrs, true); err != nil {
+		t.Errorf("Failed setting extended attributes: %s", err)
+		return
+	}
+	if got, _ := readXattrs(path, true); got == nil {
+		t.Skip("Extended attributes not supported")
+	}
+
+	r, err := NewRepository("memory://xattrs", "password")
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	vol, _ := NewVolume("test_name", "test_description")
+	r.AddVolume(vol)
+
+	snapshot, _ := vol.NewSnapshot("test_snapshot")
+	progress, err := snapshot.Add(dir, []string{filepath.Join(dir, "src")}, r, StoreOptions{Encryption: EncryptionAESGCM, DataParts: 1})
+	if err != nil {
+		t.Errorf("Failed adding to snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+
+	item, err := snapshot.FindItem("src/file")
+	if err != nil {
+		t.Errorf("Failed finding item: %s", err)
+		return
+	}
+	if !bytes.Equal(item.Xattrs["user.knoxite.test"], []byte("value")) {
+		t.Errorf("Expected extended attribute %q, got %v", "value", item.Xattrs)
+	}
+
+	targetdir, _ := ioutil.TempDir("", "knoxite.target")
+	defer os.RemoveAll(targetdir)
+	progress, err = DecodeSnapshot(r, snapshot, targetdir)
+	if err != nil {
+		t.Errorf("Failed restoring snapshot: %s", err)
+		return
+	}
+	for range progress {
+	}
+
+	got, err := readXattrs(filepath.Join(targetdir, "src", "file"), true)
+	if err != nil {
+		t.Errorf("Failed reading extended attributes: %s", err)
+	}
+	if !bytes.Equal(got["user.knoxite.test"], []byte("value")) {
+		t.Errorf("Expected restored extended attribute %q, got %v", "value", got)
+	}
+}
@@ -0,0 +1,102 @@
+//go:build darwin || freebsd || linux || netbsd
+// +build darwin freebsd linux netbsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of the item at path. Unless
+// follow is set, the attributes of a symlink itself get read instead of the
+// ones of its target. File systems without support for them have none
+func readXattrs(path string, follow bool) (map[string][]byte, error) {
+	list, get := unix.Llistxattr, unix.Lgetxattr
+	if follow {
+		list, get = unix.Listxattr, unix.Getxattr
+	}
+
+	names, err := xattrBuffer(func(dest []byte) (int, error) {
+		return list(path, dest)
+	})
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs map[string][]byte
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, gerr := xattrBuffer(func(dest []byte) (int, error) {
+			return get(path, string(name), dest)
+		})
+		if gerr != nil {
+			// Removed meanwhile or not readable by us
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string][]byte)
+		}
+		attrs[string(name)] = value
+	}
+	return attrs, nil
+}
+
+// xattrBuffer calls f with a buffer large enough for its result. The size
+// gets queried first and the call retried if the result grew meanwhile
+func xattrBuffer(f func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := f(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+		dest := make([]byte, size)
+		n, err := f(dest)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dest[:n], nil
+	}
+}
+
+// writeXattrs sets the extended attributes attrs on the item at path, on the
+// symlink itself unless follow is set. Attributes the file system doesn't
+// support or we lack the privileges for, like security.* ones of other
+// users, get skipped
+func writeXattrs(path string, attrs map[string][]byte, follow bool) error {
+	set := unix.Lsetxattr
+	if follow {
+		set = unix.Setxattr
+	}
+
+	for name, value := range attrs {
+		err := set(path, name, value, 0)
+		if err == unix.ENOTSUP || err == unix.EOPNOTSUPP || err == unix.EPERM {
+			continue
+		}
+		if err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: path, Err: err}
+		}
+	}
+	return nil
+}